@@ -0,0 +1,100 @@
+package astisub
+
+import (
+	"time"
+)
+
+// ShiftUnfitPass is the pass recorded for changes made by ShiftUnfit,
+// which runs after the three AdjustDuration passes
+const ShiftUnfitPass = 4
+
+// Change is a single change made to a subtitle while processing.
+// Timings are formatted as in .srt files. Timing and Text are those of
+// the subtitle after the change. A Field of "cue" is a subtitle being
+// inserted, its After holding the timing & text
+type Change struct {
+	Cue    int    `json:"cue"`
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+	Rule   string `json:"rule"`
+	Pass   int    `json:"pass"`
+	Timing string `json:"timing"`
+	Text   string `json:"text"`
+}
+
+// Recorder is told about every change made by the Adjust functions
+// and ShiftUnfit, when set on Subtitles
+type Recorder interface {
+	Record(c Change)
+}
+
+// TimingString formats the timing of item as in .srt files
+func TimingString(item *Item) string {
+	return FormatDurationSRT(item.StartAt) + " --> " + FormatDurationSRT(item.EndAt)
+}
+
+// CueString formats the timing & text of item as recorded for an
+// inserted subtitle
+func CueString(item *Item) string {
+	return TimingString(item) + "\n" + item.String()
+}
+
+// record passes a change of subtitle i to the recorder, if any
+func (s *Subtitles) record(i int, field string, before string, after string, rule string) {
+	if s.Recorder == nil || before == after {
+		return
+	}
+
+	s.Recorder.Record(Change{
+		Cue:    i + 1,
+		Field:  field,
+		Before: before,
+		After:  after,
+		Rule:   rule,
+		Pass:   s.Pass,
+		Timing: TimingString(s.Items[i]),
+		Text:   s.Items[i].String(),
+	})
+}
+
+// setStart changes the start of subtitle i and records it
+func (s *Subtitles) setStart(i int, d time.Duration, rule string) {
+	before := s.Items[i].StartAt
+	s.Items[i].StartAt = d
+	s.record(i, "start", FormatDurationSRT(before), FormatDurationSRT(d), rule)
+}
+
+// setEnd changes the end of subtitle i and records it
+func (s *Subtitles) setEnd(i int, d time.Duration, rule string) {
+	before := s.Items[i].EndAt
+	s.Items[i].EndAt = d
+	s.record(i, "end", FormatDurationSRT(before), FormatDurationSRT(d), rule)
+}
+
+// Edit replaces the timing & text of subtitle i, recording each field
+// which changed under rule. Lines holds the new text, one entry per line
+func (s *Subtitles) Edit(i int, start time.Duration, end time.Duration, lines []string, rule string) {
+	if start != s.Items[i].StartAt {
+		s.setStart(i, start, rule)
+	}
+	if end != s.Items[i].EndAt {
+		s.setEnd(i, end, rule)
+	}
+
+	// Unchanged lines keep their styling
+	same := len(lines) == len(s.Items[i].Lines)
+	for n := 0; same && n < len(lines); n++ {
+		same = lines[n] == s.Items[i].Lines[n].String()
+	}
+	if same {
+		return
+	}
+
+	before := s.Items[i].String()
+	s.Items[i].Lines = nil
+	for _, l := range lines {
+		s.Items[i].Lines = append(s.Items[i].Lines, Line{Items: []LineItem{{Text: l}}})
+	}
+	s.record(i, "text", before, s.Items[i].String(), rule)
+}
@@ -0,0 +1,40 @@
+package astisub
+
+// Level is the importance of a log message
+type Level int
+
+// Log levels, from the most to the least important
+const (
+	LevelError Level = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+	LevelTrace
+)
+
+var levelNames = []string{"error", "warn", "info", "debug", "trace"}
+
+// String returns the name of the level
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return "unknown"
+	}
+	return levelNames[l]
+}
+
+// Logger is given the diagnostics of the functions processing subtitles,
+// instead of them printing anything. Fields are given as name, value
+// pairs. Enabled lets callers skip preparing messages nobody will see
+type Logger interface {
+	Enabled(level Level) bool
+	Log(level Level, msg string, fields ...interface{})
+}
+
+// NopLogger discards every message
+type NopLogger struct{}
+
+// Enabled implements Logger
+func (NopLogger) Enabled(level Level) bool { return false }
+
+// Log implements Logger
+func (NopLogger) Log(level Level, msg string, fields ...interface{}) {}
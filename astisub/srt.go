@@ -29,68 +29,130 @@ func parseDurationSRT(i string) (time.Duration, error) {
 func ReadFromSRT(i io.Reader) (o *Subtitles, err error) {
 	// Init
 	o = NewSubtitles()
-	var scanner = bufio.NewScanner(i)
+	var d = NewSRTDecoder(i)
+
+	// Decode
+	var s *Item
+	for {
+		if s, err = d.Decode(); err == io.EOF {
+			err = nil
+			return
+		} else if err != nil {
+			return
+		}
+
+		// Append subtitle
+		o.Items = append(o.Items, s)
+	}
+}
+
+// SRTDecoder reads .srt items one at a time so that a file never has to be held in memory
+type SRTDecoder struct {
+	current *Item
+	lines   []Line
+	scanner *bufio.Scanner
+}
+
+// NewSRTDecoder creates a new .srt decoder reading from i
+func NewSRTDecoder(i io.Reader) *SRTDecoder {
+	return &SRTDecoder{scanner: bufio.NewScanner(i)}
+}
 
+// Decode returns the next item, or io.EOF once all items have been read
+func (d *SRTDecoder) Decode() (o *Item, err error) {
 	// Scan
 	var line string
-	var s = &Item{}
-	for scanner.Scan() {
+	for d.scanner.Scan() {
 		// Fetch line
-		line = scanner.Text()
+		line = d.scanner.Text()
 
 		// Line contains time boundaries
 		if strings.Contains(line, srtTimeBoundariesSeparator) {
 			// Remove last item of previous subtitle since it's the index
-			s.Lines = s.Lines[:len(s.Lines)-1]
-
-			// Remove trailing empty lines
-			if len(s.Lines) > 0 {
-				for i := len(s.Lines) - 1; i >= 0; i-- {
-					if len(s.Lines[i].Items) > 0 {
-						for j := len(s.Lines[i].Items) - 1; j >= 0; j-- {
-							if len(s.Lines[i].Items[j].Text) == 0 {
-								s.Lines[i].Items = s.Lines[i].Items[:j]
-							} else {
-								break
-							}
-						}
-						if len(s.Lines[i].Items) == 0 {
-							s.Lines = s.Lines[:i]
-						}
-
-					}
-				}
+			if len(d.lines) > 0 {
+				d.lines = d.lines[:len(d.lines)-1]
 			}
 
+			// Complete previous subtitle
+			o = d.flush()
+
 			// Init subtitle
-			s = &Item{}
+			d.current = &Item{}
 
 			// Fetch time boundaries
 			boundaries := strings.Split(line, srtTimeBoundariesSeparator)
-			if s.StartAt, err = parseDurationSRT(boundaries[0]); err != nil {
+			if d.current.StartAt, err = parseDurationSRT(boundaries[0]); err != nil {
 				err = errors.Wrapf(err, "astisub: parsing srt duration %s failed", boundaries[0])
 				return
 			}
-			if s.EndAt, err = parseDurationSRT(boundaries[1]); err != nil {
+			if d.current.EndAt, err = parseDurationSRT(boundaries[1]); err != nil {
 				err = errors.Wrapf(err, "astisub: parsing srt duration %s failed", boundaries[1])
 				return
 			}
 
-			// Append subtitle
-			o.Items = append(o.Items, s)
+			if o != nil {
+				return
+			}
 		} else {
 			// Add text
-			s.Lines = append(s.Lines, Line{Items: []LineItem{{Text: line}}})
+			d.lines = append(d.lines, Line{Items: []LineItem{{Text: line}}})
 		}
 	}
+	if err = d.scanner.Err(); err != nil {
+		err = errors.Wrap(err, "astisub: scanning srt failed")
+		return
+	}
+
+	// Complete last subtitle
+	if o = d.flush(); o == nil {
+		err = io.EOF
+	}
+	return
+}
+
+// flush attaches the buffered lines to the current item and returns it
+func (d *SRTDecoder) flush() (o *Item) {
+	if d.current == nil {
+		d.lines = nil
+		return
+	}
+	o, d.current = d.current, nil
+	o.Lines, d.lines = trimTrailingEmptyLines(d.lines), nil
 	return
 }
 
+// trimTrailingEmptyLines removes trailing empty lines
+func trimTrailingEmptyLines(lines []Line) []Line {
+	if len(lines) > 0 {
+		for i := len(lines) - 1; i >= 0; i-- {
+			if len(lines[i].Items) > 0 {
+				for j := len(lines[i].Items) - 1; j >= 0; j-- {
+					if len(lines[i].Items[j].Text) == 0 {
+						lines[i].Items = lines[i].Items[:j]
+					} else {
+						break
+					}
+				}
+				if len(lines[i].Items) == 0 {
+					lines = lines[:i]
+				}
+
+			}
+		}
+	}
+	return lines
+}
+
 // formatDurationSRT formats an .srt duration
 func formatDurationSRT(i time.Duration) string {
 	return formatDuration(i, ",", 3)
 }
 
+// FormatDurationSRT formats d the way it is written in .srt files
+func FormatDurationSRT(d time.Duration) string {
+	return formatDurationSRT(d)
+}
+
 // WriteToSRT writes subtitles in .srt format
 func (s Subtitles) WriteToSRT(o io.Writer) (err error) {
 	// Do not write anything if no subtitles
@@ -99,35 +161,67 @@ func (s Subtitles) WriteToSRT(o io.Writer) (err error) {
 		return
 	}
 
-	// Add BOM header
-	var c []byte
-	c = append(c, BytesBOM...)
-
 	// Loop through subtitles
-	for k, v := range s.Items {
-		// Add time boundaries
-		c = append(c, []byte(strconv.Itoa(k+1))...)
-		c = append(c, bytesLineSeparator...)
-		c = append(c, []byte(formatDurationSRT(v.StartAt))...)
-		c = append(c, bytesSRTTimeBoundariesSeparator...)
-		c = append(c, []byte(formatDurationSRT(v.EndAt))...)
-		c = append(c, bytesLineSeparator...)
-
-		// Loop through lines
-		for _, l := range v.Lines {
-			c = append(c, []byte(l.String())...)
-			c = append(c, bytesLineSeparator...)
+	var w = bufio.NewWriter(o)
+	var e = NewSRTEncoder(w)
+	for _, v := range s.Items {
+		if err = e.Encode(v); err != nil {
+			return
 		}
+	}
 
-		// Add new line
-		c = append(c, bytesLineSeparator...)
+	// Write
+	if err = w.Flush(); err != nil {
+		err = errors.Wrap(err, "astisub: writing failed")
+		return
 	}
+	return
+}
+
+// SRTEncoder writes .srt items one at a time
+type SRTEncoder struct {
+	count int
+	w     io.Writer
+}
+
+// NewSRTEncoder creates a new .srt encoder writing to o
+func NewSRTEncoder(o io.Writer) *SRTEncoder {
+	return &SRTEncoder{w: o}
+}
+
+// Count returns the number of items encoded so far
+func (e *SRTEncoder) Count() int {
+	return e.count
+}
+
+// Encode writes the next item
+func (e *SRTEncoder) Encode(v *Item) (err error) {
+	var c []byte
 
-	// Remove last new line
-	c = c[:len(c)-1]
+	// Add BOM header before the first item, and a new line between items
+	if e.count == 0 {
+		c = append(c, BytesBOM...)
+	} else {
+		c = append(c, bytesLineSeparator...)
+	}
+	e.count++
+
+	// Add time boundaries
+	c = append(c, []byte(strconv.Itoa(e.count))...)
+	c = append(c, bytesLineSeparator...)
+	c = append(c, []byte(formatDurationSRT(v.StartAt))...)
+	c = append(c, bytesSRTTimeBoundariesSeparator...)
+	c = append(c, []byte(formatDurationSRT(v.EndAt))...)
+	c = append(c, bytesLineSeparator...)
+
+	// Loop through lines
+	for _, l := range v.Lines {
+		c = append(c, []byte(l.String())...)
+		c = append(c, bytesLineSeparator...)
+	}
 
 	// Write
-	if _, err = o.Write(c); err != nil {
+	if _, err = e.w.Write(c); err != nil {
 		err = errors.Wrap(err, "astisub: writing failed")
 		return
 	}
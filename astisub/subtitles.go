@@ -2,9 +2,11 @@ package astisub
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -97,6 +99,13 @@ type Subtitles struct {
 	Metadata *Metadata
 	Regions  map[string]*Region
 	Styles   map[string]*Style
+	Recorder Recorder
+	Tracer   Tracer
+	Pass     int
+
+	// Subtitles not walked yet during a pass, from next on
+	rest []*Item
+	next int
 }
 
 // NewSubtitles creates new subtitles
@@ -309,17 +318,84 @@ type LineItem struct {
 }
 
 // Add adds a duration to each time boundaries. As in the time package, duration can be negative.
+// Items ending up entirely before 0 are removed in place.
 func (s *Subtitles) Add(d time.Duration) {
-	for idx := 0; idx < len(s.Items); idx++ {
-		s.Items[idx].EndAt += d
-		s.Items[idx].StartAt += d
-		if s.Items[idx].EndAt <= 0 && s.Items[idx].StartAt <= 0 {
-			s.Items = append(s.Items[:idx], s.Items[idx+1:]...)
-			idx--
-		} else if s.Items[idx].StartAt <= 0 {
-			s.Items[idx].StartAt = time.Duration(0)
+	var n int
+	for _, item := range s.Items {
+		item.EndAt += d
+		item.StartAt += d
+		if item.EndAt <= 0 && item.StartAt <= 0 {
+			continue
+		} else if item.StartAt <= 0 {
+			item.StartAt = time.Duration(0)
 		}
+		s.Items[n] = item
+		n++
+	}
+	s.Remove(n, len(s.Items)-n)
+}
+
+// Insert inserts items at index idx, shifting the following items in place
+func (s *Subtitles) Insert(idx int, items ...*Item) {
+	var n = len(s.Items)
+	s.Items = append(s.Items, items...)
+	copy(s.Items[idx+len(items):], s.Items[idx:n])
+	copy(s.Items[idx:], items)
+}
+
+// BeginPass starts a pass over the subtitles in order, such as that of
+// AdjustDuration. Until EndPass, Items only holds the subtitles walked
+// so far & the one after, and those split off are appended to it rather
+// than inserted, so the list is rebuilt once in a single merge instead
+// of being shifted for every split
+func (s *Subtitles) BeginPass() {
+	s.rest, s.next = s.Items, 0
+	s.Items = make([]*Item, 0, len(s.rest))
+}
+
+// Walk brings subtitles i & i+1 into Items during a pass and returns
+// whether there is a subtitle i
+func (s *Subtitles) Walk(i int) bool {
+	for len(s.Items) < i+2 && s.next < len(s.rest) {
+		s.Items = append(s.Items, s.rest[s.next])
+		s.next++
+	}
+	return i < len(s.Items)
+}
+
+// EndPass ends a pass, merging the subtitles not walked yet back after
+// those of Items
+func (s *Subtitles) EndPass() {
+	s.Items = append(s.Items, s.rest[s.next:]...)
+	s.rest, s.next = nil, 0
+}
+
+// insertAfter inserts item after subtitle i. During a pass the
+// subtitles walked past i are handed back to those not walked yet, so
+// item is appended without shifting anything
+func (s *Subtitles) insertAfter(i int, item *Item) {
+	if s.rest == nil {
+		s.Insert(i+1, item)
+		return
+	}
+	for k := len(s.Items) - 1; k > i; k-- {
+		s.next--
+		s.rest[s.next] = s.Items[k]
 	}
+	s.Items = append(s.Items[:i+1], item)
+}
+
+// Remove removes n items starting at index idx, shifting the following items in place
+func (s *Subtitles) Remove(idx, n int) {
+	if n <= 0 {
+		return
+	}
+	var l = len(s.Items)
+	copy(s.Items[idx:], s.Items[idx+n:])
+	for i := l - n; i < l; i++ {
+		s.Items[i] = nil
+	}
+	s.Items = s.Items[:l-n]
 }
 
 // Duration returns the subtitles duration
@@ -503,7 +579,8 @@ func (s *Subtitles) removeUnusedRegionsAndStyles() {
 	}
 }
 
-// Order orders items
+// Order orders items by start time, then by end time.
+// The sort is stable so items with identical boundaries keep their order
 func (s *Subtitles) Order() {
 	// Nothing to do if less than 1 element
 	if len(s.Items) <= 1 {
@@ -511,18 +588,12 @@ func (s *Subtitles) Order() {
 	}
 
 	// Order
-	var swapped = true
-	for swapped {
-		swapped = false
-		for index := 1; index < len(s.Items); index++ {
-			if s.Items[index-1].StartAt > s.Items[index].StartAt {
-				var tmp = s.Items[index-1]
-				s.Items[index-1] = s.Items[index]
-				s.Items[index] = tmp
-				swapped = true
-			}
+	sort.SliceStable(s.Items, func(i, j int) bool {
+		if s.Items[i].StartAt != s.Items[j].StartAt {
+			return s.Items[i].StartAt < s.Items[j].StartAt
 		}
-	}
+		return s.Items[i].EndAt < s.Items[j].EndAt
+	})
 }
 
 // RemoveStyling removes the styling from the subtitles
@@ -565,31 +636,30 @@ func (s *Subtitles) Unfragment() {
 	s.Order()
 }
 
-// Write writes subtitles to a file
+// Write writes subtitles to a file.
+// The content is written to a temporary file next to dst which is synced
+// and renamed over dst, so dst is never left half written
 func (s Subtitles) Write(dst string) (err error) {
-	// Create the file
-	var f *os.File
-	if f, err = os.Create(dst); err != nil {
-		err = errors.Wrapf(err, "astisub: creating %s failed", dst)
-		return
-	}
-	defer f.Close()
-
-	// Write the content
+	// Pick the writer
+	var fn func(io.Writer) error
 	switch filepath.Ext(dst) {
 	case ".srt":
-		err = s.WriteToSRT(f)
+		fn = s.WriteToSRT
 	/*case ".ssa", ".ass":
-		err = s.WriteToSSA(f)
+		fn = s.WriteToSSA
 	case ".stl":
-		err = s.WriteToSTL(f)
+		fn = s.WriteToSTL
 	case ".ttml":
-		err = s.WriteToTTML(f)
+		fn = s.WriteToTTML
 	case ".vtt":
-		err = s.WriteToWebVTT(f)*/
+		fn = s.WriteToWebVTT*/
 	default:
 		err = ErrInvalidExtension
+		return
 	}
+
+	// Write the content
+	err = WriteFileAtomic(dst, fn)
 	return
 }
 
@@ -0,0 +1,155 @@
+package astisub
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// benchCues is the size of the synthetic files, that of a long series
+// season in a single file
+const benchCues = 50000
+
+// benchSubtitles generates n two line subtitles of 8s each, one every
+// 10s, shuffled if asked
+func benchSubtitles(n int, shuffled bool) *Subtitles {
+	s := NewSubtitles()
+	for i := 0; i < n; i++ {
+		s.Items = append(s.Items, &Item{
+			StartAt: time.Duration(i) * 10 * time.Second,
+			EndAt:   time.Duration(i)*10*time.Second + 8*time.Second,
+			Lines: []Line{
+				{Items: []LineItem{{Text: fmt.Sprintf("This is the first line of #%d", i+1)}}},
+				{Items: []LineItem{{Text: "and this is the second one"}}},
+			},
+			Process: true,
+		})
+	}
+
+	if shuffled {
+		r := rand.New(rand.NewSource(1))
+		r.Shuffle(n, func(i, j int) { s.Items[i], s.Items[j] = s.Items[j], s.Items[i] })
+	}
+
+	return s
+}
+
+// orderBubble is Order as it was, a bubble sort, kept to measure against
+func orderBubble(s *Subtitles) {
+	for swapped := true; swapped; {
+		swapped = false
+		for i := 1; i < len(s.Items); i++ {
+			if s.Items[i-1].StartAt > s.Items[i].StartAt {
+				s.Items[i-1], s.Items[i] = s.Items[i], s.Items[i-1]
+				swapped = true
+			}
+		}
+	}
+}
+
+func BenchmarkOrder(b *testing.B) {
+	for _, n := range []int{5000, benchCues} {
+		b.Run(fmt.Sprintf("sort/%d", n), func(b *testing.B) {
+			for k := 0; k < b.N; k++ {
+				b.StopTimer()
+				s := benchSubtitles(n, true)
+				b.StartTimer()
+				s.Order()
+			}
+		})
+
+		b.Run(fmt.Sprintf("bubble/%d", n), func(b *testing.B) {
+			if n > 5000 && testing.Short() {
+				b.Skip("quadratic, skipped with -short")
+			}
+			for k := 0; k < b.N; k++ {
+				b.StopTimer()
+				s := benchSubtitles(n, true)
+				b.StartTimer()
+				orderBubble(s)
+			}
+		})
+	}
+}
+
+// splitRebuild splits subtitle i as AdjustDuration did, rebuilding the
+// whole slice, kept to measure against the merge of a pass
+func splitRebuild(s *Subtitles, i int, first *Item, second *Item) {
+	items := make([]*Item, 0)
+	items = append(items, s.Items[:i]...)
+	items = append(items, first, second)
+	items = append(items, s.Items[i+1:]...)
+	s.Items = items
+}
+
+// benchParams split every subtitle of benchSubtitles in two
+var benchParams = CommandParams{
+	Speed:            21,
+	SpeedEpsilon:     1,
+	MinLength:        1,
+	TrimSpaces:       1,
+	SplitLongerThan:  7,
+	ShrinkLongerThan: 7,
+	MaxLines:         2,
+	CharsPerLine:     42,
+}
+
+func BenchmarkAdjustDurationSplit(b *testing.B) {
+	for k := 0; k < b.N; k++ {
+		b.StopTimer()
+		s := benchSubtitles(benchCues, false)
+		b.StartTimer()
+
+		s.BeginPass()
+		for i := 0; s.Walk(i); i++ {
+			s.AdjustDuration(i, benchParams, NopLogger{})
+		}
+		s.EndPass()
+
+		if b.StopTimer(); len(s.Items) != 2*benchCues {
+			b.Fatalf("expected every subtitle to be split, got %d subtitles", len(s.Items))
+		}
+		b.StartTimer()
+	}
+}
+
+func BenchmarkSplit(b *testing.B) {
+	split := func(s *Subtitles, i int) (*Item, *Item) {
+		first, second := *s.Items[i], *s.Items[i]
+		first.Lines, second.Lines = first.Lines[:1], second.Lines[1:]
+		return &first, &second
+	}
+
+	for _, n := range []int{5000, benchCues} {
+		b.Run(fmt.Sprintf("merge/%d", n), func(b *testing.B) {
+			for k := 0; k < b.N; k++ {
+				b.StopTimer()
+				s := benchSubtitles(n, false)
+				b.StartTimer()
+				s.BeginPass()
+				for i := 0; s.Walk(i); i += 2 {
+					first, second := split(s, i)
+					s.Items[i] = first
+					s.insertAfter(i, second)
+				}
+				s.EndPass()
+			}
+		})
+
+		b.Run(fmt.Sprintf("rebuild/%d", n), func(b *testing.B) {
+			if n > 5000 && testing.Short() {
+				b.Skip("quadratic, skipped with -short")
+			}
+			for k := 0; k < b.N; k++ {
+				b.StopTimer()
+				s := benchSubtitles(n, false)
+				b.StartTimer()
+				for i := 0; i < len(s.Items); i += 2 {
+					first, second := split(s, i)
+					splitRebuild(s, i, first, second)
+				}
+			}
+		})
+	}
+}
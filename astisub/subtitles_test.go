@@ -0,0 +1,197 @@
+package astisub
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testItem returns an item from start to end, in seconds, whose text
+// is its index
+func testItem(index int, start, end int) *Item {
+	return &Item{
+		StartAt: time.Duration(start) * time.Second,
+		EndAt:   time.Duration(end) * time.Second,
+		Lines:   []Line{{Items: []LineItem{{Text: strconv.Itoa(index)}}}},
+	}
+}
+
+// testItems returns an item for each [start, end] pair, in seconds,
+// whose text is its position
+func testItems(times ...[2]int) []*Item {
+	items := make([]*Item, 0, len(times))
+	for i, t := range times {
+		items = append(items, testItem(i, t[0], t[1]))
+	}
+	return items
+}
+
+// indexes returns the index in the text of each item, telling where it
+// came from
+func indexes(items []*Item) []int {
+	idx := make([]int, 0, len(items))
+	for _, item := range items {
+		i, _ := strconv.Atoi(item.String())
+		idx = append(idx, i)
+	}
+	return idx
+}
+
+func TestOrder(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		items    []*Item
+		expected []int
+	}{
+		{"empty", nil, []int{}},
+		{"ordered", testItems([2]int{1, 2}, [2]int{3, 4}, [2]int{5, 6}), []int{0, 1, 2}},
+		{"reversed", testItems([2]int{5, 6}, [2]int{3, 4}, [2]int{1, 2}), []int{2, 1, 0}},
+		{"same start", testItems([2]int{1, 4}, [2]int{1, 2}, [2]int{0, 1}), []int{2, 1, 0}},
+		// Equal subtitles keep their order, as with the bubble sort
+		{"equal", testItems([2]int{3, 4}, [2]int{1, 2}, [2]int{3, 4}, [2]int{1, 2}), []int{1, 3, 0, 2}},
+	} {
+		s := NewSubtitles()
+		s.Items = c.items
+		s.Order()
+
+		if got := indexes(s.Items); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: ordered as %v, expected %v", c.name, got, c.expected)
+		}
+	}
+}
+
+func TestInsertRemove(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		edit     func(s *Subtitles)
+		expected []int
+	}{
+		{"insert first", func(s *Subtitles) { s.Insert(0, testItem(9, 0, 1)) }, []int{9, 0, 1, 2}},
+		{"insert middle", func(s *Subtitles) { s.Insert(2, testItem(7, 4, 5), testItem(8, 4, 5)) }, []int{0, 1, 7, 8, 2}},
+		{"insert last", func(s *Subtitles) { s.Insert(3, testItem(7, 7, 8)) }, []int{0, 1, 2, 7}},
+		{"remove first", func(s *Subtitles) { s.Remove(0, 1) }, []int{1, 2}},
+		{"remove middle", func(s *Subtitles) { s.Remove(1, 1) }, []int{0, 2}},
+		{"remove all", func(s *Subtitles) { s.Remove(0, 3) }, []int{}},
+		{"remove none", func(s *Subtitles) { s.Remove(1, 0) }, []int{0, 1, 2}},
+	} {
+		s := NewSubtitles()
+		s.Items = testItems([2]int{1, 2}, [2]int{3, 4}, [2]int{5, 6})
+		c.edit(s)
+
+		if got := indexes(s.Items); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: subtitles are %v, expected %v", c.name, got, c.expected)
+		}
+	}
+}
+
+func TestPass(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		splits   map[int]int
+		stop     int
+		expected []int
+	}{
+		{"no splits", nil, -1, []int{0, 1, 2}},
+		{"split first", map[int]int{0: 7}, -1, []int{0, 7, 1, 2}},
+		{"split each", map[int]int{0: 7, 2: 8, 4: 9}, -1, []int{0, 7, 1, 8, 2, 9}},
+		{"split last", map[int]int{2: 9}, -1, []int{0, 1, 2, 9}},
+		// Ending early, as when cancelled, keeps the subtitles not walked
+		{"stopped", map[int]int{0: 7}, 2, []int{0, 7, 1, 2}},
+	} {
+		s := NewSubtitles()
+		s.Items = testItems([2]int{1, 2}, [2]int{3, 4}, [2]int{5, 6})
+
+		s.BeginPass()
+		for i := 0; s.Walk(i) && i != c.stop; i++ {
+			if n, ok := c.splits[i]; ok {
+				s.insertAfter(i, testItem(n, 0, 0))
+			}
+		}
+		s.EndPass()
+
+		if got := indexes(s.Items); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: subtitles are %v, expected %v", c.name, got, c.expected)
+		}
+	}
+}
+
+// timings returns the timing & text of each item
+func timings(items []*Item) []string {
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		list = append(list, TimingString(item)+" "+item.String())
+	}
+	return list
+}
+
+func TestAdd(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		d        time.Duration
+		expected []int
+		start    time.Duration
+	}{
+		{"forward", 2 * time.Second, []int{0, 1, 2}, 3 * time.Second},
+		{"back", -time.Second, []int{0, 1, 2}, 0},
+		{"back past the first", -4 * time.Second, []int{1, 2}, 0},
+		{"back past every one", -10 * time.Second, []int{}, 0},
+	} {
+		s := NewSubtitles()
+		s.Items = testItems([2]int{1, 2}, [2]int{3, 5}, [2]int{6, 7})
+		s.Add(c.d)
+
+		if got := indexes(s.Items); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: subtitles left are %v, expected %v", c.name, got, c.expected)
+			continue
+		}
+		if len(s.Items) > 0 && s.Items[0].StartAt != c.start {
+			t.Errorf("%s: first starts at %s, expected %s", c.name, s.Items[0].StartAt, c.start)
+		}
+	}
+}
+
+func TestOrderMatchesBubble(t *testing.T) {
+	sorted, bubbled := benchSubtitles(500, true), benchSubtitles(500, true)
+	sorted.Order()
+	orderBubble(bubbled)
+
+	for i := range sorted.Items {
+		if a, b := sorted.Items[i], bubbled.Items[i]; a.StartAt != b.StartAt || a.String() != b.String() {
+			t.Fatalf("subtitle %d is %q, the bubble sort put %q there", i, sorted.Items[i], bubbled.Items[i])
+		}
+	}
+}
+
+func TestAdjustDurationSplit(t *testing.T) {
+	s := benchSubtitles(50, false)
+	s.BeginPass()
+	for i := 0; s.Walk(i); i++ {
+		s.AdjustDuration(i, benchParams, NopLogger{})
+	}
+	s.EndPass()
+
+	// Outside a pass, splits are inserted one by one to the same effect
+	inserted := benchSubtitles(50, false)
+	for i := 0; i < len(inserted.Items); i++ {
+		inserted.AdjustDuration(i, benchParams, NopLogger{})
+	}
+	if got, expected := timings(s.Items), timings(inserted.Items); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("a pass split into %v, inserting split into %v", got, expected)
+	}
+
+	// Each subtitle is split in two, line by line, each half following
+	// the other
+	if len(s.Items) != 100 {
+		t.Fatalf("got %d subtitles, expected every one of 50 split in two", len(s.Items))
+	}
+	for i := 0; i < len(s.Items); i += 2 {
+		first, second := s.Items[i], s.Items[i+1]
+		if len(first.Lines) != 1 || len(second.Lines) != 1 || first.Lines[0].String() == second.Lines[0].String() {
+			t.Fatalf("subtitles %d & %d are %q & %q, expected a line each", i, i+1, first, second)
+		}
+		if first.EndAt > second.StartAt || (i > 0 && s.Items[i-1].EndAt > first.StartAt) {
+			t.Fatalf("subtitles %d & %d are out of order: %s-%s, %s-%s", i, i+1, first.StartAt, first.EndAt, second.StartAt, second.EndAt)
+		}
+	}
+}
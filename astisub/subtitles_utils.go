@@ -5,12 +5,18 @@
 package astisub
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"math"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
-	//"github.com/chetan-prime/subfixer/strip"
-	"../strip"
+
+	"github.com/chetan-prime/subfixer/strip"
+	"github.com/pkg/errors"
 )
 
 
@@ -47,6 +53,9 @@ type CommandParams struct {
 	SpacesAsChars	bool
 	NewlinesAsChars	bool
 	ForbiddenChars	string
+	MaxLength		float64
+	MinGapFrames	int
+	FrameRate		float64
 }
 
 // AddStringIfNotInArray is a helper function
@@ -73,6 +82,114 @@ func AddStringIfNotInArray(arr []string, str string) []string {
 	return arr
 }
 
+// Errors
+var (
+	ErrUnknownFormat = errors.New("astisub: unable to detect subtitle format")
+	ErrUnsupportedFormat = errors.New("astisub: detected subtitle format is not supported")
+)
+
+// sniffLength is the number of bytes inspected by ReadFrom
+// to guess the format of the incoming subtitles
+const sniffLength = 4096
+
+// ReadFrom parses subtitles from a reader which has no file name
+// attached to it (stdin, pipes etc.). The format is guessed
+// from the first few kilobytes of the content
+func ReadFrom(i io.Reader) (*Subtitles, error) {
+	r := bufio.NewReaderSize(i, sniffLength)
+	
+	head, err := r.Peek(sniffLength)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, errors.Wrap(err, "astisub: sniffing input failed")
+	}
+	
+	switch SniffFormat(head) {
+	case ".srt":
+		return ReadFromSRT(r)
+	case "":
+		return nil, ErrUnknownFormat
+	}
+	
+	return nil, ErrUnsupportedFormat
+}
+
+// SniffFormat guesses the subtitle format of the content in head
+// and returns it as a file extension (".srt", ".vtt" etc.).
+// An empty string is returned if no known format is recognised
+func SniffFormat(head []byte) string {
+	head = bytes.TrimPrefix(head, BytesBOM)
+	trimmed := bytes.TrimLeft(head, " \t\r\n")
+	
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("WEBVTT")):
+		return ".vtt"
+	case bytes.HasPrefix(trimmed, []byte("[Script Info]")):
+		return ".ass"
+	case bytes.HasPrefix(trimmed, []byte("<?xml")),
+		 bytes.HasPrefix(trimmed, []byte("<tt")):
+		return ".ttml"
+	case bytes.Contains(head, bytesSRTTimeBoundariesSeparator):
+		return ".srt"
+	}
+	
+	return ""
+}
+
+// WriteFileAtomic calls fn to write the content of dst into a temporary
+// file in the same directory. The temporary file is synced to disk and
+// renamed over dst only if everything succeeded, so a crash or a full disk
+// never leaves a truncated dst behind
+func WriteFileAtomic(dst string, fn func(io.Writer) error) (err error) {
+	dir, base := filepath.Split(dst)
+	if dir == "" {
+		dir = "."
+	}
+	
+	var f *os.File
+	if f, err = os.CreateTemp(dir, "."+base+".*.tmp"); err != nil {
+		return errors.Wrapf(err, "astisub: creating temporary file for %s failed", dst)
+	}
+	
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	
+	// Keep the permissions of the file being replaced
+	if fi, serr := os.Stat(dst); serr == nil {
+		if err = f.Chmod(fi.Mode().Perm()); err != nil {
+			return errors.Wrapf(err, "astisub: setting mode of %s failed", f.Name())
+		}
+	}
+	
+	if err = fn(f); err != nil {
+		return
+	}
+	
+	if err = f.Sync(); err != nil {
+		return errors.Wrapf(err, "astisub: syncing %s failed", f.Name())
+	}
+	
+	if err = f.Close(); err != nil {
+		return errors.Wrapf(err, "astisub: closing %s failed", f.Name())
+	}
+	
+	if err = os.Rename(f.Name(), dst); err != nil {
+		return errors.Wrapf(err, "astisub: renaming %s to %s failed", f.Name(), dst)
+	}
+	
+	// Make the rename itself durable, this is best effort as
+	// not every platform allows syncing a directory
+	if d, derr := os.Open(dir); derr == nil {
+		d.Sync()
+		d.Close()
+	}
+	
+	return nil
+}
+
 // ParseDuration is the public function for internal parseDuration
 func ParseDuration(i string, separator string, length int) (time.Duration, error) {
 	return parseDuration(i, separator, length)
@@ -119,12 +236,17 @@ func (item *Item) GetRuneCount(params CommandParams) int {
 
 // GetSpeed returns the speed in characters / second of the current
 // subtitle. It bases this on utf8 length / duration
-func (item *Item) GetSpeed(id int, params CommandParams) float64 {
+func (item *Item) GetSpeed(id int, params CommandParams, log Logger) float64 {
 	length := item.GetLength()
 	line_speed := float64(item.GetRuneCount(params)) / length
-	text := strip.StripTags(item.String())
 	
-	fmt.Printf("#%d/Read --> '%s'/length=%g/line_speed=%g\n", id, text, length, line_speed)
+	if log.Enabled(LevelTrace) {
+		log.Log(LevelTrace, "Read",
+			"id", id,
+			"text", strip.StripTags(item.String()),
+			"length", length,
+			"speed", line_speed)
+	}
 	return line_speed
 }
 
@@ -136,8 +258,8 @@ func (item *Item) GetLength() float64 {
 // GetExtendBy returns the amount by which a subtitle can be extended.
 // This does not consider the direction of extension
 // or whether any subtitles exist to left or right of the current one
-func (item *Item) GetExtendBy(id int, params CommandParams) float64 {
-	line_speed := item.GetSpeed(id, params)
+func (item *Item) GetExtendBy(id int, params CommandParams, log Logger) float64 {
+	line_speed := item.GetSpeed(id, params, log)
 	line_time := item.GetLength()
 	
 	desired_speed := params.Speed - (params.Speed * params.SpeedEpsilon / 100.0)
@@ -162,7 +284,7 @@ func (item *Item) GetExtendBy(id int, params CommandParams) float64 {
 // It takes into account any existing subtitle to the left
 // of the current one, and extends only upto the end of the
 // subtitle to the left
-func (s *Subtitles) AdjustStart(i int, params CommandParams, reduce_by float64) (float64, float64) {
+func (s *Subtitles) AdjustStart(i int, params CommandParams, reduce_by float64, log Logger) (float64, float64) {
 	var item *Item = s.Items[i]
 	var last_item *Item = nil
 	
@@ -178,21 +300,28 @@ func (s *Subtitles) AdjustStart(i int, params CommandParams, reduce_by float64)
 	}
 	
 	adjusted_by := 0.0
-	extend_by := item.GetExtendBy(i+1, params)
+	extend_by := item.GetExtendBy(i+1, params, log)
+	
+	s.begin(i, "AdjustStart",
+		"extend_by", extend_by,
+		"reduce_by", reduce_by,
+		"gap_left", float64(item.StartAt - last_stop) / float64(time.Second))
+	defer s.end()
 	
 	if extend_by > 0 && last_stop < item.StartAt {
 		last_diff := float64(item.StartAt - last_stop) / float64(time.Second)
 		if last_diff > extend_by {
 			last_diff = extend_by
 		}
-		item.StartAt -= time.Duration( last_diff * float64( time.Second ) )
+		s.setStart(i, item.StartAt - time.Duration( last_diff * float64( time.Second ) ), "extend_start")
+		s.decide(i, "extend_start", "by", last_diff)
 		
 		line_time += last_diff
 		extend_by -= last_diff
 		
 		adjusted_by += last_diff
 		
-		fmt.Printf("id #%d: StartAt decreased by %gs/line_time=%g\n", i+1, last_diff, line_time)
+		log.Log(LevelDebug, "StartAt decreased", "id", i+1, "by", last_diff, "length", line_time)
 	}
 	
 	if reduce_by>0 && extend_by < 0 && item.StartAt < item.EndAt {
@@ -205,14 +334,15 @@ func (s *Subtitles) AdjustStart(i int, params CommandParams, reduce_by float64)
 		if last_diff > math.Abs(extend_by) {
 			last_diff = math.Abs(extend_by)
 		}
-		item.StartAt += time.Duration( last_diff * float64( time.Second ) )
+		s.setStart(i, item.StartAt + time.Duration( last_diff * float64( time.Second ) ), "shrink_start")
+		s.decide(i, "shrink_start", "by", last_diff)
 		
 		line_time -= last_diff
 		extend_by += last_diff
 		
 		adjusted_by -= last_diff
 		
-		fmt.Printf("id #%d: StartAt increased by %gs/line_time=%g\n", i+1, last_diff, line_time)
+		log.Log(LevelDebug, "StartAt increased", "id", i+1, "by", last_diff, "length", line_time)
 	}
 	
 	return adjusted_by, line_time
@@ -224,7 +354,7 @@ func (s *Subtitles) AdjustStart(i int, params CommandParams, reduce_by float64)
 // It takes into account any existing subtitle to the right
 // of the current one, and extends only upto the start of the
 // subtitle to the right
-func (s *Subtitles) AdjustEnd(i int, params CommandParams, reduce_by float64) (float64, float64) {
+func (s *Subtitles) AdjustEnd(i int, params CommandParams, reduce_by float64, log Logger) (float64, float64) {
 	var item *Item = s.Items[i]
 	var next_item *Item = nil
 	
@@ -240,7 +370,13 @@ func (s *Subtitles) AdjustEnd(i int, params CommandParams, reduce_by float64) (f
 	}
 	
 	adjusted_by := 0.0
-	extend_by := item.GetExtendBy(i+1, params)
+	extend_by := item.GetExtendBy(i+1, params, log)
+	
+	s.begin(i, "AdjustEnd",
+		"extend_by", extend_by,
+		"reduce_by", reduce_by,
+		"gap_right", float64(next_start - item.EndAt) / float64(time.Second))
+	defer s.end()
 	
 	if reduce_by == 0 && extend_by > 0 && next_start > item.EndAt {
 		next_diff := float64(next_start - item.EndAt) / float64(time.Second)
@@ -248,13 +384,14 @@ func (s *Subtitles) AdjustEnd(i int, params CommandParams, reduce_by float64) (f
 		if next_diff > extend_by {
 			next_diff = extend_by
 		}
-		item.EndAt += time.Duration( next_diff * float64( time.Second ) )
+		s.setEnd(i, item.EndAt + time.Duration( next_diff * float64( time.Second ) ), "extend_end")
+		s.decide(i, "extend_end", "by", next_diff)
 		
 		line_time += next_diff
 		extend_by -= next_diff
 		
 		adjusted_by += next_diff
-		fmt.Printf("id #%d: EndAt increased by %gs/line_time=%g\n", i+1, next_diff, line_time)
+		log.Log(LevelDebug, "EndAt increased", "id", i+1, "by", next_diff, "length", line_time)
 	}
 	
 	if reduce_by > 0 && extend_by < 0 && item.StartAt < item.EndAt {
@@ -267,14 +404,15 @@ func (s *Subtitles) AdjustEnd(i int, params CommandParams, reduce_by float64) (f
 		if last_diff > math.Abs(extend_by) {
 			last_diff = math.Abs(extend_by)
 		}
-		item.EndAt -= time.Duration( last_diff * float64( time.Second ) )
+		s.setEnd(i, item.EndAt - time.Duration( last_diff * float64( time.Second ) ), "shrink_end")
+		s.decide(i, "shrink_end", "by", last_diff)
 		
 		line_time -= last_diff
 		extend_by += last_diff
 		
 		adjusted_by -= last_diff
 		
-		fmt.Printf("id #%d: EndAt decreased by %gs/line_time=%g\n", i+1, last_diff, line_time)
+		log.Log(LevelDebug, "EndAt decreased", "id", i+1, "by", last_diff, "length", line_time)
 	}
 	
 	return adjusted_by, line_time
@@ -288,9 +426,9 @@ func (s *Subtitles) AdjustEnd(i int, params CommandParams, reduce_by float64) (f
 // 2. Join short two line subtitles
 // 3. Proportionally split a very long subtitle
 // 4. Expand or shrink duration of a subtitle to left or right
-func (s *Subtitles) AdjustDuration(i int, params CommandParams) int {
+func (s *Subtitles) AdjustDuration(i int, params CommandParams, log Logger) int {
 	var item *Item = s.Items[i]
-	line_speed := item.GetSpeed(i+1, params)
+	line_speed := item.GetSpeed(i+1, params, log)
 	line_time := item.GetLength()
 	
 	incBy := 1
@@ -300,6 +438,15 @@ func (s *Subtitles) AdjustDuration(i int, params CommandParams) int {
 	                100.0) *
 	              params.MinLength
 	
+	s.begin(i, "AdjustDuration",
+		"pass", s.Pass,
+		"speed", line_speed,
+		"length", line_time,
+		"min_length", min_length)
+	defer s.end()
+	
+	text := item.String()
+	
 	// Process each line
 	for si:=0; si<len(item.Lines); si++ {
 		if params.TrimSpaces>0 {
@@ -308,38 +455,46 @@ func (s *Subtitles) AdjustDuration(i int, params CommandParams) int {
 			if lastItem>=0 {
 				tleft := strings.TrimLeft(item.Lines[si].Items[0].Text, " ")
 				if tleft != item.Lines[si].Items[0].Text {
-					fmt.Printf("id #%d: Trimming %d spaces to left of line\n",
-								i+1,
-								len(item.Lines[si].Items[0].Text) - len(tleft) )
+					log.Log(LevelDebug, "Trimming spaces to left of line",
+						"id", i+1,
+						"spaces", len(item.Lines[si].Items[0].Text) - len(tleft))
 					item.Lines[si].Items[0].Text = tleft
 				}
 				
 				tright := strings.TrimRight(item.Lines[si].Items[lastItem].Text, " ")
 				if tright != item.Lines[si].Items[lastItem].Text {
-					fmt.Printf("id #%d: Trimming %d spaces to right of line\n",
-								i+1,
-								len(item.Lines[si].Items[lastItem].Text) - len(tright) )
+					log.Log(LevelDebug, "Trimming spaces to right of line",
+						"id", i+1,
+						"spaces", len(item.Lines[si].Items[lastItem].Text) - len(tright))
 					item.Lines[si].Items[lastItem].Text = tright
 				}
 				
 				if lastItem>0 &&
 				   item.Lines[si].Items[lastItem].Text == "" {
-					fmt.Printf("id #%d: Trimming empty space to right of line\n",
-								i+1)
+					log.Log(LevelDebug, "Trimming empty space to right of line", "id", i+1)
 					item.Lines[si].Items = item.Lines[si].Items[0:lastItem]
 				}
 			}
 		}
 	}
+	s.record(i, "text", text, item.String(), "trim_spaces")
+	if text != item.String() {
+		s.decide(i, "trim_spaces", "text", item.String())
+	}
 	
 	if len(item.Lines)==2 && item.GetRuneCount(params)<params.JoinShorterThan {
 		// Join two line subtitle shorter than n characters
+		text := item.String()
 		line := item.Lines[0]
 		line.Items = append(line.Items, item.Lines[1].Items...)
 		item.Lines = []Line{line}
-		fmt.Printf(	"id #%d: Joining 2 lines into 1 as shorter than %d characters\n",
-					i+1,
-					params.JoinShorterThan )
+		s.record(i, "text", text, item.String(), "join_lines")
+		s.decide(i, "join_lines",
+			"chars", item.GetRuneCount(params),
+			"join_shorter_than", params.JoinShorterThan)
+		log.Log(LevelDebug, "Joining 2 lines into 1",
+			"id", i+1,
+			"join_shorter_than", params.JoinShorterThan)
 	}
 	
 	if len(item.Lines)==2 && item.GetLength()>params.SplitLongerThan {
@@ -360,64 +515,77 @@ func (s *Subtitles) AdjustDuration(i int, params CommandParams) int {
 		
 		//secondLen := l * float64(secondItem.GetRuneCount()) / c
 		
-		// Split two line subtitle longer than n seconds
-		newItems := make([]*Item, 0)
-		if i>0 {
-			newItems = append(newItems, s.Items[0:i]...)
-		}
-		newItems = append(newItems, &firstItem, &secondItem)
-		if i+1<len(s.Items) {
-			newItems = append(newItems, s.Items[i+1:]...)
-		}
-		s.Items = newItems
+		// Split two line subtitle longer than n seconds.
+		// Recorded as text, end & new subtitle, one step at a time
+		text := item.String()
+		end := firstItem.EndAt
+		firstItem.EndAt = secondItem.EndAt
+		s.Items[i] = &firstItem
+		s.record(i, "text", text, firstItem.String(), "split")
+		s.setEnd(i, end, "split")
+		
+		s.insertAfter(i, &secondItem)
+		s.record(i+1, "cue", "", CueString(&secondItem), "split")
 		item = s.Items[i]
+		s.decide(i, "split",
+			"length", l,
+			"split_longer_than", params.SplitLongerThan,
+			"first_length", firstLen)
 		
-		fmt.Printf(	"id #%d: Splitting proportionately into 2 fragments as longer than %gs\n",
-					i+1,
-					params.SplitLongerThan )
+		log.Log(LevelDebug, "Splitting proportionately into 2 fragments",
+			"id", i+1,
+			"split_longer_than", params.SplitLongerThan)
 		
-		line_speed = item.GetSpeed(i+1, params)
+		line_speed = item.GetSpeed(i+1, params, log)
 		line_time = item.GetLength()
 	}
 	
 	if i+1 < len(s.Items) {
 		//diff_duration := (s.Items[i+1].StartAt - item.EndAt) * time.Second
 		diff_time := float64(s.Items[i+1].StartAt - item.EndAt) / float64(time.Second)
-		fmt.Printf("id #%d: diff_time=%g\n", i+1, diff_time)
+		log.Log(LevelTrace, "Gap to next subtitle", "id", i+1, "gap", diff_time)
 		
 		if diff_time > 0 &&
 		   diff_time < params.ExpandCloserThan {
 			expand_time := (s.Items[i+1].StartAt - item.EndAt) / 2
 		
 			if item.GetLength() + params.ExpandCloserThan / 2 < params.ShrinkLongerThan {
-				fmt.Printf("id #%d: EndAt expanded by %gs to near half point between %gs distant next subtitles\n",
-							i+1,
-							diff_time / 2,
-							diff_time)
-				item.EndAt += expand_time
+				log.Log(LevelDebug, "EndAt expanded to near half point of the gap to the next subtitle",
+					"id", i+1,
+					"by", diff_time / 2,
+					"gap", diff_time)
+				s.setEnd(i, item.EndAt + expand_time, "expand_closer")
+				s.decide(i, "expand_end",
+					"gap", diff_time,
+					"expand_closer_than", params.ExpandCloserThan)
 			}
 			if s.Items[i+1].GetLength() + params.ExpandCloserThan / 2 < params.ShrinkLongerThan {
-				fmt.Printf("id #%d: StartAt reduced by %gs to near half point between %gs previous subttle\n",
-							i+2,
-							diff_time / 2,
-							diff_time)
-				s.Items[i+1].StartAt -= expand_time
+				log.Log(LevelDebug, "StartAt reduced to near half point of the gap to the previous subtitle",
+					"id", i+2,
+					"by", diff_time / 2,
+					"gap", diff_time)
+				s.setStart(i+1, s.Items[i+1].StartAt - expand_time, "expand_closer")
+				s.decide(i+1, "expand_start",
+					"gap", diff_time,
+					"expand_closer_than", params.ExpandCloserThan)
 			}
 		}
 	}
 	
 	if item.GetLength() > params.ShrinkLongerThan {
-		item.EndAt = item.StartAt +
-					 time.Duration( params.ShrinkLongerThan * float64(time.Second) )
+		length := item.GetLength()
+		s.setEnd(i, item.StartAt +
+					 time.Duration( params.ShrinkLongerThan * float64(time.Second) ), "shrink_longer")
+		s.decide(i, "shrink_longer",
+			"length", length,
+			"shrink_longer_than", params.ShrinkLongerThan)
 		line_time = item.GetLength()
-		fmt.Printf("id #%d: EndAt changed to reduce length/line_time=%g\n",
-		           i+1,
-		           line_time)
+		log.Log(LevelDebug, "EndAt changed to reduce length", "id", i+1, "length", line_time)
 	} else
 	if (	line_speed > params.Speed ||
 			line_time < min_length ) &&
 	   item.GetLength() < params.ShrinkLongerThan {
-		extend_by := item.GetExtendBy(i+1, params)
+		extend_by := item.GetExtendBy(i+1, params, log)
 		adjusted_by := 0.0
 		
 		if extend_by>0 &&
@@ -425,14 +593,21 @@ func (s *Subtitles) AdjustDuration(i int, params CommandParams) int {
 			extend_by = item.GetLength() - params.ShrinkLongerThan
 		}
 		
-		//fmt.Printf("#%d/line_speed=%g/reading_speed=%g/last_stop=%d/line_time=%g/extend_by=%g/next_start=%d\n", i+1, line_speed, reading_speed, last_stop, line_time, extend_by, next_start)
-		//fmt.Printf("#%d/item=%#v\n", i+1, s.Items[i])
+		s.begin(i, "extend",
+			"speed", line_speed,
+			"max_speed", params.Speed,
+			"length", line_time,
+			"min_length", min_length,
+			"extend_by", extend_by)
+		
+		//fmt.Fprintf(os.Stderr, "#%d/line_speed=%g/reading_speed=%g/last_stop=%d/line_time=%g/extend_by=%g/next_start=%d\n", i+1, line_speed, reading_speed, last_stop, line_time, extend_by, next_start)
+		//fmt.Fprintf(os.Stderr, "#%d/item=%#v\n", i+1, s.Items[i])
 		if extend_by > 0 {
-			adjusted_by, line_time = s.AdjustStart(i, params, 0.0)
+			adjusted_by, line_time = s.AdjustStart(i, params, 0.0, log)
 			extend_by -= adjusted_by
 		}
 		if extend_by > 0 {
-			adjusted_by, line_time = s.AdjustEnd(i, params, 0.0)
+			adjusted_by, line_time = s.AdjustEnd(i, params, 0.0, log)
 			extend_by -= adjusted_by
 		}
 		
@@ -440,33 +615,43 @@ func (s *Subtitles) AdjustDuration(i int, params CommandParams) int {
 		if extend_by > 0 {
 			if i+1 < len(s.Items) {
 				// Reduction algorithm will be minus figure
-				adjusted_by, line_time = s.AdjustStart(i+1, params, extend_by)
+				s.decide(i, "borrow_from_next", "extend_by", extend_by)
+				adjusted_by, line_time = s.AdjustStart(i+1, params, extend_by, log)
 				
 				if adjusted_by < 0 {
-					adjusted_by, line_time = s.AdjustEnd(i, params, 0.0)
+					adjusted_by, line_time = s.AdjustEnd(i, params, 0.0, log)
 					extend_by -= adjusted_by
 				}
 			}
 			
 			if extend_by > 0 && i > 0 {
 				// Reduction algorithm will be minus figure
-				adjusted_by, line_time = s.AdjustEnd(i-1, params, extend_by)
+				s.decide(i, "borrow_from_previous", "extend_by", extend_by)
+				adjusted_by, line_time = s.AdjustEnd(i-1, params, extend_by, log)
 				
 				if adjusted_by < 0 {
-					adjusted_by, line_time = s.AdjustStart(i, params, 0.0)
+					adjusted_by, line_time = s.AdjustStart(i, params, 0.0, log)
 					extend_by -= adjusted_by
 				}
 			}
 		}
+		
+		s.end()
+	} else {
+		s.decide(i, "fits",
+			"speed", line_speed,
+			"max_speed", params.Speed,
+			"length", line_time,
+			"min_length", min_length)
 	}
 	
 	return incBy
 }
 
-func (s *Subtitles) ShiftUnfit(i int, params CommandParams) {
+func (s *Subtitles) ShiftUnfit(i int, params CommandParams, log Logger) {
 	var item *Item = s.Items[i]
 	
-	line_speed := item.GetSpeed(i+1, params)
+	line_speed := item.GetSpeed(i+1, params, log)
 	line_time := item.GetLength()
 	
 	min_length := ( ( 100.0 +
@@ -487,18 +672,25 @@ func (s *Subtitles) ShiftUnfit(i int, params CommandParams) {
 		final_space_required := target_length - line_time
 		space_required := final_space_required
 		
+		s.begin(i, "ShiftUnfit",
+			"speed", line_speed,
+			"max_speed", params.Speed,
+			"length", line_time,
+			"min_length", min_length,
+			"target_length", target_length,
+			"space_required", space_required)
+		defer s.end()
+		
 		//space_shifted := make([]float64, SUB_LEVELS)
 		
-		fmt.Printf(
-			"ShiftUnfit/#%d/line_speed=%g/params.Speed=%g/line_time=%g/min_length=%g/target_length=%g/space_required=%g/Found subtitle which is still unfit!\n",
-			i,
-			line_speed,
-			params.Speed,
-			line_time,
-			min_length,
-			target_length,
-			space_required,
-		)
+		log.Log(LevelDebug, "Found subtitle which is still unfit",
+			"id", i+1,
+			"speed", line_speed,
+			"max_speed", params.Speed,
+			"length", line_time,
+			"min_length", min_length,
+			"target_length", target_length,
+			"space_required", space_required)
 		
 		for inc := -1;
 			inc < 2 && space_required > 0;
@@ -514,7 +706,16 @@ func (s *Subtitles) ShiftUnfit(i int, params CommandParams) {
 				move_right = true
 			}
 			
+			direction := "left"
+			if move_right {
+				direction = "right"
+			}
+			
 			for masterpass:=0; masterpass<3; masterpass++ {
+				s.begin(i, "ripple",
+					"direction", direction,
+					"masterpass", masterpass+1)
+				
 				for pass:=0; pass<3; pass++ {
 					for j := i + inc;
 						j >=0 && j < len(s.Items) && j != target;
@@ -570,19 +771,20 @@ func (s *Subtitles) ShiftUnfit(i int, params CommandParams) {
 							//	s.Items[lastIdx].EndAt -= resizeBy
 							//}
 							
-							s.Items[j].StartAt -= shiftBy
-							s.Items[j].EndAt -= shiftBy
+							s.setStart(j, s.Items[j].StartAt - shiftBy, "shift_unfit")
+							s.setEnd(j, s.Items[j].EndAt - shiftBy, "shift_unfit")
+							if shiftBy != 0 {
+								s.decide(j, "shift_left",
+									"by", gap_length_left,
+									"gap_left", float64(gap_left) / float64(time.Second),
+									"space_required", space_required)
+							}
 							
-							fmt.Printf(
-								"ShiftUnfit/#%d/pass=%d/j=%d/inc=%d/gap_length_left=%g/shiftBy=%d/Shifting subtitle left by %gs\n",
-								i,
-								pass,
-								j,
-								inc,
-								gap_length_left,
-								shiftBy,
-								gap_length_left,
-							)
+							log.Log(LevelDebug, "ShiftUnfit: shifting subtitle left",
+								"id", i+1,
+								"pass", pass,
+								"shifted", j+1,
+								"by", gap_length_left)
 							
 							if j == i + inc {
 								space_required -= gap_length_left
@@ -598,35 +800,33 @@ func (s *Subtitles) ShiftUnfit(i int, params CommandParams) {
 						   space_required > 0 {
 							shiftBy := time.Duration(gap_length_right * float64(time.Second))
 							
-							s.Items[j].StartAt += shiftBy
-							s.Items[j].EndAt += shiftBy
+							s.setStart(j, s.Items[j].StartAt + shiftBy, "shift_unfit")
+							s.setEnd(j, s.Items[j].EndAt + shiftBy, "shift_unfit")
+							if shiftBy != 0 {
+								s.decide(j, "shift_right",
+									"by", gap_length_right,
+									"gap_right", float64(gap_right) / float64(time.Second),
+									"space_required", space_required)
+							}
 							
-							fmt.Printf(
-								"ShiftUnfit/#%d/pass=%d/j=%d/inc=%d/gap_length_right=%g/shiftBy=%d/Shifting subtitle right by %gs\n",
-								i,
-								pass,
-								j,
-								inc,
-								gap_length_right,
-								shiftBy,
-								gap_length_right,
-							)
+							log.Log(LevelDebug, "ShiftUnfit: shifting subtitle right",
+								"id", i+1,
+								"pass", pass,
+								"shifted", j+1,
+								"by", gap_length_right)
 						
 							if j == i + inc {
 								space_required -= gap_length_right
 							}
 						}
 							
-						fmt.Printf(
-							"ShiftUnfit/#%d/pass=%d/j=%d/inc=%d/gap_length_left=%g/gap_length_right=%g/space_required=%g\n",
-							i,
-							pass,
-							j,
-							inc,
-							gap_length_left,
-							gap_length_right,
-							space_required,
-						)
+						log.Log(LevelTrace, "ShiftUnfit: gaps",
+							"id", i+1,
+							"pass", pass,
+							"subtitle", j+1,
+							"gap_left", gap_length_left,
+							"gap_right", gap_length_right,
+							"space_required", space_required)
 						
 						if space_required <=0 {
 							break
@@ -660,17 +860,20 @@ func (s *Subtitles) ShiftUnfit(i int, params CommandParams) {
 						   space_required > 0 {
 							
 							resizeBy := time.Duration(extra_length * float64(time.Second))
-							s.Items[j].EndAt -= resizeBy
+							s.setEnd(j, s.Items[j].EndAt - resizeBy, "shift_unfit")
+							if resizeBy != 0 {
+								s.decide(j, "shrink_end",
+									"by", extra_length,
+									"minimum_length", minimum_length,
+									"space_required", space_required)
+							}
 							
-							fmt.Printf(
-								"ShiftUnfit/#%d/pass=%d/j=%d/move_left/extra_length=%g/resizeBy=%d/space_required=%g\n",
-								i,
-								pass,
-								j,
-								extra_length,
-								resizeBy,
-								space_required,
-							)
+							log.Log(LevelDebug, "ShiftUnfit: shrinking end of subtitle",
+								"id", i+1,
+								"pass", pass,
+								"shrunk", j+1,
+								"by", extra_length,
+								"space_required", space_required)
 							
 							if j == i + inc {
 								space_required -= extra_length
@@ -679,17 +882,20 @@ func (s *Subtitles) ShiftUnfit(i int, params CommandParams) {
 						if move_right &&
 						   space_required > 0 {
 							resizeBy := time.Duration(extra_length * float64(time.Second))
-							s.Items[j].StartAt += resizeBy
+							s.setStart(j, s.Items[j].StartAt + resizeBy, "shift_unfit")
+							if resizeBy != 0 {
+								s.decide(j, "shrink_start",
+									"by", extra_length,
+									"minimum_length", minimum_length,
+									"space_required", space_required)
+							}
 							
-							fmt.Printf(
-								"ShiftUnfit/#%d/pass=%d/j=%d/move_left/extra_length=%g/resizeBy=%d/space_required=%g\n",
-								i,
-								pass,
-								j,
-								extra_length,
-								resizeBy,
-								space_required,
-							)
+							log.Log(LevelDebug, "ShiftUnfit: shrinking start of subtitle",
+								"id", i+1,
+								"pass", pass,
+								"shrunk", j+1,
+								"by", extra_length,
+								"space_required", space_required)
 							
 							if j == i + inc {
 								space_required -= extra_length
@@ -697,20 +903,20 @@ func (s *Subtitles) ShiftUnfit(i int, params CommandParams) {
 						}
 						
 						
-						fmt.Printf(
-							"ShiftUnfit/#%d/pass=%d/j=%d/extra_length=%g/space_required=%g\n",
-							i,
-							pass,
-							j,
-							extra_length,
-							space_required,
-						)
+						log.Log(LevelTrace, "ShiftUnfit: room to shrink",
+							"id", i+1,
+							"pass", pass,
+							"subtitle", j+1,
+							"extra_length", extra_length,
+							"space_required", space_required)
 					}
 				}
+				
+				s.end()
 			}
 		}
 			
-		//fmt.Printf(
+		//fmt.Fprintf(os.Stderr,
 		//	"ShiftUnfit/#%d/final_space_required=%gs\n",
 		//	i,
 		//	final_space_required,
@@ -728,16 +934,10 @@ func (s *Subtitles) ShiftUnfit(i int, params CommandParams) {
 			}
 			
 			gap_length_left := float64(item.StartAt - lastItem.EndAt) / float64(time.Second)
-			fmt.Printf(
-				"ShiftUnfit/#%d/final_space_required=%gs/lastItem.StartAt=%d/lastItem.EndAt=%d/item.StartAt=%d/item.EndAt=%d/gap_length_left=%g\n",
-				i,
-				final_space_required,
-				lastItem.StartAt,
-				lastItem.EndAt,
-				item.StartAt,
-				item.EndAt,
-				gap_length_left,
-			)
+			log.Log(LevelTrace, "ShiftUnfit: gap to the previous subtitle",
+				"id", i+1,
+				"final_space_required", final_space_required,
+				"gap_left", gap_length_left)
 			
 			if gap_length_left > 0 {
 				if gap_length_left > final_space_required {
@@ -745,16 +945,16 @@ func (s *Subtitles) ShiftUnfit(i int, params CommandParams) {
 				}
 				
 				resizeBy := time.Duration(gap_length_left * float64(time.Second))
-				s.Items[i].StartAt -= resizeBy
+				s.setStart(i, s.Items[i].StartAt - resizeBy, "shift_unfit")
 				final_space_required -= gap_length_left
+				s.decide(i, "extend_start",
+					"by", gap_length_left,
+					"final_space_required", final_space_required)
 				
-				fmt.Printf(
-					"ShiftUnfit/#%d/gap_length_left=%g/resizeBy=%d/final_space_required=%g\n",
-					i,
-					gap_length_left,
-					resizeBy,
-					final_space_required,
-				)
+				log.Log(LevelDebug, "ShiftUnfit: StartAt decreased",
+					"id", i+1,
+					"by", gap_length_left,
+					"final_space_required", final_space_required)
 			}
 		}
 		
@@ -771,16 +971,10 @@ func (s *Subtitles) ShiftUnfit(i int, params CommandParams) {
 			
 			gap_length_right := float64(nextItem.StartAt - item.EndAt) / float64(time.Second)
 			
-			fmt.Printf(
-				"ShiftUnfit/#%d/final_space_required=%gs/item.StartAt=%d/item.EndAt=%d/nextItem.StartAt=%d/nextItem.EndAt=%d/gap_length_right=%g\n",
-				i,
-				final_space_required,
-				item.StartAt,
-				item.EndAt,
-				nextItem.StartAt,
-				nextItem.EndAt,
-				gap_length_right,
-			)
+			log.Log(LevelTrace, "ShiftUnfit: gap to the next subtitle",
+				"id", i+1,
+				"final_space_required", final_space_required,
+				"gap_right", gap_length_right)
 			
 			if gap_length_right > 0 {
 				if gap_length_right > final_space_required {
@@ -788,16 +982,16 @@ func (s *Subtitles) ShiftUnfit(i int, params CommandParams) {
 				}
 				
 				resizeBy := time.Duration(gap_length_right * float64(time.Second))
-				s.Items[i].EndAt += resizeBy
+				s.setEnd(i, s.Items[i].EndAt + resizeBy, "shift_unfit")
 				final_space_required -= gap_length_right
+				s.decide(i, "extend_end",
+					"by", gap_length_right,
+					"final_space_required", final_space_required)
 				
-				fmt.Printf(
-					"ShiftUnfit/#%d/gap_length_right=%g/resizeBy=%d/final_space_required=%g\n",
-					i,
-					gap_length_right,
-					resizeBy,
-					final_space_required,
-				)
+				log.Log(LevelDebug, "ShiftUnfit: EndAt increased",
+					"id", i+1,
+					"by", gap_length_right,
+					"final_space_required", final_space_required)
 			}
 		}
 	}
@@ -809,7 +1003,7 @@ func (s *Subtitles) ShiftUnfit(i int, params CommandParams) {
 // CommandParams it is called with performs :
 // 1. Expand or shrink duration of a subtitle to left or right
 
-func (s *Subtitles) AdjustOverlap(i int, params CommandParams) int {
+func (s *Subtitles) AdjustOverlap(i int, params CommandParams, log Logger) int {
 	var item *Item = s.Items[i]
 
 	if i+1 < len(s.Items) {
@@ -820,23 +1014,23 @@ func (s *Subtitles) AdjustOverlap(i int, params CommandParams) int {
 			expand_time := (s.Items[i+1].StartAt - item.EndAt) / 2
 		
 			if item.GetLength() + params.ExpandCloserThan / 2 < params.ShrinkLongerThan {
-				fmt.Printf("id #%d: EndAt expanded by %gs to near half point between %gs distant next subtitles\n",
-							i+1,
-							diff_time / 2,
-							diff_time)
+				log.Log(LevelDebug, "EndAt expanded to near half point of the gap to the next subtitle",
+					"id", i+1,
+					"by", diff_time / 2,
+					"gap", diff_time)
 				item.EndAt += expand_time
 			}
 			if s.Items[i+1].GetLength() + params.ExpandCloserThan / 2 < params.ShrinkLongerThan {
-				fmt.Printf("id #%d: StartAt reduced by %gs to near half point between %gs previous subttle\n",
-							i+2,
-							diff_time / 2,
-							diff_time)
+				log.Log(LevelDebug, "StartAt reduced to near half point of the gap to the previous subtitle",
+					"id", i+2,
+					"by", diff_time / 2,
+					"gap", diff_time)
 				s.Items[i+1].StartAt -= expand_time
 			}
 		} else*/
 		if diff_time < 0 {
-			fmt.Printf("id #%d: diff_time=%g, < 0, adjusting EndAt\n", i+1, diff_time)
-			item.EndAt = s.Items[i+1].StartAt - time.Millisecond
+			log.Log(LevelDebug, "Overlaps the next subtitle, adjusting EndAt", "id", i+1, "gap", diff_time)
+			s.setEnd(i, s.Items[i+1].StartAt - time.Millisecond, "overlap")
 		}
 	}
 
@@ -844,17 +1038,45 @@ func (s *Subtitles) AdjustOverlap(i int, params CommandParams) int {
 }
 
 
+// Violation is a perfection check failed by a subtitle. Id counts
+// from 1, as does Line for the checks made on each line. Value is
+// what was found & Limit what the parameters allow
+type Violation struct {
+	Id		int			`json:"id"`
+	Rule	string		`json:"rule"`
+	Message	string		`json:"message"`
+	Line	int			`json:"line,omitempty"`
+	Value	interface{}	`json:"value,omitempty"`
+	Limit	interface{}	`json:"limit,omitempty"`
+}
+
 // PerfectionCheck is exported as a function which can be
 // called by the main program. It processes each subtitle
 // within the Subtitles collection and performs "Perfection
 // check" based on CommandParams it is called with.
-func (s *Subtitles) PerfectionCheck(i int, params CommandParams) []string {
+func (s *Subtitles) PerfectionCheck(i int, params CommandParams, log Logger) []string {
+	perrs := make([]string, 0)
+	
+	for _, v := range s.PerfectionViolations(i, params, log) {
+		perrs = AddStringIfNotInArray(perrs, v.Message)
+	}
+	
+	return perrs
+}
+
+// PerfectionViolations performs the same checks as PerfectionCheck,
+// returning every violation with the values which failed it
+func (s *Subtitles) PerfectionViolations(i int, params CommandParams, log Logger) []Violation {
 	var item *Item = s.Items[i]
 	
-	perrs := make([]string, 0)
+	violations := make([]Violation, 0)
+	add := func(v Violation) {
+		v.Id = i+1
+		violations = append(violations, v)
+	}
 	
 	if params.MaxLines>0 && len(item.Lines)>params.MaxLines {
-		perrs = AddStringIfNotInArray(perrs, "Too Many lines")
+		add(Violation{Rule: "max_lines", Message: "Too Many lines", Value: len(item.Lines), Limit: params.MaxLines})
 	}
 	
 	maxLen := 0
@@ -878,8 +1100,8 @@ func (s *Subtitles) PerfectionCheck(i int, params CommandParams) []string {
 		if len(params.ForbiddenChars)>0 &&
 		   len(plainRune) > 0 &&
 		   strings.IndexRune(params.ForbiddenChars, plainRune[0]) > -1 {
-			err := fmt.Sprintf("No Subtitle line should start with this character - '%s'", plain[0])
-			perrs = AddStringIfNotInArray(perrs, err);
+			err := fmt.Sprintf("No Subtitle line should start with this character - '%c'", plainRune[0])
+			add(Violation{Rule: "forbidden_chars", Message: err, Line: i+1, Value: string(plainRune[0])})
 		}
 		
 		if len(plainRune) > maxLen {
@@ -892,29 +1114,47 @@ func (s *Subtitles) PerfectionCheck(i int, params CommandParams) []string {
 		plainChars += len(plainRune)
 		if params.CharsPerLine>0 &&
 		   len(plainRune) > params.CharsPerLine {
-			perrs = AddStringIfNotInArray(perrs, "Too many characters");
+			add(Violation{Rule: "chars_per_line", Message: "Too many characters", Line: i+1, Value: len(plainRune), Limit: params.CharsPerLine})
 		}
 	}
 
-	if params.ReadingSpeed > 0 &&
-	   item.GetSpeed(i+1, params) > params.ReadingSpeed {
-		perrs = AddStringIfNotInArray(perrs, "Reading speed is too high");
+	if params.ReadingSpeed > 0 {
+		if speed := item.GetSpeed(i+1, params, log); speed > params.ReadingSpeed {
+			add(Violation{Rule: "reading_speed", Message: "Reading speed is too high", Value: speed, Limit: params.ReadingSpeed})
+		}
 	}
 	
+	length := item.GetLength()
+	if params.MinLength > 0 && length < params.MinLength {
+		add(Violation{Rule: "min_length", Message: "Duration is too short", Value: length, Limit: params.MinLength})
+	}
+	if params.MaxLength > 0 && length > params.MaxLength {
+		add(Violation{Rule: "max_length", Message: "Duration is too long", Value: length, Limit: params.MaxLength})
+	}
+
+	if params.MinGapFrames > 0 &&
+	   params.FrameRate > 0 &&
+	   i+1 < len(s.Items) {
+		gap := (s.Items[i+1].StartAt - item.EndAt).Seconds()
+		if min_gap := float64(params.MinGapFrames) / params.FrameRate; gap < min_gap {
+			add(Violation{Rule: "min_gap_frames", Message: "Gap to the next subtitle is too short", Value: gap, Limit: min_gap})
+		}
+	}
+
 	if params.LineBalance > 0 &&
-	   len(item.Lines)>1 &&
-	   ( float64(minLen) / float64(maxLen) ) *
-	   100.0 < params.LineBalance {
-		perrs = AddStringIfNotInArray(perrs, "Lines are not in balance");
+	   len(item.Lines)>1 {
+		if balance := ( float64(minLen) / float64(maxLen) ) * 100.0; balance < params.LineBalance {
+			add(Violation{Rule: "line_balance", Message: "Lines are not in balance", Value: balance, Limit: params.LineBalance})
+		}
 	}
 	if params.PreferCompact &&
 	   params.CharsPerLine > 0 &&
 	   len(item.Lines) > 1 &&
 	   plainChars < params.CharsPerLine {
-		perrs = AddStringIfNotInArray(perrs, "Multiple lines unnecessarily used");
+		add(Violation{Rule: "prefer_compact", Message: "Multiple lines unnecessarily used", Value: plainChars, Limit: params.CharsPerLine})
 	}
 	
-	return perrs
+	return violations
 }
 
 
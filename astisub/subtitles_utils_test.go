@@ -0,0 +1,129 @@
+package astisub
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// utilsSRT is a small .srt file
+const utilsSRT = "1\n00:00:01,000 --> 00:00:02,000\nHello there\n\n2\n00:00:03,000 --> 00:00:04,500\nGeneral Kenobi\n"
+
+func TestSniffFormat(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		head     string
+		expected string
+	}{
+		{"srt", utilsSRT, ".srt"},
+		{"srt with a BOM", string(BytesBOM) + utilsSRT, ".srt"},
+		{"vtt", "WEBVTT\n\n00:01.000 --> 00:02.000\nHello\n", ".vtt"},
+		{"vtt after blank lines", "\r\n\n  WEBVTT\n", ".vtt"},
+		{"ass", "[Script Info]\nTitle: ep01\n", ".ass"},
+		{"ttml", `<?xml version="1.0"?><tt xmlns="http://www.w3.org/ns/ttml">`, ".ttml"},
+		{"ttml without declaration", `<tt xmlns="http://www.w3.org/ns/ttml">`, ".ttml"},
+		{"text", "Hello there\n", ""},
+		{"empty", "", ""},
+	} {
+		if got := SniffFormat([]byte(c.head)); got != c.expected {
+			t.Errorf("%s: SniffFormat = %q, expected %q", c.name, got, c.expected)
+		}
+	}
+}
+
+func TestReadFrom(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		input string
+		items int
+		err   error
+	}{
+		{"srt", utilsSRT, 2, nil},
+		{"srt with a BOM", string(BytesBOM) + utilsSRT, 2, nil},
+		// The format is guessed from the start of the content only
+		{"long srt", utilsSRT + strings.Repeat("\n", 2*sniffLength) + "3\n00:00:05,000 --> 00:00:06,000\nBye\n", 3, nil},
+		{"vtt", "WEBVTT\n\n00:01.000 --> 00:02.000\nHello\n", 0, ErrUnsupportedFormat},
+		{"unknown", "Hello there\n", 0, ErrUnknownFormat},
+	} {
+		s, err := ReadFrom(strings.NewReader(c.input))
+		if err != c.err {
+			t.Errorf("%s: error %v, expected %v", c.name, err, c.err)
+			continue
+		}
+		if err == nil && len(s.Items) != c.items {
+			t.Errorf("%s: read %d subtitles, expected %d", c.name, len(s.Items), c.items)
+		}
+	}
+}
+
+func TestWriteToSRT(t *testing.T) {
+	s, err := ReadFrom(strings.NewReader(utilsSRT))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// What is read from a pipe is written back as it was, after a BOM
+	var b bytes.Buffer
+	if err := s.WriteToSRT(&b); err != nil {
+		t.Fatal(err)
+	}
+	if expected := string(BytesBOM) + utilsSRT; b.String() != expected {
+		t.Errorf("wrote %q, expected %q", b.String(), expected)
+	}
+
+	if err := NewSubtitles().WriteToSRT(&b); err != ErrNoSubtitlesToWrite {
+		t.Errorf("writing no subtitles returned %v, expected %v", err, ErrNoSubtitlesToWrite)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	failed := errors.New("disk full")
+
+	for _, c := range []struct {
+		name     string
+		existing bool
+		err      error
+		expected string
+	}{
+		{"new file", false, nil, "new"},
+		{"replaced file", true, nil, "new"},
+		{"failed write", true, failed, "old"},
+		{"failed new file", false, failed, ""},
+	} {
+		dir := t.TempDir()
+		dst := filepath.Join(dir, "ep01.srt")
+		if c.existing {
+			if err := os.WriteFile(dst, []byte("old"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := WriteFileAtomic(dst, func(w io.Writer) error {
+			io.WriteString(w, "new")
+			return c.err
+		})
+		if errors.Cause(err) != c.err {
+			t.Errorf("%s: error %v, expected %v", c.name, err, c.err)
+		}
+
+		// Whatever happens, the file is whole & nothing else is left
+		data, _ := os.ReadFile(dst)
+		if string(data) != c.expected {
+			t.Errorf("%s: file holds %q, expected %q", c.name, data, c.expected)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) > 1 || (c.expected == "" && len(entries) > 0) {
+			t.Errorf("%s: %d files left in the folder", c.name, len(entries))
+		}
+
+		// The mode of the file replaced is kept
+		if fi, err := os.Stat(dst); c.existing && err == nil && runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+			t.Errorf("%s: mode %s, expected %s", c.name, fi.Mode().Perm(), os.FileMode(0600))
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package astisub
+
+// Tracer is told about the decisions made while processing subtitles,
+// when set on Subtitles. Begin and End bracket a step which may hold
+// further steps and decisions. Values are given as name, value pairs.
+// A "split" decision inserts a new subtitle after subtitle i
+type Tracer interface {
+	Begin(i int, step string, values ...interface{})
+	Decide(i int, decision string, values ...interface{})
+	End()
+}
+
+// begin opens a step of the trace, if any
+func (s *Subtitles) begin(i int, step string, values ...interface{}) {
+	if s.Tracer != nil {
+		s.Tracer.Begin(i, step, values...)
+	}
+}
+
+// decide traces a decision about subtitle i, adding its resulting timing
+func (s *Subtitles) decide(i int, decision string, values ...interface{}) {
+	if s.Tracer != nil {
+		s.Tracer.Decide(i, decision, append(values, "timing", TimingString(s.Items[i]))...)
+	}
+}
+
+// end closes the current step of the trace, if any
+func (s *Subtitles) end() {
+	if s.Tracer != nil {
+		s.Tracer.End()
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// backupStampLayout is the time layout used to name backups
+const backupStampLayout = "20060102-150405.000"
+
+// backupEntry is a single timestamped backup of a subtitle file
+type backupEntry struct {
+	Path  string
+	Stamp string
+}
+
+// backupDir returns the directory in which backups of name are kept.
+// Unless -backup_dir is given this is a directory next to the file
+func backupDir(name string, params cliParams) string {
+	if params.BackupDir != "" {
+		return params.BackupDir
+	}
+
+	return filepath.Join(filepath.Dir(name), DefaultBackupDir)
+}
+
+// splitExt splits the base name of name into its stem and extension
+func splitExt(name string) (string, string) {
+	base := filepath.Base(name)
+	ext := filepath.Ext(base)
+
+	return strings.TrimSuffix(base, ext), ext
+}
+
+// backupStem returns the stem & extension the backups of name are
+// named with. A -backup_dir is shared by files of every folder, so the
+// stem then holds a short hash of the absolute path of name, keeping
+// apart the backups of files with the same name
+func backupStem(name string, params cliParams) (string, string) {
+	stem, ext := splitExt(name)
+	if params.BackupDir == "" {
+		return stem, ext
+	}
+
+	if abs, err := filepath.Abs(name); err == nil {
+		name = abs
+	}
+	sum := sha1.Sum([]byte(name))
+
+	return stem + "." + hex.EncodeToString(sum[:4]), ext
+}
+
+// listBackups returns the backups of name, oldest first
+func listBackups(name string, params cliParams) ([]backupEntry, error) {
+	stem, ext := backupStem(name, params)
+	dir := backupDir(name, params)
+
+	matches, err := filepath.Glob(filepath.Join(dir, globEscape(stem)+".*"+globEscape(ext)))
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]backupEntry, 0)
+	for _, match := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), stem+"."), ext)
+		if _, err := time.Parse(backupStampLayout, stamp); err != nil {
+			continue
+		}
+
+		backups = append(backups, backupEntry{Path: match, Stamp: stamp})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Stamp < backups[j].Stamp
+	})
+
+	return backups, nil
+}
+
+// globEscape escapes the glob meta characters in s
+func globEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`)
+	return r.Replace(s)
+}
+
+// copyFile copies src to dst atomically
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return astisub.WriteFileAtomic(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
+
+// backupFile copies name into the backup directory under a timestamped
+// name and returns the path of the backup. Nothing is done if name does
+// not exist yet
+func backupFile(name string, params cliParams) (string, error) {
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		return "", nil
+	}
+
+	dir := backupDir(name, params)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	stem, ext := backupStem(name, params)
+	dst := filepath.Join(dir, stem+"."+time.Now().Format(backupStampLayout)+ext)
+
+	if err := copyFile(name, dst); err != nil {
+		return "", err
+	}
+
+	return dst, nil
+}
+
+// pruneBackups removes the oldest backups of name so that
+// only params.BackupKeep of them remain
+func pruneBackups(name string, params cliParams) error {
+	backups, err := listBackups(name, params)
+	if err != nil {
+		return err
+	}
+
+	for len(backups) > params.BackupKeep {
+		if err := os.Remove(backups[0].Path); err != nil {
+			return err
+		}
+		logger.Debugf("Removed old backup %s", backups[0].Path)
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+// makeBackup keeps a timestamped copy of name before it is overwritten,
+// unless backups were disabled with -backup_keep 0
+func makeBackup(name string, params cliParams) error {
+	if name == "-" || params.BackupKeep <= 0 {
+		return nil
+	}
+
+	path, err := backupFile(name, params)
+	if err != nil {
+		return fmt.Errorf("backing up '%s' failed: %s", name, err)
+	}
+
+	if path != "" {
+		logger.Infof("Saved backup of %s to %s", name, path)
+	}
+
+	if err := pruneBackups(name, params); err != nil {
+		return fmt.Errorf("pruning backups of '%s' failed: %s", name, err)
+	}
+
+	return nil
+}
+
+// RestoreOperation brings back a backup of params.File, the one matching
+// -backup_id or the latest one. The current file is backed up first so a
+// restore can itself be undone
+func RestoreOperation(params cliParams) int {
+	backups, err := listBackups(params.File, params)
+	if err != nil {
+		logger.Errorf("Cannot list backups of '%s': %s", params.File, err)
+		return ExitError
+	}
+
+	if len(backups) == 0 {
+		logger.Errorf("No backups found for '%s' in %s", params.File, backupDir(params.File, params))
+		return ExitError
+	}
+
+	var chosen *backupEntry
+	logger.Infof("Available backups of '%s':", params.File)
+	for i := range backups {
+		logger.Infof("  %s\t%s", backups[i].Stamp, backups[i].Path)
+		if params.BackupId == "" || backups[i].Stamp == params.BackupId {
+			chosen = &backups[i]
+		}
+	}
+
+	if chosen == nil {
+		logger.Errorf("no backup of '%s' with id '%s'", params.File, params.BackupId)
+		return ExitError
+	}
+
+	if params.BackupKeep > 0 {
+		path, err := backupFile(params.File, params)
+		if err != nil {
+			logger.Errorf("Cannot back up '%s' before restoring: %s", params.File, err)
+			return ExitWrite
+		}
+		if path != "" {
+			logger.Infof("Saved backup of %s to %s", params.File, path)
+		}
+	}
+
+	if err := copyFile(chosen.Path, params.File); err != nil {
+		logger.Errorf("Cannot restore %s from %s: %s", params.File, chosen.Path, err)
+		return ExitWrite
+	}
+	logger.Infof("Restored %s from %s", params.File, chosen.Path)
+
+	if params.BackupKeep > 0 {
+		if err := pruneBackups(params.File, params); err != nil {
+			logger.Errorf("Cannot prune backups of '%s': %s", params.File, err)
+			return ExitWrite
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitExt(t *testing.T) {
+	for _, c := range []struct {
+		name, stem, ext string
+	}{
+		{"ep01.srt", "ep01", ".srt"},
+		{"season1/ep01.en.srt", "ep01.en", ".srt"},
+		{"README", "README", ""},
+		{".subfixer.json", ".subfixer", ".json"},
+	} {
+		if stem, ext := splitExt(c.name); stem != c.stem || ext != c.ext {
+			t.Errorf("splitExt(%q) = %q, %q, expected %q, %q", c.name, stem, ext, c.stem, c.ext)
+		}
+	}
+}
+
+func TestBackupDir(t *testing.T) {
+	for _, c := range []struct {
+		name, dir, expected string
+	}{
+		{"season1/ep01.srt", "", filepath.Join("season1", DefaultBackupDir)},
+		{"ep01.srt", "", DefaultBackupDir},
+		{"season1/ep01.srt", "/backups", "/backups"},
+	} {
+		if got := backupDir(c.name, cliParams{BackupDir: c.dir}); got != c.expected {
+			t.Errorf("backupDir(%q, %q) = %q, expected %q", c.name, c.dir, got, c.expected)
+		}
+	}
+}
+
+func TestBackupStem(t *testing.T) {
+	shared := cliParams{BackupDir: "/backups"}
+	a, ext := backupStem("a/ep01.srt", shared)
+	b, _ := backupStem("b/ep01.srt", shared)
+	again, _ := backupStem(filepath.Join("a", ".", "ep01.srt"), shared)
+
+	if a == b || a != again || ext != ".srt" || !strings.HasPrefix(a, "ep01.") {
+		t.Errorf("backups in a shared folder are named %q & %q, & %q again", a+ext, b+ext, again+ext)
+	}
+
+	// Next to each file, the name alone tells the files apart
+	if stem, _ := backupStem("a/ep01.srt", cliParams{}); stem != "ep01" {
+		t.Errorf("backups next to the file are named %q, expected ep01", stem)
+	}
+}
+
+// writeBackups writes a backup of name for each stamp, & returns the
+// folder of the backups
+func writeBackups(t *testing.T, name string, stamps ...string) string {
+	dir := backupDir(name, cliParams{})
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	stem, ext := splitExt(name)
+	for _, stamp := range stamps {
+		if err := os.WriteFile(filepath.Join(dir, stem+"."+stamp+ext), []byte(stamp), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// backupStamps returns the stamps of the backups of name, oldest first
+func backupStamps(t *testing.T, name string, params cliParams) []string {
+	backups, err := listBackups(name, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamps := make([]string, 0, len(backups))
+	for _, b := range backups {
+		stamps = append(stamps, b.Stamp)
+	}
+	return stamps
+}
+
+func TestListBackups(t *testing.T) {
+	name := writeTestFiles(t, "ep[1].srt")[0]
+	dir := writeBackups(t, name, "20240102-100000.000", "20240101-100000.000")
+
+	// Neither other files nor those of other subtitles are backups
+	for _, other := range []string{"ep[1].notes.srt", "ep[1].20240103-100000.000.vtt", "ep1.20240103-100000.000.srt"} {
+		if err := os.WriteFile(filepath.Join(dir, other), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{"20240101-100000.000", "20240102-100000.000"}
+	if got := backupStamps(t, name, cliParams{}); !reflect.DeepEqual(got, expected) {
+		t.Errorf("backups are %v, expected %v", got, expected)
+	}
+}
+
+func TestMakeBackup(t *testing.T) {
+	captureLog(t, LogFormatText)
+
+	for _, c := range []struct {
+		keep     int
+		existing []string
+		expected int
+	}{
+		{keep: 0, existing: []string{"20240101-100000.000"}, expected: 1},
+		{keep: 5, expected: 1},
+		{keep: 2, existing: []string{"20240101-100000.000"}, expected: 2},
+		{keep: 2, existing: []string{"20240101-100000.000", "20240102-100000.000", "20240103-100000.000"}, expected: 2},
+	} {
+		name := writeTestFiles(t, "ep01.srt")[0]
+		writeBackups(t, name, c.existing...)
+		params := cliParams{BackupKeep: c.keep}
+
+		if err := makeBackup(name, params); err != nil {
+			t.Fatal(err)
+		}
+
+		stamps := backupStamps(t, name, params)
+		if len(stamps) != c.expected {
+			t.Errorf("keep %d with %d backups: %d left, expected %d", c.keep, len(c.existing), len(stamps), c.expected)
+			continue
+		}
+
+		// The backup just made is kept, & holds the file
+		if c.keep > 0 {
+			backups, _ := listBackups(name, params)
+			data, err := os.ReadFile(backups[len(backups)-1].Path)
+			if err != nil || string(data) != testSRT {
+				t.Errorf("keep %d: latest backup holds %q, %v", c.keep, data, err)
+			}
+		}
+	}
+
+	// A file which does not exist yet has nothing to back up
+	name := filepath.Join(t.TempDir(), "new.srt")
+	if err := makeBackup(name, cliParams{BackupKeep: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if stamps := backupStamps(t, name, cliParams{}); len(stamps) != 0 {
+		t.Errorf("backups of a new file: %v", stamps)
+	}
+}
+
+func TestRestoreOperation(t *testing.T) {
+	captureLog(t, LogFormatText)
+
+	for _, c := range []struct {
+		id       string
+		expected int
+		content  string
+	}{
+		{"", ExitOK, "20240102-100000.000"},
+		{"20240101-100000.000", ExitOK, "20240101-100000.000"},
+		{"20230101-100000.000", ExitError, testSRT},
+	} {
+		name := writeTestFiles(t, "ep01.srt")[0]
+		writeBackups(t, name, "20240101-100000.000", "20240102-100000.000")
+		params := cliParams{BackupKeep: 5, BackupId: c.id}
+		params.File = name
+
+		if code := RestoreOperation(params); code != c.expected {
+			t.Errorf("restore %q: exit %d, expected %d", c.id, code, c.expected)
+		}
+
+		data, err := os.ReadFile(name)
+		if err != nil || string(data) != c.content {
+			t.Errorf("restore %q: file holds %q, %v, expected %q", c.id, data, err, c.content)
+		}
+
+		// The file restored over is backed up first
+		if stamps := backupStamps(t, name, params); c.expected == ExitOK && len(stamps) != 3 {
+			t.Errorf("restore %q: %d backups left, expected 3", c.id, len(stamps))
+		}
+	}
+
+	params := cliParams{}
+	params.File = writeTestFiles(t, "ep01.srt")[0]
+	if code := RestoreOperation(params); code != ExitError {
+		t.Errorf("restore without backups: exit %d, expected %d", code, ExitError)
+	}
+}
+
+func TestSharedBackupDir(t *testing.T) {
+	captureLog(t, LogFormatText)
+
+	files := writeTestFiles(t, "a/ep01.srt", "b/ep01.srt")
+	params := cliParams{BackupDir: filepath.Join(t.TempDir(), "backups"), BackupKeep: 1}
+	for _, name := range files {
+		if err := os.WriteFile(name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := makeBackup(name, params); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Pruning the backups of one file leaves those of the other alone,
+	// & each restores its own
+	for _, name := range files {
+		if stamps := backupStamps(t, name, params); len(stamps) != 1 {
+			t.Errorf("%s: %d backups, expected 1", name, len(stamps))
+		}
+
+		if err := os.WriteFile(name, []byte(testSRT), 0644); err != nil {
+			t.Fatal(err)
+		}
+		params.File = name
+		if code := RestoreOperation(params); code != ExitOK {
+			t.Errorf("%s: restore exited with %d", name, code)
+		}
+		if data, _ := os.ReadFile(name); string(data) != name {
+			t.Errorf("%s: restored %q", name, data)
+		}
+	}
+}
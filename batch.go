@@ -0,0 +1,328 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+	"github.com/chetan-prime/subfixer/fixer"
+)
+
+// DefaultJobs is the number of files processed at once
+const DefaultJobs = 1
+
+// fileResult is the outcome of processing one file of a batch
+type fileResult struct {
+	File    string
+	Code    int
+	Skipped bool
+	Changes string
+	Diff    []byte
+	Elapsed time.Duration
+	Tally   *tally
+}
+
+// batch runs an operation over a list of files
+type batch struct {
+	params  cliParams
+	writes  bool
+	op      fileOperation
+	files   []string
+	limits  fixer.Limits
+	journal *journal
+	failed  int32
+}
+
+// run processes the files with a pool of -jobs workers and returns
+// their results in the order of the files. With -fail_fast no file
+// is started once one has failed
+func (b *batch) run() []fileResult {
+	results := make([]fileResult, len(b.files))
+	for n, fname := range b.files {
+		results[n] = fileResult{File: fname, Skipped: true}
+	}
+
+	jobs := b.params.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	if jobs > len(b.files) {
+		jobs = len(b.files)
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range next {
+				// The file may have been handed over while another was
+				// still failing
+				if b.params.FailFast && atomic.LoadInt32(&b.failed) != 0 {
+					continue
+				}
+
+				start := time.Now()
+				r := b.runFile(b.files[n])
+				r.Elapsed = time.Since(start)
+				results[n] = r
+
+				if r.Code != ExitOK && r.Code != ExitChanges {
+					atomic.StoreInt32(&b.failed, 1)
+				}
+			}
+		}()
+	}
+
+	for n := range b.files {
+		if b.params.FailFast && atomic.LoadInt32(&b.failed) != 0 {
+			break
+		}
+		next <- n
+	}
+
+	close(next)
+	wg.Wait()
+
+	return results
+}
+
+// runFile opens, processes and saves one file of the batch
+func (b *batch) runFile(fname string) fileResult {
+	r := fileResult{File: fname}
+
+	params := b.params
+	params.File = fname
+
+	log := logger
+	if len(b.files) > 1 {
+		log = logger.With("file", fname)
+	}
+
+	// Every file of the batch is recorded as part of the same run
+	var j *journal
+	if b.journal != nil {
+		fj := *b.journal
+		j = &fj
+	}
+	r.Tally = newTally(j)
+
+	if b.writes && isZipEntry(fname) {
+		params.Out = zipOutput(fname, params)
+	} else if b.writes {
+		var err error
+		params.Out, err = prepareOutput(fname, b.params.File, b.files, params)
+		if err != nil {
+			log.Errorf("%s", err)
+			// Refusing to overwrite an input is a usage error, failing to
+			// create the folder of the output a write error
+			r.Code = ExitUsage
+			if _, ok := err.(*os.PathError); ok {
+				r.Code = ExitWrite
+			}
+			return r
+		}
+	}
+
+	if params.Stream {
+		log.Infof("Performing %s in streaming mode on '%s'", params.Mode, fname)
+		r.Code = StreamOperation(params, b.limits, j, r.Tally, log)
+		return r
+	}
+
+	// Open
+	s, err := openSubtitles(fname)
+	if err != nil {
+		log.Errorf("Cannot open file '%s': %s", fname, err)
+		r.Code = openExitCode(err)
+		return r
+	}
+
+	fixer.Mark(s, b.limits, log)
+	s.Recorder = r.Tally
+
+	var before []cue
+	if params.DryRun || (params.ReportHTML != "" && b.writes) {
+		before = snapshot(s)
+	}
+
+	log.Infof("Performing %s on '%s'", params.Mode, fname)
+	r.Code = b.op(s, params, log)
+
+	// A failed check is what the report is for, so it is written too
+	if params.ReportHTML != "" && !isErrorCode(r.Code) {
+		path := htmlReportPath(fname, params)
+		if err := writeHTMLReport(path, s, params, before, log); err != nil {
+			log.Errorf("Cannot write report '%s': %s", path, err)
+			r.Code = ExitWrite
+			return r
+		}
+		log.Infof("Wrote report of '%s' to %s", fname, path)
+	}
+
+	if r.Code != 0 {
+		return r
+	}
+
+	dst := params.Out
+	if dst == "" {
+		dst = fname
+	}
+
+	// Entries of archives cannot be undone, so are not journaled
+	if j != nil && !isZipEntry(dst) {
+		if err := j.save(dst, params); err != nil {
+			log.Errorf("Cannot write journal of '%s': %s", dst, err)
+			r.Code = ExitWrite
+			return r
+		}
+	}
+
+	if params.DryRun {
+		if dst == "-" {
+			dst = fname
+		}
+
+		var diff bytes.Buffer
+		counts := writeDiff(&diff, fname, dst, before, snapshot(s))
+		r.Diff = diff.Bytes()
+		r.Changes = formatCounts(counts)
+		log.Infof("Changes to '%s': %s", fname, r.Changes)
+
+		// Lets CI insist on files which are already fixed
+		if len(counts) > 0 {
+			r.Code = ExitChanges
+		}
+	}
+
+	return r
+}
+
+// outcome describes the result of a file in the summary
+func (r fileResult) outcome() string {
+	if r.Skipped {
+		return "skipped"
+	}
+
+	switch r.Code {
+	case ExitOK:
+		return "ok"
+	case ExitError:
+		return "error"
+	case ExitUsage:
+		return "usage error"
+	case ExitParse:
+		return "parse error"
+	case ExitWrite:
+		return "write error"
+	case ExitCheckFailed:
+		return "check failed"
+	case ExitChanges:
+		return "changes"
+	}
+
+	return fmt.Sprintf("exit %d", r.Code)
+}
+
+// batchExitCode sums up the exit codes of the files. When some files
+// failed with an error & others did not, the batch partly failed.
+// Otherwise an error wins, the same for all files or ExitError, then a
+// failed check, then changes found by a dry run
+func batchExitCode(results []fileResult) int {
+	processed, failed := 0, 0
+	code := ExitOK
+
+	for _, r := range results {
+		if r.Skipped {
+			continue
+		}
+		processed++
+
+		switch {
+		case isErrorCode(r.Code):
+			if failed > 0 && r.Code != code {
+				code = ExitError
+			} else {
+				code = r.Code
+			}
+			failed++
+		case failed > 0:
+		case r.Code == ExitCheckFailed, r.Code == ExitChanges && code == ExitOK:
+			code = r.Code
+		}
+	}
+
+	if failed > 0 && failed < processed {
+		return ExitPartial
+	}
+
+	return code
+}
+
+// logSummary logs the result of every file of a batch, as a table in
+// text or as one message per file in JSON, followed by the totals
+func logSummary(results []fileResult) {
+	counts := make(map[string]int)
+	var order []string
+
+	for _, r := range results {
+		o := r.outcome()
+		if counts[o] == 0 {
+			order = append(order, o)
+		}
+		counts[o]++
+	}
+
+	var totals []string
+	for _, o := range order {
+		totals = append(totals, fmt.Sprintf("%d %s", counts[o], o))
+	}
+
+	if logger.out.format == LogFormatJSON {
+		for _, r := range results {
+			logger.Log(astisub.LevelInfo, "Result",
+				"file", r.File,
+				"result", r.outcome(),
+				"exit", r.Code,
+				"seconds", r.Elapsed.Seconds(),
+				"changes", r.Changes)
+		}
+
+		fields := []interface{}{"files", len(results)}
+		for _, o := range order {
+			fields = append(fields, o, counts[o])
+		}
+		logger.Log(astisub.LevelInfo, "Summary", fields...)
+		return
+	}
+
+	var table bytes.Buffer
+	tw := tabwriter.NewWriter(&table, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "FILE\tRESULT\tTIME\tCHANGES\n")
+	for _, r := range results {
+		elapsed := ""
+		if !r.Skipped {
+			elapsed = r.Elapsed.Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.File, r.outcome(), elapsed, r.Changes)
+	}
+	tw.Flush()
+
+	logger.Infof("")
+	for _, line := range strings.Split(strings.TrimRight(table.String(), "\n"), "\n") {
+		logger.Infof("%s", line)
+	}
+	logger.Infof("Processed %d files: %s", len(results), strings.Join(totals, ", "))
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// testSRT is a small valid file for the tests which need one on disk
+const testSRT = `1
+00:00:01,000 --> 00:00:03,000
+Hello there
+
+2
+00:00:04,000 --> 00:00:06,000
+General Kenobi
+`
+
+// writeTestFiles writes testSRT to each of names in a temporary folder
+// & returns their paths
+func writeTestFiles(t *testing.T, names ...string) []string {
+	dir := t.TempDir()
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(testSRT), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// captureLog sends the messages of the logger to the returned buffer,
+// in format, until the end of the test
+func captureLog(t *testing.T, format string) *bytes.Buffer {
+	var buf bytes.Buffer
+	out := logger.out
+	logger.out = &logOutput{level: astisub.LevelInfo, format: format, w: &buf}
+	t.Cleanup(func() { logger.out = out })
+	return &buf
+}
+
+// commandParams returns the settings of command given args, starting
+// from its defaults as the command line does
+func commandParams(t *testing.T, command string, args ...string) cliParams {
+	var params cliParams
+	fs := newFlagSet(findCommand(command), &params)
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	params.Mode = command
+	return params
+}
+
+func TestBatchRun(t *testing.T) {
+	captureLog(t, LogFormatText)
+	files := writeTestFiles(t, "a.srt", "b.srt", "c.srt")
+	codes := map[string]int{files[0]: ExitOK, files[1]: ExitCheckFailed, files[2]: ExitOK}
+
+	for _, c := range []struct {
+		name     string
+		jobs     int
+		failFast bool
+		expected []string
+	}{
+		{"sequential", 1, false, []string{"ok", "check failed", "ok"}},
+		{"parallel", 3, false, []string{"ok", "check failed", "ok"}},
+		{"more jobs than files", 8, false, []string{"ok", "check failed", "ok"}},
+		{"fail fast", 1, true, []string{"ok", "check failed", "skipped"}},
+	} {
+		b := &batch{
+			params: cliParams{CommandParams: astisub.CommandParams{File: filepath.Dir(files[0]), Mode: "check"}, Jobs: c.jobs, FailFast: c.failFast},
+			files:  files,
+			op: func(s *astisub.Subtitles, params cliParams, log *leveledLogger) int {
+				return codes[params.File]
+			},
+		}
+
+		results := b.run()
+		for n, r := range results {
+			if r.File != files[n] {
+				t.Errorf("%s: result %d is for '%s', expected '%s'", c.name, n, r.File, files[n])
+			}
+			if r.outcome() != c.expected[n] {
+				t.Errorf("%s: '%s' is %s, expected %s", c.name, filepath.Base(r.File), r.outcome(), c.expected[n])
+			}
+		}
+	}
+}
+
+func TestBatchRunMissingFile(t *testing.T) {
+	captureLog(t, LogFormatText)
+	files := writeTestFiles(t, "a.srt")
+	files = append(files, filepath.Join(filepath.Dir(files[0]), "missing.srt"))
+
+	b := &batch{
+		params: cliParams{CommandParams: astisub.CommandParams{Mode: "check"}, Jobs: 2},
+		files:  files,
+		op: func(s *astisub.Subtitles, params cliParams, log *leveledLogger) int {
+			return ExitOK
+		},
+	}
+
+	results := b.run()
+	if results[0].Code != ExitOK || results[1].Code != ExitError {
+		t.Errorf("codes are %d & %d, expected %d & %d", results[0].Code, results[1].Code, ExitOK, ExitError)
+	}
+}
+
+func TestBatchLogsFile(t *testing.T) {
+	buf := captureLog(t, LogFormatJSON)
+	files := writeTestFiles(t, "a.srt", "b.srt")
+	files = append(files, filepath.Join(filepath.Dir(files[0]), "missing.srt"))
+
+	b := &batch{
+		params: cliParams{CommandParams: astisub.CommandParams{Mode: "check"}, Jobs: 3},
+		files:  files,
+		op: func(s *astisub.Subtitles, params cliParams, log *leveledLogger) int {
+			log.Warnf("Something about a subtitle")
+			return ExitCheckFailed
+		},
+	}
+	b.run()
+
+	// Every message about one file of a batch must name it, as the
+	// messages of files processed at once are interleaved
+	lines := 0
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var m map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			t.Fatalf("invalid JSON log line %q: %s", scanner.Text(), err)
+		}
+		if _, ok := m["file"]; !ok {
+			t.Errorf("message %q has no file field", m["msg"])
+		}
+		lines++
+	}
+
+	if lines < len(files) {
+		t.Errorf("expected at least %d messages, got %d", len(files), lines)
+	}
+}
+
+func TestFileResultOutcome(t *testing.T) {
+	for _, c := range []struct {
+		result   fileResult
+		expected string
+	}{
+		{fileResult{Code: ExitOK}, "ok"},
+		{fileResult{Code: ExitOK, Skipped: true}, "skipped"},
+		{fileResult{Code: ExitError}, "error"},
+		{fileResult{Code: ExitUsage}, "usage error"},
+		{fileResult{Code: ExitParse}, "parse error"},
+		{fileResult{Code: ExitWrite}, "write error"},
+		{fileResult{Code: ExitCheckFailed}, "check failed"},
+		{fileResult{Code: ExitChanges}, "changes"},
+		{fileResult{Code: 42}, "exit 42"},
+	} {
+		if got := c.result.outcome(); got != c.expected {
+			t.Errorf("outcome of %+v = %q, expected %q", c.result, got, c.expected)
+		}
+	}
+}
+
+func TestLogSummary(t *testing.T) {
+	results := []fileResult{
+		{File: "a.srt", Code: ExitOK, Changes: "2 split", Elapsed: 1500 * time.Microsecond},
+		{File: "b.srt", Code: ExitCheckFailed, Elapsed: 2 * time.Millisecond},
+		{File: "c.srt", Code: ExitOK, Elapsed: time.Millisecond},
+		{File: "d.srt", Skipped: true},
+	}
+
+	buf := captureLog(t, LogFormatText)
+	logSummary(results)
+	for _, expected := range []string{
+		"FILE   RESULT        TIME  CHANGES",
+		"a.srt  ok            2ms   2 split",
+		"d.srt  skipped",
+		"Processed 4 files: 2 ok, 1 check failed, 1 skipped",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("%q missing from the summary\n%s", expected, buf.String())
+		}
+	}
+
+	buf = captureLog(t, LogFormatJSON)
+	logSummary(results)
+	var last map[string]interface{}
+	lines := 0
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			t.Fatalf("invalid JSON log line %q: %s", scanner.Text(), err)
+		}
+		lines++
+	}
+	if lines != len(results)+1 {
+		t.Errorf("%d messages, expected one per file & the totals", lines)
+	}
+	for name, expected := range map[string]interface{}{"msg": "Summary", "files": 4.0, "ok": 2.0, "check failed": 1.0, "skipped": 1.0} {
+		if last[name] != expected {
+			t.Errorf("totals: %s is %v, expected %v", name, last[name], expected)
+		}
+	}
+}
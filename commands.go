@@ -0,0 +1,981 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+	"github.com/chetan-prime/subfixer/fixer"
+)
+
+// cliParams are the settings of a command: those the fixing & checking
+// functions of astisub take, and those of subfixer itself
+type cliParams struct {
+	astisub.CommandParams
+
+	// Inputs & outputs
+	Out         string
+	OutDir      string
+	OutTemplate string
+	Overwrite   bool
+	Stream      bool
+	Include     string
+	Exclude     string
+	Lang        string
+	List        bool
+	StdinName   string
+
+	// Backups, journal, undo & dry runs
+	BackupDir  string
+	BackupKeep int
+	BackupId   string
+	Journal    string
+	Run        string
+	Rules      string
+	DryRun     bool
+	Patch      string
+
+	// Settings of single commands
+	ShiftBy     time.Duration
+	Cues        []astisub.RangeStruct
+	Neighbours  int
+	Interactive bool
+	GitDiff     string
+	Plugins     string
+
+	// Where the settings come from
+	Profile string
+	Config  string
+	Preset  string
+
+	// Logging, batches & what is written about them
+	Quiet       bool
+	Verbose     bool
+	VeryVerbose bool
+	LogFormat   string
+	Jobs        int
+	FailFast    bool
+	Result      string
+	SummaryJSON string
+	ReportHTML  string
+
+	// watch
+	Pipeline    string
+	Interval    time.Duration
+	Settle      time.Duration
+	Once        bool
+	DoneDir     string
+	FailedDir   string
+	RejectedDir string
+	State       string
+
+	// serve
+	Addr    string
+	MaxBody int64
+	Timeout time.Duration
+}
+
+// command is a subcommand of subfixer with its own flags. Only those
+// taking Words accept arguments other than flags, e.g. "config show"
+type command struct {
+	Name     string
+	Summary  string
+	NoInput  bool
+	Words    bool
+	Flags    func(fs *flag.FlagSet, p *cliParams)
+	Validate func(p cliParams) error
+	Run      func(inv *invocation) int
+}
+
+// invocation is a parsed command line for a command
+type invocation struct {
+	Params  cliParams
+	Args    []string
+	Flags   *flag.FlagSet
+	Sources map[string]string
+}
+
+// commands lists every subcommand in the order shown in the help
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			Name:    "fix",
+			Summary: "Adjust durations, join, split, expand & shrink subtitles (was -mode normal)",
+			Flags: func(fs *flag.FlagSet, p *cliParams) {
+				inputFlags(fs, p)
+				batchFlags(fs, p)
+				outputFlags(fs, p)
+				backupFlags(fs, p)
+				limitFlags(fs, p)
+				timingFlags(fs, p)
+				minLengthFlag(fs, p, DefaultMinLength)
+				countingFlags(fs, p)
+				dryRunFlags(fs, p)
+				journalFlags(fs, p)
+				fs.BoolVar(&p.Stream, "stream", false, "Process the file as a stream, keeping only a few subtitles in memory")
+				fs.BoolVar(&p.Interactive, "interactive", false, "Review each proposed change, accepting, rejecting or editing it")
+				pluginFlags(fs, p)
+				reportFlags(fs, p)
+			},
+			Validate: validateFix,
+			Run: func(inv *invocation) int {
+				if inv.Params.Interactive {
+					return runFiles(inv.Params, true, InteractiveOperation)
+				}
+				return runFiles(inv.Params, true, NormalOperation)
+			},
+		},
+		{
+			Name:    "check",
+			Summary: "Perform the perfection check without changing anything (was -mode perfection)",
+			Flags: func(fs *flag.FlagSet, p *cliParams) {
+				inputFlags(fs, p)
+				batchFlags(fs, p)
+				limitFlags(fs, p)
+				checkFlags(fs, p)
+				minLengthFlag(fs, p, 0)
+				countingFlags(fs, p)
+				fs.BoolVar(&p.Stream, "stream", false, "Process the file as a stream, keeping only a few subtitles in memory")
+				gitDiffFlags(fs, p)
+				pluginFlags(fs, p)
+				reportFlags(fs, p)
+			},
+			Validate: validateCheck,
+			Run: func(inv *invocation) int {
+				if inv.Params.GitDiff != "" {
+					return runFiles(inv.Params, false, GitDiffOperation)
+				}
+				return runFiles(inv.Params, false, PerfectionOperation)
+			},
+		},
+		{
+			Name:    "overlap",
+			Summary: "Only remove overlaps between consecutive subtitles",
+			Flags: func(fs *flag.FlagSet, p *cliParams) {
+				inputFlags(fs, p)
+				batchFlags(fs, p)
+				outputFlags(fs, p)
+				backupFlags(fs, p)
+				dryRunFlags(fs, p)
+				journalFlags(fs, p)
+				fs.BoolVar(&p.Stream, "stream", false, "Process the file as a stream, keeping only a few subtitles in memory")
+			},
+			Validate: validateOutput,
+			Run: func(inv *invocation) int {
+				return runFiles(inv.Params, true, OverlapOperation)
+			},
+		},
+		{
+			Name:    "shift",
+			Summary: "Shift all subtitles by a duration",
+			Flags: func(fs *flag.FlagSet, p *cliParams) {
+				inputFlags(fs, p)
+				batchFlags(fs, p)
+				outputFlags(fs, p)
+				backupFlags(fs, p)
+				dryRunFlags(fs, p)
+				fs.Var(&durationFlag{&p.ShiftBy}, "by", "Duration to shift by, negative to shift back (1.5s, -250ms, 00:00:01,500) (Required)")
+			},
+			Validate: func(p cliParams) error {
+				if p.ShiftBy == 0 {
+					return errors.New("A non zero -by duration is required")
+				}
+				return validateOutput(p)
+			},
+			Run: func(inv *invocation) int {
+				return runFiles(inv.Params, true, ShiftOperation)
+			},
+		},
+		{
+			Name:    "convert",
+			Summary: "Write subtitles to another file, in the format of its extension",
+			Flags: func(fs *flag.FlagSet, p *cliParams) {
+				inputFlags(fs, p)
+				batchFlags(fs, p)
+				outputFlags(fs, p)
+			},
+			Validate: func(p cliParams) error {
+				if p.Out == "" && p.OutDir == "" {
+					return errors.New("One of -out OR -out_dir is required")
+				}
+				return validateOutput(p)
+			},
+			Run: func(inv *invocation) int {
+				return runFiles(inv.Params, true, ConvertOperation)
+			},
+		},
+		{
+			Name:    "stats",
+			Summary: "Print statistics on durations, reading speeds & gaps",
+			Flags: func(fs *flag.FlagSet, p *cliParams) {
+				inputFlags(fs, p)
+				limitFlags(fs, p)
+				countingFlags(fs, p)
+				fs.Float64Var(&p.ReadingSpeed, "reading_speed", DefaultReadingSpeed, "Reading Speed (ch/sec) to count subtitles above")
+			},
+			Run: func(inv *invocation) int {
+				return runFiles(inv.Params, false, StatsOperation)
+			},
+		},
+		{
+			Name:    "restore",
+			Summary: "Bring back a backup made by fix, overlap or shift",
+			Flags: func(fs *flag.FlagSet, p *cliParams) {
+				inputFlags(fs, p)
+				backupFlags(fs, p)
+				fs.StringVar(&p.BackupId, "backup_id", "", "Backup to bring back (default: latest)")
+			},
+			Run: func(inv *invocation) int {
+				return restoreFiles(inv.Params)
+			},
+		},
+		{
+			Name:    "undo",
+			Summary: "Revert the changes of a fix or overlap run recorded in the journal",
+			Flags: func(fs *flag.FlagSet, p *cliParams) {
+				inputFlags(fs, p)
+				batchFlags(fs, p)
+				outputFlags(fs, p)
+				backupFlags(fs, p)
+				journalFlags(fs, p)
+				undoFlags(fs, p)
+			},
+			Validate: validateOutput,
+			Run: func(inv *invocation) int {
+				return runFiles(inv.Params, true, UndoOperation)
+			},
+		},
+		{
+			Name:    "explain",
+			Summary: "Show why fix would change a subtitle & its neighbours, decision by decision",
+			Flags: func(fs *flag.FlagSet, p *cliParams) {
+				inputFlags(fs, p)
+				timingFlags(fs, p)
+				minLengthFlag(fs, p, DefaultMinLength)
+				countingFlags(fs, p)
+				explainFlags(fs, p)
+			},
+			Validate: validateExplain,
+			Run: func(inv *invocation) int {
+				return runFiles(inv.Params, false, ExplainOperation)
+			},
+		},
+		{
+			Name:    "watch",
+			Summary: "Watch a folder, fixing & checking files dropped into it & moving them to done, failed or rejected",
+			Flags: func(fs *flag.FlagSet, p *cliParams) {
+				inputFlags(fs, p)
+				backupFlags(fs, p)
+				timingFlags(fs, p)
+				minLengthFlag(fs, p, DefaultMinLength)
+				checkFlags(fs, p)
+				countingFlags(fs, p)
+				watchFlags(fs, p)
+				pluginFlags(fs, p)
+			},
+			Validate: validateWatch,
+			Run: func(inv *invocation) int {
+				return WatchOperation(inv.Params, inv.Sources)
+			},
+		},
+		{
+			Name:    "serve",
+			Summary: "Serve fix & check as a local HTTP API for editors & other tools",
+			NoInput: true,
+			Flags: func(fs *flag.FlagSet, p *cliParams) {
+				requestFlags(fs, p)
+				serveFlags(fs, p)
+			},
+			Validate: validateServe,
+			Run:      ServeOperation,
+		},
+		{
+			Name:    "lsp",
+			Summary: "Speak the Language Server Protocol on stdio, checking subtitle files as they are edited",
+			NoInput: true,
+			Flags:   checkRequestFlags,
+			Run:     LspOperation,
+		},
+		{
+			Name:    "run",
+			Summary: "Run the job described by a manifest, with settings, modes & outputs for each input (run job.json)",
+			NoInput: true,
+			Words:   true,
+			Flags: func(fs *flag.FlagSet, p *cliParams) {
+				requestFlags(fs, p)
+				backupFlags(fs, p)
+				journalFlags(fs, p)
+				pluginFlags(fs, p)
+				fs.StringVar(&p.Result, "result", "", "Where to write the result of the job (default: <manifest>.result.json)")
+			},
+			Run: RunOperation,
+		},
+		{
+			Name:    "config",
+			Summary: "Show the effective settings & where each one comes from (config show)",
+			NoInput: true,
+			Words:   true,
+			Flags:   allFlags,
+			Run: func(inv *invocation) int {
+				if len(inv.Args) == 0 || inv.Args[0] != "show" {
+					logger.Errorf("usage is 'config show [-profile name] [flags]'")
+					return ExitUsage
+				}
+				return ConfigOperation(inv.Flags, inv.Sources)
+			},
+		},
+		{
+			Name:    "preset",
+			Summary: "List, show & compare the built-in style guide presets (preset list|show|diff)",
+			NoInput: true,
+			Words:   true,
+			Flags:   func(fs *flag.FlagSet, p *cliParams) {},
+			Run: func(inv *invocation) int {
+				return PresetOperation(inv.Args)
+			},
+		},
+	}
+}
+
+// allFlags registers the flags of every command, for config show
+func allFlags(fs *flag.FlagSet, p *cliParams) {
+	inputFlags(fs, p)
+	outputFlags(fs, p)
+	backupFlags(fs, p)
+	limitFlags(fs, p)
+	timingFlags(fs, p)
+	minLengthFlag(fs, p, DefaultMinLength)
+	checkFlags(fs, p)
+	countingFlags(fs, p)
+	dryRunFlags(fs, p)
+	fs.BoolVar(&p.Stream, "stream", false, "Process the file as a stream, keeping only a few subtitles in memory")
+	fs.Var(&durationFlag{&p.ShiftBy}, "by", "Duration to shift by, negative to shift back (1.5s, -250ms, 00:00:01,500)")
+	fs.StringVar(&p.BackupId, "backup_id", "", "Backup to bring back (default: latest)")
+	journalFlags(fs, p)
+	undoFlags(fs, p)
+	batchFlags(fs, p)
+	fs.BoolVar(&p.Interactive, "interactive", false, "Review each proposed change, accepting, rejecting or editing it")
+	fs.IntVar(&p.Neighbours, "neighbours", DefaultNeighbours, "No. of subtitles to explain on each side of -cue")
+	gitDiffFlags(fs, p)
+	pluginFlags(fs, p)
+	reportFlags(fs, p)
+	watchFlags(fs, p)
+	serveFlags(fs, p)
+	fs.StringVar(&p.Result, "result", "", "Where to write the result of the job (default: <manifest>.result.json)")
+}
+
+// inputFlags registers the flags selecting input files
+func inputFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.StringVar(&p.File, "file", "", "Subtitle Input File, glob, directory or ** pattern, - for stdin (Required)")
+	fs.StringVar(&p.Include, "include", "", "Only process files matching these globs (*.en.srt,season1/**)")
+	fs.StringVar(&p.Exclude, "exclude", "", "Skip files matching these globs (*.forced.srt,extras/**)")
+	fs.StringVar(&p.Lang, "lang", "", "Only process files with these language tags in their name (en,pt-BR)")
+	fs.BoolVar(&p.List, "list", false, "Print the files which would be processed & exit")
+}
+
+// batchFlags registers the flags controlling how a batch of files is run
+func batchFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.IntVar(&p.Jobs, "jobs", DefaultJobs, "No. of files to process at once")
+	fs.BoolVar(&p.FailFast, "fail_fast", false, "Stop starting new files once one has failed")
+	fs.StringVar(&p.SummaryJSON, "summary_json", "", "Write a JSON summary of the outcome of each file to this file")
+}
+
+// outputFlags registers the flags selecting where results are written
+func outputFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.StringVar(&p.Out, "out", "", "Subtitle Output File, - for stdout (default: same as input)")
+	fs.StringVar(&p.OutDir, "out_dir", "", "Output Directory, mirrors the directories of the inputs")
+	fs.StringVar(&p.OutTemplate, "out_template", DefaultOutTemplate, "File name template for -out_dir ({name}, {base}, {lang}, {ext})")
+	fs.BoolVar(&p.Overwrite, "overwrite", false, "Allow -out / -out_dir to overwrite input files")
+}
+
+// backupFlags registers the flags controlling backups
+func backupFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.StringVar(&p.BackupDir, "backup_dir", "", "Directory for backups (default: "+DefaultBackupDir+" next to each file)")
+	fs.IntVar(&p.BackupKeep, "backup_keep", DefaultBackupKeep, "No. of backups to keep per file, 0 disables backups")
+}
+
+// dryRunFlags registers the flags previewing changes instead of saving them
+func dryRunFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.BoolVar(&p.DryRun, "dry_run", false, "Print a diff of the changes instead of saving them, exit with 11 if there are any")
+	fs.StringVar(&p.Patch, "patch", "", "With -dry_run, write the diff to this patch file instead of stdout")
+}
+
+// journalFlags registers the flag locating the journal of changes
+func journalFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.StringVar(&p.Journal, "journal", "", "Journal of changes (default: <file>.journal.jsonl in the backup directory, '"+JournalOff+"' to disable)")
+}
+
+// undoFlags registers the flags selecting the changes to undo
+func undoFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.StringVar(&p.Run, "run", "", "Run to revert (default: latest)")
+	fs.StringVar(&p.Rules, "rule", "", "Only revert changes made by these rules (split,shift_unfit,...)")
+	fs.Var(&limitFlag{&p.Cues}, "cue", "Only revert changes to these subtitle id's as recorded (1-2,4-10,18) or times")
+}
+
+// explainFlags registers the flags selecting the subtitles to explain
+func explainFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.Var(&limitFlag{&p.Cues}, "cue", "Subtitle id to explain (Required)")
+	fs.IntVar(&p.Neighbours, "neighbours", DefaultNeighbours, "No. of subtitles to explain on each side of -cue")
+}
+
+// watchFlags registers the flags of the watch command
+func watchFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.StringVar(&p.Pipeline, "pipeline", DefaultPipeline, "Steps to run on each file, of fix, overlap & check")
+	fs.DurationVar(&p.Interval, "interval", DefaultWatchInterval, "How often to look for new or changed files")
+	fs.DurationVar(&p.Settle, "settle", DefaultWatchSettle, "How long a file must stay unchanged before it is processed")
+	fs.BoolVar(&p.Once, "once", false, "Exit once no file is left waiting instead of watching forever")
+	fs.StringVar(&p.DoneDir, "done_dir", "", "Folder for files which passed (default: "+WatchDone+" in the watched folder)")
+	fs.StringVar(&p.FailedDir, "failed_dir", "", "Folder for files which failed the check (default: "+WatchFailed+" in the watched folder)")
+	fs.StringVar(&p.RejectedDir, "rejected_dir", "", "Folder for files which cannot be read (default: "+WatchRejected+" in the watched folder)")
+	fs.StringVar(&p.State, "state", "", "File recording the files being processed (default: "+DefaultWatchState+" in the watched folder)")
+}
+
+// gitDiffFlags registers -git_diff & the path it uses for stdin
+func gitDiffFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.Var(&gitDiffFlag{&p.GitDiff}, "git_diff", "Only check subtitles changed since a git revision, "+DefaultGitRev+" if given alone (-git_diff main)")
+	fs.StringVar(&p.StdinName, "stdin_name", "", "Path in the git repository of the subtitles read with -file -, such as a staged file")
+}
+
+// pluginFlags registers -plugins. Plugins are programs, so none is run
+// unless asked for
+func pluginFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.StringVar(&p.Plugins, "plugins", PluginsOff, "Plugins from the config files to run: "+PluginsAll+", "+PluginsOff+" or a list of names (no-and,glossary)")
+}
+
+// limitFlags registers -limit_to
+func limitFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.Var(&limitFlag{&p.LimitTo}, "limit_to", "Limit to range or list of subtitle id's (1-2,4-10,14-16,18) or times (00:01:00.000-00:02:00.000)")
+}
+
+// timingFlags registers the flags used when fixing subtitles, but for
+// -min_length which fix & check register with their own defaults
+func timingFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.Float64Var(&p.Speed, "speed", DefaultReadingSpeed, "Desired Characters Per Second")
+	fs.Float64Var(&p.SpeedEpsilon, "speed_epsilon", DefaultSpeedEpsilon, "Epsilon in % of Speed value")
+	fs.IntVar(&p.TrimSpaces, "trim_spaces", DefaultTrimSpaces, "Trim space to left & right of each subtitle")
+	fs.IntVar(&p.JoinShorterThan, "join_shorter_than", DefaultJoinShorterThan, "Join two lines shorter in length than")
+	fs.Float64Var(&p.ExpandCloserThan, "expand_closer_than", DefaultExpandCloserThan, "Expand two subtitles closer than n seconds")
+	fs.Float64Var(&p.SplitLongerThan, "split_longer_than", DefaultSplitLongerThan, "Proportionately split a two line subtitle longer than n seconds")
+	fs.Float64Var(&p.ShrinkLongerThan, "shrink_longer_than", DefaultShrinkLongerThan, "Shrink a single line subtitle longer than n seconds")
+}
+
+// minLengthFlag registers -min_length, which fix enforces & check verifies.
+// check leaves it off by default, as it did before it verified durations
+func minLengthFlag(fs *flag.FlagSet, p *cliParams, value float64) {
+	usage := "Minimum Length for each subtitle"
+	if value == 0 {
+		usage += ", 0 for no limit"
+	}
+	fs.Float64Var(&p.MinLength, "min_length", value, usage)
+}
+
+// checkParams returns the settings of a check run along with a fix,
+// such as by watch, from those the two share. Unless it was set,
+// -min_length is left off as it is by check
+func checkParams(params cliParams, sources map[string]string) cliParams {
+	if sources["min_length"] == "default" {
+		params.MinLength = 0
+	}
+	return params
+}
+
+// checkFlags registers the flags used by the perfection check
+func checkFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.StringVar(&p.ForbiddenChars, "forbidden_chars", DefaultForbiddenChars, "Forbidden Characters at the start of a line")
+	fs.IntVar(&p.MaxLines, "max_lines", DefaultMaxLines, "Max. lines")
+	fs.IntVar(&p.CharsPerLine, "chars_per_line", DefaultCharsPerLine, "No. of characters/line")
+	fs.Float64Var(&p.ReadingSpeed, "reading_speed", DefaultReadingSpeed, "Reading Speed (ch/sec)")
+	fs.Float64Var(&p.LineBalance, "line_balance", DefaultLineBalance, "Length Balance (%)")
+	fs.BoolVar(&p.PreferCompact, "prefer_compact", DefaultPreferCompact, "Prefer Compact Subtitles")
+	fs.BoolVar(&p.SpacesAsChars, "spaces_as_chars", DefaultSpacesAsChars, "Treat Spaces as characters")
+	fs.Float64Var(&p.MaxLength, "max_length", DefaultMaxLength, "Maximum Length for each subtitle, 0 for no limit")
+	fs.IntVar(&p.MinGapFrames, "min_gap_frames", DefaultMinGapFrames, "Minimum gap between subtitles in frames, 0 for no limit")
+	fs.Float64Var(&p.FrameRate, "frame_rate", DefaultFrameRate, "Frame rate of the video, for -min_gap_frames")
+}
+
+// reportFlags registers the flag writing an HTML report of each file
+func reportFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.StringVar(&p.ReportHTML, "report_html", "", "Write an HTML report of each file to this file ({name}, {base}, {lang}, {ext})")
+}
+
+// countingFlags registers the flags changing how characters are counted
+func countingFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.BoolVar(&p.NewlinesAsChars, "newlines_as_chars", DefaultNewlinesAsChars, "Treat newlines as characters")
+}
+
+// validateOutput checks the output flags can be used together
+func validateOutput(p cliParams) error {
+	if p.Out != "" && p.OutDir != "" {
+		return errors.New("Only one of -out OR -out_dir can be used")
+	}
+	if p.DryRun && p.Stream {
+		return errors.New("-dry_run cannot be used with -stream")
+	}
+	if p.Patch != "" && !p.DryRun {
+		return errors.New("-patch can only be used with -dry_run")
+	}
+	return nil
+}
+
+// validateFix checks the flags of fix can be used together
+func validateFix(p cliParams) error {
+	if err := validateOutput(p); err != nil {
+		return err
+	}
+	if err := validateHTMLReport(p); err != nil {
+		return err
+	}
+
+	if p.Interactive {
+		switch {
+		case p.File == "-":
+			return errors.New("-interactive reads answers from stdin, so -file cannot be -")
+		case p.Out == "-":
+			return errors.New("-interactive cannot be used with -out -")
+		case p.Stream:
+			return errors.New("-interactive cannot be used with -stream")
+		case p.DryRun:
+			return errors.New("-interactive cannot be used with -dry_run")
+		case p.Jobs > 1:
+			return errors.New("-interactive reviews one file at a time, -jobs cannot be used")
+		}
+	}
+
+	return nil
+}
+
+// validateCheck checks the flags of check can be used together
+func validateCheck(p cliParams) error {
+	if p.GitDiff != "" && p.Stream {
+		return errors.New("-git_diff cannot be used with -stream")
+	}
+	if p.GitDiff != "" && p.File == "-" && p.StdinName == "" {
+		return errors.New("-git_diff needs a file in a git repository, give its path with -stdin_name when -file is -")
+	}
+	if p.StdinName != "" && p.File != "-" {
+		return errors.New("-stdin_name can only be used with -file -")
+	}
+	return validateHTMLReport(p)
+}
+
+// validateExplain checks a single subtitle id was given to explain
+func validateExplain(p cliParams) error {
+	if len(p.Cues) != 1 || p.Cues[0].Start != p.Cues[0].Stop {
+		return errors.New("-cue takes a single subtitle id")
+	}
+	if _, err := strconv.Atoi(p.Cues[0].Start); err != nil {
+		return fmt.Errorf("-cue takes a subtitle id, not '%s'", p.Cues[0].Start)
+	}
+	if p.Neighbours < 0 {
+		return errors.New("-neighbours cannot be negative")
+	}
+	return nil
+}
+
+// limitFlag parses -limit_to into a list of RangeStructs
+type limitFlag struct {
+	ranges *[]astisub.RangeStruct
+}
+
+func (f *limitFlag) String() string {
+	if f.ranges == nil {
+		return ""
+	}
+
+	var parts []string
+	for _, r := range *f.ranges {
+		if r.Start == r.Stop {
+			parts = append(parts, r.Start)
+		} else {
+			parts = append(parts, r.Start+"-"+r.Stop)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *limitFlag) Set(limitTo string) error {
+	*f.ranges = fixer.ParseRanges(limitTo)
+	return nil
+}
+
+// findCommand returns the command called name or nil
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// newFlagSet creates the flag set of cmd bound to p
+func newFlagSet(cmd *command, p *cliParams) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	cmd.Flags(fs, p)
+	profileFlags(fs, p)
+	logFlags(fs, p)
+
+	fs.Usage = func() {
+		title := fmt.Sprintf("%s %s: %s", filepath.Base(os.Args[0]), cmd.Name, cmd.Summary)
+		fmt.Fprintf(os.Stderr, "%s\n%s\n", title, strings.Repeat("-", len(title)))
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// commandsWithFlag lists the commands, other than skip, which accept flag name
+func commandsWithFlag(name string, skip *command) []string {
+	var names []string
+	for _, cmd := range commands {
+		// config accepts every flag, so naming it does not help
+		if cmd == skip || cmd.Name == "config" {
+			continue
+		}
+		var p cliParams
+		if newFlagSet(cmd, &p).Lookup(name) != nil {
+			names = append(names, cmd.Name)
+		}
+	}
+	return names
+}
+
+// flagError turns a flag parsing error into a clearer message, naming
+// the commands an unknown flag belongs to
+func flagError(cmd *command, err error) error {
+	const undefined = "flag provided but not defined: -"
+
+	msg := err.Error()
+	if !strings.HasPrefix(msg, undefined) {
+		return err
+	}
+
+	name := strings.TrimPrefix(msg, undefined)
+	if others := commandsWithFlag(name, cmd); len(others) > 0 {
+		return fmt.Errorf("-%s is not a flag of '%s', it can only be used with: %s", name, cmd.Name, strings.Join(others, ", "))
+	}
+
+	return fmt.Errorf("-%s is not a flag of '%s'", name, cmd.Name)
+}
+
+// usage prints the list of commands
+func usage() {
+	name := filepath.Base(os.Args[0])
+	avail := fmt.Sprintf("%s: Available commands are below", name)
+	avail += "\n" + strings.Repeat("-", len(avail)) + "\n"
+	os.Stderr.WriteString(avail)
+
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.Name, cmd.Summary)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nRun '%s help <command>' or '%s <command> -help' for the flags of a command\n", name, name)
+}
+
+// runCommand parses args for the command they name and runs it
+func runCommand(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return ExitUsage
+	}
+
+	if args[0] == "help" || args[0] == "-help" || args[0] == "-h" || args[0] == "--help" {
+		if len(args) > 1 {
+			if cmd := findCommand(args[1]); cmd != nil {
+				var p cliParams
+				newFlagSet(cmd, &p).Usage()
+				return 0
+			}
+		}
+		usage()
+		return 0
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		if strings.HasPrefix(args[0], "-") {
+			logger.Errorf("a command is required before flags, -mode has been replaced by commands")
+		} else {
+			logger.Errorf("unknown command '%s'", args[0])
+		}
+		os.Stderr.WriteString("\n")
+		usage()
+		return ExitUsage
+	}
+
+	// Leading words are actions of the command, e.g. "config show"
+	var words []string
+	args = args[1:]
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		words = append(words, args[0])
+		args = args[1:]
+	}
+
+	params := cliParams{CommandParams: astisub.CommandParams{Mode: cmd.Name}}
+	fs := newFlagSet(cmd, &params)
+	printUsage := fs.Usage
+	fs.Usage = func() {}
+	fs.SetOutput(discard{})
+
+	if err := fs.Parse(joinOptionalValues(fs, args)); err != nil {
+		if err == flag.ErrHelp {
+			fs.SetOutput(os.Stderr)
+			printUsage()
+			return 0
+		}
+		logger.Errorf("%s", flagError(cmd, err))
+		logger.Infof("Run '%s %s -help' for the list of flags", filepath.Base(os.Args[0]), cmd.Name)
+		return ExitUsage
+	}
+
+	args = append(words, fs.Args()...)
+	if len(args) > 0 && !cmd.Words {
+		logger.Errorf("unexpected argument '%s', '%s' only takes flags", args[0], cmd.Name)
+		return ExitUsage
+	}
+
+	sources, err := applyProfile(fs, cmd, &params)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return ExitUsage
+	}
+
+	if err := configureLogger(params); err != nil {
+		logger.Errorf("%s", err)
+		return ExitUsage
+	}
+
+	if err := configurePlugins(params); err != nil {
+		logger.Errorf("%s", err)
+		return ExitUsage
+	}
+	if len(plugins) > 0 && params.Stream {
+		logger.Warnf("Plugins are not run on streamed files")
+	}
+
+	if params.File == "" && !cmd.NoInput {
+		logger.Errorf("Input Subtitle file is required (-file)")
+		return ExitUsage
+	}
+
+	if cmd.Validate != nil {
+		if err := cmd.Validate(params); err != nil {
+			logger.Errorf("%s", err)
+			return ExitUsage
+		}
+	}
+
+	return cmd.Run(&invocation{
+		Params:  params,
+		Args:    args,
+		Flags:   fs,
+		Sources: sources,
+	})
+}
+
+// optionalValue is a flag which can be given alone, as a boolean, or
+// with a value which may be the next argument, such as -git_diff [rev]
+type optionalValue interface {
+	flag.Value
+	OptionalValue() bool
+}
+
+// joinOptionalValues joins each flag of fs taking an optional value to
+// the argument after it, unless that is a flag too, as the flag package
+// only reads the value of a boolean flag after an =
+func joinOptionalValues(fs *flag.FlagSet, args []string) []string {
+	joined := make([]string, 0, len(args))
+	for n := 0; n < len(args); n++ {
+		arg := args[n]
+		if arg == "--" {
+			return append(joined, args[n:]...)
+		}
+		joined = append(joined, arg)
+
+		name := strings.TrimLeft(arg, "-")
+		if !strings.HasPrefix(arg, "-") || strings.Contains(name, "=") ||
+			n+1 == len(args) || strings.HasPrefix(args[n+1], "-") {
+			continue
+		}
+
+		if f := fs.Lookup(name); f != nil {
+			if v, ok := f.Value.(optionalValue); ok && v.OptionalValue() {
+				joined[len(joined)-1] = arg + "=" + args[n+1]
+				n++
+			}
+		}
+	}
+
+	return joined
+}
+
+// discard silences the default error output of a flag set,
+// runCommand prints its own clearer messages instead
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// fileOperation processes one opened subtitle file and returns an exit
+// code. Its messages go to log, which names the file in a batch
+type fileOperation func(s *astisub.Subtitles, params cliParams, log *leveledLogger) int
+
+// runFiles runs op on every file matching params.File, -jobs of them at
+// a time. Commands which write have their output path worked out for
+// each file first. A failing file does not stop the others unless
+// -fail_fast is given; the exit code is the worst outcome of all files
+func runFiles(params cliParams, writes bool, op fileOperation) int {
+	files, err := expandFiles(params)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return ExitError
+	}
+
+	if params.List {
+		return listFiles(files)
+	}
+
+	// A named input which is missing, or matches nothing, is an error
+	// rather than an empty batch, for scripts not to take it as fixed
+	if len(files) == 0 {
+		if _, err := os.Stat(params.File); err != nil && os.IsNotExist(err) && !strings.ContainsAny(params.File, "*?[") {
+			logger.Errorf("Input file '%s' does not exist", params.File)
+		} else {
+			logger.Errorf("No subtitle files found for '%s'", params.File)
+		}
+		return ExitError
+	}
+
+	if err := checkZipFiles(files, params); err != nil {
+		logger.Errorf("%s", err)
+		return ExitUsage
+	}
+	if err := checkHTMLReport(files, params); err != nil {
+		logger.Errorf("%s", err)
+		return ExitUsage
+	}
+	if writes {
+		if err := checkOutputs(files, params); err != nil {
+			logger.Errorf("%s", err)
+			return ExitUsage
+		}
+	}
+
+	b := &batch{
+		params: params,
+		writes: writes,
+		op:     op,
+		files:  files,
+		limits: fixer.ParseLimits(params.LimitTo),
+	}
+
+	if writes && !params.DryRun {
+		b.journal = newJournal(params.Mode)
+	}
+
+	results := b.run()
+
+	if err := pendingZips.flush(params); err != nil {
+		logger.Errorf("%s", err)
+		return ExitWrite
+	}
+
+	if params.DryRun {
+		var diff bytes.Buffer
+		for _, r := range results {
+			diff.Write(r.Diff)
+		}
+
+		if err := writePatch(diff.Bytes(), params); err != nil {
+			logger.Errorf("Cannot write patch '%s': %s", params.Patch, err)
+			return ExitWrite
+		}
+	}
+
+	if len(files) > 1 {
+		logSummary(results)
+	}
+
+	code := batchExitCode(results)
+	if params.SummaryJSON != "" {
+		if err := writeSummary(params.SummaryJSON, params.Mode, results, code); err != nil {
+			logger.Errorf("Cannot write summary '%s': %s", params.SummaryJSON, err)
+			return ExitWrite
+		}
+	}
+
+	return code
+}
+
+// writePatch writes the dry run diff to params.Patch, or stdout
+func writePatch(diff []byte, params cliParams) error {
+	if params.Patch == "" {
+		_, err := os.Stdout.Write(diff)
+		return err
+	}
+
+	return astisub.WriteFileAtomic(params.Patch, func(w io.Writer) error {
+		_, err := w.Write(diff)
+		return err
+	})
+}
+
+// restoreFiles restores every file matching params.File. As the files
+// may have been deleted, the name is used as is when nothing matches
+func restoreFiles(params cliParams) int {
+	// Archives are backed up as a whole, so are restored as such even
+	// when they cannot be read anymore
+	found := []string{params.File}
+	if !isZip(params.File) || strings.ContainsAny(params.File, "*?[") {
+		var err error
+		if found, err = expandFiles(params); err != nil {
+			logger.Errorf("%s", err)
+			return ExitError
+		}
+	}
+
+	var files []string
+	seen := make(map[string]bool)
+	for _, f := range found {
+		if archive, _, ok := splitZipPath(f); ok {
+			f = archive
+		}
+		if !seen[f] {
+			seen[f] = true
+			files = append(files, f)
+		}
+	}
+
+	if params.List {
+		return listFiles(files)
+	}
+
+	if len(files) == 0 {
+		files = []string{params.File}
+	}
+
+	for _, fname := range files {
+		params.File = fname
+		if error_code := RestoreOperation(params); error_code != 0 {
+			return error_code
+		}
+	}
+
+	return 0
+}
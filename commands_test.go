@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+func TestFindCommand(t *testing.T) {
+	for _, name := range []string{"fix", "check", "overlap", "shift", "convert", "restore"} {
+		if cmd := findCommand(name); cmd == nil || cmd.Name != name {
+			t.Errorf("findCommand(%q) = %v", name, cmd)
+		}
+	}
+	for _, name := range []string{"", "normal", "perfection", "-mode"} {
+		if cmd := findCommand(name); cmd != nil {
+			t.Errorf("findCommand(%q) = %s, expected none", name, cmd.Name)
+		}
+	}
+}
+
+func TestRunCommandUsage(t *testing.T) {
+	captureLog(t, LogFormatText)
+	redirect(t, &os.Stderr, "")
+	inDir(t, t.TempDir())
+
+	for _, c := range []struct {
+		name     string
+		args     []string
+		expected int
+	}{
+		{"no command", nil, ExitUsage},
+		{"help", []string{"help"}, ExitOK},
+		{"help of a command", []string{"help", "fix"}, ExitOK},
+		{"flags of a command", []string{"check", "-help"}, ExitOK},
+		{"unknown command", []string{"normal"}, ExitUsage},
+		{"old -mode", []string{"-mode", "normal", "-file", "ep01.srt"}, ExitUsage},
+		{"no file", []string{"fix"}, ExitUsage},
+		{"flag of another command", []string{"check", "-file", "ep01.srt", "-out", "x.srt"}, ExitUsage},
+		{"invalid value", []string{"fix", "-file", "ep01.srt", "-speed", "fast"}, ExitUsage},
+		{"shift without -by", []string{"shift", "-file", "ep01.srt"}, ExitUsage},
+		{"convert without output", []string{"convert", "-file", "ep01.srt"}, ExitUsage},
+		{"-out & -out_dir", []string{"fix", "-file", "ep01.srt", "-out", "a.srt", "-out_dir", "out"}, ExitUsage},
+		{"argument after flags", []string{"check", "-file", "ep01.srt", "extra.srt"}, ExitUsage},
+		{"argument after -out", []string{"fix", "-file", "ep01.srt", "-out", "b.srt", "stray"}, ExitUsage},
+		{"argument before flags", []string{"stats", "ep01.srt", "-file", "ep01.srt"}, ExitUsage},
+	} {
+		if code := runCommand(c.args); code != c.expected {
+			t.Errorf("%s: exit %d, expected %d", c.name, code, c.expected)
+		}
+	}
+}
+
+func TestCheckParams(t *testing.T) {
+	for _, c := range []struct {
+		source   string
+		expected float64
+	}{
+		{"default", 0},
+		{"flag", 2},
+		{"preset bbc:en-GB:adult", 2},
+	} {
+		p := cliParams{}
+		p.MinLength = 2
+		if got := checkParams(p, map[string]string{"min_length": c.source}).MinLength; got != c.expected {
+			t.Errorf("-min_length from %s is %g for check, expected %g", c.source, got, c.expected)
+		}
+	}
+}
+
+func TestFlagError(t *testing.T) {
+	for _, c := range []struct {
+		command  string
+		err      string
+		expected string
+	}{
+		{"check", "flag provided but not defined: -out", "-out is not a flag of 'check', it can only be used with: fix, overlap, shift, convert, undo"},
+		{"fix", "flag provided but not defined: -speling", "-speling is not a flag of 'fix'"},
+		{"fix", `invalid value "fast" for flag -speed: parse error`, `invalid value "fast" for flag -speed: parse error`},
+	} {
+		if got := flagError(findCommand(c.command), errors.New(c.err)).Error(); got != c.expected {
+			t.Errorf("flagError(%s, %q) = %q, expected %q", c.command, c.err, got, c.expected)
+		}
+	}
+}
+
+func TestLimitFlag(t *testing.T) {
+	for _, c := range []struct {
+		value, expected string
+	}{
+		{"", ""},
+		{"1-2,4-10,18", "1-2,4-10,18"},
+		{"1-2, 5-", "1-2,5"},
+		{"00:01:00.000-00:02:00.000", "00:01:00.000-00:02:00.000"},
+	} {
+		f := &limitFlag{new([]astisub.RangeStruct)}
+		if err := f.Set(c.value); err != nil {
+			t.Errorf("Set(%q): %s", c.value, err)
+		}
+		if got := f.String(); got != c.expected {
+			t.Errorf("-limit_to %q is shown as %q, expected %q", c.value, got, c.expected)
+		}
+	}
+}
+
+func TestDurationFlag(t *testing.T) {
+	for _, c := range []struct {
+		value    string
+		expected time.Duration
+		fails    bool
+	}{
+		{value: "1.5s", expected: 1500 * time.Millisecond},
+		{value: "-250ms", expected: -250 * time.Millisecond},
+		{value: "00:00:01,500", expected: 1500 * time.Millisecond},
+		{value: "-00:01:00.250", expected: -time.Minute - 250*time.Millisecond},
+		{value: "soon", fails: true},
+	} {
+		var d time.Duration
+		err := (&durationFlag{&d}).Set(c.value)
+		if (err != nil) != c.fails || (!c.fails && d != c.expected) {
+			t.Errorf("durationFlag.Set(%q) = %s, %v, expected %s", c.value, d, err, c.expected)
+		}
+	}
+}
+
+func TestValidateFix(t *testing.T) {
+	for _, c := range []struct {
+		args  []string
+		fails bool
+	}{
+		{args: []string{"-file", "ep01.srt"}},
+		{args: []string{"-file", "ep01.srt", "-out", "a.srt", "-out_dir", "out"}, fails: true},
+		{args: []string{"-file", "ep01.srt", "-dry_run", "-stream"}, fails: true},
+		{args: []string{"-file", "ep01.srt", "-patch", "a.patch"}, fails: true},
+		{args: []string{"-file", "ep01.srt", "-dry_run", "-patch", "a.patch"}},
+		{args: []string{"-file", "ep01.srt", "-interactive"}},
+		{args: []string{"-file", "-", "-interactive"}, fails: true},
+		{args: []string{"-file", "ep01.srt", "-interactive", "-jobs", "2"}, fails: true},
+	} {
+		err := validateFix(commandParams(t, "fix", c.args...))
+		if (err != nil) != c.fails {
+			t.Errorf("validateFix(%v) = %v, expected failure %t", c.args, err, c.fails)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+// Config file names and the profile used when -profile is not given
+const (
+	ConfigFileName = ".subfixer.json"
+	UserConfigName = "config.json"
+	DefaultProfile = "default"
+)
+
+// configFile is a configuration file holding named profiles. Each profile
+// maps flag names to the values they take, e.g.
+//
+//	{ "profiles": { "netflix-en": { "speed": 17, "chars_per_line": 42 } } }
+//
+// It can also declare plugins, see pluginConfig. Project is set for the
+// config found in the working directory or its parents, which comes
+// with whatever is checked out, so cannot choose to run plugins
+type configFile struct {
+	Path     string                            `json:"-"`
+	Project  bool                              `json:"-"`
+	Profiles map[string]map[string]interface{} `json:"profiles"`
+	Plugins  map[string]*pluginConfig          `json:"plugins"`
+}
+
+// projectConfigPath looks for ConfigFileName in the current directory
+// and its parents, and returns the first one found
+func projectConfigPath() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+
+	for {
+		path := filepath.Join(dir, ConfigFileName)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// userConfigPath returns the path of the configuration file of the user
+func userConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "subfixer", UserConfigName)
+}
+
+// loadConfig reads and parses the configuration file at path
+func loadConfig(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &configFile{Path: path}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("parsing config '%s' failed: %s", path, err)
+	}
+
+	return c, nil
+}
+
+// loadConfigs returns the configuration files to apply, lowest priority
+// first. An explicit -config replaces the project & user lookup
+func loadConfigs(explicit string) ([]*configFile, error) {
+	if explicit != "" {
+		c, err := loadConfig(explicit)
+		if err != nil {
+			return nil, err
+		}
+		return []*configFile{c}, nil
+	}
+
+	var configs []*configFile
+	project := projectConfigPath()
+	for _, path := range []string{userConfigPath(), project} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+
+		c, err := loadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		c.Project = path == project
+		configs = append(configs, c)
+	}
+
+	return configs, nil
+}
+
+// configValue converts a JSON value into the text form a flag accepts
+func configValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+// profileFlags registers the flags selecting the configuration
+func profileFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.StringVar(&p.Profile, "profile", "", "Named profile from the config files (default: \""+DefaultProfile+"\" if present)")
+	fs.StringVar(&p.Config, "config", "", "Config file to use instead of "+ConfigFileName+" & the user config")
+	fs.StringVar(&p.Preset, "preset", "", "Built-in style guide preset, e.g. netflix:de-DE:adult (see 'preset list')")
+}
+
+// logFlags registers the flags controlling what is logged on stderr
+func logFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.BoolVar(&p.Quiet, "quiet", false, "Only log warnings & errors")
+	fs.BoolVar(&p.Verbose, "v", false, "Also log every change made to each subtitle")
+	fs.BoolVar(&p.VeryVerbose, "vv", false, "Also log every step of the processing, including reading speeds")
+	fs.StringVar(&p.LogFormat, "log_format", LogFormatText, "Format of the log on stderr: "+LogFormatText+" or "+LogFormatJSON)
+}
+
+// applyProfile sets every flag of fs which was not given on the command
+// line from the selected preset and profile, the profile taking priority.
+// It returns where each flag value came from, keyed by flag name
+func applyProfile(fs *flag.FlagSet, cmd *command, p *cliParams) (map[string]string, error) {
+	sources := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		sources[f.Name] = "default"
+	})
+	fs.Visit(func(f *flag.Flag) {
+		sources[f.Name] = "flag"
+	})
+
+	configs, err := loadConfigs(p.Config)
+	if err != nil {
+		return sources, err
+	}
+
+	name := p.Profile
+	if name == "" {
+		name = DefaultProfile
+	}
+
+	var found []*configFile
+	for _, c := range configs {
+		if _, ok := c.Profiles[name]; ok {
+			found = append(found, c)
+		}
+	}
+
+	if len(found) == 0 && p.Profile != "" {
+		return sources, fmt.Errorf("profile '%s' not found in any config file", p.Profile)
+	}
+
+	// A profile may pick the preset it builds upon
+	for _, c := range found {
+		v, ok := c.Profiles[name]["preset"]
+		if !ok || sources["preset"] == "flag" {
+			continue
+		}
+
+		value, err := configValue(v)
+		if err != nil {
+			return sources, fmt.Errorf("profile '%s' in '%s': invalid value for 'preset': %s", name, c.Path, err)
+		}
+		p.Preset = value
+		sources["preset"] = fmt.Sprintf("profile %s (%s)", name, c.Path)
+	}
+
+	if p.Preset != "" {
+		if err := applyPreset(fs, cmd, sources, p.Preset); err != nil {
+			return sources, err
+		}
+	}
+
+	for _, c := range found {
+		values := c.Profiles[name]
+
+		// Apply in a fixed order so errors are reproducible
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if key == "preset" || sources[key] == "flag" {
+				continue
+			}
+			if key == "plugins" && c.Project {
+				logger.Warnf("Ignoring 'plugins' of profile '%s' in '%s', plugins are only turned on by -plugins or the user config", name, c.Path)
+				continue
+			}
+
+			if fs.Lookup(key) == nil {
+				if len(commandsWithFlag(key, cmd)) == 0 {
+					return sources, fmt.Errorf("profile '%s' in '%s' has unknown setting '%s'", name, c.Path, key)
+				}
+				continue
+			}
+
+			value, err := configValue(values[key])
+			if err == nil {
+				err = fs.Set(key, value)
+			}
+			if err != nil {
+				return sources, fmt.Errorf("profile '%s' in '%s': invalid value for '%s': %s", name, c.Path, key, err)
+			}
+
+			sources[key] = fmt.Sprintf("profile %s (%s)", name, c.Path)
+		}
+	}
+
+	return sources, nil
+}
+
+// ConfigOperation prints the effective value of every setting and
+// where it came from
+func ConfigOperation(fs *flag.FlagSet, sources map[string]string) int {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "SETTING\tVALUE\tSOURCE\n")
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "profile" || f.Name == "config" {
+			return
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.Name, f.Value.String(), sources[f.Name])
+	})
+
+	w.Flush()
+	return 0
+}
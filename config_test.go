@@ -0,0 +1,162 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigValue(t *testing.T) {
+	for _, c := range []struct {
+		value    interface{}
+		expected string
+		fails    bool
+	}{
+		{value: "bbc:en-GB:adult", expected: "bbc:en-GB:adult"},
+		{value: true, expected: "true"},
+		{value: 17.0, expected: "17"},
+		{value: 1.5, expected: "1.5"},
+		{value: []interface{}{"a"}, fails: true},
+		{value: nil, fails: true},
+	} {
+		got, err := configValue(c.value)
+		if (err != nil) != c.fails || got != c.expected {
+			t.Errorf("configValue(%v) = %q, %v, expected %q", c.value, got, err, c.expected)
+		}
+	}
+}
+
+func TestProjectConfigPath(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "season1", "extras")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	inDir(t, sub)
+
+	if path := projectConfigPath(); path != "" && strings.HasPrefix(path, dir) {
+		t.Errorf("found %s before writing it", path)
+	}
+
+	config := filepath.Join(dir, ConfigFileName)
+	if err := os.WriteFile(config, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if path, _ := filepath.EvalSymlinks(projectConfigPath()); path != mustEvalSymlinks(t, config) {
+		t.Errorf("found config %q, expected %q", path, config)
+	}
+}
+
+// mustEvalSymlinks resolves path, temporary folders being behind a link
+// on some systems
+func mustEvalSymlinks(t *testing.T, path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resolved
+}
+
+func TestApplyProfile(t *testing.T) {
+	captureLog(t, LogFormatText)
+	dir := t.TempDir()
+	inDir(t, dir)
+
+	user := `{ "profiles": {
+		"default": { "speed": 15, "chars_per_line": 37, "min_length": 1.5 },
+		"kids": { "speed": 12 }
+	} }`
+	project := `{ "profiles": {
+		"default": { "speed": 17, "out_dir": "fixed" },
+		"typo": { "speling": 1 },
+		"invalid": { "speed": "fast" },
+		"broadcast": { "preset": "bbc:en-GB:adult", "reading_speed": 20 }
+	} }`
+	userPath := userConfigPath()
+	if err := os.MkdirAll(filepath.Dir(userPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(userPath, []byte(user), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ConfigFileName), []byte(project), 0644); err != nil {
+		t.Fatal(err)
+	}
+	explicit := filepath.Join(dir, "other.json")
+	if err := os.WriteFile(explicit, []byte(`{ "profiles": { "default": { "speed": 19 } } }`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name     string
+		command  string
+		args     []string
+		fails    bool
+		expected func(p cliParams) bool
+	}{
+		{"project over user", "fix", nil, false, func(p cliParams) bool {
+			return p.Speed == 17 && p.MinLength == 1.5 && p.OutDir == "fixed"
+		}},
+		{"flag over profiles", "fix", []string{"-speed", "21"}, false, func(p cliParams) bool { return p.Speed == 21 }},
+		{"other profile", "fix", []string{"-profile", "kids"}, false, func(p cliParams) bool { return p.Speed == 12 && p.MinLength == DefaultMinLength }},
+		{"explicit config", "fix", []string{"-config", explicit}, false, func(p cliParams) bool { return p.Speed == 19 && p.OutDir == "" }},
+		// Settings of other commands are left out
+		{"check", "check", nil, false, func(p cliParams) bool { return p.CharsPerLine == 37 && p.MinLength == 1.5 }},
+		{"preset of a profile", "check", []string{"-profile", "broadcast"}, false, func(p cliParams) bool {
+			return p.Preset == "bbc:en-GB:adult" && p.ReadingSpeed == 20
+		}},
+		{"unknown profile", "fix", []string{"-profile", "nope"}, true, nil},
+		{"unknown setting", "fix", []string{"-profile", "typo"}, true, nil},
+		{"invalid value", "fix", []string{"-profile", "invalid"}, true, nil},
+		{"missing config", "fix", []string{"-config", filepath.Join(dir, "missing.json")}, true, nil},
+	} {
+		cmd := findCommand(c.command)
+		var params cliParams
+		fs := newFlagSet(cmd, &params)
+		if err := fs.Parse(c.args); err != nil {
+			t.Fatal(err)
+		}
+
+		sources, err := applyProfile(fs, cmd, &params)
+		if (err != nil) != c.fails {
+			t.Errorf("%s: error %v, expected failure %t", c.name, err, c.fails)
+			continue
+		}
+		if err == nil && !c.expected(params) {
+			t.Errorf("%s: unexpected settings %+v, from %v", c.name, params, sources)
+		}
+	}
+}
+
+func TestApplyProfileSources(t *testing.T) {
+	captureLog(t, LogFormatText)
+	dir := t.TempDir()
+	inDir(t, dir)
+
+	config := filepath.Join(dir, ConfigFileName)
+	if err := os.WriteFile(config, []byte(`{ "profiles": { "default": { "speed": 17 } } }`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := findCommand("fix")
+	var params cliParams
+	fs := newFlagSet(cmd, &params)
+	if err := fs.Parse([]string{"-min_length", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	sources, err := applyProfile(fs, cmd, &params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for flag, expected := range map[string]string{
+		"min_length":  "flag",
+		"speed":       "profile default (" + config + ")",
+		"trim_spaces": "default",
+	} {
+		if sources[flag] != expected {
+			t.Errorf("-%s comes from %q, expected %q", flag, sources[flag], expected)
+		}
+	}
+}
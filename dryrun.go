@@ -0,0 +1,388 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// diffContext is the number of unchanged lines shown around a change
+const diffContext = 3
+
+// maxSplitParts is the largest number of subtitles a split or a join
+// is looked for across
+const maxSplitParts = 8
+
+// resyncWindow is how far ahead unrelated subtitles are searched for
+// a common one before giving up on aligning the rest
+const resyncWindow = 64
+
+// cue is a snapshot of a subtitle as it is written out
+type cue struct {
+	Start time.Duration
+	End   time.Duration
+	Lines []string
+}
+
+// snapshot copies the timing and text of every subtitle of s
+func snapshot(s *astisub.Subtitles) []cue {
+	cues := make([]cue, 0, len(s.Items))
+	for _, item := range s.Items {
+		c := cue{Start: item.StartAt, End: item.EndAt}
+		for _, l := range item.Lines {
+			c.Lines = append(c.Lines, l.String())
+		}
+		cues = append(cues, c)
+	}
+
+	return cues
+}
+
+// key returns the text of the cues with spacing normalised, so that
+// re-wrapped, split and joined text compare equal
+func key(cues []cue) string {
+	var words []string
+	for _, c := range cues {
+		for _, l := range c.Lines {
+			words = append(words, strings.Fields(l)...)
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// block renders cue number n as SRT lines. Every cue but the first is
+// preceded by the blank line separating it from the previous one
+func (c cue) block(n int) []string {
+	var lines []string
+	if n > 1 {
+		lines = append(lines, "")
+	}
+
+	lines = append(lines, strconv.Itoa(n))
+	lines = append(lines, astisub.FormatDurationSRT(c.Start)+" --> "+astisub.FormatDurationSRT(c.End))
+
+	return append(lines, c.Lines...)
+}
+
+// cueEdit is a step of the alignment between the subtitles before and
+// after processing. Before and After are ranges of cue indices
+type cueEdit struct {
+	Kind   string
+	Before [2]int
+	After  [2]int
+}
+
+// Changed checks if the edit changes anything besides numbering
+func (e cueEdit) Changed() bool {
+	return e.Kind != ""
+}
+
+// String describes the edit with the cue numbers of each side
+func (e cueEdit) String() string {
+	ids := func(r [2]int) string {
+		if r[1]-r[0] == 1 {
+			return fmt.Sprintf("#%d", r[0]+1)
+		}
+		return fmt.Sprintf("#%d-#%d", r[0]+1, r[1])
+	}
+
+	switch e.Kind {
+	case "split":
+		return fmt.Sprintf("%s split into %s", ids(e.Before), ids(e.After))
+	case "join":
+		return fmt.Sprintf("%s joined into %s", ids(e.Before), ids(e.After))
+	case "removed":
+		return fmt.Sprintf("%s removed", ids(e.Before))
+	case "added":
+		return fmt.Sprintf("%s added", ids(e.After))
+	case "replaced":
+		return fmt.Sprintf("%s replaced by %s", ids(e.Before), ids(e.After))
+	case "":
+		return fmt.Sprintf("%s renumbered to %s", ids(e.Before), ids(e.After))
+	}
+
+	return fmt.Sprintf("%s %s", ids(e.Before), e.Kind)
+}
+
+// alignCues pairs the subtitles of a and b. Subtitles are matched on
+// their text, then splits and joins are looked for, then the next
+// matching pair within resyncWindow. Whatever is left is replaced
+func alignCues(a []cue, b []cue) []cueEdit {
+	var edits []cueEdit
+	i, j := 0, 0
+
+	add := func(kind string, na int, nb int) {
+		edits = append(edits, cueEdit{Kind: kind, Before: [2]int{i, i + na}, After: [2]int{j, j + nb}})
+		i += na
+		j += nb
+	}
+
+next:
+	for i < len(a) && j < len(b) {
+		if key(a[i:i+1]) == key(b[j:j+1]) {
+			add(matchKind(a[i], b[j]), 1, 1)
+			continue
+		}
+
+		for k := 2; k <= maxSplitParts; k++ {
+			if j+k <= len(b) && key(a[i:i+1]) == key(b[j:j+k]) {
+				add("split", 1, k)
+				continue next
+			}
+			if i+k <= len(a) && key(a[i:i+k]) == key(b[j:j+1]) {
+				add("join", k, 1)
+				continue next
+			}
+		}
+
+		for d := 1; d <= resyncWindow; d++ {
+			for da := 0; da <= d; da++ {
+				db := d - da
+				if i+da < len(a) && j+db < len(b) && key(a[i+da:i+da+1]) == key(b[j+db:j+db+1]) {
+					add(replaceKind(da, db), da, db)
+					continue next
+				}
+			}
+		}
+
+		break
+	}
+
+	if i < len(a) || j < len(b) {
+		add(replaceKind(len(a)-i, len(b)-j), len(a)-i, len(b)-j)
+	}
+
+	return edits
+}
+
+// matchKind describes how two subtitles with the same text differ
+func matchKind(a cue, b cue) string {
+	timing := a.Start != b.Start || a.End != b.End
+	text := strings.Join(a.Lines, "\n") != strings.Join(b.Lines, "\n")
+
+	switch {
+	case timing && text:
+		return "timing & text"
+	case timing:
+		return "timing"
+	case text:
+		return "text"
+	}
+
+	return ""
+}
+
+// replaceKind describes na subtitles being replaced by nb others
+func replaceKind(na int, nb int) string {
+	switch {
+	case nb == 0:
+		return "removed"
+	case na == 0:
+		return "added"
+	}
+
+	return "replaced"
+}
+
+// lineOp is a line of a unified diff, Op being ' ', '-' or '+'.
+// Edit is the index of the cueEdit the line belongs to
+type lineOp struct {
+	Op   byte
+	Text string
+	Edit int
+}
+
+// cueLines renders the cues in r as SRT lines
+func cueLines(cues []cue, r [2]int) []string {
+	var lines []string
+	for n := r[0]; n < r[1]; n++ {
+		lines = append(lines, cues[n].block(n+1)...)
+	}
+
+	return lines
+}
+
+// lineOps turns the alignment into a line by line edit script. Edits
+// rendering to as many lines on both sides are compared line by line,
+// others are replaced as a whole, less their common first & last lines
+func lineOps(a []cue, b []cue, edits []cueEdit) []lineOp {
+	var ops []lineOp
+
+	for e, edit := range edits {
+		old := cueLines(a, edit.Before)
+		new := cueLines(b, edit.After)
+
+		if len(old) == len(new) {
+			for n := 0; n < len(old); {
+				if old[n] == new[n] {
+					ops = append(ops, lineOp{' ', old[n], e})
+					n++
+					continue
+				}
+
+				end := n
+				for end < len(old) && old[end] != new[end] {
+					end++
+				}
+				for _, l := range old[n:end] {
+					ops = append(ops, lineOp{'-', l, e})
+				}
+				for _, l := range new[n:end] {
+					ops = append(ops, lineOp{'+', l, e})
+				}
+				n = end
+			}
+			continue
+		}
+
+		head := 0
+		for head < len(old) && head < len(new) && old[head] == new[head] {
+			head++
+		}
+		tail := 0
+		for tail < len(old)-head && tail < len(new)-head && old[len(old)-1-tail] == new[len(new)-1-tail] {
+			tail++
+		}
+
+		for _, l := range old[:head] {
+			ops = append(ops, lineOp{' ', l, e})
+		}
+		for _, l := range old[head : len(old)-tail] {
+			ops = append(ops, lineOp{'-', l, e})
+		}
+		for _, l := range new[head : len(new)-tail] {
+			ops = append(ops, lineOp{'+', l, e})
+		}
+		for _, l := range old[len(old)-tail:] {
+			ops = append(ops, lineOp{' ', l, e})
+		}
+	}
+
+	return ops
+}
+
+// writeHunks writes ops as unified diff hunks with diffContext lines
+// of context. Each hunk header names the subtitle changes it holds
+func writeHunks(w io.Writer, ops []lineOp, edits []cueEdit) {
+	oldPos := make([]int, len(ops)+1)
+	newPos := make([]int, len(ops)+1)
+	for k, op := range ops {
+		oldPos[k+1], newPos[k+1] = oldPos[k], newPos[k]
+		if op.Op != '+' {
+			oldPos[k+1]++
+		}
+		if op.Op != '-' {
+			newPos[k+1]++
+		}
+	}
+
+	for k := 0; k < len(ops); {
+		if ops[k].Op == ' ' {
+			k++
+			continue
+		}
+
+		// Merge changes separated by less than twice the context
+		end := k
+		for {
+			for end < len(ops) && ops[end].Op != ' ' {
+				end++
+			}
+			next := end
+			for next < len(ops) && ops[next].Op == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContext {
+				break
+			}
+			end = next
+		}
+
+		start := k - diffContext
+		if start < 0 {
+			start = 0
+		}
+		stop := end + diffContext
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		var names []string
+		seen := -1
+		for _, op := range ops[k:end] {
+			if op.Op != ' ' && op.Edit != seen {
+				seen = op.Edit
+				names = append(names, edits[op.Edit].String())
+			}
+		}
+		if len(names) > 3 {
+			names = append(names[:3], fmt.Sprintf("%d more", len(names)-3))
+		}
+
+		fmt.Fprintf(w, "@@ -%s +%s @@ %s\n",
+			hunkRange(oldPos[start], oldPos[stop]), hunkRange(newPos[start], newPos[stop]),
+			strings.Join(names, ", "))
+		for _, op := range ops[start:stop] {
+			fmt.Fprintf(w, "%c%s\n", op.Op, op.Text)
+		}
+
+		k = stop
+	}
+}
+
+// hunkRange formats the lines from start up to stop of a hunk header
+func hunkRange(start int, stop int) string {
+	if start == stop {
+		return fmt.Sprintf("%d,0", start)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, stop-start)
+}
+
+// writeDiff writes a unified diff from the subtitles before processing
+// to those after, labelled with the names of the input and output
+// files. It returns the number of subtitle changes of each kind
+func writeDiff(w io.Writer, from string, to string, before []cue, after []cue) map[string]int {
+	edits := alignCues(before, after)
+
+	counts := make(map[string]int)
+	for _, edit := range edits {
+		if edit.Changed() {
+			counts[edit.Kind]++
+		}
+	}
+
+	if len(counts) == 0 {
+		return counts
+	}
+
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", from, to)
+	writeHunks(w, lineOps(before, after, edits), edits)
+
+	return counts
+}
+
+// formatCounts summarises the changes found by writeDiff
+func formatCounts(counts map[string]int) string {
+	var parts []string
+	for _, kind := range []string{"timing", "text", "timing & text", "split", "join", "removed", "added", "replaced"} {
+		if counts[kind] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[kind], kind))
+		}
+	}
+
+	if len(parts) == 0 {
+		return "no changes"
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCue returns a cue from start to end, in seconds
+func testCue(start float64, end float64, lines ...string) cue {
+	return cue{
+		Start: time.Duration(start * float64(time.Second)),
+		End:   time.Duration(end * float64(time.Second)),
+		Lines: lines,
+	}
+}
+
+func TestAlignCues(t *testing.T) {
+	before := []cue{
+		testCue(1, 2, "One"),
+		testCue(3, 4, "Two words", "on two lines"),
+		testCue(5, 6, "Three"),
+		testCue(7, 8, "Four"),
+	}
+
+	for _, c := range []struct {
+		name     string
+		after    []cue
+		expected []cueEdit
+	}{
+		{"unchanged", before, []cueEdit{
+			{"", [2]int{0, 1}, [2]int{0, 1}},
+			{"", [2]int{1, 2}, [2]int{1, 2}},
+			{"", [2]int{2, 3}, [2]int{2, 3}},
+			{"", [2]int{3, 4}, [2]int{3, 4}},
+		}},
+		{"timing & text", []cue{testCue(1, 2.5, "One"), testCue(3, 4, "Two words on", "two lines"), testCue(5, 7, "Three "), before[3]}, []cueEdit{
+			{"timing", [2]int{0, 1}, [2]int{0, 1}},
+			{"text", [2]int{1, 2}, [2]int{1, 2}},
+			{"timing & text", [2]int{2, 3}, [2]int{2, 3}},
+			{"", [2]int{3, 4}, [2]int{3, 4}},
+		}},
+		{"split", []cue{before[0], testCue(3, 3.5, "Two words"), testCue(3.5, 4, "on two lines"), before[2], before[3]}, []cueEdit{
+			{"", [2]int{0, 1}, [2]int{0, 1}},
+			{"split", [2]int{1, 2}, [2]int{1, 3}},
+			{"", [2]int{2, 3}, [2]int{3, 4}},
+			{"", [2]int{3, 4}, [2]int{4, 5}},
+		}},
+		{"join", []cue{before[0], before[1], testCue(5, 8, "Three", "Four")}, []cueEdit{
+			{"", [2]int{0, 1}, [2]int{0, 1}},
+			{"", [2]int{1, 2}, [2]int{1, 2}},
+			{"join", [2]int{2, 4}, [2]int{2, 3}},
+		}},
+		{"removed", []cue{before[0], before[2], before[3]}, []cueEdit{
+			{"", [2]int{0, 1}, [2]int{0, 1}},
+			{"removed", [2]int{1, 2}, [2]int{1, 1}},
+			{"", [2]int{2, 3}, [2]int{1, 2}},
+			{"", [2]int{3, 4}, [2]int{2, 3}},
+		}},
+		{"added at the end", append(append([]cue{}, before...), testCue(9, 10, "Five")), []cueEdit{
+			{"", [2]int{0, 1}, [2]int{0, 1}},
+			{"", [2]int{1, 2}, [2]int{1, 2}},
+			{"", [2]int{2, 3}, [2]int{2, 3}},
+			{"", [2]int{3, 4}, [2]int{3, 4}},
+			{"added", [2]int{4, 4}, [2]int{4, 5}},
+		}},
+		{"replaced", []cue{before[0], testCue(3, 4, "Something else"), before[2], before[3]}, []cueEdit{
+			{"", [2]int{0, 1}, [2]int{0, 1}},
+			{"replaced", [2]int{1, 2}, [2]int{1, 2}},
+			{"", [2]int{2, 3}, [2]int{2, 3}},
+			{"", [2]int{3, 4}, [2]int{3, 4}},
+		}},
+		{"emptied", nil, []cueEdit{{"removed", [2]int{0, 4}, [2]int{0, 0}}}},
+	} {
+		if got := alignCues(before, c.after); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: aligned as %v, expected %v", c.name, got, c.expected)
+		}
+	}
+}
+
+func TestCueEditString(t *testing.T) {
+	for _, c := range []struct {
+		edit     cueEdit
+		expected string
+	}{
+		{cueEdit{"split", [2]int{1, 2}, [2]int{1, 3}}, "#2 split into #2-#3"},
+		{cueEdit{"join", [2]int{2, 4}, [2]int{2, 3}}, "#3-#4 joined into #3"},
+		{cueEdit{"removed", [2]int{1, 2}, [2]int{1, 1}}, "#2 removed"},
+		{cueEdit{"added", [2]int{4, 4}, [2]int{4, 5}}, "#5 added"},
+		{cueEdit{"replaced", [2]int{1, 2}, [2]int{1, 3}}, "#2 replaced by #2-#3"},
+		{cueEdit{"", [2]int{3, 4}, [2]int{4, 5}}, "#4 renumbered to #5"},
+		{cueEdit{"timing", [2]int{0, 1}, [2]int{0, 1}}, "#1 timing"},
+	} {
+		if got := c.edit.String(); got != c.expected {
+			t.Errorf("%+v is described as %q, expected %q", c.edit, got, c.expected)
+		}
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	before := []cue{testCue(1, 2, "One"), testCue(3, 4, "Two"), testCue(5, 6, "Three")}
+	after := []cue{testCue(1, 2, "One"), testCue(3, 4.5, "Two"), testCue(5, 6, "Three")}
+
+	var b bytes.Buffer
+	counts := writeDiff(&b, "ep01.srt", "ep01.srt", before, after)
+
+	expected := strings.Join([]string{
+		"--- ep01.srt",
+		"+++ ep01.srt",
+		"@@ -3,7 +3,7 @@ #2 timing",
+		" One",
+		" ",
+		" 2",
+		"-00:00:03,000 --> 00:00:04,000",
+		"+00:00:03,000 --> 00:00:04,500",
+		" Two",
+		" ",
+		" 3",
+	}, "\n") + "\n"
+	if b.String() != expected {
+		t.Errorf("diff is\n%s\nexpected\n%s", b.String(), expected)
+	}
+	if !reflect.DeepEqual(counts, map[string]int{"timing": 1}) {
+		t.Errorf("counts are %v", counts)
+	}
+
+	b.Reset()
+	if counts := writeDiff(&b, "ep01.srt", "ep01.srt", before, before); len(counts) != 0 || b.Len() != 0 {
+		t.Errorf("diff of unchanged subtitles is %q, %v", b.String(), counts)
+	}
+}
+
+func TestWriteDiffApplies(t *testing.T) {
+	if _, err := exec.LookPath("patch"); err != nil {
+		t.Skip("patch is not installed")
+	}
+
+	// Changes of every kind, close enough to share hunks
+	var before, after []cue
+	for i := 0; i < 40; i++ {
+		at := float64(i * 3)
+		before = append(before, testCue(at, at+2, "Subtitle", "number "+string(rune('A'+i%26))+string(rune('a'+i/26))))
+	}
+	after = append(after, before[:3]...)
+	after = append(after, testCue(9, 9.5, "Subtitle"), testCue(9.5, 11, "number Da"))
+	after = append(after, testCue(12, 17, "Subtitle number Ea Subtitle number Fa"))
+	after = append(after, before[6:20]...)
+	after = append(after, before[21:30]...)
+	after = append(after, testCue(95, 96, "New"))
+	after = append(after, before[30:]...)
+	after[35].End += time.Second
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "ep01.srt")
+	if err := os.WriteFile(name, []byte(srtText(before)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var diff bytes.Buffer
+	writeDiff(&diff, "ep01.srt", "ep01.srt", before, after)
+
+	cmd := exec.Command("patch", "-s", name)
+	cmd.Stdin = &diff
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("patch failed: %s: %s", err, out)
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != srtText(after) {
+		t.Errorf("patched file is\n%s\nexpected\n%s", data, srtText(after))
+	}
+}
+
+// srtText renders cues as the lines of an SRT file
+func srtText(cues []cue) string {
+	var b bytes.Buffer
+	for n, item := range cues {
+		for _, l := range item.block(n + 1) {
+			b.WriteString(l + "\n")
+		}
+	}
+	return b.String()
+}
+
+func TestFormatCounts(t *testing.T) {
+	for _, c := range []struct {
+		counts   map[string]int
+		expected string
+	}{
+		{nil, "no changes"},
+		{map[string]int{"timing": 2}, "2 timing"},
+		{map[string]int{"join": 1, "timing": 3, "split": 2}, "3 timing, 2 split, 1 join"},
+	} {
+		if got := formatCounts(c.counts); got != c.expected {
+			t.Errorf("formatCounts(%v) = %q, expected %q", c.counts, got, c.expected)
+		}
+	}
+}
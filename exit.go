@@ -0,0 +1,157 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// Exit codes of subfixer, listed in the README for scripts to rely on.
+// A batch exits with the worst code of its files, see batchExitCode
+const (
+	ExitOK          = 0  // everything went fine
+	ExitError       = 1  // any other error, such as a missing input, a missing journal or a failing plugin
+	ExitUsage       = 2  // invalid command, flags, config or manifest
+	ExitParse       = 3  // a subtitle file could be read but not parsed
+	ExitWrite       = 4  // subtitles, a backup, the journal or a report could not be written
+	ExitCheckFailed = 10 // the perfection check failed
+	ExitChanges     = 11 // a dry run found changes
+	ExitPartial     = 12 // some files of a batch failed with an error, the others did not
+)
+
+// isErrorCode checks if code is one of an error, rather than a result
+// such as a failed check
+func isErrorCode(code int) bool {
+	return code != ExitOK && code != ExitCheckFailed && code != ExitChanges
+}
+
+// openExitCode returns the exit code for a subtitle file which cannot
+// be opened: ExitParse when it was read but is not valid
+func openExitCode(err error) int {
+	var pe *os.PathError
+	switch {
+	case errors.As(err, &pe):
+		return ExitError
+	case errors.Is(err, astisub.ErrInvalidExtension):
+		return ExitUsage
+	}
+	return ExitParse
+}
+
+// tally counts the changes made to a file & the violations found in it
+// by rule, for the summary. Changes are passed on to next, if set
+type tally struct {
+	changes    int
+	violations int
+	rules      map[string]int
+	next       astisub.Recorder
+}
+
+// newTally returns a tally passing changes on to j, if not nil
+func newTally(j *journal) *tally {
+	t := &tally{rules: make(map[string]int)}
+	if j != nil {
+		t.next = j
+	}
+	return t
+}
+
+// Record implements astisub.Recorder
+func (t *tally) Record(c astisub.Change) {
+	t.changes++
+	t.rules[c.Rule]++
+	if t.next != nil {
+		t.next.Record(c)
+	}
+}
+
+// countViolation adds v to the tally of s, when there is one
+func countViolation(s *astisub.Subtitles, v astisub.Violation) {
+	if t, ok := s.Recorder.(*tally); ok {
+		t.violations++
+		t.rules[v.Rule]++
+	}
+}
+
+// perfectionMessages runs the perfection checks on subtitle i & returns
+// the messages of its violations, along with those of extra, counting
+// each of them in the tally of s
+func perfectionMessages(s *astisub.Subtitles, i int, params cliParams, log astisub.Logger, extra []astisub.Violation) []string {
+	perrs := make([]string, 0)
+	for _, v := range append(s.PerfectionViolations(i, params.CommandParams, log), extra...) {
+		countViolation(s, v)
+		perrs = astisub.AddStringIfNotInArray(perrs, v.Message)
+	}
+
+	return perrs
+}
+
+// fileSummary is the outcome of a file in the -summary_json file. Rules
+// counts the changes or the violations of each rule
+type fileSummary struct {
+	File       string         `json:"file"`
+	Outcome    string         `json:"outcome"`
+	Exit       int            `json:"exit"`
+	Changes    int            `json:"changes"`
+	Violations int            `json:"violations"`
+	Rules      map[string]int `json:"rules"`
+	Seconds    float64        `json:"seconds"`
+}
+
+// runSummary is written to -summary_json once every file was processed
+type runSummary struct {
+	Command    string         `json:"command"`
+	Exit       int            `json:"exit"`
+	Files      []fileSummary  `json:"files"`
+	Outcomes   map[string]int `json:"outcomes"`
+	Changes    int            `json:"changes"`
+	Violations int            `json:"violations"`
+	Rules      map[string]int `json:"rules"`
+}
+
+// writeSummary writes the summary of the results of a batch to path
+func writeSummary(path string, command string, results []fileResult, code int) error {
+	sum := runSummary{
+		Command:  command,
+		Exit:     code,
+		Files:    make([]fileSummary, 0, len(results)),
+		Outcomes: make(map[string]int),
+		Rules:    make(map[string]int),
+	}
+
+	for _, r := range results {
+		fs := fileSummary{
+			File:    r.File,
+			Outcome: r.outcome(),
+			Exit:    r.Code,
+			Rules:   make(map[string]int),
+			Seconds: r.Elapsed.Seconds(),
+		}
+
+		if r.Tally != nil {
+			fs.Changes, fs.Violations = r.Tally.changes, r.Tally.violations
+			for rule, n := range r.Tally.rules {
+				fs.Rules[rule] = n
+				sum.Rules[rule] += n
+			}
+		}
+
+		sum.Outcomes[fs.Outcome]++
+		sum.Changes += fs.Changes
+		sum.Violations += fs.Violations
+		sum.Files = append(sum.Files, fs)
+	}
+
+	return astisub.WriteFileAtomic(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(sum)
+	})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+func TestBatchExitCode(t *testing.T) {
+	results := func(codes ...int) []fileResult {
+		var rs []fileResult
+		for _, code := range codes {
+			rs = append(rs, fileResult{Code: code})
+		}
+		return rs
+	}
+
+	for _, c := range []struct {
+		name     string
+		results  []fileResult
+		expected int
+	}{
+		{"empty", nil, ExitOK},
+		{"ok", results(ExitOK, ExitOK), ExitOK},
+		{"check failed", results(ExitOK, ExitCheckFailed, ExitOK), ExitCheckFailed},
+		{"changes", results(ExitChanges, ExitOK), ExitChanges},
+		{"check failed over changes", results(ExitChanges, ExitCheckFailed), ExitCheckFailed},
+		{"check failed after changes", results(ExitCheckFailed, ExitChanges), ExitCheckFailed},
+		{"same error", results(ExitParse, ExitParse), ExitParse},
+		{"different errors", results(ExitParse, ExitWrite), ExitError},
+		{"partial", results(ExitOK, ExitParse), ExitPartial},
+		{"partial after a failed check", results(ExitCheckFailed, ExitWrite), ExitPartial},
+		{"skipped files", []fileResult{{Code: ExitUsage}, {Code: ExitOK, Skipped: true}}, ExitUsage},
+	} {
+		if got := batchExitCode(c.results); got != c.expected {
+			t.Errorf("%s: exit %d, expected %d", c.name, got, c.expected)
+		}
+	}
+}
+
+func TestOpenExitCode(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.srt")
+	if err := os.WriteFile(invalid, []byte("1\n00:00:01,000 --> soon\nText\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	notes := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(notes, []byte(testSRT), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name     string
+		expected int
+	}{
+		{filepath.Join(dir, "missing.srt"), ExitError},
+		{invalid, ExitParse},
+		{notes, ExitUsage},
+	} {
+		_, err := openSubtitles(c.name)
+		if err == nil {
+			t.Errorf("%s opened", filepath.Base(c.name))
+			continue
+		}
+		if got := openExitCode(err); got != c.expected {
+			t.Errorf("%s: exit %d for %s, expected %d", filepath.Base(c.name), got, err, c.expected)
+		}
+	}
+}
+
+func TestMissingInputExitCode(t *testing.T) {
+	captureLog(t, LogFormatText)
+	files := writeTestFiles(t, "ep01.srt")
+	dir := filepath.Dir(files[0])
+	empty := t.TempDir()
+
+	for _, c := range []struct {
+		name     string
+		args     []string
+		expected int
+	}{
+		{"existing file", []string{"check", "-file", files[0]}, ExitOK},
+		{"missing file", []string{"check", "-file", filepath.Join(dir, "missing.srt")}, ExitError},
+		{"missing file to fix", []string{"fix", "-file", filepath.Join(dir, "missing.srt")}, ExitError},
+		{"glob matching nothing", []string{"check", "-file", filepath.Join(dir, "*.vtt.srt")}, ExitError},
+		{"folder without subtitles", []string{"check", "-file", empty}, ExitError},
+		{"all filtered out", []string{"check", "-file", dir, "-include", "*.ass"}, ExitError},
+	} {
+		if got := runTestCommand(c.args...); got != c.expected {
+			t.Errorf("%s: exit %d, expected %d", c.name, got, c.expected)
+		}
+	}
+}
+
+func TestTally(t *testing.T) {
+	j := newJournal("fix")
+	tl := newTally(j)
+	tl.Record(astisub.Change{Cue: 1, Rule: "split"})
+	tl.Record(astisub.Change{Cue: 2, Rule: "split"})
+	tl.Record(astisub.Change{Cue: 2, Rule: "extend_end"})
+
+	s := astisub.NewSubtitles()
+	s.Recorder = tl
+	countViolation(s, astisub.Violation{Rule: "chars_per_line"})
+
+	if tl.changes != 3 || tl.violations != 1 {
+		t.Errorf("%d changes & %d violations, expected 3 & 1", tl.changes, tl.violations)
+	}
+	if expected := map[string]int{"split": 2, "extend_end": 1, "chars_per_line": 1}; !reflect.DeepEqual(tl.rules, expected) {
+		t.Errorf("rules are %v, expected %v", tl.rules, expected)
+	}
+	// Changes are journaled too
+	if len(j.entries) != 3 {
+		t.Errorf("%d changes journaled, expected 3", len(j.entries))
+	}
+
+	// Without a tally, violations are not counted anywhere
+	s.Recorder = j
+	countViolation(s, astisub.Violation{Rule: "chars_per_line"})
+	if tl.violations != 1 {
+		t.Errorf("%d violations counted without a tally, expected 1", tl.violations)
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.json")
+	results := []fileResult{
+		{File: "a.srt", Code: ExitOK, Elapsed: time.Second, Tally: &tally{changes: 2, rules: map[string]int{"split": 2}}},
+		{File: "b.srt", Code: ExitCheckFailed, Tally: &tally{violations: 1, rules: map[string]int{"split": 1}}},
+		{File: "c.srt", Code: ExitParse},
+		{File: "d.srt", Skipped: true},
+	}
+	if err := writeSummary(path, "fix", results, ExitPartial); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum runSummary
+	if err := json.Unmarshal(data, &sum); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := runSummary{
+		Command: "fix",
+		Exit:    ExitPartial,
+		Files: []fileSummary{
+			{File: "a.srt", Outcome: "ok", Exit: ExitOK, Changes: 2, Rules: map[string]int{"split": 2}, Seconds: 1},
+			{File: "b.srt", Outcome: "check failed", Exit: ExitCheckFailed, Violations: 1, Rules: map[string]int{"split": 1}},
+			{File: "c.srt", Outcome: "parse error", Exit: ExitParse, Rules: map[string]int{}},
+			{File: "d.srt", Outcome: "skipped", Exit: ExitOK, Rules: map[string]int{}},
+		},
+		Outcomes:   map[string]int{"ok": 1, "check failed": 1, "parse error": 1, "skipped": 1},
+		Changes:    2,
+		Violations: 1,
+		Rules:      map[string]int{"split": 3},
+	}
+	if !reflect.DeepEqual(sum, expected) {
+		t.Errorf("summary is %+v, expected %+v", sum, expected)
+	}
+}
+
+func TestSummaryJSON(t *testing.T) {
+	captureLog(t, LogFormatText)
+	dir := t.TempDir()
+	inDir(t, dir)
+
+	files := writeTestFiles(t, "a.srt", "b.srt")
+	if err := os.WriteFile(files[1], []byte(streamTestSRT(5)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "summary.json")
+
+	for _, c := range []struct {
+		command  string
+		args     []string
+		expected int
+		outcomes map[string]int
+	}{
+		{"check", nil, ExitCheckFailed, map[string]int{"ok": 1, "check failed": 1}},
+		{"fix", []string{"-backup_keep", "0", "-journal", JournalOff}, ExitOK, map[string]int{"ok": 2}},
+	} {
+		args := append([]string{c.command, "-file", filepath.Dir(files[0]), "-summary_json", path}, c.args...)
+		if code := runTestCommand(args...); code != c.expected {
+			t.Errorf("%s: exit %d, expected %d", c.command, code, c.expected)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sum runSummary
+		if err := json.Unmarshal(data, &sum); err != nil {
+			t.Fatal(err)
+		}
+
+		if sum.Command != c.command || sum.Exit != c.expected || !reflect.DeepEqual(sum.Outcomes, c.outcomes) {
+			t.Errorf("%s: summary of %s with exit %d & %v", c.command, sum.Command, sum.Exit, sum.Outcomes)
+		}
+		// The bad file is what the check found violations in & what the
+		// fix changed
+		if len(sum.Files) != 2 || (sum.Files[1].Violations == 0 && sum.Files[1].Changes == 0) {
+			t.Errorf("%s: files are %+v", c.command, sum.Files)
+		}
+	}
+}
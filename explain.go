@@ -0,0 +1,222 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// DefaultNeighbours is the number of subtitles explained on each side
+// of the one asked for
+const DefaultNeighbours = 1
+
+// traceNode is a step or a decision of the trace of a fix
+type traceNode struct {
+	cue      int
+	label    string
+	values   []interface{}
+	step     bool
+	watched  bool
+	children []*traceNode
+}
+
+// explainTracer builds the trace of a fix as a tree of steps, keeping
+// track of the subtitles being explained as splits renumber them
+type explainTracer struct {
+	s       *astisub.Subtitles
+	watched map[int]bool
+	roots   []*traceNode
+	stack   []*traceNode
+}
+
+// add appends n to the current step, or as a new root
+func (t *explainTracer) add(n *traceNode) {
+	if len(t.stack) == 0 {
+		t.roots = append(t.roots, n)
+		return
+	}
+
+	top := t.stack[len(t.stack)-1]
+	top.children = append(top.children, n)
+}
+
+// Begin implements astisub.Tracer
+func (t *explainTracer) Begin(i int, step string, values ...interface{}) {
+	n := &traceNode{cue: i, label: step, values: values, step: true, watched: t.watched[i]}
+	t.add(n)
+	t.stack = append(t.stack, n)
+}
+
+// Decide implements astisub.Tracer
+func (t *explainTracer) Decide(i int, decision string, values ...interface{}) {
+	n := &traceNode{cue: i, label: decision, values: values, watched: t.watched[i]}
+
+	if decision == "split" {
+		// Subtitles after i move down by one, the new one
+		// being explained along with the one it was split from
+		watched := make(map[int]bool)
+		for w := range t.watched {
+			if w > i {
+				watched[w+1] = true
+			} else {
+				watched[w] = true
+			}
+		}
+		if t.watched[i] {
+			watched[i+1] = true
+		}
+		t.watched = watched
+
+		n.values = append(n.values, "new", fmt.Sprintf("#%d %s", i+2, astisub.TimingString(t.s.Items[i+1])))
+	}
+
+	t.add(n)
+}
+
+// End implements astisub.Tracer
+func (t *explainTracer) End() {
+	if len(t.stack) > 0 {
+		t.stack = t.stack[:len(t.stack)-1]
+	}
+}
+
+// shown lists the children of n to print. Every decision is shown
+// under a step about a subtitle being explained, elsewhere only those
+// leading to one. Steps which decided nothing are left out
+func (n *traceNode) shown(full bool) []*traceNode {
+	var nodes []*traceNode
+	for _, c := range n.children {
+		if c.visible(full) {
+			nodes = append(nodes, c)
+		}
+	}
+
+	return nodes
+}
+
+// visible checks if n is printed under a step, full if every
+// decision of the step is
+func (n *traceNode) visible(full bool) bool {
+	if !n.step {
+		return full || n.watched
+	}
+
+	return len(n.shown(full || n.watched)) > 0
+}
+
+// formatValue formats a traced value, rounding seconds & speeds
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(math.Round(v*1000)/1000, 'f', -1, 64)
+	case string:
+		if strings.ContainsAny(v, " \n") {
+			return strconv.Quote(v)
+		}
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// String formats n as a line of the tree, the timing it resulted in last
+func (n *traceNode) String() string {
+	parts := []string{fmt.Sprintf("%s #%d", n.label, n.cue+1)}
+	timing := ""
+
+	for k := 0; k+1 < len(n.values); k += 2 {
+		name := fmt.Sprint(n.values[k])
+		if name == "timing" {
+			timing = fmt.Sprint(n.values[k+1])
+			continue
+		}
+		parts = append(parts, name+"="+formatValue(n.values[k+1]))
+	}
+
+	line := strings.Join(parts, " ")
+	if timing != "" {
+		line += " → " + timing
+	}
+
+	return line
+}
+
+// writeTree writes the children of n below it with tree glyphs
+func writeTree(w io.Writer, n *traceNode, prefix string, full bool) {
+	children := n.shown(full)
+
+	for k, c := range children {
+		glyph, indent := "├─ ", "│  "
+		if k == len(children)-1 {
+			glyph, indent = "└─ ", "   "
+		}
+
+		fmt.Fprintf(w, "%s%s%s\n", prefix, glyph, c)
+		writeTree(w, c, prefix+indent, full || c.watched)
+	}
+}
+
+// writeCues writes the timing & text of the watched subtitles
+func writeCues(w io.Writer, s *astisub.Subtitles, watched map[int]bool) {
+	for i, item := range s.Items {
+		if watched[i] {
+			fmt.Fprintf(w, "  #%-5d %s  %s\n", i+1, astisub.TimingString(item), strings.Replace(item.String(), "\n", " | ", -1))
+		}
+	}
+}
+
+// ExplainOperation replays a fix of the subtitles, printing the
+// decisions taken about subtitle -cue & its neighbours as a tree,
+// with the values which led to each one. Nothing is saved
+func ExplainOperation(s *astisub.Subtitles, params cliParams, log *leveledLogger) int {
+	target, _ := strconv.Atoi(params.Cues[0].Start)
+	if target < 1 || target > len(s.Items) {
+		log.Errorf("'%s' has no subtitle #%d, it has %d", params.File, target, len(s.Items))
+		return ExitError
+	}
+
+	t := &explainTracer{s: s, watched: make(map[int]bool)}
+	for i := target - 1 - params.Neighbours; i <= target-1+params.Neighbours; i++ {
+		if i >= 0 && i < len(s.Items) {
+			t.watched[i] = true
+		}
+	}
+
+	w := os.Stdout
+	fmt.Fprintf(w, "Explaining subtitle #%d of '%s' with %d neighbour(s) on each side\n\n", target, params.File, params.Neighbours)
+	fmt.Fprintf(w, "Before:\n")
+	writeCues(w, s, t.watched)
+
+	s.Tracer = t
+	fixSubtitles(s, params, log)
+	s.Tracer = nil
+
+	fmt.Fprintf(w, "\nDecisions (ids as numbered at the time):\n")
+	decided := false
+	for _, root := range t.roots {
+		if !root.visible(false) {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\n", root)
+		writeTree(w, root, "", root.watched)
+		decided = true
+	}
+	if !decided {
+		fmt.Fprintf(w, "  none, the subtitles are left as they are\n")
+	}
+
+	fmt.Fprintf(w, "\nAfter:\n")
+	writeCues(w, s, t.watched)
+
+	return 0
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// explainTestSRT holds a subtitle to split between two others
+const explainTestSRT = `1
+00:00:01,000 --> 00:00:01,300
+Far too short to be read in time
+
+2
+00:00:03,300 --> 00:00:05,300
+Hi
+
+3
+00:00:05,500 --> 00:00:14,500
+A first line long enough to be split
+and a second line to go with it
+
+4
+00:00:20,000 --> 00:00:22,000
+Fine as it is here
+`
+
+func TestFormatValue(t *testing.T) {
+	for _, c := range []struct {
+		value    interface{}
+		expected string
+	}{
+		{4.83612, "4.836"},
+		{7.0, "7"},
+		{"split", "split"},
+		{"two words", `"two words"`},
+		{"two\nlines", `"two\nlines"`},
+		{3, "3"},
+		{true, "true"},
+	} {
+		if got := formatValue(c.value); got != c.expected {
+			t.Errorf("formatValue(%#v) = %q, expected %q", c.value, got, c.expected)
+		}
+	}
+}
+
+func TestTraceNodeString(t *testing.T) {
+	for _, c := range []struct {
+		node     traceNode
+		expected string
+	}{
+		{traceNode{cue: 2, label: "AdjustDuration", values: []interface{}{"pass", 1, "speed", 7.44444}}, "AdjustDuration #3 pass=1 speed=7.444"},
+		{traceNode{cue: 0, label: "extend", values: []interface{}{"extend_by", 0.5, "timing", "00:00:01,000 --> 00:00:02,500"}}, "extend #1 extend_by=0.5 → 00:00:01,000 --> 00:00:02,500"},
+		{traceNode{cue: 4, label: "fits"}, "fits #5"},
+		// A value without a name is left out
+		{traceNode{cue: 0, label: "trim", values: []interface{}{"length", 2.0, "odd"}}, "trim #1 length=2"},
+	} {
+		if got := c.node.String(); got != c.expected {
+			t.Errorf("node is %q, expected %q", got, c.expected)
+		}
+	}
+}
+
+func TestExplainTracer(t *testing.T) {
+	s := readTestSubtitles(t, explainTestSRT)
+	// The split has already inserted the new subtitle when it is traced
+	s.Insert(3, s.Items[2])
+	tr := &explainTracer{s: s, watched: map[int]bool{1: true, 2: true, 3: true}}
+
+	tr.Begin(2, "AdjustDuration")
+	tr.Decide(2, "split")
+	tr.Decide(0, "trim")
+	tr.End()
+	tr.Begin(0, "AdjustDuration")
+	tr.Decide(0, "extend")
+	tr.End()
+	tr.End()
+
+	// Subtitles after the split move down, the new one being watched
+	if expected := map[int]bool{1: true, 2: true, 3: true, 4: true}; !reflect.DeepEqual(tr.watched, expected) {
+		t.Errorf("watching %v after the split, expected %v", tr.watched, expected)
+	}
+	if len(tr.roots) != 2 || len(tr.roots[0].children) != 2 {
+		t.Fatalf("trace has %d roots, expected 2 with 2 decisions under the first", len(tr.roots))
+	}
+	if split := tr.roots[0].children[0].String(); !strings.Contains(split, `new="#4 00:00:05,500 --> 00:00:14,500"`) {
+		t.Errorf("split is traced as %q, without the new subtitle", split)
+	}
+
+	// Under a watched step every decision is shown, elsewhere only those
+	// about a watched subtitle
+	for _, c := range []struct {
+		node     *traceNode
+		full     bool
+		expected int
+	}{
+		{tr.roots[0], true, 2},
+		{tr.roots[0], false, 1},
+		{tr.roots[1], false, 0},
+	} {
+		if got := len(c.node.shown(c.full)); got != c.expected {
+			t.Errorf("%s, full %t: %d decisions shown, expected %d", c.node, c.full, got, c.expected)
+		}
+	}
+	if tr.roots[1].visible(false) {
+		t.Errorf("%s is shown without deciding anything about a watched subtitle", tr.roots[1])
+	}
+}
+
+func TestValidateExplain(t *testing.T) {
+	for _, c := range []struct {
+		args  []string
+		fails bool
+	}{
+		{args: []string{"-cue", "3"}},
+		{args: []string{"-cue", "3", "-neighbours", "0"}},
+		{args: []string{"-cue", "3-5"}, fails: true},
+		{args: []string{"-cue", "3,5"}, fails: true},
+		{args: []string{"-cue", "3", "-neighbours", "-1"}, fails: true},
+		{args: nil, fails: true},
+	} {
+		err := validateExplain(commandParams(t, "explain", c.args...))
+		if (err != nil) != c.fails {
+			t.Errorf("validateExplain(%v) = %v, expected failure %t", c.args, err, c.fails)
+		}
+	}
+}
+
+func TestExplainOperation(t *testing.T) {
+	captureLog(t, LogFormatText)
+	name := writeTestFiles(t, "ep01.srt")[0]
+	if err := os.WriteFile(name, []byte(explainTestSRT), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		args     []string
+		expected int
+		contains []string
+		excludes []string
+	}{
+		{[]string{"-cue", "3", "-neighbours", "0"}, ExitOK, []string{
+			"Explaining subtitle #3",
+			"#3     00:00:05,500 --> 00:00:14,500",
+			"├─ split #3 length=9 split_longer_than=7",
+			"#4     00:00:10,335 --> 00:00:14,500  and a second line to go with it",
+		}, []string{"#1     ", "AdjustDuration #1 "}},
+		{[]string{"-cue", "4", "-neighbours", "0"}, ExitOK, []string{"#4     00:00:20,000 --> 00:00:22,000"}, []string{"split #3"}},
+		{[]string{"-cue", "9"}, ExitError, nil, nil},
+	} {
+		out := redirect(t, &os.Stdout, "")
+		if code := runTestCommand(append([]string{"explain", "-file", name}, c.args...)...); code != c.expected {
+			t.Errorf("explain %v: exit %d, expected %d", c.args, code, c.expected)
+			continue
+		}
+
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range c.contains {
+			if !bytes.Contains(data, []byte(s)) {
+				t.Errorf("explain %v: %q missing from\n%s", c.args, s, data)
+			}
+		}
+		for _, s := range c.excludes {
+			if bytes.Contains(data, []byte(s)) {
+				t.Errorf("explain %v: unexpected %q in\n%s", c.args, s, data)
+			}
+		}
+
+		// Nothing is saved
+		if saved, _ := os.ReadFile(name); string(saved) != explainTestSRT {
+			t.Errorf("explain %v changed the file", c.args)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// subtitleExts are the extensions of the files picked up from
+// directories, those astisub can read
+var subtitleExts = map[string]bool{".srt": true}
+
+// isSubtitle checks if name has the extension of a subtitle file
+func isSubtitle(name string) bool {
+	return subtitleExts[strings.ToLower(filepath.Ext(name))]
+}
+
+// isDir checks if path is an existing directory
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// walker collects the files below directories. Links to directories are
+// followed, each directory being walked once so that links pointing back
+// up do not loop. Hidden files & directories are skipped, as are the
+// directories in skip, by their path with links resolved
+type walker struct {
+	visited map[string]bool
+	skip    map[string]bool
+	files   []string
+}
+
+// walk adds the files below dir for which match returns true
+func (w *walker) walk(dir string, match func(path string) bool) error {
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	if w.skip[real] {
+		return nil
+	}
+
+	if w.visited[real] {
+		logger.Debugf("Skipping %s, which links to %s already walked", dir, real)
+		return nil
+	}
+	w.visited[real] = true
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		path := filepath.Join(dir, e.Name())
+		sub := e.IsDir()
+
+		if e.Type()&os.ModeSymlink != 0 {
+			fi, err := os.Stat(path)
+			if err != nil {
+				logger.Warnf("Skipping broken link %s", path)
+				continue
+			}
+			sub = fi.IsDir()
+		}
+
+		if sub {
+			if err := w.walk(path, match); err != nil {
+				logger.Warnf("Skipping directory %s: %s", path, err)
+			}
+			continue
+		}
+
+		if match(path) {
+			w.files = append(w.files, path)
+		}
+	}
+
+	return nil
+}
+
+// matchSegments matches the parts of a slash separated path against
+// those of a pattern, where a ** part matches any number of directories
+func matchSegments(pattern []string, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for k := 0; k <= len(path); k++ {
+				if matchSegments(pattern[1:], path[k:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(path) == 0 {
+			return false
+		}
+
+		if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+			return false
+		}
+
+		pattern, path = pattern[1:], path[1:]
+	}
+
+	return len(path) == 0
+}
+
+// matchPath matches path against pattern, which may use **
+func matchPath(pattern string, path string) bool {
+	return matchSegments(
+		strings.Split(filepath.ToSlash(pattern), "/"),
+		strings.Split(filepath.ToSlash(path), "/"))
+}
+
+// walkPattern returns the files matching a pattern using **. A pattern
+// ending in ** matches every subtitle file below it
+func walkPattern(pattern string) ([]string, error) {
+	root := globBase(pattern)
+	rel, err := filepath.Rel(root, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	anyFile := strings.HasSuffix(rel, "**")
+
+	w := &walker{visited: make(map[string]bool)}
+	err = w.walk(root, func(path string) bool {
+		r, err := filepath.Rel(root, path)
+		if err != nil {
+			return false
+		}
+		return matchPath(rel, r) && (!anyFile || isSubtitle(path))
+	})
+
+	return w.files, err
+}
+
+// matchFilter checks if path matches one of the comma separated globs.
+// Globs with a / are matched against the path, others against the name
+func matchFilter(globs string, path string) bool {
+	for _, glob := range strings.Split(globs, ",") {
+		if glob = strings.TrimSpace(glob); glob == "" {
+			continue
+		}
+
+		if strings.Contains(glob, "/") {
+			if matchPath(glob, path) || matchPath("**/"+glob, path) {
+				return true
+			}
+		} else if ok, _ := filepath.Match(glob, filepath.Base(path)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeLang makes language tags comparable, pt_br matching pt-BR
+func normalizeLang(lang string) string {
+	return strings.ToLower(strings.Replace(lang, "_", "-", -1))
+}
+
+// keepFile applies -include, -exclude & -lang to path
+func keepFile(path string, params cliParams) bool {
+	if params.Include != "" && !matchFilter(params.Include, path) {
+		return false
+	}
+
+	if params.Exclude != "" && matchFilter(params.Exclude, path) {
+		return false
+	}
+
+	if params.Lang != "" {
+		stem, _ := splitExt(filepath.Base(path))
+		_, lang := splitLang(stem)
+
+		wanted := false
+		for _, l := range strings.Split(params.Lang, ",") {
+			if lang != "" && normalizeLang(strings.TrimSpace(l)) == normalizeLang(lang) {
+				wanted = true
+			}
+		}
+		if !wanted {
+			return false
+		}
+	}
+
+	return true
+}
+
+// expandFiles returns the files to process for params.File, which can
+// be a file, a glob, a directory or a pattern using ** to match any
+// number of directories. Directories are searched for subtitle files
+// recursively. A zip archive stands for its subtitle entries, and a
+// path inside one (delivery.zip!/en/*.srt) for those it matches. The
+// files are then filtered with -include, -exclude and -lang
+func expandFiles(params cliParams) ([]string, error) {
+	if params.File == "-" {
+		return []string{"-"}, nil
+	}
+
+	var found []string
+
+	if archive, pattern, ok := splitZipPath(params.File); ok {
+		entries, err := zipEntries(archive, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("reading archive '%s' failed: %s", archive, err)
+		}
+		found = entries
+	} else if strings.Contains(params.File, "**") {
+		files, err := walkPattern(params.File)
+		if err != nil {
+			return nil, fmt.Errorf("searching files for '%s' failed: %s", params.File, err)
+		}
+		found = files
+	} else {
+		matches, err := filepath.Glob(params.File)
+		if err != nil {
+			return nil, fmt.Errorf("globbing list of files for '%s' failed: %s", params.File, err)
+		}
+
+		w := &walker{visited: make(map[string]bool)}
+		for _, m := range matches {
+			if isZip(m) && !isDir(m) {
+				entries, err := zipEntries(m, "")
+				if err != nil {
+					return nil, fmt.Errorf("reading archive '%s' failed: %s", m, err)
+				}
+				found = append(found, entries...)
+				continue
+			}
+
+			if !isDir(m) {
+				found = append(found, m)
+				continue
+			}
+
+			if err := w.walk(m, isSubtitle); err != nil {
+				return nil, fmt.Errorf("searching files in '%s' failed: %s", m, err)
+			}
+		}
+		found = append(found, w.files...)
+	}
+
+	seen := make(map[string]bool)
+	var files []string
+	for _, f := range found {
+		if !seen[f] && keepFile(f, params) {
+			seen[f] = true
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+
+	if len(files) > 1 && params.Out != "" && params.Out != "-" && !isZip(params.Out) {
+		return nil, fmt.Errorf("-out '%s' can only be used with a single input file", params.Out)
+	}
+
+	return files, nil
+}
+
+// listFiles prints the files which would be processed, one per line
+func listFiles(files []string) int {
+	for _, f := range files {
+		fmt.Println(f)
+	}
+
+	logger.Infof("%d files would be processed", len(files))
+	return 0
+}
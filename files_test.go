@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchPath(t *testing.T) {
+	for _, c := range []struct {
+		pattern, path string
+		expected      bool
+	}{
+		{"ep01.srt", "ep01.srt", true},
+		{"*.srt", "ep01.srt", true},
+		{"*.srt", "season1/ep01.srt", false},
+		{"**/*.srt", "ep01.srt", true},
+		{"**/*.srt", "season1/extras/ep01.srt", true},
+		{"season1/**", "season1/extras/ep01.srt", true},
+		{"season1/**", "season2/ep01.srt", false},
+		{"season*/**/ep0?.srt", "season1/a/b/ep01.srt", true},
+		{"season*/**/ep0?.srt", "season1/a/b/ep10.srt", false},
+		{"**/extras/*", "season1/extras/ep01.srt", true},
+		{"**/extras/*", "season1/extras", false},
+		{"season1/*", "season1/extras/ep01.srt", false},
+	} {
+		if got := matchPath(c.pattern, c.path); got != c.expected {
+			t.Errorf("matchPath(%q, %q) = %t, expected %t", c.pattern, c.path, got, c.expected)
+		}
+	}
+}
+
+func TestMatchFilter(t *testing.T) {
+	for _, c := range []struct {
+		globs, path string
+		expected    bool
+	}{
+		{"*.srt", "season1/ep01.srt", true},
+		{"*.vtt, *.srt", "season1/ep01.srt", true},
+		{"*.vtt", "season1/ep01.srt", false},
+		{"extras/*", "season1/extras/ep01.srt", true},
+		{"season1/extras/*", "season1/extras/ep01.srt", true},
+		{"extras/*", "season1/ep01.srt", false},
+		{" , ", "season1/ep01.srt", false},
+	} {
+		if got := matchFilter(c.globs, c.path); got != c.expected {
+			t.Errorf("matchFilter(%q, %q) = %t, expected %t", c.globs, c.path, got, c.expected)
+		}
+	}
+}
+
+func TestKeepFile(t *testing.T) {
+	for _, c := range []struct {
+		path     string
+		params   cliParams
+		expected bool
+	}{
+		{"ep01.en.srt", cliParams{}, true},
+		{"ep01.en.srt", cliParams{Include: "ep01*"}, true},
+		{"ep01.en.srt", cliParams{Include: "ep02*"}, false},
+		{"ep01.en.srt", cliParams{Exclude: "*.en.srt"}, false},
+		{"ep01.en.srt", cliParams{Include: "ep*", Exclude: "ep01*"}, false},
+		{"ep01.en.srt", cliParams{Lang: "fr, en"}, true},
+		{"ep01.pt_br.srt", cliParams{Lang: "pt-BR"}, true},
+		{"ep01.fr.srt", cliParams{Lang: "en"}, false},
+		{"ep01.srt", cliParams{Lang: "en"}, false},
+	} {
+		if got := keepFile(c.path, c.params); got != c.expected {
+			t.Errorf("keepFile(%q, %+v) = %t, expected %t", c.path, c.params, got, c.expected)
+		}
+	}
+}
+
+func TestExpandFiles(t *testing.T) {
+	captureLog(t, LogFormatText)
+	dir := t.TempDir()
+	inDir(t, dir)
+
+	for _, name := range []string{
+		"season1/ep01.en.srt",
+		"season1/ep01.fr.srt",
+		"season1/notes.txt",
+		"season1/extras/ep01.en.srt",
+		"season1/.hidden/ep01.en.srt",
+		"season2/ep01.en.srt",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(testSRT), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A link back up is walked once
+	if err := os.Symlink("..", filepath.Join("season1", "extras", "up")); err != nil {
+		t.Skipf("cannot create links: %s", err)
+	}
+
+	for _, c := range []struct {
+		file     string
+		params   cliParams
+		expected []string
+		fails    bool
+	}{
+		{file: "-", expected: []string{"-"}},
+		{file: "season1/ep01.en.srt", expected: []string{"season1/ep01.en.srt"}},
+		{file: "season1", expected: []string{"season1/ep01.en.srt", "season1/ep01.fr.srt", "season1/extras/ep01.en.srt"}},
+		{file: ".", expected: []string{"season1/ep01.en.srt", "season1/ep01.fr.srt", "season1/extras/ep01.en.srt", "season2/ep01.en.srt"}},
+		{file: ".", params: cliParams{Lang: "fr"}, expected: []string{"season1/ep01.fr.srt"}},
+		{file: ".", params: cliParams{Exclude: "extras/*"}, expected: []string{"season1/ep01.en.srt", "season1/ep01.fr.srt", "season2/ep01.en.srt"}},
+		{file: "*/ep01.en.srt", expected: []string{"season1/ep01.en.srt", "season2/ep01.en.srt"}},
+		{file: "**/extras/*.srt", expected: []string{"season1/extras/ep01.en.srt"}},
+		{file: "season1/**", expected: []string{"season1/ep01.en.srt", "season1/ep01.fr.srt", "season1/extras/ep01.en.srt"}},
+		{file: "season1/**/*.txt", expected: []string{"season1/notes.txt"}},
+		{file: "season3", expected: nil},
+		{file: "season1/*.srt", params: cliParams{Out: "out.srt"}, fails: true},
+		{file: "season1/*.srt", params: cliParams{Out: "-"}, expected: []string{"season1/ep01.en.srt", "season1/ep01.fr.srt"}},
+	} {
+		c.params.File = c.file
+		files, err := expandFiles(c.params)
+		if (err != nil) != c.fails {
+			t.Errorf("%s: error %v, expected failure %t", c.file, err, c.fails)
+			continue
+		}
+
+		var expected []string
+		for _, f := range c.expected {
+			expected = append(expected, filepath.FromSlash(f))
+		}
+		if err == nil && !reflect.DeepEqual(files, expected) {
+			t.Errorf("%s %+v: expanded to %v, expected %v", c.file, c.params, files, expected)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+// Package fixer fixes & checks subtitles as the subfixer command does,
+// for programs embedding it. Nothing is printed: diagnostics go to the
+// Logger of the Options, and the changes made are returned as data.
+//
+//	s, err := astisub.OpenFile("ep01.srt")
+//	...
+//	opts := fixer.DefaultOptions()
+//	opts.Speed = 17
+//	report, err := fixer.Fix(ctx, s, opts)
+//	...
+//	violations, err := fixer.Check(ctx, s, fixer.DefaultCheckOptions())
+package fixer
+
+import (
+	"context"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// Defaults of the options, also those of the flags of subfixer
+const (
+	DefaultSpeed            = 21.0
+	DefaultSpeedEpsilon     = 1.0
+	DefaultMinLength        = time.Second
+	DefaultTrimSpaces       = 1
+	DefaultJoinShorterThan  = 42
+	DefaultExpandCloserThan = 500 * time.Millisecond
+	DefaultSplitLongerThan  = 7 * time.Second
+	DefaultShrinkLongerThan = 7 * time.Second
+	DefaultMaxLines         = 2
+	DefaultCharsPerLine     = 42
+	DefaultLineBalance      = 50.0
+	DefaultPreferCompact    = true
+	DefaultForbiddenChars   = "{./;/!/?/,:}"
+	DefaultSpacesAsChars    = true
+	DefaultNewlinesAsChars  = false
+	DefaultMaxLength        = time.Duration(0)
+	DefaultMinGapFrames     = 0
+	DefaultFrameRate        = 25.0
+)
+
+// Violation is a perfection check failed by a subtitle
+type Violation = astisub.Violation
+
+// Change is a change made to a subtitle by Fix
+type Change = astisub.Change
+
+// Options are the settings of Fix & Check, named after the flags of
+// subfixer. Start from DefaultOptions, as a zero value turns most of
+// the rules off
+type Options struct {
+	// Speed is the reading speed Fix aims for, in characters per second,
+	// within SpeedEpsilon percent
+	Speed        float64
+	SpeedEpsilon float64
+
+	// MinLength is the shortest a subtitle may last
+	MinLength time.Duration
+
+	// TrimSpaces trims the spaces around lines, 0 to keep them
+	TrimSpaces int
+
+	// JoinShorterThan joins subtitles whose text together has fewer
+	// characters, ExpandCloserThan expands subtitles closer than that to
+	// the next one, and subtitles lasting longer than SplitLongerThan or
+	// ShrinkLongerThan are split or shrunk
+	JoinShorterThan  int
+	ExpandCloserThan time.Duration
+	SplitLongerThan  time.Duration
+	ShrinkLongerThan time.Duration
+
+	// MaxLines, CharsPerLine, ReadingSpeed (characters per second),
+	// LineBalance (percent), PreferCompact & ForbiddenChars are checked
+	// on each subtitle, as are MaxLength & the gap of MinGapFrames at
+	// FrameRate to the next one. A MaxLength of 0 allows any length
+	MaxLines       int
+	CharsPerLine   int
+	ReadingSpeed   float64
+	LineBalance    float64
+	PreferCompact  bool
+	ForbiddenChars string
+	MaxLength      time.Duration
+	MinGapFrames   int
+	FrameRate      float64
+
+	// SpacesAsChars & NewlinesAsChars count spaces & line breaks as
+	// characters when working out reading speeds & line lengths
+	SpacesAsChars   bool
+	NewlinesAsChars bool
+
+	// LimitTo only processes some subtitles, by id or time, in the syntax
+	// of -limit_to: 1-2,4-10,18 or 00:01:00.000-00:02:00.000. Empty
+	// processes every subtitle
+	LimitTo string
+
+	// Logger is given the diagnostics of the passes, nil discards them
+	Logger astisub.Logger
+}
+
+// Report is what Fix did to the subtitles
+type Report struct {
+	// Changes lists every change applied, in order. Cue is the id of
+	// the subtitle at the time of the change
+	Changes []Change `json:"changes"`
+
+	// Before & After are the number of subtitles before & after
+	Before int `json:"before"`
+	After  int `json:"after"`
+}
+
+// DefaultOptions returns the options subfixer fix uses when given no
+// flags. Check given them also reports subtitles shorter than MinLength,
+// which subfixer check does not unless asked: see DefaultCheckOptions
+func DefaultOptions() Options {
+	return Options{
+		Speed:            DefaultSpeed,
+		SpeedEpsilon:     DefaultSpeedEpsilon,
+		MinLength:        DefaultMinLength,
+		TrimSpaces:       DefaultTrimSpaces,
+		JoinShorterThan:  DefaultJoinShorterThan,
+		ExpandCloserThan: DefaultExpandCloserThan,
+		SplitLongerThan:  DefaultSplitLongerThan,
+		ShrinkLongerThan: DefaultShrinkLongerThan,
+		MaxLines:         DefaultMaxLines,
+		CharsPerLine:     DefaultCharsPerLine,
+		ReadingSpeed:     DefaultSpeed,
+		LineBalance:      DefaultLineBalance,
+		PreferCompact:    DefaultPreferCompact,
+		ForbiddenChars:   DefaultForbiddenChars,
+		MaxLength:        DefaultMaxLength,
+		MinGapFrames:     DefaultMinGapFrames,
+		FrameRate:        DefaultFrameRate,
+		SpacesAsChars:    DefaultSpacesAsChars,
+		NewlinesAsChars:  DefaultNewlinesAsChars,
+	}
+}
+
+// DefaultCheckOptions returns the options subfixer check uses when given
+// no flags: those of fix, MinLength being off
+func DefaultCheckOptions() Options {
+	opts := DefaultOptions()
+	opts.MinLength = 0
+	return opts
+}
+
+// params converts the options to the parameters of the astisub functions
+func (o Options) params() astisub.CommandParams {
+	return astisub.CommandParams{
+		Speed:            o.Speed,
+		SpeedEpsilon:     o.SpeedEpsilon,
+		MinLength:        o.MinLength.Seconds(),
+		TrimSpaces:       o.TrimSpaces,
+		JoinShorterThan:  o.JoinShorterThan,
+		ExpandCloserThan: o.ExpandCloserThan.Seconds(),
+		SplitLongerThan:  o.SplitLongerThan.Seconds(),
+		ShrinkLongerThan: o.ShrinkLongerThan.Seconds(),
+		LimitTo:          ParseRanges(o.LimitTo),
+		MaxLines:         o.MaxLines,
+		CharsPerLine:     o.CharsPerLine,
+		ReadingSpeed:     o.ReadingSpeed,
+		LineBalance:      o.LineBalance,
+		PreferCompact:    o.PreferCompact,
+		SpacesAsChars:    o.SpacesAsChars,
+		NewlinesAsChars:  o.NewlinesAsChars,
+		ForbiddenChars:   o.ForbiddenChars,
+		MaxLength:        o.MaxLength.Seconds(),
+		MinGapFrames:     o.MinGapFrames,
+		FrameRate:        o.FrameRate,
+	}
+}
+
+// logger returns the Logger of the options, or one discarding everything
+func (o Options) logger() astisub.Logger {
+	if o.Logger == nil {
+		return astisub.NopLogger{}
+	}
+	return o.Logger
+}
+
+// changes collects the changes made by Fix, passing them on to the
+// recorder which was set on the subtitles, if any
+type changes struct {
+	list []Change
+	next astisub.Recorder
+}
+
+// Record implements astisub.Recorder
+func (c *changes) Record(change Change) {
+	c.list = append(c.list, change)
+	if c.next != nil {
+		c.next.Record(change)
+	}
+}
+
+// Fix adjusts the durations of the subtitles within the limits of the
+// options, joining, splitting, expanding & shrinking them as needed.
+// The subtitles are changed in place. If ctx is done before the end,
+// the context error is returned along with the changes made so far,
+// the subtitles being left partly fixed
+func Fix(ctx context.Context, s *astisub.Subtitles, opts Options) (Report, error) {
+	log := opts.logger()
+	params := opts.params()
+
+	report := Report{Before: len(s.Items)}
+	rec := &changes{next: s.Recorder}
+	s.Recorder = rec
+	defer func() { s.Recorder = rec.next }()
+
+	Mark(s, ParseLimits(params.LimitTo), log)
+	err := passes(ctx, s, params, log)
+
+	report.Changes = rec.list
+	report.After = len(s.Items)
+
+	return report, err
+}
+
+// Check runs the perfection checks on the subtitles within the limits
+// of the options, without changing them. The violations are returned
+// in the order of the subtitles
+func Check(ctx context.Context, s *astisub.Subtitles, opts Options) ([]Violation, error) {
+	log := opts.logger()
+	params := opts.params()
+
+	Mark(s, ParseLimits(params.LimitTo), log)
+
+	violations := make([]Violation, 0)
+	for i, item := range s.Items {
+		if err := ctx.Err(); err != nil {
+			return violations, err
+		}
+		if item.Process {
+			violations = append(violations, s.PerfectionViolations(i, params, log)...)
+		}
+	}
+
+	return violations, nil
+}
+
+// Adjust runs the three AdjustDuration passes on subtitle i and
+// returns the number of subtitles to move forward by. It lets a caller
+// fix subtitles as they come, such as when streaming a file
+func Adjust(s *astisub.Subtitles, i int, opts Options) int {
+	return adjust(s, i, opts.params(), opts.logger())
+}
+
+// adjust is Adjust with the options converted
+func adjust(s *astisub.Subtitles, i int, params astisub.CommandParams, log astisub.Logger) int {
+	incBy := 1
+
+	for p := 0; p < 3; p++ {
+		log.Log(astisub.LevelDebug, "Starting pass", "id", i+1, "pass", p+1)
+		s.Pass = p + 1
+		incBy = s.AdjustDuration(i, params, log)
+		if incBy <= 0 {
+			log.Log(astisub.LevelDebug, "Skipping further passes as subtitles seems to have been deleted / split")
+			break
+		}
+	}
+
+	return incBy
+}
+
+// Passes runs the Adjust passes over the subtitles marked for
+// processing, then shifts those which are still unfit. Unlike Fix, it
+// leaves the marks, the LimitTo of the options & the recorder of the
+// subtitles to the caller. It stops with the context error once ctx is
+// done
+func Passes(ctx context.Context, s *astisub.Subtitles, opts Options) error {
+	return passes(ctx, s, opts.params(), opts.logger())
+}
+
+// passes is Passes with the options converted
+func passes(ctx context.Context, s *astisub.Subtitles, params astisub.CommandParams, log astisub.Logger) error {
+	incBy := 1
+
+	// Splits are merged into the subtitles as the pass walks them
+	s.BeginPass()
+	for i := 0; s.Walk(i); i += incBy {
+		if err := ctx.Err(); err != nil {
+			s.EndPass()
+			return err
+		}
+		if s.Items[i].Process {
+			incBy = adjust(s, i, params, log)
+		}
+	}
+	s.EndPass()
+
+	log.Log(astisub.LevelDebug, "Post-processing check for subtitles which are still unfit")
+
+	s.Pass = astisub.ShiftUnfitPass
+	for i := 0; i < len(s.Items); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if s.Items[i].Process {
+			s.ShiftUnfit(i, params, log)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package fixer
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// testSRT has a subtitle too short to be read & a fine one
+const testSRT = `1
+00:00:01,000 --> 00:00:01,200
+This one is far too short to read
+
+2
+00:00:10,000 --> 00:00:12,000
+Fine
+`
+
+// readSubtitles reads subtitles from text
+func readSubtitles(t *testing.T, text string) *astisub.Subtitles {
+	s, err := astisub.ReadFrom(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestParseRanges(t *testing.T) {
+	for _, c := range []struct {
+		limitTo  string
+		expected []astisub.RangeStruct
+	}{
+		{"", nil},
+		{"3", []astisub.RangeStruct{{Start: "3", Stop: "3"}}},
+		{"1-2, 4-10,18", []astisub.RangeStruct{{Start: "1", Stop: "2"}, {Start: "4", Stop: "10"}, {Start: "18", Stop: "18"}}},
+		{"5-", []astisub.RangeStruct{{Start: "5", Stop: "5"}}},
+		{"00:01:00.000-00:02:00.000", []astisub.RangeStruct{{Start: "00:01:00.000", Stop: "00:02:00.000"}}},
+		{",,", nil},
+	} {
+		if got := ParseRanges(c.limitTo); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("ParseRanges(%q) = %v, expected %v", c.limitTo, got, c.expected)
+		}
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	for _, c := range []struct {
+		limitTo  string
+		expected Limits
+	}{
+		{"", nil},
+		{"1-2,18", Limits{{First: 1, Last: 2}, {First: 18, Last: 18}}},
+		{"0-3", Limits{{First: 1, Last: 3}}},
+		{"00:01:00.000-00:02:00.500", Limits{{ByTime: true, Start: time.Minute, Stop: 2*time.Minute + 500*time.Millisecond}}},
+		{"1.5-3", Limits{{ByTime: true, Start: 1500 * time.Millisecond, Stop: 3 * time.Second}}},
+	} {
+		if got := ParseLimits(ParseRanges(c.limitTo)); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("ParseLimits(%q) = %+v, expected %+v", c.limitTo, got, c.expected)
+		}
+	}
+}
+
+func TestLimitsContains(t *testing.T) {
+	item := &astisub.Item{StartAt: 10 * time.Second, EndAt: 12 * time.Second}
+
+	for _, c := range []struct {
+		limitTo  string
+		id       int
+		expected bool
+	}{
+		{"", 7, true},
+		{"1-2,7", 7, true},
+		{"1-2,8", 7, false},
+		{"00:00:11.000-00:00:20.000", 7, true},
+		{"00:00:09.000-00:00:13.000", 7, true},
+		{"00:00:12.500-00:00:20.000", 7, false},
+	} {
+		if got := ParseLimits(ParseRanges(c.limitTo)).Contains(c.id, item); got != c.expected {
+			t.Errorf("%q contains subtitle %d: %t, expected %t", c.limitTo, c.id, got, c.expected)
+		}
+	}
+}
+
+func TestFix(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		limitTo string
+		changed bool
+	}{
+		{"every subtitle", "", true},
+		{"the short one", "1", true},
+		{"the fine one", "2", false},
+	} {
+		s := readSubtitles(t, testSRT)
+		opts := DefaultOptions()
+		opts.LimitTo = c.limitTo
+
+		report, err := Fix(context.Background(), s, opts)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+
+		if report.Before != 2 || report.After != len(s.Items) {
+			t.Errorf("%s: report of %d to %d subtitles, expected 2 to %d", c.name, report.Before, report.After, len(s.Items))
+		}
+		if changed := len(report.Changes) > 0; changed != c.changed {
+			t.Errorf("%s: changed %t, expected %t: %+v", c.name, changed, c.changed, report.Changes)
+		}
+		if short := s.Items[0].EndAt-s.Items[0].StartAt < DefaultMinLength; short == c.changed {
+			t.Errorf("%s: first subtitle lasts %s", c.name, s.Items[0].EndAt-s.Items[0].StartAt)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		opts  func(o *Options)
+		rules []string
+	}{
+		{"defaults", func(o *Options) {}, []string{"min_length", "reading_speed"}},
+		{"check defaults", func(o *Options) { *o = DefaultCheckOptions() }, []string{"reading_speed"}},
+		{"short lines", func(o *Options) { o.CharsPerLine = 5 }, []string{"chars_per_line", "min_length", "reading_speed"}},
+		{"limited", func(o *Options) { o.LimitTo = "2" }, nil},
+	} {
+		opts := DefaultOptions()
+		c.opts(&opts)
+
+		violations, err := Check(context.Background(), readSubtitles(t, testSRT), opts)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+
+		for _, rule := range c.rules {
+			found := false
+			for _, v := range violations {
+				found = found || v.Rule == rule
+			}
+			if !found {
+				t.Errorf("%s: expected a %s violation, got %+v", c.name, rule, violations)
+			}
+		}
+		if len(c.rules) == 0 && len(violations) != 0 {
+			t.Errorf("%s: expected no violations, got %+v", c.name, violations)
+		}
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s := readSubtitles(t, testSRT)
+	if _, err := Fix(ctx, s, DefaultOptions()); err != context.Canceled {
+		t.Errorf("Fix returned %v, expected %v", err, context.Canceled)
+	}
+	if s.Items[0].EndAt != 1200*time.Millisecond {
+		t.Errorf("Fix changed subtitles once canceled, first ends at %s", s.Items[0].EndAt)
+	}
+
+	if _, err := Check(ctx, s, DefaultOptions()); err != context.Canceled {
+		t.Errorf("Check returned %v, expected %v", err, context.Canceled)
+	}
+}
+
+func TestAdjust(t *testing.T) {
+	s := readSubtitles(t, testSRT)
+
+	// Adjust leaves marking to the caller, & only works on subtitle i
+	if next := Adjust(s, 0, DefaultOptions()); next < 1 {
+		t.Errorf("Adjust moves forward by %d subtitles, expected at least 1", next)
+	}
+	if s.Items[0].EndAt-s.Items[0].StartAt < DefaultMinLength {
+		t.Errorf("first subtitle lasts %s, expected at least %s", s.Items[0].EndAt-s.Items[0].StartAt, DefaultMinLength)
+	}
+	if s.Items[1].StartAt != 10*time.Second || s.Items[1].EndAt != 12*time.Second {
+		t.Errorf("second subtitle moved to %s --> %s", s.Items[1].StartAt, s.Items[1].EndAt)
+	}
+}
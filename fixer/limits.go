@@ -0,0 +1,112 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package fixer
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// Limit is a range of subtitles to process, either by id or by time
+type Limit struct {
+	ByTime bool
+	First  int
+	Last   int
+	Start  time.Duration
+	Stop   time.Duration
+}
+
+// Limits selects the subtitles to process. Every subtitle is within
+// limits if there are none
+type Limits []Limit
+
+// ParseRanges parses a list of ranges as given to -limit_to, such as
+// 1-2,4-10,14-16,18 or 00:01:00.000-00:02:00.000
+func ParseRanges(limitTo string) []astisub.RangeStruct {
+	var ranges []astisub.RangeStruct
+
+	for _, r := range strings.Split(limitTo, ",") {
+		bounds := strings.Split(strings.TrimSpace(r), "-")
+		if bounds[0] == "" {
+			continue
+		}
+
+		rng := astisub.RangeStruct{Start: bounds[0], Stop: bounds[0]}
+		if len(bounds) > 1 && bounds[1] != "" {
+			rng.Stop = bounds[1]
+		}
+		ranges = append(ranges, rng)
+	}
+
+	return ranges
+}
+
+// ParseLimits converts ranges into Limits. Ranges holding a : or a .
+// are times, in hh:mm:ss.mmm or seconds, others are subtitle ids
+func ParseLimits(ranges []astisub.RangeStruct) Limits {
+	var limits Limits
+
+	for _, r := range ranges {
+		var limit Limit
+
+		switch {
+		case strings.Contains(r.Start, ":"):
+			limit.ByTime = true
+			limit.Start, _ = astisub.ParseDuration(r.Start, ".", 3)
+			limit.Stop, _ = astisub.ParseDuration(r.Stop, ".", 3)
+		case strings.Contains(r.Start, "."):
+			limit.ByTime = true
+			start, _ := strconv.ParseFloat(r.Start, 64)
+			stop, _ := strconv.ParseFloat(r.Stop, 64)
+			limit.Start = time.Duration(start * float64(time.Second))
+			limit.Stop = time.Duration(stop * float64(time.Second))
+		default:
+			limit.First, _ = strconv.Atoi(r.Start)
+			if limit.First < 1 {
+				limit.First = 1
+			}
+			limit.Last, _ = strconv.Atoi(r.Stop)
+		}
+
+		limits = append(limits, limit)
+	}
+
+	return limits
+}
+
+// Contains checks if subtitle number id (starting at 1) falls within
+// one of the limits. A limit by time contains the subtitles overlapping it
+func (l Limits) Contains(id int, item *astisub.Item) bool {
+	if len(l) == 0 {
+		return true
+	}
+
+	for _, limit := range l {
+		if limit.ByTime {
+			if (item.StartAt >= limit.Start && item.EndAt <= limit.Stop) ||
+				(item.StartAt <= limit.Stop && item.EndAt >= limit.Start) {
+				return true
+			}
+		} else if id >= limit.First && id <= limit.Last {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Mark sets the Process flag of the subtitles within limits, the
+// subtitles the passes & checks look at
+func Mark(s *astisub.Subtitles, limits Limits, log astisub.Logger) {
+	for i, item := range s.Items {
+		item.Process = limits.Contains(i+1, item)
+		if item.Process && len(limits) > 0 && log.Enabled(astisub.LevelDebug) {
+			log.Log(astisub.LevelDebug, "Marking subtitle for processing", "id", i+1)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// DefaultGitRev is the revision -git_diff compares with when given
+// without one
+const DefaultGitRev = "HEAD"
+
+// gitDiffFlag parses -git_diff, which can be given alone to compare
+// with HEAD or with a revision, as -git_diff=rev or -git_diff rev
+type gitDiffFlag struct {
+	rev *string
+}
+
+func (f *gitDiffFlag) String() string {
+	if f.rev == nil {
+		return ""
+	}
+	return *f.rev
+}
+
+func (f *gitDiffFlag) Set(value string) error {
+	if value == "true" {
+		value = DefaultGitRev
+	}
+	if value == "false" {
+		value = ""
+	}
+	*f.rev = value
+	return nil
+}
+
+// IsBoolFlag lets -git_diff be given without a value
+func (f *gitDiffFlag) IsBoolFlag() bool {
+	return true
+}
+
+// OptionalValue lets the revision follow -git_diff as the next argument,
+// as in -git_diff main
+func (f *gitDiffFlag) OptionalValue() bool {
+	return true
+}
+
+// git runs the git binary in dir and returns its output
+func git(dir string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %s", args[0], err)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// gitSubtitles reads the subtitles of name as they were at rev. It
+// returns nil if name did not exist yet at rev
+func gitSubtitles(name string, rev string) (*astisub.Subtitles, error) {
+	dir, base := filepath.Dir(name), filepath.Base(name)
+
+	if _, err := git(dir, "rev-parse", "--verify", "--quiet", rev+"^{commit}"); err != nil {
+		return nil, fmt.Errorf("'%s' is not a revision of the git repository of '%s'", rev, name)
+	}
+
+	if _, err := git(dir, "cat-file", "-e", rev+":./"+base); err != nil {
+		return nil, nil
+	}
+
+	data, err := git(dir, "show", rev+":./"+base)
+	if err != nil {
+		return nil, err
+	}
+
+	return astisub.ReadFrom(bytes.NewReader(data))
+}
+
+// changedCues returns the indices of the subtitles of s which differ
+// from those of old. The subtitles are aligned on their text as for a
+// dry run, so renumbering alone does not count as a change
+func changedCues(old *astisub.Subtitles, s *astisub.Subtitles) map[int]bool {
+	changed := make(map[int]bool)
+	for _, edit := range alignCues(snapshot(old), snapshot(s)) {
+		if !edit.Changed() {
+			continue
+		}
+		for i := edit.After[0]; i < edit.After[1]; i++ {
+			changed[i] = true
+		}
+	}
+
+	return changed
+}
+
+// GitDiffOperation runs the perfection check on the subtitles which
+// changed since the revision given with -git_diff, so that old
+// violations nobody touched do not fail a commit. Subtitles read from
+// stdin are compared with the file named by -stdin_name
+func GitDiffOperation(s *astisub.Subtitles, params cliParams, log *leveledLogger) int {
+	name := params.File
+	if name == "-" {
+		name = params.StdinName
+	}
+
+	old, err := gitSubtitles(name, params.GitDiff)
+	if err != nil {
+		log.Errorf("Cannot read '%s' at %s: %s", name, params.GitDiff, err)
+		return ExitError
+	}
+
+	if old == nil {
+		log.Infof("'%s' is not in %s, checking every subtitle", name, params.GitDiff)
+		return PerfectionOperation(s, params, log)
+	}
+
+	changed := changedCues(old, s)
+
+	var ids []int
+	for i, item := range s.Items {
+		if item.Process && !changed[i] {
+			item.Process = false
+		}
+		if item.Process {
+			ids = append(ids, i+1)
+		}
+	}
+
+	if len(ids) == 0 {
+		log.Infof("No subtitles of '%s' changed since %s", name, params.GitDiff)
+		return 0
+	}
+
+	log.Infof("Checking %d subtitles of '%s' changed since %s - [%s]", len(ids), name, params.GitDiff, formatIds(ids))
+	return PerfectionOperation(s, params, log)
+}
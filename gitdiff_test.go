@@ -0,0 +1,197 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// readTestSubtitles reads subtitles from text, marked for processing
+func readTestSubtitles(t *testing.T, text string) *astisub.Subtitles {
+	s, err := astisub.ReadFrom(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range s.Items {
+		item.Process = true
+	}
+	return s
+}
+
+func TestGitDiffFlag(t *testing.T) {
+	for _, c := range []struct {
+		value, expected string
+	}{
+		{"true", DefaultGitRev},
+		{"false", ""},
+		{"origin/main", "origin/main"},
+	} {
+		var rev string
+		if err := (&gitDiffFlag{&rev}).Set(c.value); err != nil || rev != c.expected {
+			t.Errorf("-git_diff=%s sets %q (%v), expected %q", c.value, rev, err, c.expected)
+		}
+	}
+}
+
+func TestValidateCheckGitDiff(t *testing.T) {
+	for _, c := range []struct {
+		args  []string
+		fails bool
+	}{
+		{args: []string{"-file", "ep01.srt", "-git_diff"}},
+		{args: []string{"-file", "-", "-git_diff", "-stdin_name", "ep01.srt"}},
+		{args: []string{"-file", "-", "-stdin_name", "ep01.srt"}},
+		{args: []string{"-file", "-", "-git_diff"}, fails: true},
+		{args: []string{"-file", "ep01.srt", "-stdin_name", "ep01.srt"}, fails: true},
+		{args: []string{"-file", "ep01.srt", "-git_diff", "-stream"}, fails: true},
+	} {
+		err := validateCheck(commandParams(t, "check", c.args...))
+		if (err != nil) != c.fails {
+			t.Errorf("validateCheck(%v) = %v, expected failure %t", c.args, err, c.fails)
+		}
+	}
+}
+
+func TestChangedCues(t *testing.T) {
+	const before = `1
+00:00:01,000 --> 00:00:02,000
+One
+
+2
+00:00:03,000 --> 00:00:04,000
+Two
+
+3
+00:00:05,000 --> 00:00:06,000
+Three
+`
+	for _, c := range []struct {
+		name     string
+		after    string
+		expected []int
+	}{
+		{"unchanged", before, nil},
+		{"text changed", strings.Replace(before, "Two", "Deux", 1), []int{1}},
+		{"timing changed", strings.Replace(before, "00:00:06,000", "00:00:06,500", 1), []int{2}},
+		{"inserted", strings.Replace(before, "3\n00:00:05", "3\n00:00:04,500 --> 00:00:04,900\nNew\n\n4\n00:00:05", 1), []int{2}},
+		{"removed", before[:strings.Index(before, "2\n")] + before[strings.Index(before, "3\n"):], nil},
+	} {
+		changed := changedCues(readTestSubtitles(t, before), readTestSubtitles(t, c.after))
+
+		var got []int
+		for i := range changed {
+			got = append(got, i)
+		}
+		sort.Ints(got)
+
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: changed cues are %v, expected %v", c.name, got, c.expected)
+		}
+	}
+}
+
+func TestGitDiffOperation(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	captureLog(t, LogFormatText)
+
+	dir := t.TempDir()
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %s: %s", args[0], err, out)
+		}
+	}
+
+	// A file with an old violation nobody touches, committed as such
+	const old = `1
+00:00:01,000 --> 00:00:02,000
+An old subtitle far too long to be read in the single second it is shown
+
+2
+00:00:04,000 --> 00:00:06,000
+Fine
+`
+	name := filepath.Join(dir, "ep[1].srt")
+	if err := os.WriteFile(name, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run("init", "-q")
+	run("add", ".")
+	run("commit", "-q", "-m", "init")
+
+	for _, c := range []struct {
+		name     string
+		content  string
+		expected int
+	}{
+		{"untouched", old, ExitOK},
+		{"fine change", strings.Replace(old, "Fine", "Still fine", 1), ExitOK},
+		{"bad change", strings.Replace(old, "Fine", "A new subtitle too long to be read in the two seconds it is shown for", 1), ExitCheckFailed},
+	} {
+		for _, args := range [][]string{
+			{"-file", name, "-git_diff"},
+			{"-file", "-", "-stdin_name", name, "-git_diff"},
+		} {
+			params := commandParams(t, "check", args...)
+			if code := GitDiffOperation(readTestSubtitles(t, c.content), params, logger); code != c.expected {
+				t.Errorf("%s %v: exit %d, expected %d", c.name, args, code, c.expected)
+			}
+		}
+	}
+
+	// Once the bad change is committed, only an earlier revision given
+	// after -git_diff, with or without an =, tells it changed
+	run("tag", "base")
+	bad := strings.Replace(old, "Fine", "A new subtitle too long to be read in the two seconds it is shown for", 1)
+	if err := os.WriteFile(name, []byte(bad), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run("commit", "-q", "-a", "-m", "bad")
+
+	for _, c := range []struct {
+		args     []string
+		expected int
+	}{
+		{[]string{"-git_diff"}, ExitOK},
+		{[]string{"-git_diff", "base"}, ExitCheckFailed},
+		{[]string{"-git_diff=base"}, ExitCheckFailed},
+		{[]string{"-git_diff", "base", "-min_length", "0"}, ExitCheckFailed},
+		{[]string{"-git_diff", "base", "extra"}, ExitUsage},
+	} {
+		// The name is a glob, so the folder holding it is given instead
+		args := append([]string{"check", "-quiet", "-file", dir}, c.args...)
+		if code := runCommand(args); code != c.expected {
+			t.Errorf("%v: exit %d, expected %d", c.args, code, c.expected)
+		}
+	}
+}
+
+func TestJoinOptionalValues(t *testing.T) {
+	var p cliParams
+	fs := newFlagSet(findCommand("check"), &p)
+
+	for _, c := range []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"-git_diff"}, []string{"-git_diff"}},
+		{[]string{"-git_diff", "main", "-quiet"}, []string{"-git_diff=main", "-quiet"}},
+		{[]string{"--git_diff", "main"}, []string{"--git_diff=main"}},
+		{[]string{"-git_diff", "-file", "a.srt"}, []string{"-git_diff", "-file", "a.srt"}},
+		{[]string{"-git_diff=main", "extra"}, []string{"-git_diff=main", "extra"}},
+		{[]string{"-file", "a.srt", "b.srt"}, []string{"-file", "a.srt", "b.srt"}},
+		{[]string{"--", "-git_diff", "main"}, []string{"--", "-git_diff", "main"}},
+	} {
+		if got := joinOptionalValues(fs, c.args); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("joinOptionalValues(%q) = %q, expected %q", c.args, got, c.expected)
+		}
+	}
+}
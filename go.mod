@@ -0,0 +1,5 @@
+module github.com/chetan-prime/subfixer
+
+go 1.17
+
+require github.com/pkg/errors v0.9.1
@@ -0,0 +1,392 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// reviewRecorder follows the subtitles through a fix, keeping which
+// original subtitle each one comes from as splits insert new ones
+type reviewRecorder struct {
+	origin  []int
+	changes []astisub.Change
+	owners  []int
+}
+
+// Record implements astisub.Recorder
+func (r *reviewRecorder) Record(c astisub.Change) {
+	if c.Field == "cue" {
+		// A split inserts a subtitle after the one it comes from
+		at := c.Cue - 1
+		r.origin = append(r.origin, 0)
+		copy(r.origin[at+1:], r.origin[at:])
+		r.origin[at] = r.origin[at-1]
+	}
+
+	r.changes = append(r.changes, c)
+	r.owners = append(r.owners, r.origin[c.Cue-1])
+}
+
+// proposal is what a fix would make of one original subtitle
+type proposal struct {
+	cue    int
+	before *astisub.Item
+	after  []*astisub.Item
+	first  int
+	rules  []string
+	answer byte
+}
+
+// reviewer asks the operator about each proposal. It lives for the
+// whole run, so rules accepted for good carry over to the next files
+type reviewer struct {
+	in       *bufio.Reader
+	out      io.Writer
+	accepted map[string]bool
+	stopped  bool
+}
+
+// review reads answers from the terminal, one file after the other
+var review = &reviewer{
+	in:       bufio.NewReader(os.Stdin),
+	out:      os.Stdout,
+	accepted: make(map[string]bool),
+}
+
+// Answers to a proposal
+const (
+	answerAccept = 'y'
+	answerReject = 'n'
+	answerEdit   = 'e'
+	answerRule   = 'a'
+	answerQuit   = 'q'
+	answerHelp   = '?'
+)
+
+// cloneItem copies the timing & text of item, so that the original
+// subtitle can be put back when a change is rejected
+func cloneItem(item *astisub.Item) *astisub.Item {
+	c := *item
+	c.Lines = make([]astisub.Line, len(item.Lines))
+	for n, l := range item.Lines {
+		c.Lines[n] = l
+		c.Lines[n].Items = append([]astisub.LineItem{}, l.Items...)
+	}
+
+	return &c
+}
+
+// sameItem checks if a & b have the same timing & text
+func sameItem(a *astisub.Item, b *astisub.Item) bool {
+	return astisub.TimingString(a) == astisub.TimingString(b) && a.String() == b.String()
+}
+
+// InteractiveOperation runs a fix, then walks the operator through the
+// changes it proposes for each subtitle, showing the timing, text &
+// reading speed before & after. Only the changes accepted or edited
+// are saved, and recorded in the journal
+func InteractiveOperation(s *astisub.Subtitles, params cliParams, log *leveledLogger) int {
+	originals := make([]*astisub.Item, len(s.Items))
+	rec := &reviewRecorder{origin: make([]int, len(s.Items))}
+	for i, item := range s.Items {
+		originals[i] = cloneItem(item)
+		rec.origin[i] = i
+	}
+
+	journal := s.Recorder
+	s.Recorder = rec
+	fixSubtitles(s, params, log)
+	_, err := runPlugins(s, params, "fix", log)
+	s.Recorder = journal
+	if err != nil {
+		log.Errorf("Cannot fix subtitles: %s", err)
+		return ExitError
+	}
+
+	groups := make([][]*astisub.Item, len(originals))
+	firsts := make([]int, len(originals))
+	for i, item := range s.Items {
+		k := rec.origin[i]
+		if len(groups[k]) == 0 {
+			firsts[k] = i
+		}
+		groups[k] = append(groups[k], item)
+	}
+
+	var proposals []*proposal
+	for k, after := range groups {
+		if len(after) == 1 && sameItem(after[0], originals[k]) {
+			continue
+		}
+
+		p := &proposal{cue: k, before: originals[k], after: after, first: firsts[k]}
+		seen := make(map[string]bool)
+		for n, c := range rec.changes {
+			if rec.owners[n] == k && !seen[c.Rule] {
+				seen[c.Rule] = true
+				p.rules = append(p.rules, c.Rule)
+			}
+		}
+		proposals = append(proposals, p)
+	}
+
+	if len(proposals) == 0 {
+		log.Infof("No changes proposed for '%s'", params.File)
+		return 0
+	}
+
+	fmt.Fprintf(review.out, "\n%d changes proposed for '%s'\n", len(proposals), params.File)
+	for n, p := range proposals {
+		if err := review.ask(p, n+1, len(proposals), params); err != nil {
+			log.Errorf("Cannot review changes: %s", err)
+			return ExitError
+		}
+	}
+
+	// Rejected subtitles are put back as they were
+	var items []*astisub.Item
+	counts := make(map[byte]int)
+	n := 0
+	for k := range groups {
+		if n < len(proposals) && proposals[n].cue == k {
+			p := proposals[n]
+			counts[p.answer]++
+			n++
+
+			if p.answer != answerReject {
+				items = append(items, p.after...)
+				continue
+			}
+		}
+		items = append(items, originals[k])
+	}
+	s.Items = items
+
+	// Only the changes kept as they were proposed can be undone
+	if journal != nil {
+		kept := make(map[int]bool)
+		for _, p := range proposals {
+			kept[p.cue] = p.answer == answerAccept
+		}
+		for n, c := range rec.changes {
+			if kept[rec.owners[n]] {
+				journal.Record(c)
+			}
+		}
+	}
+
+	for i := 1; i < len(s.Items); i++ {
+		if s.Items[i].StartAt < s.Items[i-1].EndAt {
+			log.Warnf("Subtitle #%d now overlaps subtitle #%d, as only some of the changes around them were accepted", i, i+1)
+		}
+	}
+
+	log.Infof("Accepted %d, edited %d & rejected %d of %d proposed changes",
+		counts[answerAccept], counts[answerEdit], counts[answerReject], len(proposals))
+
+	if counts[answerAccept]+counts[answerEdit] == 0 {
+		log.Infof("Nothing accepted, leaving '%s' as it was", params.File)
+		return 0
+	}
+
+	if err := saveSubtitles(s, params); err != nil {
+		log.Errorf("Cannot save subtitles: %s", err)
+		return ExitWrite
+	}
+
+	return 0
+}
+
+// ask shows proposal p, number n of total, & records the answer. Once
+// the operator stopped or accepted all of its rules, no question is asked
+func (r *reviewer) ask(p *proposal, n int, total int, params cliParams) error {
+	if r.stopped {
+		p.answer = answerReject
+		return nil
+	}
+
+	if r.acceptedAll(p) {
+		p.answer = answerAccept
+		fmt.Fprintf(r.out, "[%d/%d] Subtitle #%d: %s, accepted\n", n, total, p.cue+1, strings.Join(p.rules, ", "))
+		return nil
+	}
+
+	r.show(p, n, total, params)
+
+	for {
+		fmt.Fprintf(r.out, "Accept? [y]es, [n]o, [e]dit, [a]ll changes by %s, [q]uit, [?] ", strings.Join(p.rules, ", "))
+
+		line, err := r.in.ReadString('\n')
+		if err == io.EOF && strings.TrimSpace(line) == "" {
+			fmt.Fprintf(r.out, "\nNo more answers, rejecting the remaining changes\n")
+			r.stopped = true
+			p.answer = answerReject
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		answer := strings.ToLower(strings.TrimSpace(line))
+		if answer == "" {
+			continue
+		}
+
+		switch answer[0] {
+		case answerAccept, answerReject:
+			p.answer = answer[0]
+			return nil
+
+		case answerRule:
+			for _, rule := range p.rules {
+				r.accepted[rule] = true
+			}
+			p.answer = answerAccept
+			return nil
+
+		case answerQuit:
+			r.stopped = true
+			p.answer = answerReject
+			return nil
+
+		case answerEdit:
+			items, err := r.edit(p.after)
+			if err != nil {
+				fmt.Fprintf(r.out, "Cannot use the edit: %s\n", err)
+				continue
+			}
+			p.after = items
+			p.answer = answerEdit
+			return nil
+
+		default:
+			fmt.Fprintf(r.out, "  y  accept the change\n")
+			fmt.Fprintf(r.out, "  n  reject it, keeping the subtitle as it was\n")
+			fmt.Fprintf(r.out, "  e  edit the timing & text to keep ($VISUAL or $EDITOR if set)\n")
+			fmt.Fprintf(r.out, "  a  accept it & every further change made only by %s\n", strings.Join(p.rules, ", "))
+			fmt.Fprintf(r.out, "  q  reject it & the remaining changes, keeping those accepted\n")
+		}
+	}
+}
+
+// acceptedAll checks if every rule of p was accepted for good
+func (r *reviewer) acceptedAll(p *proposal) bool {
+	if len(p.rules) == 0 {
+		return false
+	}
+
+	for _, rule := range p.rules {
+		if !r.accepted[rule] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// show prints the subtitle before & after the proposed change
+func (r *reviewer) show(p *proposal, n int, total int, params cliParams) {
+	fmt.Fprintf(r.out, "\n[%d/%d] Subtitle #%d: %s\n", n, total, p.cue+1, strings.Join(p.rules, ", "))
+
+	r.showItem("before", p.cue+1, p.before, params)
+	for k, item := range p.after {
+		label := ""
+		if k == 0 {
+			label = "after"
+		}
+		r.showItem(label, p.first+k+1, item, params)
+	}
+}
+
+// showItem prints the timing, duration, reading speed & text of item
+func (r *reviewer) showItem(label string, id int, item *astisub.Item, params cliParams) {
+	fmt.Fprintf(r.out, "  %-6s #%-5d %s  %ss  %s CPS\n", label, id, astisub.TimingString(item),
+		formatValue(item.GetLength()), formatValue(item.GetSpeed(id, params.CommandParams, astisub.NopLogger{})))
+
+	for _, l := range item.Lines {
+		fmt.Fprintf(r.out, "                 %s\n", l.String())
+	}
+}
+
+// edit lets the operator rewrite items as SRT, in $VISUAL or $EDITOR
+// if set, otherwise typed in ending with a line holding a single dot
+func (r *reviewer) edit(items []*astisub.Item) ([]*astisub.Item, error) {
+	var text strings.Builder
+	for k, item := range items {
+		if k > 0 {
+			text.WriteString("\n")
+		}
+		fmt.Fprintf(&text, "%d\n%s\n%s\n", k+1, astisub.TimingString(item), item.String())
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+
+	var edited string
+	if editor != "" {
+		f, err := os.CreateTemp("", "subfixer-*.srt")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(f.Name())
+
+		_, err = f.WriteString(text.String())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		args := append(strings.Fields(editor), f.Name())
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("%s failed: %s", editor, err)
+		}
+
+		data, err := os.ReadFile(f.Name())
+		if err != nil {
+			return nil, err
+		}
+		edited = string(data)
+	} else {
+		fmt.Fprintf(r.out, "Type the subtitles to keep as SRT, then a line with a single dot. Proposed:\n%s", text.String())
+
+		var lines []string
+		for {
+			line, err := r.in.ReadString('\n')
+			if strings.TrimRight(line, "\r\n") == "." {
+				break
+			}
+			lines = append(lines, strings.TrimRight(line, "\r\n"))
+			if err != nil {
+				return nil, errors.New("input ended before the line with a single dot")
+			}
+		}
+		edited = strings.Join(lines, "\n")
+	}
+
+	s, err := astisub.ReadFromSRT(strings.NewReader(edited))
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Items) == 0 {
+		return nil, errors.New("no subtitles in the edit, use n to reject the change")
+	}
+
+	return s.Items, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// reviewWith answers the questions of the review with input until the
+// end of the test, & returns what the review printed
+func reviewWith(t *testing.T, input string) *bytes.Buffer {
+	var out bytes.Buffer
+	old := review
+	review = &reviewer{in: bufio.NewReader(strings.NewReader(input)), out: &out, accepted: make(map[string]bool)}
+	t.Cleanup(func() { review = old })
+	return &out
+}
+
+func TestReviewRecorder(t *testing.T) {
+	r := &reviewRecorder{origin: []int{0, 1, 2}}
+	r.Record(astisub.Change{Cue: 2, Field: "end", Rule: "extend_end"})
+	// #2 split into #2 & #3, then the new #3 split again
+	r.Record(astisub.Change{Cue: 3, Field: "cue", Rule: "split"})
+	r.Record(astisub.Change{Cue: 4, Field: "cue", Rule: "split"})
+	r.Record(astisub.Change{Cue: 5, Field: "start", Rule: "extend_start"})
+
+	if expected := []int{0, 1, 1, 1, 2}; !reflect.DeepEqual(r.origin, expected) {
+		t.Errorf("subtitles come from %v, expected %v", r.origin, expected)
+	}
+	if expected := []int{1, 1, 1, 2}; !reflect.DeepEqual(r.owners, expected) {
+		t.Errorf("changes belong to %v, expected %v", r.owners, expected)
+	}
+}
+
+func TestReviewerAsk(t *testing.T) {
+	proposals := func() []*proposal {
+		item := readTestSubtitles(t, testSRT).Items[0]
+		return []*proposal{
+			{cue: 0, before: item, after: []*astisub.Item{item}, rules: []string{"split"}},
+			{cue: 1, before: item, after: []*astisub.Item{item}, rules: []string{"split"}},
+			{cue: 2, before: item, after: []*astisub.Item{item}, rules: []string{"split", "extend_end"}},
+		}
+	}
+
+	for _, c := range []struct {
+		input    string
+		expected string
+	}{
+		{"y\nn\ny\n", "yny"},
+		{"Yes\n\nno\nY\n", "yny"},
+		{"?\nx\ny\nn\nn\n", "ynn"},
+		{"a\nn\n", "yyn"},
+		{"n\nq\n", "nnn"},
+		{"y\n", "ynn"},
+		{"", "nnn"},
+	} {
+		out := reviewWith(t, c.input)
+		var answers []byte
+		for n, p := range proposals() {
+			if err := review.ask(p, n+1, 3, cliParams{}); err != nil {
+				t.Fatal(err)
+			}
+			answers = append(answers, p.answer)
+		}
+
+		if string(answers) != c.expected {
+			t.Errorf("answering %q: %s, expected %s\n%s", c.input, answers, c.expected, out)
+		}
+	}
+}
+
+func TestReviewerEdit(t *testing.T) {
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "")
+	items := readTestSubtitles(t, testSRT).Items
+
+	for _, c := range []struct {
+		input    string
+		expected []string
+		fails    bool
+	}{
+		{"1\n00:00:01,000 --> 00:00:02,500\nEdited\n.\n", []string{"00:00:01,000 --> 00:00:02,500 Edited"}, false},
+		{"1\n00:00:01,000 --> 00:00:02,000\nOne\n\n2\n00:00:02,000 --> 00:00:03,000\nTwo\r\n.\r\n", []string{
+			"00:00:01,000 --> 00:00:02,000 One",
+			"00:00:02,000 --> 00:00:03,000 Two",
+		}, false},
+		{".\n", nil, true},
+		{"1\n00:00:01,000 --> 00:00:02,500\nNo dot\n", nil, true},
+	} {
+		out := reviewWith(t, c.input)
+		edited, err := review.edit(items)
+		if (err != nil) != c.fails {
+			t.Errorf("editing with %q: error %v, expected failure %t", c.input, err, c.fails)
+			continue
+		}
+		if err == nil {
+			s := astisub.NewSubtitles()
+			s.Items = edited
+			if got := cueStrings(s); !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("editing with %q: got %v, expected %v", c.input, got, c.expected)
+			}
+		}
+
+		// The proposed subtitles are shown to be typed over
+		if !strings.Contains(out.String(), "2\n00:00:04,000 --> 00:00:06,000\nGeneral Kenobi\n") {
+			t.Errorf("proposed subtitles not shown:\n%s", out)
+		}
+	}
+}
+
+func TestInteractiveOperation(t *testing.T) {
+	captureLog(t, LogFormatText)
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "")
+	dir := t.TempDir()
+	inDir(t, dir)
+
+	original := cueStrings(readTestSubtitles(t, explainTestSRT))
+	extended := "00:00:00,000 --> 00:00:01,539 Far too short to be read in time"
+	expanded := "00:00:03,300 --> 00:00:05,475 Hi"
+	split := []string{
+		"00:00:05,500 --> 00:00:10,335 A first line long enough to be split",
+		"00:00:10,335 --> 00:00:14,500 and a second line to go with it",
+	}
+
+	for _, c := range []struct {
+		name     string
+		input    string
+		expected []string
+		rules    []string
+	}{
+		{"accept all", "y\ny\ny\n", []string{extended, expanded, split[0], split[1], original[3]}, []string{"expand_closer", "extend_end", "extend_start", "split"}},
+		{"accept some", "y\nn\ny\n", []string{extended, original[1], split[0], split[1], original[3]}, []string{"extend_end", "extend_start", "split"}},
+		{"reject all", "n\nn\nn\n", original, nil},
+		{"quit", "y\nq\n", []string{extended, original[1], original[2], original[3]}, []string{"extend_end", "extend_start"}},
+		{"no answers", "", original, nil},
+		// Edited changes are saved but cannot be undone
+		{"edit", "e\n1\n00:00:00,500 --> 00:00:02,000\nEdited\n.\nn\nn\n", []string{"00:00:00,500 --> 00:00:02,000 Edited", original[1], original[2], original[3]}, nil},
+	} {
+		name := filepath.Join(dir, "ep01.srt")
+		if err := os.WriteFile(name, []byte(explainTestSRT), 0644); err != nil {
+			t.Fatal(err)
+		}
+		journal := filepath.Join(dir, c.name+".jsonl")
+		out := reviewWith(t, c.input)
+
+		if code := runTestCommand("fix", "-interactive", "-file", name, "-backup_keep", "0", "-journal", journal); code != ExitOK {
+			t.Fatalf("%s: fix exited with %d\n%s", c.name, code, out)
+		}
+
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cueStrings(readTestSubtitles(t, string(data))); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: saved %v, expected %v", c.name, got, c.expected)
+		}
+
+		// Only the changes accepted as proposed are journaled
+		var rules []string
+		entries, _ := readJournal(journal)
+		for _, e := range entries {
+			if !containsString(rules, e.Rule) {
+				rules = append(rules, e.Rule)
+			}
+		}
+		sort.Strings(rules)
+		if !reflect.DeepEqual(rules, c.rules) {
+			t.Errorf("%s: journal has changes by %v, expected %v", c.name, rules, c.rules)
+		}
+	}
+}
+
+// containsString checks if list holds s
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
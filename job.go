@@ -0,0 +1,423 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+	"github.com/chetan-prime/subfixer/fixer"
+)
+
+// Outcomes of a file of a job
+const (
+	JobPassed = "passed"
+	JobFailed = "failed"
+	JobError  = "error"
+)
+
+// jobSettings are the settings of a job or of one of its inputs.
+// Params takes settings by flag name, as profiles do, over those of
+// Preset & Profile
+type jobSettings struct {
+	Profile string                 `json:"profile"`
+	Preset  string                 `json:"preset"`
+	Params  map[string]interface{} `json:"params"`
+	Modes   []string               `json:"modes"`
+}
+
+// jobInput is a file, glob or directory of a job, with the settings
+// overriding the defaults of the job. Outputs are written in the
+// format of their extension & may use the placeholders of -out_template
+type jobInput struct {
+	jobSettings
+	File    string   `json:"file"`
+	LimitTo string   `json:"limit_to"`
+	Outputs []string `json:"outputs"`
+}
+
+// jobManifest lists what a run command does, e.g.
+//
+//	{
+//	  "defaults": { "preset": "netflix:en-US:adult", "modes": ["fix", "check"] },
+//	  "inputs": [
+//	    { "file": "ep01.en.srt", "outputs": ["out/{name}.srt", "archive/{base}.{lang}.srt"] },
+//	    { "file": "ep02.en.srt", "limit_to": "1-200", "params": { "speed": 17 } }
+//	  ]
+//	}
+//
+// Paths are relative to the manifest
+type jobManifest struct {
+	Defaults jobSettings `json:"defaults"`
+	Inputs   []jobInput  `json:"inputs"`
+}
+
+// jobFileResult is the outcome of one file of a job
+type jobFileResult struct {
+	File       string              `json:"file"`
+	Input      int                 `json:"input"`
+	Modes      []string            `json:"modes"`
+	Outcome    string              `json:"outcome"`
+	Exit       int                 `json:"exit"`
+	Error      string              `json:"error,omitempty"`
+	Subtitles  int                 `json:"subtitles"`
+	Changes    map[string]int      `json:"changes,omitempty"`
+	Violations []astisub.Violation `json:"violations,omitempty"`
+	Outputs    []string            `json:"outputs,omitempty"`
+	Seconds    float64             `json:"seconds"`
+}
+
+// jobResult is written next to the manifest once every input ran
+type jobResult struct {
+	Manifest string          `json:"manifest"`
+	Outcome  string          `json:"outcome"`
+	Exit     int             `json:"exit"`
+	Counts   map[string]int  `json:"counts"`
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished"`
+	Files    []jobFileResult `json:"files"`
+}
+
+// job runs the inputs of a manifest
+type job struct {
+	params   cliParams
+	cmd      *command
+	base     map[string]string
+	configs  []*configFile
+	dir      string
+	manifest jobManifest
+	journal  *journal
+}
+
+// jobResultPath returns where the result of the job in manifest is
+// written: job.json gives job.result.json
+func jobResultPath(manifest string, params cliParams) string {
+	if params.Result != "" {
+		return params.Result
+	}
+
+	return strings.TrimSuffix(manifest, filepath.Ext(manifest)) + ".result.json"
+}
+
+// loadJob reads the manifest at path, checking the modes it uses
+func loadJob(path string, params cliParams, fs *flag.FlagSet) (*job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &job{
+		params: params,
+		cmd:    findCommand("run"),
+		base:   requestBase(fs),
+		dir:    filepath.Dir(path),
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&j.manifest); err != nil {
+		return nil, fmt.Errorf("parsing manifest '%s' failed: %s", path, err)
+	}
+
+	if len(j.manifest.Inputs) == 0 {
+		return nil, fmt.Errorf("manifest '%s' has no inputs", path)
+	}
+
+	for n, in := range j.manifest.Inputs {
+		if in.File == "" {
+			return nil, fmt.Errorf("input %d of '%s' has no file", n+1, path)
+		}
+		if _, err := j.modes(in); err != nil {
+			return nil, fmt.Errorf("input %d of '%s': %s", n+1, path, err)
+		}
+		for _, out := range in.Outputs {
+			if !isSubtitle(out) {
+				return nil, fmt.Errorf("input %d of '%s': output '%s' is not in a format subfixer can write", n+1, path, out)
+			}
+		}
+	}
+
+	if j.configs, err = loadConfigs(params.Config); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// modes returns the modes of in, by default those of the job
+func (j *job) modes(in jobInput) ([]string, error) {
+	modes := in.Modes
+	if modes == nil {
+		modes = j.manifest.Defaults.Modes
+	}
+	if modes == nil {
+		return parsePipeline(DefaultPipeline)
+	}
+
+	if len(modes) == 0 {
+		return nil, errors.New("no modes")
+	}
+	for _, mode := range modes {
+		if !watchSteps[mode] {
+			return nil, fmt.Errorf("mode '%s' is unknown, use fix, overlap or check", mode)
+		}
+	}
+
+	return modes, nil
+}
+
+// path resolves name relative to the manifest
+func (j *job) path(name string) string {
+	if name == "-" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(j.dir, name)
+}
+
+// inputParams works out the settings of in: those of the command line,
+// then the defaults of the job, then those of the input
+func (j *job) inputParams(in jobInput) (cliParams, error) {
+	profile, preset := j.manifest.Defaults.Profile, j.manifest.Defaults.Preset
+	if in.Profile != "" {
+		profile = in.Profile
+	}
+	if in.Preset != "" {
+		preset = in.Preset
+	}
+
+	values := make(map[string]interface{})
+	for key, v := range j.manifest.Defaults.Params {
+		values[key] = v
+	}
+	for key, v := range in.Params {
+		values[key] = v
+	}
+	if in.LimitTo != "" {
+		values["limit_to"] = in.LimitTo
+	}
+
+	return requestSettings(j.params, j.cmd, requestFlags, j.base, j.configs, profile, preset, values)
+}
+
+// runInput runs input n on every file it names
+func (j *job) runInput(n int, in jobInput) []jobFileResult {
+	modes, _ := j.modes(in)
+
+	failed := func(file string, format string, args ...interface{}) []jobFileResult {
+		r := jobFileResult{File: file, Input: n + 1, Modes: modes, Outcome: JobError, Exit: ExitUsage, Error: fmt.Sprintf(format, args...)}
+		logger.Errorf("Input %d: %s", n+1, r.Error)
+		return []jobFileResult{r}
+	}
+
+	params, err := j.inputParams(in)
+	if err != nil {
+		return failed(in.File, "%s", err)
+	}
+	params.File = j.path(in.File)
+
+	files, err := expandFiles(params)
+	if err != nil {
+		return failed(in.File, "%s", err)
+	}
+	if len(files) == 0 {
+		return failed(in.File, "no subtitle files found for '%s'", in.File)
+	}
+
+	var results []jobFileResult
+	for _, fname := range files {
+		start := time.Now()
+		r := j.runFile(fname, params, modes, in.Outputs)
+		r.Input, r.Modes = n+1, modes
+		r.Seconds = time.Since(start).Seconds()
+		results = append(results, r)
+	}
+
+	return results
+}
+
+// runFile runs the modes on one file & writes its outputs. Without
+// outputs, a file changed by fix or overlap is saved in place
+func (j *job) runFile(fname string, params cliParams, modes []string, outputs []string) jobFileResult {
+	r := jobFileResult{File: fname, Outcome: JobPassed}
+	params.File = fname
+
+	log := logger.With("file", fname)
+	fail := func(code int, err error) jobFileResult {
+		r.Outcome, r.Exit, r.Error = JobError, code, err.Error()
+		log.Errorf("%s", err)
+		return r
+	}
+
+	s, err := openSubtitles(fname)
+	if err != nil {
+		return fail(openExitCode(err), fmt.Errorf("cannot open file: %s", err))
+	}
+	r.Subtitles = len(s.Items)
+
+	fixer.Mark(s, fixer.ParseLimits(params.LimitTo), log)
+
+	var jl *journal
+	if j.journal != nil {
+		fj := *j.journal
+		jl = &fj
+		s.Recorder = jl
+	}
+
+	log.Infof("Running %s on '%s'", strings.Join(modes, ", "), fname)
+	before := snapshot(s)
+
+	for _, mode := range modes {
+		switch mode {
+		case "fix":
+			fixSubtitles(s, params, log)
+			if _, err := runPlugins(s, params, "fix", log); err != nil {
+				return fail(ExitError, err)
+			}
+		case "overlap":
+			for i := 0; i < len(s.Items); i++ {
+				s.AdjustOverlap(i, params.CommandParams, log)
+			}
+		case "check":
+			violations, err := runPlugins(s, params, "check", log)
+			if err != nil {
+				return fail(ExitError, err)
+			}
+			for i, item := range s.Items {
+				if item.Process {
+					r.Violations = append(r.Violations, s.PerfectionViolations(i, params.CommandParams, log)...)
+				}
+			}
+			r.Violations = append(r.Violations, violations...)
+			sort.SliceStable(r.Violations, func(a, b int) bool {
+				return r.Violations[a].Id < r.Violations[b].Id
+			})
+			if len(r.Violations) > 0 {
+				r.Outcome, r.Exit = JobFailed, ExitCheckFailed
+				log.Warnf("Perfection check failed with %d violations", len(r.Violations))
+			}
+		}
+	}
+
+	r.Changes = writeDiff(io.Discard, fname, fname, before, snapshot(s))
+	if len(r.Changes) > 0 {
+		log.Infof("Changes: %s", formatCounts(r.Changes))
+	}
+
+	if len(outputs) == 0 && len(r.Changes) > 0 {
+		outputs = []string{fname}
+	}
+
+	for _, out := range outputs {
+		dst := out
+		if dst != fname {
+			dir, name := filepath.Split(j.path(out))
+			dst = filepath.Join(dir, expandTemplate(name, filepath.Base(fname)))
+		}
+
+		// Entries of archives are saved with the rest of their archive
+		// once every input ran
+		if !isZipEntry(dst) {
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return fail(ExitWrite, fmt.Errorf("cannot create the folder of '%s': %s", dst, err))
+			}
+		}
+
+		params.Out = dst
+		if err := saveSubtitles(s, params); err != nil {
+			return fail(ExitWrite, fmt.Errorf("cannot save subtitles to '%s': %s", dst, err))
+		}
+		r.Outputs = append(r.Outputs, dst)
+
+		if jl != nil && isSubtitle(dst) && !isZipEntry(dst) {
+			fj := *jl
+			if err := fj.save(dst, params); err != nil {
+				return fail(ExitWrite, fmt.Errorf("cannot write journal of '%s': %s", dst, err))
+			}
+		}
+	}
+
+	return r
+}
+
+// RunOperation runs the job described by the manifest given as the
+// argument of the run command, then writes the result of every file
+// next to it. The exit code is the worst of all files
+func RunOperation(inv *invocation) int {
+	if len(inv.Args) != 1 {
+		logger.Errorf("usage is 'run [flags] job.json'")
+		return ExitUsage
+	}
+	manifest := inv.Args[0]
+
+	j, err := loadJob(manifest, inv.Params, inv.Flags)
+	if err != nil {
+		logger.Errorf("Cannot load job: %s", err)
+		return ExitUsage
+	}
+
+	if inv.Params.Journal != JournalOff {
+		j.journal = newJournal("run")
+	}
+
+	res := jobResult{
+		Manifest: manifest,
+		Counts:   map[string]int{JobPassed: 0, JobFailed: 0, JobError: 0},
+		Started:  time.Now(),
+		Files:    make([]jobFileResult, 0),
+	}
+
+	var results []fileResult
+	for n, in := range j.manifest.Inputs {
+		for _, r := range j.runInput(n, in) {
+			res.Files = append(res.Files, r)
+			res.Counts[r.Outcome]++
+			results = append(results, fileResult{File: r.File, Code: r.Exit})
+		}
+	}
+
+	if err := pendingZips.flush(j.params); err != nil {
+		logger.Errorf("%s", err)
+		res.Files = append(res.Files, jobFileResult{Outcome: JobError, Exit: ExitWrite, Error: err.Error()})
+		res.Counts[JobError]++
+		results = append(results, fileResult{Code: ExitWrite})
+	}
+
+	res.Finished = time.Now()
+	res.Exit = batchExitCode(results)
+	switch res.Exit {
+	case ExitOK:
+		res.Outcome = JobPassed
+	case ExitCheckFailed:
+		res.Outcome = JobFailed
+	default:
+		res.Outcome = JobError
+	}
+
+	path := jobResultPath(manifest, inv.Params)
+	err = astisub.WriteFileAtomic(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	})
+	if err != nil {
+		logger.Errorf("Cannot write job result '%s': %s", path, err)
+		return ExitWrite
+	}
+
+	logger.Infof("Job %s: %d passed, %d failed & %d errors, result in %s",
+		res.Outcome, res.Counts[JobPassed], res.Counts[JobFailed], res.Counts[JobError], path)
+
+	return res.Exit
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJobResultPath(t *testing.T) {
+	for _, c := range []struct {
+		manifest string
+		result   string
+		expected string
+	}{
+		{"jobs/job.json", "", "jobs/job.result.json"},
+		{"jobs/job", "", "jobs/job.result.json"},
+		{"jobs/job.v2.json", "", "jobs/job.v2.result.json"},
+		{"jobs/job.json", "out.json", "out.json"},
+	} {
+		if got := jobResultPath(c.manifest, cliParams{Result: c.result}); got != c.expected {
+			t.Errorf("jobResultPath(%q, %q) = %q, expected %q", c.manifest, c.result, got, c.expected)
+		}
+	}
+}
+
+// writeManifest writes a manifest in a temporary folder holding
+// ep01.en.srt & returns its path
+func writeManifest(t *testing.T, manifest string) string {
+	dir := filepath.Dir(writeTestFiles(t, "ep01.en.srt")[0])
+	path := filepath.Join(dir, "job.json")
+	if err := os.WriteFile(path, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// loadTestJob loads the manifest with the settings run starts from
+func loadTestJob(t *testing.T, path string) (*job, error) {
+	var params cliParams
+	fs := newFlagSet(findCommand("run"), &params)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	return loadJob(path, params, fs)
+}
+
+func TestLoadJob(t *testing.T) {
+	captureLog(t, LogFormatText)
+	inDir(t, t.TempDir())
+
+	for _, c := range []struct {
+		name     string
+		manifest string
+		fails    bool
+	}{
+		{"valid", `{ "inputs": [ { "file": "ep01.en.srt", "outputs": ["out/{name}.srt"] } ] }`, false},
+		{"invalid JSON", `{ "inputs": [`, true},
+		{"unknown field", `{ "inputs": [ { "file": "ep01.en.srt", "output": "a.srt" } ] }`, true},
+		{"no inputs", `{ "inputs": [] }`, true},
+		{"input without file", `{ "inputs": [ { "limit_to": "1-2" } ] }`, true},
+		{"unknown mode", `{ "inputs": [ { "file": "ep01.en.srt", "modes": ["polish"] } ] }`, true},
+		{"no modes", `{ "defaults": { "modes": [] }, "inputs": [ { "file": "ep01.en.srt" } ] }`, true},
+		{"output format", `{ "inputs": [ { "file": "ep01.en.srt", "outputs": ["out/{name}.vtt"] } ] }`, true},
+	} {
+		if _, err := loadTestJob(t, writeManifest(t, c.manifest)); (err != nil) != c.fails {
+			t.Errorf("%s: error %v, expected failure %t", c.name, err, c.fails)
+		}
+	}
+
+	if _, err := loadTestJob(t, filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loading a missing manifest succeeded")
+	}
+}
+
+func TestJobSettings(t *testing.T) {
+	captureLog(t, LogFormatText)
+	inDir(t, t.TempDir())
+
+	j, err := loadTestJob(t, writeManifest(t, `{
+		"defaults": { "modes": ["fix"], "params": { "speed": 15, "min_length": 1.5 } },
+		"inputs": [
+			{ "file": "ep01.en.srt" },
+			{ "file": "ep01.en.srt", "modes": ["check", "fix"], "limit_to": "1-2", "params": { "speed": 17 } },
+			{ "file": "ep01.en.srt", "preset": "bbc:en-GB:adult" },
+			{ "file": "ep01.en.srt", "params": { "speling": 1 } }
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n, c := range []struct {
+		modes    []string
+		fails    bool
+		expected func(p cliParams) bool
+	}{
+		{[]string{"fix"}, false, func(p cliParams) bool { return p.Speed == 15 && p.MinLength == 1.5 && len(p.LimitTo) == 0 }},
+		{[]string{"check", "fix"}, false, func(p cliParams) bool { return p.Speed == 17 && p.MinLength == 1.5 && len(p.LimitTo) == 1 }},
+		{[]string{"fix"}, false, func(p cliParams) bool { return p.Speed == 15 && p.CharsPerLine == 37 }},
+		{[]string{"fix"}, true, nil},
+	} {
+		in := j.manifest.Inputs[n]
+		if modes, err := j.modes(in); err != nil || !reflect.DeepEqual(modes, c.modes) {
+			t.Errorf("input %d: modes %v, %v, expected %v", n+1, modes, err, c.modes)
+		}
+
+		params, err := j.inputParams(in)
+		if (err != nil) != c.fails {
+			t.Errorf("input %d: error %v, expected failure %t", n+1, err, c.fails)
+			continue
+		}
+		if err == nil && !c.expected(params) {
+			t.Errorf("input %d: unexpected settings %+v", n+1, params)
+		}
+	}
+}
+
+func TestRunOperation(t *testing.T) {
+	captureLog(t, LogFormatText)
+	inDir(t, t.TempDir())
+
+	manifest := writeManifest(t, `{
+		"defaults": { "modes": ["fix", "check"] },
+		"inputs": [
+			{ "file": "ep01.en.srt", "outputs": ["out/{base}.{lang}.srt", "archive/{name}.srt"] },
+			{ "file": "ep01.en.srt", "modes": ["check"], "params": { "chars_per_line": 5 } },
+			{ "file": "missing/*.srt" }
+		]
+	}`)
+	dir := filepath.Dir(manifest)
+
+	// Flags go before the manifest, which runTestCommand would not do
+	if code := runCommand([]string{"run", "-quiet", "-journal", JournalOff, manifest}); code != ExitPartial {
+		t.Errorf("run exited with %d, expected %d", code, ExitPartial)
+	}
+
+	data, err := os.ReadFile(jobResultPath(manifest, cliParams{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res jobResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := map[string]int{JobPassed: 1, JobFailed: 1, JobError: 1}; !reflect.DeepEqual(res.Counts, expected) {
+		t.Errorf("counts are %v, expected %v", res.Counts, expected)
+	}
+	if res.Outcome != JobError || res.Exit != ExitPartial {
+		t.Errorf("job is %s with exit %d, expected %s with exit %d", res.Outcome, res.Exit, JobError, ExitPartial)
+	}
+	if len(res.Files) != 3 {
+		t.Fatalf("%d files in the result, expected 3", len(res.Files))
+	}
+
+	for n, c := range []struct {
+		outcome string
+		exit    int
+		outputs []string
+	}{
+		{JobPassed, ExitOK, []string{"out/ep01.en.srt", "archive/ep01.en.srt"}},
+		{JobFailed, ExitCheckFailed, nil},
+		{JobError, ExitUsage, nil},
+	} {
+		r := res.Files[n]
+		if r.Input != n+1 || r.Outcome != c.outcome || r.Exit != c.exit {
+			t.Errorf("input %d: %s with exit %d, expected %s with exit %d", r.Input, r.Outcome, r.Exit, c.outcome, c.exit)
+		}
+
+		var outputs []string
+		for _, out := range r.Outputs {
+			rel, _ := filepath.Rel(dir, out)
+			outputs = append(outputs, filepath.ToSlash(rel))
+
+			saved, err := os.ReadFile(out)
+			if err != nil || !strings.Contains(string(saved), "General Kenobi") {
+				t.Errorf("input %d: output %s holds %q, %v", n+1, out, saved, err)
+			}
+		}
+		if !reflect.DeepEqual(outputs, c.outputs) {
+			t.Errorf("input %d: outputs %v, expected %v", n+1, outputs, c.outputs)
+		}
+	}
+
+	if v := res.Files[1].Violations; len(v) == 0 || v[0].Rule != "chars_per_line" {
+		t.Errorf("violations of input 2 are %+v, expected chars_per_line", v)
+	}
+
+	// The input is left as it was, having outputs
+	if data, _ := os.ReadFile(filepath.Join(dir, "ep01.en.srt")); string(data) != testSRT {
+		t.Errorf("input changed to %q", data)
+	}
+}
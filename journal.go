@@ -0,0 +1,140 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// JournalOff disables the journal when given as -journal
+const JournalOff = "off"
+
+// journalEntry is a line of a journal: a change and the run it belongs to
+type journalEntry struct {
+	Run     string `json:"run"`
+	Command string `json:"command"`
+	astisub.Change
+}
+
+// journal collects the changes made to a file during a run, so they
+// can be appended to its journal once the file has been saved
+type journal struct {
+	run     string
+	command string
+	offset  *int
+	entries []journalEntry
+}
+
+// newJournal starts the journal of a run of command
+func newJournal(command string) *journal {
+	return &journal{
+		run:     time.Now().Format(backupStampLayout),
+		command: command,
+	}
+}
+
+// Record implements astisub.Recorder. When streaming, offset holds the
+// number of subtitles already written out, which ids are relative to
+func (j *journal) Record(c astisub.Change) {
+	if j.offset != nil {
+		c.Cue += *j.offset
+	}
+
+	j.entries = append(j.entries, journalEntry{Run: j.run, Command: j.command, Change: c})
+}
+
+// journalPath returns the journal of name, by default next to its
+// backups & named as they are. It is empty when the journal is disabled
+func journalPath(name string, params cliParams) string {
+	if name == "-" || params.Journal == JournalOff {
+		return ""
+	}
+
+	if params.Journal != "" {
+		return params.Journal
+	}
+
+	stem, ext := backupStem(name, params)
+	return filepath.Join(backupDir(name, params), stem+ext+".journal.jsonl")
+}
+
+// save appends the changes recorded for name to its journal and
+// starts over for the next file
+func (j *journal) save(name string, params cliParams) error {
+	defer func() {
+		j.entries = nil
+	}()
+
+	path := journalPath(name, params)
+	if path == "" || len(j.entries) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, entry := range j.entries {
+		if err = enc.Encode(entry); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Recorded %d changes to %s in %s (run %s)", len(j.entries), name, path, j.run)
+	return nil
+}
+
+// readJournal reads every entry of the journal at path
+func readJournal(path string) ([]journalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
@@ -0,0 +1,194 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// Log formats accepted by -log_format
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// logOutput is where the messages of every logger end up, as text for
+// people or as one JSON object per line for programs
+type logOutput struct {
+	mu     sync.Mutex
+	level  astisub.Level
+	format string
+	w      io.Writer
+}
+
+// leveledLogger writes the messages at or above the level of its output,
+// adding its fields to each one. Messages are also passed on to tee,
+// if set, such as when a report collects the messages about a file
+type leveledLogger struct {
+	out    *logOutput
+	fields []interface{}
+	tee    *leveledLogger
+}
+
+// logger is where every diagnostic of subfixer goes, stderr by default
+var logger = &leveledLogger{out: &logOutput{level: astisub.LevelInfo, format: LogFormatText, w: os.Stderr}}
+
+// With returns a logger adding fields to every message of l, such as
+// the file being processed when several are processed at once
+func (l *leveledLogger) With(fields ...interface{}) *leveledLogger {
+	return &leveledLogger{
+		out:    l.out,
+		fields: append(append([]interface{}{}, l.fields...), fields...),
+		tee:    l.tee,
+	}
+}
+
+// configureLogger sets the level & format of the logger from the flags
+func configureLogger(params cliParams) error {
+	switch params.LogFormat {
+	case LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("-log_format must be %s or %s, not '%s'", LogFormatText, LogFormatJSON, params.LogFormat)
+	}
+
+	if params.Quiet && (params.Verbose || params.VeryVerbose) {
+		return fmt.Errorf("-quiet cannot be used with -v or -vv")
+	}
+
+	level := astisub.LevelInfo
+	switch {
+	case params.Quiet:
+		level = astisub.LevelWarn
+	case params.VeryVerbose:
+		level = astisub.LevelTrace
+	case params.Verbose:
+		level = astisub.LevelDebug
+	}
+
+	logger.out.mu.Lock()
+	logger.out.level = level
+	logger.out.format = params.LogFormat
+	logger.out.mu.Unlock()
+
+	return nil
+}
+
+// Enabled implements astisub.Logger
+func (l *leveledLogger) Enabled(level astisub.Level) bool {
+	if l.tee != nil && l.tee.Enabled(level) {
+		return true
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	return level <= l.out.level
+}
+
+// Log implements astisub.Logger. In text, file & id fields are written
+// in front of the message, as in "ep01.srt: id #3: ..."
+func (l *leveledLogger) Log(level astisub.Level, msg string, fields ...interface{}) {
+	if l.tee != nil {
+		l.tee.Log(level, msg, fields...)
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	if level > l.out.level {
+		return
+	}
+
+	fields = append(fields, l.fields...)
+
+	var b bytes.Buffer
+	if l.out.format == LogFormatJSON {
+		b.WriteString(`{"time":`)
+		writeJSON(&b, time.Now().Format(time.RFC3339Nano))
+		b.WriteString(`,"level":`)
+		writeJSON(&b, level.String())
+		b.WriteString(`,"msg":`)
+		writeJSON(&b, msg)
+		for k := 0; k+1 < len(fields); k += 2 {
+			b.WriteString(",")
+			writeJSON(&b, fmt.Sprint(fields[k]))
+			b.WriteString(":")
+			writeJSON(&b, fields[k+1])
+		}
+		b.WriteString("}\n")
+	} else {
+		switch level {
+		case astisub.LevelError:
+			b.WriteString("Error: ")
+		case astisub.LevelWarn:
+			b.WriteString("Warning: ")
+		}
+
+		var prefix, rest []string
+		for k := 0; k+1 < len(fields); k += 2 {
+			switch name := fmt.Sprint(fields[k]); name {
+			case "file":
+				prefix = append([]string{fmt.Sprint(fields[k+1]) + ": "}, prefix...)
+			case "id":
+				prefix = append(prefix, fmt.Sprintf("id #%v: ", fields[k+1]))
+			default:
+				rest = append(rest, name+"="+formatValue(fields[k+1]))
+			}
+		}
+
+		b.WriteString(strings.Join(prefix, ""))
+
+		b.WriteString(msg)
+		if len(rest) > 0 {
+			b.WriteString(" " + strings.Join(rest, " "))
+		}
+		b.WriteString("\n")
+	}
+
+	l.out.w.Write(b.Bytes())
+}
+
+// writeJSON writes v as JSON, as a string if it has no JSON form
+func writeJSON(b *bytes.Buffer, v interface{}) {
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
+
+// Errorf logs a formatted message at the error level
+func (l *leveledLogger) Errorf(format string, args ...interface{}) {
+	l.Log(astisub.LevelError, fmt.Sprintf(format, args...))
+}
+
+// Warnf logs a formatted message at the warn level
+func (l *leveledLogger) Warnf(format string, args ...interface{}) {
+	l.Log(astisub.LevelWarn, fmt.Sprintf(format, args...))
+}
+
+// Infof logs a formatted message at the info level
+func (l *leveledLogger) Infof(format string, args ...interface{}) {
+	l.Log(astisub.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+// Debugf logs a formatted message at the debug level
+func (l *leveledLogger) Debugf(format string, args ...interface{}) {
+	if l.Enabled(astisub.LevelDebug) {
+		l.Log(astisub.LevelDebug, fmt.Sprintf(format, args...))
+	}
+}
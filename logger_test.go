@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+func TestConfigureLogger(t *testing.T) {
+	captureLog(t, LogFormatText)
+
+	for _, c := range []struct {
+		args   []string
+		level  astisub.Level
+		format string
+		fails  bool
+	}{
+		{args: nil, level: astisub.LevelInfo, format: LogFormatText},
+		{args: []string{"-quiet"}, level: astisub.LevelWarn, format: LogFormatText},
+		{args: []string{"-v"}, level: astisub.LevelDebug, format: LogFormatText},
+		{args: []string{"-vv"}, level: astisub.LevelTrace, format: LogFormatText},
+		{args: []string{"-v", "-vv"}, level: astisub.LevelTrace, format: LogFormatText},
+		{args: []string{"-log_format", "json"}, level: astisub.LevelInfo, format: LogFormatJSON},
+		{args: []string{"-log_format", "xml"}, fails: true},
+		{args: []string{"-quiet", "-v"}, fails: true},
+	} {
+		err := configureLogger(commandParams(t, "fix", c.args...))
+		if (err != nil) != c.fails {
+			t.Errorf("%v: error %v, expected failure %t", c.args, err, c.fails)
+			continue
+		}
+		if err == nil && (logger.out.level != c.level || logger.out.format != c.format) {
+			t.Errorf("%v: logging %s as %s, expected %s as %s", c.args, logger.out.level, logger.out.format, c.level, c.format)
+		}
+	}
+}
+
+func TestLogText(t *testing.T) {
+	for _, c := range []struct {
+		level    astisub.Level
+		msg      string
+		fields   []interface{}
+		expected string
+	}{
+		{astisub.LevelInfo, "Saved", nil, "Saved\n"},
+		{astisub.LevelError, "Cannot open", nil, "Error: Cannot open\n"},
+		{astisub.LevelWarn, "Too fast", []interface{}{"id", 3, "speed", 25.12345}, "Warning: id #3: Too fast speed=25.123\n"},
+		{astisub.LevelInfo, "Split", []interface{}{"id", 3, "file", "ep01.srt", "text", "two words"}, `ep01.srt: id #3: Split text="two words"` + "\n"},
+		// A field without a value is left out
+		{astisub.LevelInfo, "Odd", []interface{}{"length"}, "Odd\n"},
+	} {
+		buf := captureLog(t, LogFormatText)
+		logger.Log(c.level, c.msg, c.fields...)
+		if buf.String() != c.expected {
+			t.Errorf("%s %q %v is logged as %q, expected %q", c.level, c.msg, c.fields, buf.String(), c.expected)
+		}
+	}
+}
+
+func TestLogJSON(t *testing.T) {
+	buf := captureLog(t, LogFormatJSON)
+	logger.With("file", "ep01.srt").Log(astisub.LevelWarn, "Cannot split", "id", 3, "err", errors.New("too short"), "ch", make(chan int))
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("%q is not JSON: %s", buf.String(), err)
+	}
+	for name, expected := range map[string]interface{}{
+		"level": "warn",
+		"msg":   "Cannot split",
+		"file":  "ep01.srt",
+		"id":    3.0,
+		"err":   "too short",
+	} {
+		if line[name] != expected {
+			t.Errorf("%s is %#v, expected %#v", name, line[name], expected)
+		}
+	}
+	// Values without a JSON form are written as strings
+	if _, ok := line["ch"].(string); !ok {
+		t.Errorf("ch is %#v, expected a string", line["ch"])
+	}
+	if _, ok := line["time"].(string); !ok {
+		t.Errorf("time is %#v, expected a string", line["time"])
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	buf := captureLog(t, LogFormatText)
+	logger.out.level = astisub.LevelWarn
+
+	var teed bytes.Buffer
+	l := logger.With("file", "ep01.srt")
+	l.tee = &leveledLogger{out: &logOutput{level: astisub.LevelDebug, format: LogFormatText, w: &teed}}
+
+	l.Errorf("failed %d", 1)
+	l.Warnf("warned")
+	l.Infof("informed")
+	l.Debugf("debugged")
+	l.Log(astisub.LevelTrace, "traced")
+
+	expected := "Error: ep01.srt: failed 1\nWarning: ep01.srt: warned\n"
+	if buf.String() != expected {
+		t.Errorf("logged %q, expected %q", buf.String(), expected)
+	}
+	// The tee gets every message at or above its own level, without
+	// the fields of l
+	if expected := "Error: failed 1\nWarning: warned\ninformed\ndebugged\n"; teed.String() != expected {
+		t.Errorf("teed %q, expected %q", teed.String(), expected)
+	}
+
+	for _, c := range []struct {
+		level    astisub.Level
+		expected bool
+	}{
+		{astisub.LevelWarn, true},
+		{astisub.LevelDebug, true},
+		{astisub.LevelTrace, false},
+	} {
+		if got := l.Enabled(c.level); got != c.expected {
+			t.Errorf("%s enabled: %t, expected %t", c.level, got, c.expected)
+		}
+	}
+}
+
+func TestFixPrintsNothing(t *testing.T) {
+	buf := captureLog(t, LogFormatText)
+	name := writeTestFiles(t, "ep01.srt")[0]
+	if err := os.WriteFile(name, []byte(streamTestSRT(20)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := redirect(t, &os.Stdout, "")
+
+	if code := runCommand([]string{"fix", "-file", name, "-vv"}); code != ExitOK {
+		t.Fatalf("fix exited with %d", code)
+	}
+
+	// Diagnostics go to the log, not to stdout
+	if data, _ := os.ReadFile(out); len(data) != 0 {
+		t.Errorf("fix printed %q", data)
+	}
+	if !strings.Contains(buf.String(), "id #") {
+		t.Errorf("no diagnostics about subtitles logged with -vv:\n%s", buf.String())
+	}
+}
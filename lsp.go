@@ -0,0 +1,763 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+	"github.com/chetan-prime/subfixer/strip"
+)
+
+// LSP error codes & constants used by the lsp command
+const (
+	lspMethodNotFound  = -32601
+	lspInvalidParams   = -32602
+	lspSeverityError   = 1
+	lspSyncFull        = 1
+	lspSource          = "subfixer"
+	lspQuickFix        = "quickfix"
+	lspMarkdown        = "markdown"
+	srtTimingSeparator = "-->"
+)
+
+// lspMessage is a JSON-RPC request, notification or response
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	Id      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+// lspError is the error of a JSON-RPC response
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code,omitempty"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspCodeAction struct {
+	Title       string          `json:"title"`
+	Kind        string          `json:"kind"`
+	Diagnostics []lspDiagnostic `json:"diagnostics,omitempty"`
+	IsPreferred bool            `json:"isPreferred,omitempty"`
+	Edit        struct {
+		Changes map[string][]lspTextEdit `json:"changes"`
+	} `json:"edit"`
+}
+
+type lspDocumentId struct {
+	URI string `json:"uri"`
+}
+
+// lspSettings are the settings an editor can pass in the
+// initializationOptions, or as "subfixer" in its configuration.
+// They are layered over those of the command line as in serve
+type lspSettings struct {
+	Profile string                 `json:"profile"`
+	Preset  string                 `json:"preset"`
+	Params  map[string]interface{} `json:"params"`
+}
+
+// lspCue locates a subtitle in a document: the line of its id, the
+// line of its timing & the lines of its text
+type lspCue struct {
+	first  int
+	timing int
+	text   []int
+}
+
+// lspDocument is an open subtitle file & the subtitles parsed from it
+type lspDocument struct {
+	uri   string
+	lines []string
+	s     *astisub.Subtitles
+	cues  []lspCue
+	err   error
+}
+
+// lspServer answers an editor over stdin & stdout
+type lspServer struct {
+	in       *bufio.Reader
+	out      io.Writer
+	mu       sync.Mutex
+	base     *server
+	params   cliParams
+	docs     map[string]*lspDocument
+	shutdown bool
+}
+
+// LspOperation speaks the Language Server Protocol on stdin & stdout,
+// publishing the perfection check of each open subtitle file as
+// diagnostics, with code actions for those which can be fixed in
+// place and hover information about the subtitle under the cursor
+func LspOperation(inv *invocation) int {
+	base, err := newServer(inv.Params, inv.Flags)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return ExitError
+	}
+
+	ls := &lspServer{
+		in:     bufio.NewReader(os.Stdin),
+		out:    os.Stdout,
+		base:   base,
+		params: inv.Params,
+		docs:   make(map[string]*lspDocument),
+	}
+
+	return ls.run()
+}
+
+// run handles messages until the editor exits. The exit code is 0
+// only if the editor asked for a shutdown first, as LSP requires
+func (ls *lspServer) run() int {
+	for {
+		msg, err := ls.read()
+		if err == io.EOF {
+			logger.Debugf("lsp: input closed")
+			return ExitError
+		}
+		if err != nil {
+			logger.Errorf("lsp: %s", err)
+			return ExitError
+		}
+
+		if msg.Method == "exit" {
+			if ls.shutdown {
+				return 0
+			}
+			return ExitError
+		}
+
+		result, rerr := ls.handle(msg)
+		if msg.Id != nil {
+			ls.respond(*msg.Id, result, rerr)
+		}
+	}
+}
+
+// read reads a message framed by a Content-Length header
+func (ls *lspServer) read() (*lspMessage, error) {
+	length := -1
+	for {
+		line, err := ls.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+
+		if v := strings.TrimPrefix(line, "Content-Length:"); v != line {
+			if length, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+				return nil, fmt.Errorf("invalid header '%s'", line)
+			}
+		}
+	}
+
+	if length < 0 {
+		return nil, errors.New("message without Content-Length")
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(ls.in, body); err != nil {
+		return nil, err
+	}
+
+	msg := &lspMessage{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, fmt.Errorf("invalid message: %s", err)
+	}
+
+	return msg, nil
+}
+
+// write sends v as a message framed by a Content-Length header
+func (ls *lspServer) write(v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		logger.Errorf("lsp: cannot encode message: %s", err)
+		return
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	fmt.Fprintf(ls.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// respond answers the request id with its result or error
+func (ls *lspServer) respond(id json.RawMessage, result interface{}, rerr *lspError) {
+	if rerr != nil {
+		ls.write(map[string]interface{}{"jsonrpc": "2.0", "id": id, "error": rerr})
+		return
+	}
+
+	ls.write(map[string]interface{}{"jsonrpc": "2.0", "id": id, "result": result})
+}
+
+// notify sends a notification to the editor
+func (ls *lspServer) notify(method string, params interface{}) {
+	ls.write(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+// handle runs the method of msg, returning the result of a request.
+// Notifications which are not handled are ignored, as LSP requires
+func (ls *lspServer) handle(msg *lspMessage) (interface{}, *lspError) {
+	logger.Debugf("lsp: %s", msg.Method)
+
+	invalid := func(err error) *lspError {
+		return &lspError{Code: lspInvalidParams, Message: err.Error()}
+	}
+
+	switch msg.Method {
+	case "initialize":
+		var p struct {
+			InitializationOptions *lspSettings `json:"initializationOptions"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, invalid(err)
+		}
+		if p.InitializationOptions != nil {
+			if err := ls.configure(*p.InitializationOptions); err != nil {
+				return nil, invalid(err)
+			}
+		}
+
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   lspSyncFull,
+				"hoverProvider":      true,
+				"codeActionProvider": map[string]interface{}{"codeActionKinds": []string{lspQuickFix}},
+			},
+			"serverInfo": map[string]string{"name": "subfixer"},
+		}, nil
+
+	case "shutdown":
+		ls.shutdown = true
+		return nil, nil
+
+	case "workspace/didChangeConfiguration":
+		var p struct {
+			Settings struct {
+				Subfixer *lspSettings `json:"subfixer"`
+			} `json:"settings"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil || p.Settings.Subfixer == nil {
+			return nil, nil
+		}
+		if err := ls.configure(*p.Settings.Subfixer); err != nil {
+			ls.notify("window/showMessage", map[string]interface{}{"type": 1, "message": "subfixer: " + err.Error()})
+			return nil, nil
+		}
+		for _, doc := range ls.docs {
+			ls.publish(doc)
+		}
+
+	case "textDocument/didOpen":
+		var p struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, invalid(err)
+		}
+		ls.open(p.TextDocument.URI, p.TextDocument.Text)
+
+	case "textDocument/didChange":
+		var p struct {
+			TextDocument   lspDocumentId `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, invalid(err)
+		}
+		if n := len(p.ContentChanges); n > 0 {
+			ls.open(p.TextDocument.URI, p.ContentChanges[n-1].Text)
+		}
+
+	case "textDocument/didClose":
+		var p struct {
+			TextDocument lspDocumentId `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, invalid(err)
+		}
+		delete(ls.docs, p.TextDocument.URI)
+		ls.notify("textDocument/publishDiagnostics", map[string]interface{}{
+			"uri":         p.TextDocument.URI,
+			"diagnostics": []lspDiagnostic{},
+		})
+
+	case "textDocument/hover":
+		var p struct {
+			TextDocument lspDocumentId `json:"textDocument"`
+			Position     lspPosition   `json:"position"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, invalid(err)
+		}
+		return ls.hover(p.TextDocument.URI, p.Position), nil
+
+	case "textDocument/codeAction":
+		var p struct {
+			TextDocument lspDocumentId `json:"textDocument"`
+			Range        lspRange      `json:"range"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, invalid(err)
+		}
+		return ls.codeActions(p.TextDocument.URI, p.Range), nil
+
+	default:
+		if msg.Id != nil {
+			return nil, &lspError{Code: lspMethodNotFound, Message: "method not supported: " + msg.Method}
+		}
+	}
+
+	return nil, nil
+}
+
+// configure applies settings from the editor, which are those of check
+func (ls *lspServer) configure(settings lspSettings) error {
+	params, err := ls.base.requestParams(&serveRequest{
+		Profile: settings.Profile,
+		Preset:  settings.Preset,
+		Params:  settings.Params,
+	}, checkRequestFlags)
+	if err != nil {
+		return err
+	}
+
+	ls.params = params
+	return nil
+}
+
+// open parses the text of a document & publishes its diagnostics
+func (ls *lspServer) open(uri string, text string) {
+	doc := parseDocument(uri, text)
+	ls.docs[uri] = doc
+	ls.publish(doc)
+}
+
+// parseDocument reads the subtitles of text & finds their lines
+func parseDocument(uri string, text string) *lspDocument {
+	doc := &lspDocument{uri: uri, lines: strings.Split(text, "\n")}
+	for n, line := range doc.lines {
+		doc.lines[n] = strings.TrimSuffix(line, "\r")
+	}
+
+	doc.s, doc.err = astisub.ReadFromSRT(strings.NewReader(text))
+	if doc.err != nil {
+		return doc
+	}
+
+	doc.cues = locateCues(doc.lines)
+	if len(doc.cues) != len(doc.s.Items) {
+		doc.err = fmt.Errorf("found %d subtitles but could only locate %d of them", len(doc.s.Items), len(doc.cues))
+		return doc
+	}
+
+	for _, item := range doc.s.Items {
+		item.Process = true
+	}
+
+	return doc
+}
+
+// locateCues finds the lines of each subtitle the way the SRT decoder
+// reads them: a timing line starts a subtitle, the line before it is
+// the id of the subtitle and its text stops at the first empty line
+func locateCues(lines []string) []lspCue {
+	var timings []int
+	for n, line := range lines {
+		if strings.Contains(line, srtTimingSeparator) {
+			timings = append(timings, n)
+		}
+	}
+
+	cues := make([]lspCue, 0, len(timings))
+	for k, t := range timings {
+		c := lspCue{first: t, timing: t}
+		if t > 0 && (k == 0 || timings[k-1] < t-1) {
+			c.first = t - 1
+		}
+
+		stop := len(lines)
+		if k+1 < len(timings) {
+			stop = timings[k+1] - 1
+		}
+
+		for n := t + 1; n < stop && lines[n] != ""; n++ {
+			c.text = append(c.text, n)
+		}
+
+		cues = append(cues, c)
+	}
+
+	return cues
+}
+
+// utf16Len returns the length of s in UTF-16 code units, the unit of
+// the character positions of LSP
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+
+	return n
+}
+
+// lineRange returns the range of line n from rune from onwards
+func (doc *lspDocument) lineRange(n int, from int) lspRange {
+	line := doc.lines[n]
+	runes := []rune(line)
+	if from > len(runes) {
+		from = 0
+	}
+
+	return lspRange{
+		Start: lspPosition{n, utf16Len(string(runes[:from]))},
+		End:   lspPosition{n, utf16Len(line)},
+	}
+}
+
+// textRange returns the range of the text of cue c
+func (doc *lspDocument) textRange(c lspCue) lspRange {
+	if len(c.text) == 0 {
+		return doc.lineRange(c.timing, 0)
+	}
+
+	last := c.text[len(c.text)-1]
+	return lspRange{
+		Start: lspPosition{c.text[0], 0},
+		End:   lspPosition{last, utf16Len(doc.lines[last])},
+	}
+}
+
+// diagnostic places a violation of the perfection check on the lines
+// it is about: the characters past the limit of a line which is too
+// long, the first character of a line starting with a forbidden one,
+// the text for the checks of the whole text & the timing otherwise
+func (doc *lspDocument) diagnostic(c lspCue, v astisub.Violation) lspDiagnostic {
+	d := lspDiagnostic{Severity: lspSeverityError, Code: v.Rule, Source: lspSource, Message: v.Message}
+
+	switch {
+	case v.Value != nil && v.Limit != nil:
+		d.Message += fmt.Sprintf(": %s, limit %s", formatValue(v.Value), formatValue(v.Limit))
+	case v.Value != nil:
+		d.Message += fmt.Sprintf(": %s", formatValue(v.Value))
+	}
+
+	if v.Line > 0 && v.Line <= len(c.text) {
+		n := c.text[v.Line-1]
+		line := doc.lines[n]
+		indent := len([]rune(line)) - len([]rune(strings.TrimLeft(line, " ")))
+
+		switch v.Rule {
+		case "chars_per_line":
+			d.Range = doc.lineRange(n, indent+v.Limit.(int))
+		case "forbidden_chars":
+			d.Range = doc.lineRange(n, indent)
+			d.Range.End = lspPosition{n, d.Range.Start.Character + utf16Len(v.Value.(string))}
+		default:
+			d.Range = doc.lineRange(n, 0)
+		}
+		return d
+	}
+
+	switch v.Rule {
+	case "max_lines", "line_balance", "prefer_compact":
+		d.Range = doc.textRange(c)
+	case "min_gap_frames":
+		// The end of the subtitle is what is too close to the next one
+		line := doc.lines[c.timing]
+		at := strings.Index(line, srtTimingSeparator) + len(srtTimingSeparator)
+		d.Range = doc.lineRange(c.timing, len([]rune(line[:at])))
+	default:
+		d.Range = doc.lineRange(c.timing, 0)
+	}
+
+	return d
+}
+
+// diagnostics returns the diagnostics of cue k
+func (ls *lspServer) diagnostics(doc *lspDocument, k int) []lspDiagnostic {
+	var list []lspDiagnostic
+	for _, v := range doc.s.PerfectionViolations(k, ls.params.CommandParams, logger) {
+		list = append(list, doc.diagnostic(doc.cues[k], v))
+	}
+
+	return list
+}
+
+// publish sends the diagnostics of every subtitle of doc
+func (ls *lspServer) publish(doc *lspDocument) {
+	list := make([]lspDiagnostic, 0)
+
+	if doc.err != nil {
+		list = append(list, lspDiagnostic{
+			Range:    lspRange{End: lspPosition{0, 0}},
+			Severity: lspSeverityError,
+			Source:   lspSource,
+			Message:  "Cannot read subtitles: " + doc.err.Error(),
+		})
+	} else {
+		for k := range doc.cues {
+			list = append(list, ls.diagnostics(doc, k)...)
+		}
+	}
+
+	ls.notify("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri":         doc.uri,
+		"diagnostics": list,
+	})
+}
+
+// cueAt returns the index of the subtitle on line n, or -1
+func (doc *lspDocument) cueAt(n int) int {
+	for k, c := range doc.cues {
+		last := c.timing
+		if len(c.text) > 0 {
+			last = c.text[len(c.text)-1]
+		}
+		if n >= c.first && n <= last {
+			return k
+		}
+	}
+
+	return -1
+}
+
+// hover describes the timing of the subtitle under the cursor: its
+// duration, reading speed, line lengths & gaps to its neighbours
+func (ls *lspServer) hover(uri string, pos lspPosition) interface{} {
+	doc := ls.docs[uri]
+	if doc == nil || doc.err != nil {
+		return nil
+	}
+
+	k := doc.cueAt(pos.Line)
+	if k < 0 {
+		return nil
+	}
+
+	s, item, p := doc.s, doc.s.Items[k], ls.params
+	limit := func(format string, v float64) string {
+		if v <= 0 {
+			return ""
+		}
+		return fmt.Sprintf(format, formatValue(v))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "**Subtitle #%d** `%s`\n\n", k+1, astisub.TimingString(item))
+	fmt.Fprintf(&b, "- Duration: %ss%s%s\n", formatValue(item.GetLength()), limit(", min %ss", p.MinLength), limit(", max %ss", p.MaxLength))
+	fmt.Fprintf(&b, "- Reading speed: %s CPS%s\n", formatValue(item.GetSpeed(k+1, p.CommandParams, logger)), limit(", max %s", p.ReadingSpeed))
+
+	var lengths []string
+	for _, l := range item.Lines {
+		lengths = append(lengths, strconv.Itoa(len([]rune(strings.TrimSpace(strip.StripTags(l.String()))))))
+	}
+	fmt.Fprintf(&b, "- Characters per line: %s%s\n", strings.Join(lengths, ", "), limit(", max %s", float64(p.CharsPerLine)))
+
+	if k > 0 {
+		fmt.Fprintf(&b, "- Gap to previous: %ss\n", formatValue((item.StartAt - s.Items[k-1].EndAt).Seconds()))
+	}
+	if k+1 < len(s.Items) {
+		fmt.Fprintf(&b, "- Gap to next: %ss\n", formatValue((s.Items[k+1].StartAt - item.EndAt).Seconds()))
+	}
+
+	c := doc.cues[k]
+	r := doc.textRange(c)
+	r.Start = lspPosition{c.first, 0}
+
+	return map[string]interface{}{
+		"contents": map[string]string{"kind": lspMarkdown, "value": b.String()},
+		"range":    r,
+	}
+}
+
+// codeActions offers fixes for the subtitles within r
+func (ls *lspServer) codeActions(uri string, r lspRange) []lspCodeAction {
+	actions := make([]lspCodeAction, 0)
+
+	doc := ls.docs[uri]
+	if doc == nil || doc.err != nil {
+		return actions
+	}
+
+	for k, c := range doc.cues {
+		last := c.timing
+		if len(c.text) > 0 {
+			last = c.text[len(c.text)-1]
+		}
+		if last < r.Start.Line || c.first > r.End.Line {
+			continue
+		}
+
+		diags := make(map[string][]lspDiagnostic)
+		for _, d := range ls.diagnostics(doc, k) {
+			diags[d.Code] = append(diags[d.Code], d)
+		}
+
+		add := func(title string, edit lspTextEdit, rules ...string) {
+			a := lspCodeAction{Title: fmt.Sprintf(title, k+1), Kind: lspQuickFix, IsPreferred: true}
+			for _, rule := range rules {
+				a.Diagnostics = append(a.Diagnostics, diags[rule]...)
+			}
+			a.Edit.Changes = map[string][]lspTextEdit{uri: {edit}}
+			actions = append(actions, a)
+		}
+
+		text := make([]string, len(c.text))
+		for n, line := range c.text {
+			text[n] = doc.lines[line]
+		}
+
+		if len(diags["prefer_compact"]) > 0 {
+			add("Join the lines of subtitle #%d", lspTextEdit{doc.textRange(c), strings.Join(strings.Fields(strings.Join(text, " ")), " ")}, "prefer_compact")
+		}
+
+		if len(diags["line_balance"]) > 0 && len(text) == 2 {
+			if lines := balanceLines(text); lines != nil {
+				add("Rebalance the lines of subtitle #%d", lspTextEdit{doc.textRange(c), strings.Join(lines, "\n")}, "line_balance")
+			}
+		}
+
+		trimmed := make([]string, len(text))
+		for n, line := range text {
+			trimmed[n] = strings.TrimSpace(line)
+		}
+		if strings.Join(trimmed, "\n") != strings.Join(text, "\n") {
+			add("Trim the spaces of subtitle #%d", lspTextEdit{doc.textRange(c), strings.Join(trimmed, "\n")})
+		}
+
+		if len(diags["reading_speed"]) > 0 || len(diags["min_length"]) > 0 {
+			if start, end, ok := extendTiming(doc.s, k, ls.params); ok {
+				timing := astisub.FormatDurationSRT(start) + " --> " + astisub.FormatDurationSRT(end)
+				add("Extend the timing of subtitle #%d", lspTextEdit{doc.lineRange(c.timing, 0), timing}, "reading_speed", "min_length")
+			}
+		}
+	}
+
+	return actions
+}
+
+// balanceLines rewraps two lines at the space leaving them closest in
+// length, or returns nil if that is how they already are
+func balanceLines(text []string) []string {
+	words := strings.Fields(strings.Join(text, " "))
+	if len(words) < 2 {
+		return nil
+	}
+
+	var best []string
+	bestDiff := -1
+	for n := 1; n < len(words); n++ {
+		top, bottom := strings.Join(words[:n], " "), strings.Join(words[n:], " ")
+		diff := len([]rune(top)) - len([]rune(bottom))
+		if diff < 0 {
+			diff = -diff
+		}
+		if bestDiff < 0 || diff < bestDiff {
+			best, bestDiff = []string{top, bottom}, diff
+		}
+	}
+
+	if best[0] == strings.TrimSpace(text[0]) && best[1] == strings.TrimSpace(text[1]) {
+		return nil
+	}
+
+	return best
+}
+
+// extendTiming works out the timing subtitle k needs to be read at the
+// reading speed & last the minimum length. The end is moved later, then
+// the start earlier, keeping the minimum gap to the neighbours
+func extendTiming(s *astisub.Subtitles, k int, p cliParams) (time.Duration, time.Duration, bool) {
+	item := s.Items[k]
+
+	need := p.MinLength
+	if p.ReadingSpeed > 0 {
+		need = math.Max(need, float64(item.GetRuneCount(p.CommandParams))/p.ReadingSpeed)
+	}
+	needed := time.Duration(math.Ceil(need*1000)) * time.Millisecond
+
+	var gap time.Duration
+	if p.MinGapFrames > 0 && p.FrameRate > 0 {
+		gap = time.Duration(math.Ceil(float64(p.MinGapFrames)/p.FrameRate*1000)) * time.Millisecond
+	}
+
+	start, end := item.StartAt, item.StartAt+needed
+	if end < item.EndAt {
+		end = item.EndAt
+	}
+	if k+1 < len(s.Items) && end > s.Items[k+1].StartAt-gap {
+		end = s.Items[k+1].StartAt - gap
+	}
+	if end < item.EndAt {
+		end = item.EndAt
+	}
+
+	if missing := needed - (end - start); missing > 0 {
+		var lowest time.Duration
+		if k > 0 {
+			lowest = s.Items[k-1].EndAt + gap
+		}
+		start -= missing
+		if start < lowest {
+			start = lowest
+		}
+		if start > item.StartAt {
+			start = item.StartAt
+		}
+	}
+
+	return start, end, start != item.StartAt || end != item.EndAt
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lspTestSRT has a subtitle too short & too fast to read, and one with
+// a line too long
+const lspTestSRT = "1\n00:00:01,000 --> 00:00:01,200\nFar too short to be read\n\n" +
+	"2\n00:00:05,000 --> 00:00:08,000\nThis line is much too long to fit on a single subtitle line\n"
+
+func TestLocateCues(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		text     string
+		expected []lspCue
+	}{
+		{"two cues", lspTestSRT, []lspCue{{0, 1, []int{2}}, {4, 5, []int{6}}}},
+		{"without an id", "00:00:01,000 --> 00:00:02,000\nOne\nTwo\n\n2\n00:00:03,000 --> 00:00:04,000\nThree", []lspCue{{0, 0, []int{1, 2}}, {4, 5, []int{6}}}},
+		{"without text", "1\n00:00:01,000 --> 00:00:02,000\n\n2\n00:00:03,000 --> 00:00:04,000\nTwo\n", []lspCue{{0, 1, nil}, {3, 4, []int{5}}}},
+		{"empty", "", []lspCue{}},
+	} {
+		if got := locateCues(strings.Split(c.text, "\n")); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: located %v, expected %v", c.name, got, c.expected)
+		}
+	}
+}
+
+func TestUtf16Len(t *testing.T) {
+	for _, c := range []struct {
+		s        string
+		expected int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"été", 3},
+		{"a😀b", 4},
+	} {
+		if got := utf16Len(c.s); got != c.expected {
+			t.Errorf("utf16Len(%q) = %d, expected %d", c.s, got, c.expected)
+		}
+	}
+}
+
+func TestBalanceLines(t *testing.T) {
+	for _, c := range []struct {
+		text     []string
+		expected []string
+	}{
+		{[]string{"A very long first line of text", "short"}, []string{"A very long first", "line of text short"}},
+		{[]string{"One two", "three four"}, nil},
+		{[]string{" One two ", "three four"}, nil},
+		{[]string{"Word", ""}, nil},
+	} {
+		if got := balanceLines(c.text); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("balanceLines(%q) = %q, expected %q", c.text, got, c.expected)
+		}
+	}
+}
+
+func TestExtendTiming(t *testing.T) {
+	const srt = "1\n00:00:01,000 --> 00:00:02,000\nBefore\n\n" +
+		"2\n00:00:02,500 --> 00:00:02,700\nTwenty one characters\n\n" +
+		"3\n00:00:03,500 --> 00:00:05,000\nAfter\n"
+
+	for _, c := range []struct {
+		name       string
+		params     cliParams
+		start, end time.Duration
+		ok         bool
+	}{
+		{"end later", withSettings(cliParams{}, 1, 0, 0, 0), 2500 * time.Millisecond, 3500 * time.Millisecond, true},
+		{"reading speed", withSettings(cliParams{}, 0, 10, 0, 0), 2000 * time.Millisecond, 3500 * time.Millisecond, true},
+		{"start earlier too", withSettings(cliParams{}, 2, 0, 0, 0), 2000 * time.Millisecond, 3500 * time.Millisecond, true},
+		{"keeping the gaps", withSettings(cliParams{}, 2, 0, 12, 24), 2500 * time.Millisecond, 3000 * time.Millisecond, true},
+		{"long enough", withSettings(cliParams{}, 0.1, 0, 0, 0), 2500 * time.Millisecond, 2700 * time.Millisecond, false},
+	} {
+		s := readTestSubtitles(t, srt)
+		start, end, ok := extendTiming(s, 1, c.params)
+		if start != c.start || end != c.end || ok != c.ok {
+			t.Errorf("%s: extended to %s-%s, %t, expected %s-%s, %t", c.name, start, end, ok, c.start, c.end, c.ok)
+		}
+	}
+}
+
+// withSettings returns p with the settings extendTiming uses
+func withSettings(p cliParams, minLength float64, readingSpeed float64, minGapFrames int, frameRate float64) cliParams {
+	p.MinLength, p.ReadingSpeed, p.MinGapFrames, p.FrameRate = minLength, readingSpeed, minGapFrames, frameRate
+	return p
+}
+
+// lspInput frames each message as an editor sends it
+func lspInput(messages ...interface{}) string {
+	var b strings.Builder
+	for _, m := range messages {
+		body, _ := json.Marshal(m)
+		fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	return b.String()
+}
+
+// readLspMessages reads the messages framed in data
+func readLspMessages(t *testing.T, data []byte) []map[string]interface{} {
+	r := bufio.NewReader(strings.NewReader(string(data)))
+	var messages []map[string]interface{}
+	for {
+		if _, err := r.Peek(1); err == io.EOF {
+			return messages
+		}
+
+		var length int
+		if _, err := fmt.Fscanf(r, "Content-Length: %d\r\n\r\n", &length); err != nil {
+			t.Fatal(err)
+		}
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			t.Fatal(err)
+		}
+
+		var m map[string]interface{}
+		if err := json.Unmarshal(body, &m); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, m)
+	}
+}
+
+func TestLspSession(t *testing.T) {
+	captureLog(t, LogFormatText)
+	inDir(t, t.TempDir())
+
+	uri := "file:///a.srt"
+	doc := map[string]interface{}{"uri": uri}
+	for _, c := range []struct {
+		name     string
+		messages []interface{}
+		expected int
+		check    func(responses map[string]string, diagnostics []string) error
+	}{
+		{"session", []interface{}{
+			map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": map[string]interface{}{}},
+			map[string]interface{}{"jsonrpc": "2.0", "method": "textDocument/didOpen", "params": map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri, "text": lspTestSRT}}},
+			map[string]interface{}{"jsonrpc": "2.0", "id": 2, "method": "textDocument/hover", "params": map[string]interface{}{"textDocument": doc, "position": lspPosition{1, 0}}},
+			map[string]interface{}{"jsonrpc": "2.0", "id": 3, "method": "textDocument/codeAction", "params": map[string]interface{}{"textDocument": doc, "range": lspRange{End: lspPosition{7, 0}}}},
+			map[string]interface{}{"jsonrpc": "2.0", "id": 4, "method": "nope"},
+			map[string]interface{}{"jsonrpc": "2.0", "method": "textDocument/didClose", "params": map[string]interface{}{"textDocument": doc}},
+			map[string]interface{}{"jsonrpc": "2.0", "id": 5, "method": "shutdown"},
+			map[string]interface{}{"jsonrpc": "2.0", "method": "exit"},
+		}, ExitOK, func(responses map[string]string, diagnostics []string) error {
+			for id, expected := range map[string]string{
+				"1": `"hoverProvider":true`,
+				"2": `Reading speed: 120 CPS, max 21`,
+				"3": `"newText":"00:00:01,000 --\u003e 00:00:02,143"`,
+				"4": `"code":-32601`,
+				"5": `"result":null`,
+			} {
+				if !strings.Contains(responses[id], expected) {
+					return fmt.Errorf("response %s is %s, without %s", id, responses[id], expected)
+				}
+			}
+			// -min_length is off, as it is for check
+			expected := []string{
+				`{"code":"reading_speed","range":{"end":{"character":29,"line":1},"start":{"character":0,"line":1}}}`,
+				`{"code":"chars_per_line","range":{"end":{"character":59,"line":6},"start":{"character":42,"line":6}}}`,
+				"none",
+			}
+			if !reflect.DeepEqual(diagnostics, expected) {
+				return fmt.Errorf("diagnostics are %v, expected %v", diagnostics, expected)
+			}
+			return nil
+		}},
+		{"settings", []interface{}{
+			map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": map[string]interface{}{"initializationOptions": map[string]interface{}{"params": map[string]interface{}{"chars_per_line": 60, "min_length": 0, "reading_speed": 0}}}},
+			map[string]interface{}{"jsonrpc": "2.0", "method": "textDocument/didOpen", "params": map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri, "text": lspTestSRT}}},
+			map[string]interface{}{"jsonrpc": "2.0", "method": "workspace/didChangeConfiguration", "params": map[string]interface{}{"settings": map[string]interface{}{"subfixer": map[string]interface{}{"params": map[string]interface{}{"chars_per_line": 50, "min_length": 1}}}}},
+			map[string]interface{}{"jsonrpc": "2.0", "id": 2, "method": "shutdown"},
+			map[string]interface{}{"jsonrpc": "2.0", "method": "exit"},
+		}, ExitOK, func(responses map[string]string, diagnostics []string) error {
+			// Settings changed later are layered over those of the
+			// command line, not over the earlier ones
+			expected := []string{
+				"none",
+				`{"code":"reading_speed","range":{"end":{"character":29,"line":1},"start":{"character":0,"line":1}}}`,
+				`{"code":"min_length","range":{"end":{"character":29,"line":1},"start":{"character":0,"line":1}}}`,
+				`{"code":"chars_per_line","range":{"end":{"character":59,"line":6},"start":{"character":50,"line":6}}}`,
+			}
+			if !reflect.DeepEqual(diagnostics, expected) {
+				return fmt.Errorf("diagnostics are %v, expected %v", diagnostics, expected)
+			}
+			return nil
+		}},
+		{"unknown setting", []interface{}{
+			map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": map[string]interface{}{"initializationOptions": map[string]interface{}{"params": map[string]interface{}{"speling": 1}}}},
+			map[string]interface{}{"jsonrpc": "2.0", "method": "exit"},
+		}, ExitError, func(responses map[string]string, diagnostics []string) error {
+			if !strings.Contains(responses["1"], `"error"`) {
+				return fmt.Errorf("response is %s, expected an error", responses["1"])
+			}
+			return nil
+		}},
+		{"unreadable", []interface{}{
+			map[string]interface{}{"jsonrpc": "2.0", "method": "textDocument/didOpen", "params": map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri, "text": "1\n00:00:01,000 --> soon\nText\n"}}},
+		}, ExitError, func(responses map[string]string, diagnostics []string) error {
+			if len(diagnostics) != 1 || !strings.Contains(diagnostics[0], "Cannot read subtitles") {
+				return fmt.Errorf("diagnostics are %v, expected an error reading the subtitles", diagnostics)
+			}
+			return nil
+		}},
+	} {
+		redirect(t, &os.Stdin, lspInput(c.messages...))
+		out := redirect(t, &os.Stdout, "")
+
+		if code := runCommand([]string{"lsp"}); code != c.expected {
+			t.Errorf("%s: exit %d, expected %d", c.name, code, c.expected)
+		}
+
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Each publication of diagnostics is summed up as the rule &
+		// range of each one, or none when it clears them
+		responses := make(map[string]string)
+		var diagnostics []string
+		for _, m := range readLspMessages(t, data) {
+			body, _ := json.Marshal(m)
+			if id, ok := m["id"]; ok {
+				responses[fmt.Sprint(id)] = string(body)
+				continue
+			}
+
+			list := m["params"].(map[string]interface{})["diagnostics"].([]interface{})
+			if len(list) == 0 {
+				diagnostics = append(diagnostics, "none")
+			}
+			for _, d := range list {
+				d := d.(map[string]interface{})
+				summary := map[string]interface{}{"code": d["code"], "range": d["range"]}
+				if d["code"] == nil {
+					summary = map[string]interface{}{"message": d["message"]}
+				}
+				s, _ := json.Marshal(summary)
+				diagnostics = append(diagnostics, string(s))
+			}
+		}
+
+		if err := c.check(responses, diagnostics); err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// langPattern matches language tags used as the last part of a subtitle
+// file name before the extension, like the "en" in "ep01.en.srt" or the
+// "pt-BR" in "ep01.pt-BR.srt"
+var langPattern = regexp.MustCompile(`^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{2,4})?$`)
+
+// globBase returns the leading directories of pattern which contain
+// no glob meta characters, or pattern itself if it is a directory.
+// Outputs are mirrored relative to this
+func globBase(pattern string) string {
+	if isDir(pattern) {
+		return pattern
+	}
+
+	dir := filepath.Dir(pattern)
+
+	for dir != "." && dir != string(filepath.Separator) && strings.ContainsAny(dir, "*?[\\") {
+		dir = filepath.Dir(dir)
+	}
+
+	return dir
+}
+
+// splitLang splits a file name without extension into its base
+// and its language tag, if the name ends in one
+func splitLang(stem string) (string, string) {
+	i := strings.LastIndex(stem, ".")
+	if i > 0 && langPattern.MatchString(stem[i+1:]) {
+		return stem[:i], stem[i+1:]
+	}
+
+	return stem, ""
+}
+
+// expandTemplate builds an output file name for input from tmpl.
+// Supported placeholders are {name} (file name without extension),
+// {base} (name without language tag), {lang} and {ext}. Separators
+// left dangling by empty placeholders are removed
+func expandTemplate(tmpl string, input string) string {
+	stem, ext := splitExt(input)
+	base, lang := splitLang(stem)
+
+	name := strings.NewReplacer(
+		"{name}", stem,
+		"{base}", base,
+		"{lang}", lang,
+		"{ext}", strings.TrimPrefix(ext, "."),
+	).Replace(tmpl)
+
+	for strings.Contains(name, "..") {
+		name = strings.Replace(name, "..", ".", -1)
+	}
+
+	return strings.Trim(name, ".")
+}
+
+// outputPath returns where the result for input should be written when
+// -out_dir is used, mirroring the path of input relative to base
+func outputPath(input string, base string, params cliParams) string {
+	rel, err := filepath.Rel(base, filepath.Dir(input))
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = "."
+	}
+
+	return filepath.Join(params.OutDir, rel, expandTemplate(params.OutTemplate, input))
+}
+
+// checkOutputs refuses an -out_dir & -out_template which would write
+// the results of several inputs to the same path, one over the other
+func checkOutputs(files []string, params cliParams) error {
+	if params.OutDir == "" || len(files) < 2 {
+		return nil
+	}
+
+	base := globBase(params.File)
+	seen := make(map[string]string)
+	for _, f := range files {
+		dst := outputPath(f, base, params)
+		if other, ok := seen[dst]; ok {
+			return fmt.Errorf("'%s' & '%s' would both be written to '%s', change -out_template to tell them apart", other, f, dst)
+		}
+		seen[dst] = f
+	}
+
+	return nil
+}
+
+// sameFile checks if a and b refer to the same existing file
+func sameFile(a string, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(ai, bi)
+}
+
+// checkOverwrite refuses an output path which would overwrite one of
+// the inputs, unless -overwrite was given
+func checkOverwrite(dst string, inputs []string, params cliParams) error {
+	if dst == "" || dst == "-" || params.Overwrite {
+		return nil
+	}
+
+	for _, input := range inputs {
+		if sameFile(dst, input) {
+			return fmt.Errorf("output '%s' would overwrite input '%s', use -overwrite to allow this", dst, input)
+		}
+	}
+
+	return nil
+}
+
+// prepareOutput works out the output path for input, which was matched
+// by pattern, checks that it does not overwrite any of the inputs and
+// creates its directory
+func prepareOutput(input string, pattern string, inputs []string, params cliParams) (string, error) {
+	dst := params.Out
+
+	if params.OutDir != "" {
+		dst = outputPath(input, globBase(pattern), params)
+	}
+
+	if err := checkOverwrite(dst, inputs, params); err != nil {
+		return "", err
+	}
+
+	if dst != "" && dst != "-" && !params.DryRun {
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return "", err
+		}
+	}
+
+	return dst, nil
+}
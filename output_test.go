@@ -0,0 +1,119 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	for _, c := range []struct {
+		tmpl, input, expected string
+	}{
+		{"{name}.{ext}", "in/ep01.en.srt", "ep01.en.srt"},
+		{"{base}.{ext}", "in/ep01.en.srt", "ep01.srt"},
+		{"{base}.{lang}.fixed.{ext}", "ep01.pt-BR.srt", "ep01.pt-BR.fixed.srt"},
+		{"{base}.{lang}.{ext}", "ep01.srt", "ep01.srt"},
+		{"{lang}.{base}.{ext}", "ep01.srt", "ep01.srt"},
+		{"{name}.fixed.{ext}", "movie.final.srt", "movie.final.fixed.srt"},
+		{"{base}.{ext}", "movie.final.srt", "movie.final.srt"},
+		{"{name}", "ep01", "ep01"},
+	} {
+		if got := expandTemplate(c.tmpl, c.input); got != c.expected {
+			t.Errorf("expandTemplate(%q, %q) = %q, expected %q", c.tmpl, c.input, got, c.expected)
+		}
+	}
+}
+
+func TestSplitLang(t *testing.T) {
+	for _, c := range []struct {
+		stem, base, lang string
+	}{
+		{"ep01.en", "ep01", "en"},
+		{"ep01.pt-BR", "ep01", "pt-BR"},
+		{"ep01.zh_Hans", "ep01", "zh_Hans"},
+		{"ep01", "ep01", ""},
+		{"ep01.final", "ep01.final", ""},
+		{".en", ".en", ""},
+	} {
+		base, lang := splitLang(c.stem)
+		if base != c.base || lang != c.lang {
+			t.Errorf("splitLang(%q) = %q, %q, expected %q, %q", c.stem, base, lang, c.base, c.lang)
+		}
+	}
+}
+
+func TestGlobBase(t *testing.T) {
+	for _, c := range []struct {
+		pattern, expected string
+	}{
+		{"in/*.srt", "in"},
+		{"in/season*/ep?.srt", "in"},
+		{"in/s1/**/*.srt", "in/s1"},
+		{"*.srt", "."},
+		{"ep01.srt", "."},
+	} {
+		if got := globBase(filepath.FromSlash(c.pattern)); got != filepath.FromSlash(c.expected) {
+			t.Errorf("globBase(%q) = %q, expected %q", c.pattern, got, c.expected)
+		}
+	}
+}
+
+func TestOutputPath(t *testing.T) {
+	params := cliParams{OutDir: "out", OutTemplate: "{name}.{ext}"}
+	for _, c := range []struct {
+		input, base, expected string
+	}{
+		{"in/ep01.srt", "in", "out/ep01.srt"},
+		{"in/s1/ep01.srt", "in", "out/s1/ep01.srt"},
+		{"other/ep01.srt", "in", "out/ep01.srt"},
+	} {
+		got := outputPath(filepath.FromSlash(c.input), filepath.FromSlash(c.base), params)
+		if got != filepath.FromSlash(c.expected) {
+			t.Errorf("outputPath(%q, %q) = %q, expected %q", c.input, c.base, got, c.expected)
+		}
+	}
+}
+
+func TestCheckOutputs(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		params   cliParams
+		files    []string
+		conflict string
+	}{
+		{
+			name:   "distinct names",
+			params: cliParams{CommandParams: astisub.CommandParams{File: "in/*.srt"}, OutDir: "out", OutTemplate: "{name}.{ext}"},
+			files:  []string{"in/ep.en.srt", "in/ep.fr.srt"},
+		},
+		{
+			name:     "language dropped",
+			params:   cliParams{CommandParams: astisub.CommandParams{File: "in/*.srt"}, OutDir: "out", OutTemplate: "{base}.{ext}"},
+			files:    []string{"in/ep.en.srt", "in/ep.fr.srt"},
+			conflict: "out/ep.srt",
+		},
+		{
+			name:   "same name in other folders",
+			params: cliParams{CommandParams: astisub.CommandParams{File: "in/**/*.srt"}, OutDir: "out", OutTemplate: "{base}.{ext}"},
+			files:  []string{"in/s1/ep.en.srt", "in/s2/ep.en.srt"},
+		},
+		{
+			name:   "no out_dir",
+			params: cliParams{CommandParams: astisub.CommandParams{File: "in/*.srt"}, OutTemplate: "{base}.{ext}"},
+			files:  []string{"in/ep.en.srt", "in/ep.fr.srt"},
+		},
+	} {
+		err := checkOutputs(c.files, c.params)
+		switch {
+		case c.conflict == "" && err != nil:
+			t.Errorf("%s: unexpected error %s", c.name, err)
+		case c.conflict != "" && err == nil:
+			t.Errorf("%s: expected a conflict on '%s'", c.name, c.conflict)
+		case c.conflict != "" && !strings.Contains(err.Error(), c.conflict):
+			t.Errorf("%s: error %q does not name '%s'", c.name, err, c.conflict)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// Values of -plugins selecting every plugin or none
+const (
+	PluginsAll = "all"
+	PluginsOff = "off"
+)
+
+// Inputs a plugin can ask for: one subtitle per line, or the whole file
+const (
+	PluginInputCue  = "cue"
+	PluginInputFile = "file"
+)
+
+// DefaultPluginTimeout is how long a plugin may take for a file
+const DefaultPluginTimeout = 10 * time.Second
+
+// pluginConfig declares an external rule in a config file, e.g.
+//
+//	{ "plugins": { "no-and": { "command": ["python3", "rules/no_and.py"] } } }
+//
+// Relative commands are run from the directory of the config file
+type pluginConfig struct {
+	Command  []string `json:"command"`
+	Input    string   `json:"input"`
+	Timeout  string   `json:"timeout"`
+	Optional bool     `json:"optional"`
+}
+
+// plugin is a configured plugin ready to run
+type plugin struct {
+	name     string
+	command  []string
+	input    string
+	timeout  time.Duration
+	optional bool
+}
+
+// pluginRequest is written to the stdin of a plugin, once per
+// subtitle or once for the file depending on its input
+type pluginRequest struct {
+	Mode   string       `json:"mode"`
+	File   string       `json:"file"`
+	Params pluginParams `json:"params"`
+	Cue    *serveCue    `json:"cue,omitempty"`
+	Cues   []serveCue   `json:"cues,omitempty"`
+}
+
+// pluginParams are the fixing & checking settings in effect, keyed by
+// flag name as in profiles. They are part of the plugin protocol, so
+// settings are only added, never renamed or removed
+type pluginParams struct {
+	Speed            float64 `json:"speed"`
+	SpeedEpsilon     float64 `json:"speed_epsilon"`
+	MinLength        float64 `json:"min_length"`
+	MaxLength        float64 `json:"max_length"`
+	TrimSpaces       int     `json:"trim_spaces"`
+	JoinShorterThan  int     `json:"join_shorter_than"`
+	ExpandCloserThan float64 `json:"expand_closer_than"`
+	SplitLongerThan  float64 `json:"split_longer_than"`
+	ShrinkLongerThan float64 `json:"shrink_longer_than"`
+	MaxLines         int     `json:"max_lines"`
+	CharsPerLine     int     `json:"chars_per_line"`
+	ReadingSpeed     float64 `json:"reading_speed"`
+	LineBalance      float64 `json:"line_balance"`
+	PreferCompact    bool    `json:"prefer_compact"`
+	SpacesAsChars    bool    `json:"spaces_as_chars"`
+	NewlinesAsChars  bool    `json:"newlines_as_chars"`
+	ForbiddenChars   string  `json:"forbidden_chars"`
+	MinGapFrames     int     `json:"min_gap_frames"`
+	FrameRate        float64 `json:"frame_rate"`
+}
+
+// newPluginParams picks the settings of params sent to plugins
+func newPluginParams(params cliParams) pluginParams {
+	return pluginParams{
+		Speed:            params.Speed,
+		SpeedEpsilon:     params.SpeedEpsilon,
+		MinLength:        params.MinLength,
+		MaxLength:        params.MaxLength,
+		TrimSpaces:       params.TrimSpaces,
+		JoinShorterThan:  params.JoinShorterThan,
+		ExpandCloserThan: params.ExpandCloserThan,
+		SplitLongerThan:  params.SplitLongerThan,
+		ShrinkLongerThan: params.ShrinkLongerThan,
+		MaxLines:         params.MaxLines,
+		CharsPerLine:     params.CharsPerLine,
+		ReadingSpeed:     params.ReadingSpeed,
+		LineBalance:      params.LineBalance,
+		PreferCompact:    params.PreferCompact,
+		SpacesAsChars:    params.SpacesAsChars,
+		NewlinesAsChars:  params.NewlinesAsChars,
+		ForbiddenChars:   params.ForbiddenChars,
+		MinGapFrames:     params.MinGapFrames,
+		FrameRate:        params.FrameRate,
+	}
+}
+
+// pluginEdit changes the timing or text of a subtitle. Fields left
+// out are kept as they are
+type pluginEdit struct {
+	Id    int      `json:"id"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+	Lines []string `json:"lines"`
+}
+
+// pluginResponse is read from the stdout of a plugin for each request.
+// When given a subtitle, ids may be left out & edit used instead of edits
+type pluginResponse struct {
+	Violations []astisub.Violation `json:"violations"`
+	Edit       *pluginEdit         `json:"edit"`
+	Edits      []pluginEdit        `json:"edits"`
+}
+
+// plugins are the plugins selected by -plugins, run by fix & check
+var plugins []*plugin
+
+// configurePlugins loads the plugins declared in the config files and
+// keeps those selected by -plugins. A later config file replaces the
+// plugins of the same name of an earlier one
+func configurePlugins(params cliParams) error {
+	plugins = nil
+	if params.Plugins == "" || params.Plugins == PluginsOff {
+		return nil
+	}
+
+	configs, err := loadConfigs(params.Config)
+	if err != nil {
+		return err
+	}
+
+	declared := make(map[string]*plugin)
+	for _, c := range configs {
+		for name, pc := range c.Plugins {
+			p, err := newPlugin(name, pc, filepath.Dir(c.Path))
+			if err != nil {
+				return fmt.Errorf("plugin '%s' in '%s': %s", name, c.Path, err)
+			}
+			declared[name] = p
+		}
+	}
+
+	var names []string
+	if params.Plugins == PluginsAll {
+		for name := range declared {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	} else {
+		for name := range splitList(params.Plugins) {
+			if declared[name] == nil {
+				return fmt.Errorf("-plugins: no plugin '%s' in the config files", name)
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	for _, name := range names {
+		plugins = append(plugins, declared[name])
+	}
+
+	return nil
+}
+
+// newPlugin checks the declaration of a plugin found in dir
+func newPlugin(name string, pc *pluginConfig, dir string) (*plugin, error) {
+	if pc == nil || len(pc.Command) == 0 {
+		return nil, errors.New("command is required")
+	}
+
+	p := &plugin{
+		name:     name,
+		command:  append([]string{}, pc.Command...),
+		input:    pc.Input,
+		timeout:  DefaultPluginTimeout,
+		optional: pc.Optional,
+	}
+
+	if p.input == "" {
+		p.input = PluginInputCue
+	}
+	if p.input != PluginInputCue && p.input != PluginInputFile {
+		return nil, fmt.Errorf("input must be %s or %s, not '%s'", PluginInputCue, PluginInputFile, p.input)
+	}
+
+	if pc.Timeout != "" {
+		d, err := time.ParseDuration(pc.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid timeout '%s'", pc.Timeout)
+		}
+		p.timeout = d
+	}
+
+	if cmd := p.command[0]; strings.Contains(cmd, "/") && !filepath.IsAbs(cmd) {
+		p.command[0] = filepath.Join(dir, cmd)
+	}
+
+	return p, nil
+}
+
+// pluginCue converts subtitle i for a plugin
+func pluginCue(s *astisub.Subtitles, i int) serveCue {
+	c := serveCue{
+		Id:    i + 1,
+		Start: astisub.FormatDurationSRT(s.Items[i].StartAt),
+		End:   astisub.FormatDurationSRT(s.Items[i].EndAt),
+		Lines: make([]string, 0, len(s.Items[i].Lines)),
+	}
+	for _, l := range s.Items[i].Lines {
+		c.Lines = append(c.Lines, l.String())
+	}
+
+	return c
+}
+
+// run sends the subtitles to process to the plugin & returns its
+// responses. The plugin runs in its own process, killed once it takes
+// longer than its timeout, so a crash or a hang is only an error
+func (p *plugin) run(ctx context.Context, s *astisub.Subtitles, mode string, params cliParams) ([]pluginResponse, []int, error) {
+	var requests []pluginRequest
+	var ids []int
+	settings := newPluginParams(params)
+
+	if p.input == PluginInputFile {
+		req := pluginRequest{Mode: mode, File: params.File, Params: settings, Cues: make([]serveCue, 0, len(s.Items))}
+		for i := range s.Items {
+			req.Cues = append(req.Cues, pluginCue(s, i))
+		}
+		requests = append(requests, req)
+		ids = append(ids, 0)
+	} else {
+		for i, item := range s.Items {
+			if item.Process {
+				c := pluginCue(s, i)
+				requests = append(requests, pluginRequest{Mode: mode, File: params.File, Params: settings, Cue: &c})
+				ids = append(ids, i+1)
+			}
+		}
+	}
+
+	if len(requests) == 0 {
+		return nil, nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	stdout, stderr, err := p.exec(ctx, requests)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, nil, fmt.Errorf("timed out after %s", p.timeout)
+	}
+	if err != nil {
+		if msg := lastLine(stderr.String()); msg != "" {
+			return nil, nil, fmt.Errorf("%s: %s", err, msg)
+		}
+		return nil, nil, err
+	}
+
+	var responses []pluginResponse
+	dec := json.NewDecoder(stdout)
+	for {
+		var resp pluginResponse
+		if err := dec.Decode(&resp); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("invalid response: %s", err)
+		}
+		responses = append(responses, resp)
+	}
+
+	if len(responses) != len(requests) {
+		return nil, nil, fmt.Errorf("answered %d of %d requests", len(responses), len(requests))
+	}
+
+	return responses, ids, nil
+}
+
+// exec runs the plugin with requests on its stdin & returns what it
+// wrote to stdout & stderr. Once ctx is done, the plugin & the processes
+// it started are killed and its output is closed, so that a process
+// left holding it cannot keep the plugin running
+func (p *plugin) exec(ctx context.Context, requests []pluginRequest) (*bytes.Buffer, *bytes.Buffer, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	setProcessGroup(cmd)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return &stdout, &stderr, err
+	}
+
+	// Pipes of our own, as Wait would otherwise wait for every process
+	// holding the output of the plugin
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		return &stdout, &stderr, err
+	}
+	defer outR.Close()
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		outW.Close()
+		return &stdout, &stderr, err
+	}
+	defer errR.Close()
+
+	cmd.Stdout, cmd.Stderr = outW, errW
+	err = cmd.Start()
+	outW.Close()
+	errW.Close()
+	if err != nil {
+		return &stdout, &stderr, err
+	}
+
+	// Written while the plugin runs, so that it may answer as it reads
+	go func() {
+		w := bufio.NewWriter(stdin)
+		enc := json.NewEncoder(w)
+		for _, req := range requests {
+			if enc.Encode(req) != nil {
+				break
+			}
+		}
+		w.Flush()
+		stdin.Close()
+	}()
+
+	var copies sync.WaitGroup
+	copies.Add(2)
+	go func() {
+		io.Copy(&stdout, outR)
+		copies.Done()
+	}()
+	go func() {
+		io.Copy(&stderr, errR)
+		copies.Done()
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		copies.Wait()
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		outR.Close()
+		errR.Close()
+		err = <-done
+	}
+
+	return &stdout, &stderr, err
+}
+
+// lastLine returns the last non empty line of text
+func lastLine(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// runPlugins runs every selected plugin on the subtitles. In check mode
+// it returns the violations found on the subtitles to process; in fix
+// mode the edits are applied, recorded as made by plugin:<name>. A
+// failing plugin is an error, unless it was declared optional
+func runPlugins(s *astisub.Subtitles, params cliParams, mode string, log *leveledLogger) ([]astisub.Violation, error) {
+	var violations []astisub.Violation
+
+	for _, p := range plugins {
+		start := time.Now()
+		responses, ids, err := p.run(context.Background(), s, mode, params)
+		if err == nil && mode == "fix" {
+			err = p.applyEdits(s, responses, ids, log)
+		}
+		if err != nil {
+			if p.optional {
+				log.Warnf("Plugin %s failed, skipping it: %s", p.name, err)
+				continue
+			}
+			return violations, fmt.Errorf("plugin %s failed: %s", p.name, err)
+		}
+
+		log.Log(astisub.LevelTrace, "Ran plugin", "plugin", p.name, "mode", mode, "seconds", time.Since(start).Seconds())
+
+		if mode != "check" {
+			continue
+		}
+
+		for n, resp := range responses {
+			for _, v := range resp.Violations {
+				if v.Id == 0 {
+					v.Id = ids[n]
+				}
+				if v.Rule == "" {
+					v.Rule = p.name
+				}
+				if v.Id < 1 || v.Id > len(s.Items) || v.Message == "" {
+					log.Warnf("Plugin %s reported an invalid violation, ignoring it: %+v", p.name, v)
+					continue
+				}
+				if s.Items[v.Id-1].Process {
+					violations = append(violations, v)
+				}
+			}
+		}
+	}
+
+	return violations, nil
+}
+
+// applyEdits applies the edits of the responses of plugin p. They are
+// all checked first, so that an invalid one leaves the subtitles as
+// they were
+func (p *plugin) applyEdits(s *astisub.Subtitles, responses []pluginResponse, ids []int, log *leveledLogger) error {
+	type edit struct {
+		i          int
+		start, end time.Duration
+		lines      []string
+	}
+
+	var edits []edit
+	for n, resp := range responses {
+		list := resp.Edits
+		if resp.Edit != nil {
+			list = append(list, *resp.Edit)
+		}
+
+		for _, e := range list {
+			if e.Id == 0 {
+				e.Id = ids[n]
+			}
+			if e.Id < 1 || e.Id > len(s.Items) {
+				return fmt.Errorf("edit of subtitle #%d, which does not exist", e.Id)
+			}
+
+			item := s.Items[e.Id-1]
+			if !item.Process {
+				continue
+			}
+
+			ed := edit{i: e.Id - 1, start: item.StartAt, end: item.EndAt}
+			var err error
+			if e.Start != "" {
+				if ed.start, err = astisub.ParseDuration(e.Start, ",", 3); err != nil {
+					return fmt.Errorf("edit of subtitle #%d: invalid start '%s'", e.Id, e.Start)
+				}
+			}
+			if e.End != "" {
+				if ed.end, err = astisub.ParseDuration(e.End, ",", 3); err != nil {
+					return fmt.Errorf("edit of subtitle #%d: invalid end '%s'", e.Id, e.End)
+				}
+			}
+			if ed.end <= ed.start {
+				return fmt.Errorf("edit of subtitle #%d ends before it starts", e.Id)
+			}
+
+			ed.lines = e.Lines
+			if ed.lines == nil {
+				for _, l := range item.Lines {
+					ed.lines = append(ed.lines, l.String())
+				}
+			}
+
+			edits = append(edits, ed)
+		}
+	}
+
+	for _, ed := range edits {
+		log.Log(astisub.LevelDebug, "Plugin edit", "id", ed.i+1, "plugin", p.name)
+		s.Edit(ed.i, ed.start, ed.end, ed.lines, "plugin:"+p.name)
+	}
+
+	return nil
+}
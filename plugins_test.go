@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// inDir runs the rest of the test in dir, with a user config of its own
+// so that the one of whoever runs the tests is left out
+func inDir(t *testing.T, dir string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", home)
+	t.Setenv("AppData", home)
+}
+
+// needShell skips tests running plugins written for sh
+func needShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugins of the tests are sh scripts")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+}
+
+func TestNewPlugin(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		config  pluginConfig
+		command string
+		fails   bool
+	}{
+		{name: "on the PATH", config: pluginConfig{Command: []string{"python3", "rule.py"}}, command: "python3"},
+		{name: "relative", config: pluginConfig{Command: []string{"./rules/check"}}, command: filepath.Join("conf", "rules", "check")},
+		{name: "absolute", config: pluginConfig{Command: []string{"/usr/bin/rule"}}, command: "/usr/bin/rule"},
+		{name: "no command", config: pluginConfig{}, fails: true},
+		{name: "unknown input", config: pluginConfig{Command: []string{"rule"}, Input: "line"}, fails: true},
+		{name: "invalid timeout", config: pluginConfig{Command: []string{"rule"}, Timeout: "soon"}, fails: true},
+		{name: "negative timeout", config: pluginConfig{Command: []string{"rule"}, Timeout: "-1s"}, fails: true},
+	} {
+		p, err := newPlugin(c.name, &c.config, "conf")
+		switch {
+		case c.fails && err == nil:
+			t.Errorf("%s: expected an error", c.name)
+		case !c.fails && err != nil:
+			t.Errorf("%s: %s", c.name, err)
+		case !c.fails && p.command[0] != c.command:
+			t.Errorf("%s: runs '%s', expected '%s'", c.name, p.command[0], c.command)
+		}
+	}
+}
+
+func TestPluginsOptIn(t *testing.T) {
+	captureLog(t, LogFormatText)
+	dir := t.TempDir()
+	inDir(t, dir)
+
+	// A checkout trying to run its plugins on its own
+	config := `{
+		"profiles": { "default": { "plugins": "all" } },
+		"plugins": { "rule": { "command": ["./rule"] } }
+	}`
+	if err := os.WriteFile(filepath.Join(dir, ConfigFileName), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { plugins = nil })
+
+	for _, c := range []struct {
+		args     []string
+		expected int
+	}{
+		{nil, 0},
+		{[]string{"-plugins", PluginsAll}, 1},
+		{[]string{"-plugins", "rule"}, 1},
+		{[]string{"-plugins", PluginsOff}, 0},
+	} {
+		cmd := findCommand("check")
+		var params cliParams
+		fs := newFlagSet(cmd, &params)
+		if err := fs.Parse(c.args); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := applyProfile(fs, cmd, &params); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := configurePlugins(params); err != nil {
+			t.Fatalf("%v: %s", c.args, err)
+		}
+		if len(plugins) != c.expected {
+			t.Errorf("%v: %d plugins selected, expected %d", c.args, len(plugins), c.expected)
+		}
+	}
+}
+
+func TestPluginParams(t *testing.T) {
+	data, err := json.Marshal(newPluginParams(commandParams(t, "check")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var settings map[string]interface{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every setting is named after the flag setting it, & only the
+	// settings of fix & check are sent
+	for key := range settings {
+		if len(commandsWithFlag(key, nil)) == 0 {
+			t.Errorf("setting '%s' is not a flag", key)
+		}
+		for _, cli := range []string{"addr", "max_body", "done_dir", "result", "interactive"} {
+			if key == cli {
+				t.Errorf("setting '%s' of the command line is sent to plugins", key)
+			}
+		}
+	}
+
+	if settings["chars_per_line"] != float64(DefaultCharsPerLine) {
+		t.Errorf("chars_per_line is %v, expected %d", settings["chars_per_line"], DefaultCharsPerLine)
+	}
+}
+
+func TestPluginRun(t *testing.T) {
+	needShell(t)
+	dir := t.TempDir()
+	requests := filepath.Join(dir, "requests.jsonl")
+
+	// Flags every subtitle starting with "General", keeping the requests
+	script := `while read -r line; do
+	echo "$line" >> "` + requests + `"
+	case "$line" in
+	*'"lines":["General'*) echo '{"violations":[{"message":"No generals","line":1}]}' ;;
+	*) echo '{}' ;;
+	esac
+done`
+	p := &plugin{name: "no-generals", command: []string{"sh", "-c", script}, input: PluginInputCue, timeout: 10 * time.Second}
+
+	s := readTestSubtitles(t, testSRT)
+	params := commandParams(t, "check", "-file", "ep01.srt")
+	responses, ids, err := p.run(context.Background(), s, "check", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(responses) != 2 || len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Fatalf("got %d responses for ids %v, expected 2 for [1 2]", len(responses), ids)
+	}
+	if len(responses[0].Violations) != 0 || len(responses[1].Violations) != 1 {
+		t.Errorf("expected a violation on subtitle 2 only, got %+v", responses)
+	}
+
+	data, err := os.ReadFile(requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.SplitN(string(data), "\n", 2)[0]), &req); err != nil {
+		t.Fatal(err)
+	}
+	settings, _ := req["params"].(map[string]interface{})
+	if req["mode"] != "check" || req["file"] != "ep01.srt" || settings["reading_speed"] == nil || settings["Addr"] != nil {
+		t.Errorf("unexpected request %s", data)
+	}
+}
+
+func TestPluginFailures(t *testing.T) {
+	needShell(t)
+
+	for _, c := range []struct {
+		name    string
+		script  string
+		timeout time.Duration
+		err     string
+	}{
+		{"exit status", `echo "no dictionary" >&2; exit 3`, 10 * time.Second, "no dictionary"},
+		{"not JSON", `while read -r line; do echo nope; done`, 10 * time.Second, "invalid response"},
+		{"too few answers", `read -r line; echo '{}'`, 10 * time.Second, "answered 1 of 2"},
+		{"hangs", `sleep 30`, 200 * time.Millisecond, "timed out"},
+		// A process started by the plugin keeps its output open
+		{"hangs in a child", `sleep 30 & wait`, 200 * time.Millisecond, "timed out"},
+		{"leaves a child", `sleep 30 & exit 0`, 200 * time.Millisecond, "timed out"},
+	} {
+		p := &plugin{name: c.name, command: []string{"sh", "-c", c.script}, input: PluginInputCue, timeout: c.timeout}
+
+		start := time.Now()
+		_, _, err := p.run(context.Background(), readTestSubtitles(t, testSRT), "check", commandParams(t, "check"))
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: error %v, expected %q", c.name, err, c.err)
+		}
+		if elapsed := time.Since(start); elapsed > c.timeout+5*time.Second {
+			t.Errorf("%s: took %s, past the timeout of %s", c.name, elapsed, c.timeout)
+		}
+	}
+}
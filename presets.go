@@ -0,0 +1,279 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// preset is a versioned set of settings transcribed from a published
+// style guide. Values are keyed by flag name, like profiles are. A new
+// Version is added whenever a guide changes, older ones are kept so
+// existing pipelines keep their limits until they opt in
+type preset struct {
+	Name        string
+	Version     string
+	Description string
+	Values      map[string]string
+}
+
+// Ref returns the fully qualified name of the preset, name@version
+func (p *preset) Ref() string {
+	return p.Name + "@" + p.Version
+}
+
+// presets are the built-in presets, oldest version of each name first
+var presets []*preset
+
+func init() {
+	presets = append(presets, &preset{
+		Name:        "subfixer",
+		Version:     "1",
+		Description: "Built-in defaults of subfixer",
+		Values: map[string]string{
+			"speed":              ftoa(DefaultReadingSpeed),
+			"reading_speed":      ftoa(DefaultReadingSpeed),
+			"chars_per_line":     strconv.Itoa(DefaultCharsPerLine),
+			"join_shorter_than":  strconv.Itoa(DefaultJoinShorterThan),
+			"max_lines":          strconv.Itoa(DefaultMaxLines),
+			"min_length":         ftoa(DefaultMinLength),
+			"split_longer_than":  ftoa(DefaultSplitLongerThan),
+			"shrink_longer_than": ftoa(DefaultShrinkLongerThan),
+		},
+	})
+
+	// Netflix Timed Text Style Guides: reading speed per language and
+	// audience, 42 characters per line for Latin scripts, durations of
+	// 5/6s to 7s, a gap of at least 2 frames and no line starting with
+	// punctuation which belongs at the end of the line before
+	netflix := []struct {
+		lang     string
+		adult    float64
+		children float64
+	}{
+		{"en-US", 20, 17},
+		{"en-GB", 20, 17},
+		{"de-DE", 17, 13},
+		{"es-ES", 17, 13},
+		{"es-419", 17, 13},
+		{"fr-FR", 17, 13},
+		{"it-IT", 17, 13},
+		{"nl-NL", 17, 13},
+		{"pt-BR", 17, 13},
+		{"pt-PT", 17, 13},
+	}
+	for _, n := range netflix {
+		for _, audience := range []string{"adult", "children"} {
+			cps := n.adult
+			if audience == "children" {
+				cps = n.children
+			}
+			presets = append(presets, &preset{
+				Name:        "netflix:" + n.lang + ":" + audience,
+				Version:     "2024.1",
+				Description: fmt.Sprintf("Netflix %s, %s programs", n.lang, audience),
+				Values: map[string]string{
+					"speed":              ftoa(cps),
+					"reading_speed":      ftoa(cps),
+					"chars_per_line":     "42",
+					"join_shorter_than":  "42",
+					"max_lines":          "2",
+					"min_length":         "0.833",
+					"max_length":         "7",
+					"split_longer_than":  "7",
+					"shrink_longer_than": "7",
+					"min_gap_frames":     "2",
+					"forbidden_chars":    ".,;:!?)]}",
+				},
+			})
+		}
+	}
+
+	// BBC Subtitle Guidelines: 160-180 words per minute, 37 characters
+	// per line, the width of a teletext row, and no line starting with
+	// the punctuation ending the sentence before
+	presets = append(presets, &preset{
+		Name:        "bbc:en-GB:adult",
+		Version:     "2024.1",
+		Description: "BBC, English programs",
+		Values: map[string]string{
+			"speed":             "15",
+			"reading_speed":     "15",
+			"chars_per_line":    "37",
+			"join_shorter_than": "37",
+			"max_lines":         "2",
+			"max_length":        "7",
+			"forbidden_chars":   ".,;:!?)",
+		},
+	})
+}
+
+// ftoa formats f the way a flag prints it
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// findPreset returns the preset called ref, name or name@version. Without
+// a version the latest one is returned
+func findPreset(ref string) (*preset, error) {
+	name, version := ref, ""
+	if i := strings.LastIndex(ref, "@"); i >= 0 {
+		name, version = ref[:i], ref[i+1:]
+	}
+
+	var found *preset
+	for _, p := range presets {
+		if p.Name == name && (version == "" || p.Version == version) {
+			found = p
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("unknown preset '%s', run 'subfixer preset list' for the available ones", ref)
+	}
+
+	return found, nil
+}
+
+// sortedKeys returns the keys of values in order
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// applyPreset sets every flag of fs which was not given on the command
+// line from the preset called ref. Settings belonging to other commands
+// are ignored
+func applyPreset(fs *flag.FlagSet, cmd *command, sources map[string]string, ref string) error {
+	p, err := findPreset(ref)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range sortedKeys(p.Values) {
+		if sources[key] == "flag" {
+			continue
+		}
+
+		if fs.Lookup(key) == nil {
+			if len(commandsWithFlag(key, cmd)) == 0 {
+				return fmt.Errorf("preset '%s' has unknown setting '%s'", p.Ref(), key)
+			}
+			continue
+		}
+
+		if err := fs.Set(key, p.Values[key]); err != nil {
+			return fmt.Errorf("preset '%s': invalid value for '%s': %s", p.Ref(), key, err)
+		}
+
+		sources[key] = "preset " + p.Ref()
+	}
+
+	return nil
+}
+
+// defaultValues returns the default value of every setting
+func defaultValues() map[string]string {
+	var params cliParams
+	fs := flag.NewFlagSet("defaults", flag.ContinueOnError)
+	allFlags(fs, &params)
+
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.DefValue
+	})
+
+	return values
+}
+
+// PresetOperation lists the built-in presets, shows one of them or
+// compares two of them, depending on the action in args
+func PresetOperation(args []string) int {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	action := ""
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch {
+	case action == "list" && len(args) == 1:
+		fmt.Fprintf(w, "PRESET\tVERSION\tDESCRIPTION\n")
+		for _, p := range presets {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", p.Name, p.Version, p.Description)
+		}
+		return 0
+
+	case action == "show" && len(args) == 2:
+		p, err := findPreset(args[1])
+		if err != nil {
+			logger.Errorf("%s", err)
+			return ExitUsage
+		}
+
+		fmt.Fprintf(w, "%s: %s\n\n", p.Ref(), p.Description)
+		fmt.Fprintf(w, "SETTING\tVALUE\n")
+		for _, key := range sortedKeys(p.Values) {
+			fmt.Fprintf(w, "%s\t%s\n", key, p.Values[key])
+		}
+		return 0
+
+	case action == "diff" && len(args) == 3:
+		a, err := findPreset(args[1])
+		if err == nil {
+			var b *preset
+			if b, err = findPreset(args[2]); err == nil {
+				presetDiff(w, a, b)
+				return 0
+			}
+		}
+		logger.Errorf("%s", err)
+		return ExitUsage
+	}
+
+	logger.Errorf("usage is 'preset list', 'preset show <preset>' or 'preset diff <preset> <preset>'")
+	return ExitUsage
+}
+
+// presetDiff prints the settings on which a and b differ. Settings a
+// preset leaves alone are shown with their default value
+func presetDiff(w *tabwriter.Writer, a *preset, b *preset) {
+	defaults := defaultValues()
+	value := func(p *preset, key string) (string, string) {
+		if v, ok := p.Values[key]; ok {
+			return v, v
+		}
+		return defaults[key], defaults[key] + " (default)"
+	}
+
+	keys := make(map[string]string)
+	for key := range a.Values {
+		keys[key] = key
+	}
+	for key := range b.Values {
+		keys[key] = key
+	}
+
+	fmt.Fprintf(w, "SETTING\t%s\t%s\n", a.Ref(), b.Ref())
+	for _, key := range sortedKeys(keys) {
+		va, sa := value(a, key)
+		vb, sb := value(b, key)
+		if va != vb {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", key, sa, sb)
+		}
+	}
+}
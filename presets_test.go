@@ -0,0 +1,88 @@
+package main
+
+import "testing"
+
+func TestFindPreset(t *testing.T) {
+	for _, c := range []struct {
+		ref, expected string
+	}{
+		{"subfixer", "subfixer@1"},
+		{"netflix:de-DE:adult", "netflix:de-DE:adult@2024.1"},
+		{"netflix:de-DE:adult@2024.1", "netflix:de-DE:adult@2024.1"},
+		{"bbc:en-GB:adult", "bbc:en-GB:adult@2024.1"},
+		{"netflix:de-DE:adult@1999", ""},
+		{"netflix:xx-XX:adult", ""},
+	} {
+		p, err := findPreset(c.ref)
+		switch {
+		case c.expected == "" && err == nil:
+			t.Errorf("findPreset(%q) = %s, expected an error", c.ref, p.Ref())
+		case c.expected != "" && err != nil:
+			t.Errorf("findPreset(%q) failed: %s", c.ref, err)
+		case c.expected != "" && p.Ref() != c.expected:
+			t.Errorf("findPreset(%q) = %s, expected %s", c.ref, p.Ref(), c.expected)
+		}
+	}
+}
+
+func TestPresetsApply(t *testing.T) {
+	for _, name := range []string{"fix", "check"} {
+		cmd := findCommand(name)
+		for _, p := range presets {
+			var params cliParams
+			fs := newFlagSet(cmd, &params)
+			if err := applyPreset(fs, cmd, make(map[string]string), p.Ref()); err != nil {
+				t.Errorf("%s: %s", name, err)
+			}
+		}
+	}
+}
+
+func TestPresetsForbiddenChars(t *testing.T) {
+	for _, p := range presets {
+		if p.Name == "subfixer" {
+			continue
+		}
+		if p.Values["forbidden_chars"] == "" {
+			t.Errorf("preset %s has no forbidden_chars", p.Ref())
+		}
+	}
+}
+
+func TestApplyPresetKeepsFlags(t *testing.T) {
+	cmd := findCommand("check")
+	var params cliParams
+	fs := newFlagSet(cmd, &params)
+	if err := fs.Parse([]string{"-chars_per_line", "50"}); err != nil {
+		t.Fatal(err)
+	}
+
+	sources := map[string]string{"chars_per_line": "flag"}
+	if err := applyPreset(fs, cmd, sources, "netflix:de-DE:adult"); err != nil {
+		t.Fatal(err)
+	}
+
+	if params.CharsPerLine != 50 {
+		t.Errorf("chars_per_line is %d, expected the 50 given as a flag", params.CharsPerLine)
+	}
+	if params.ReadingSpeed != 17 || params.MinGapFrames != 2 || params.MinLength != 0.833 {
+		t.Errorf("preset not applied: reading_speed=%g min_gap_frames=%d min_length=%g", params.ReadingSpeed, params.MinGapFrames, params.MinLength)
+	}
+}
+
+func TestMinLengthDefault(t *testing.T) {
+	// check did not verify durations before presets, so it must not by
+	// default, while fix always enforced a minimum length
+	for _, c := range []struct {
+		command, expected string
+	}{
+		{"check", "0"},
+		{"fix", "1"},
+	} {
+		var params cliParams
+		f := newFlagSet(findCommand(c.command), &params).Lookup("min_length")
+		if f == nil || f.DefValue != c.expected {
+			t.Errorf("%s: -min_length defaults to %v, expected %s", c.command, f, c.expected)
+		}
+	}
+}
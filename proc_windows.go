@@ -0,0 +1,19 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup does nothing on Windows, where processes have no group
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// killProcessGroup kills cmd, once started. The processes it started
+// are left running, but cannot keep its output open once it is closed
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,484 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// reportCue is a subtitle as shown in the report, times in seconds.
+// CPS is its reading speed in characters per second
+type reportCue struct {
+	Id    int      `json:"id"`
+	Start float64  `json:"start"`
+	End   float64  `json:"end"`
+	Lines []string `json:"lines"`
+	CPS   float64  `json:"cps,omitempty"`
+}
+
+// reportChange is a change made by fix, the subtitles before & after
+type reportChange struct {
+	Label  string      `json:"label"`
+	Before []reportCue `json:"before"`
+	After  []reportCue `json:"after"`
+}
+
+// htmlReport is everything the HTML report shows for a file
+type htmlReport struct {
+	File         string              `json:"file"`
+	Command      string              `json:"command"`
+	Generated    string              `json:"generated"`
+	ReadingSpeed float64             `json:"readingSpeed"`
+	MinGap       float64             `json:"minGap"`
+	Cues         []reportCue         `json:"cues"`
+	Violations   []astisub.Violation `json:"violations"`
+	Changes      []reportChange      `json:"changes"`
+	Fixed        bool                `json:"fixed"`
+}
+
+// validateHTMLReport checks -report_html can be used with the other flags
+func validateHTMLReport(p cliParams) error {
+	switch {
+	case p.ReportHTML == "":
+		return nil
+	case p.Stream:
+		return errors.New("-report_html needs the whole file, so cannot be used with -stream")
+	case p.GitDiff != "":
+		return errors.New("-report_html cannot be used with -git_diff")
+	}
+	return nil
+}
+
+// htmlReportPath returns where the report of fname is written. The name
+// of -report_html may use the placeholders of -out_template
+func htmlReportPath(fname string, params cliParams) string {
+	input := filepath.Base(fname)
+	if fname == "-" {
+		input = "stdin.srt"
+	}
+
+	dir, name := filepath.Split(params.ReportHTML)
+	return filepath.Join(dir, expandTemplate(name, input))
+}
+
+// checkHTMLReport refuses a -report_html which would be written over by
+// every file of a batch
+func checkHTMLReport(files []string, params cliParams) error {
+	if params.ReportHTML == "" || len(files) < 2 {
+		return nil
+	}
+
+	seen := make(map[string]string)
+	for _, f := range files {
+		path := htmlReportPath(f, params)
+		if other, ok := seen[path]; ok {
+			return fmt.Errorf("-report_html '%s' would write the reports of '%s' & '%s' to the same file, use {name} in it", params.ReportHTML, other, f)
+		}
+		seen[path] = f
+	}
+
+	return nil
+}
+
+// newReportCue converts c, subtitle number id, for the report
+func newReportCue(id int, c cue) reportCue {
+	return reportCue{
+		Id:    id,
+		Start: c.Start.Seconds(),
+		End:   c.End.Seconds(),
+		Lines: c.Lines,
+	}
+}
+
+// reportCheckParams returns the settings the subtitles of a fix report
+// are checked with. fix takes none of the flags of check, so those are
+// the defaults of check, counting newlines as fix did
+func reportCheckParams(params cliParams) astisub.CommandParams {
+	var p cliParams
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	checkFlags(fs, &p)
+	minLengthFlag(fs, &p, 0)
+
+	p.NewlinesAsChars = params.NewlinesAsChars
+	return p.CommandParams
+}
+
+// newHTMLReport gathers the report of the subtitles. The perfection
+// checks are run on the subtitles to process, and when before is given,
+// the changes made since are worked out too
+func newHTMLReport(s *astisub.Subtitles, params cliParams, before []cue, log astisub.Logger) htmlReport {
+	r := htmlReport{
+		File:         params.File,
+		Command:      params.Mode,
+		Generated:    time.Now().Format(time.RFC3339),
+		ReadingSpeed: params.ReadingSpeed,
+		Cues:         make([]reportCue, 0, len(s.Items)),
+		Violations:   make([]astisub.Violation, 0),
+		Changes:      make([]reportChange, 0),
+		Fixed:        before != nil,
+	}
+
+	// fix has no reading speed to check, only the one it aims for
+	if r.ReadingSpeed <= 0 {
+		r.ReadingSpeed = params.Speed
+	}
+
+	check := params.CommandParams
+	if before != nil {
+		check = reportCheckParams(params)
+	}
+
+	if check.MinGapFrames > 0 && check.FrameRate > 0 {
+		r.MinGap = float64(check.MinGapFrames) / check.FrameRate
+	}
+
+	after := snapshot(s)
+	for i, item := range s.Items {
+		c := newReportCue(i+1, after[i])
+		if length := item.GetLength(); length > 0 {
+			c.CPS = float64(item.GetRuneCount(params.CommandParams)) / length
+		}
+		r.Cues = append(r.Cues, c)
+
+		if item.Process {
+			r.Violations = append(r.Violations, s.PerfectionViolations(i, check, log)...)
+		}
+	}
+
+	if before == nil {
+		return r
+	}
+
+	for _, e := range alignCues(before, after) {
+		if !e.Changed() {
+			continue
+		}
+
+		rc := reportChange{Label: e.String(), Before: make([]reportCue, 0), After: make([]reportCue, 0)}
+		for i := e.Before[0]; i < e.Before[1]; i++ {
+			rc.Before = append(rc.Before, newReportCue(i+1, before[i]))
+		}
+		for i := e.After[0]; i < e.After[1]; i++ {
+			rc.After = append(rc.After, newReportCue(i+1, after[i]))
+		}
+		r.Changes = append(r.Changes, rc)
+	}
+
+	return r
+}
+
+// writeHTMLReport writes the HTML report of the subtitles to path. The
+// page holds its styles, scripts & data, so it can be opened offline
+// & passed around as a single file
+func writeHTMLReport(path string, s *astisub.Subtitles, params cliParams, before []cue, log astisub.Logger) error {
+	r := newHTMLReport(s, params, before, log)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return astisub.WriteFileAtomic(path, func(w io.Writer) error {
+		return htmlReportTemplate.Execute(w, r)
+	})
+}
+
+// htmlReportTemplate is the page of the report. The data is embedded as
+// JSON & rendered by the script, so no asset is loaded from the network
+var htmlReportTemplate = template.Must(template.New("report").Parse(strings.TrimSpace(`
+<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>subfixer report: {{.File}}</title>
+<style>
+body { font: 14px/1.4 -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0; color: #222; background: #fafafa; }
+header, section { padding: 12px 20px; }
+header { background: #263238; color: #eceff1; }
+header h1 { font-size: 18px; margin: 0 0 4px; word-break: break-all; }
+h2 { font-size: 16px; margin: 0 0 8px; }
+.meta span { margin-right: 18px; }
+.toolbar { margin-bottom: 6px; }
+.toolbar button { min-width: 32px; }
+.legend span { display: inline-block; margin-right: 12px; }
+.swatch { display: inline-block; width: 12px; height: 12px; vertical-align: middle; margin-right: 4px; border-radius: 2px; }
+#timeline { overflow-x: auto; overflow-y: hidden; border: 1px solid #ccc; background: #fff; }
+#track { position: relative; height: 108px; }
+.ruler { position: absolute; top: 0; height: 18px; border-left: 1px solid #bbb; font-size: 10px; color: #777; padding-left: 2px; white-space: nowrap; }
+.cue { position: absolute; height: 26px; border-radius: 2px; box-sizing: border-box; cursor: pointer; overflow: hidden; font-size: 10px; color: #000; padding: 1px 2px; white-space: nowrap; }
+.cue.row0 { top: 22px; }
+.cue.row1 { top: 52px; }
+.cue.bad { outline: 2px solid #b71c1c; outline-offset: -2px; }
+.cue.selected { box-shadow: 0 0 0 3px #1565c0; z-index: 2; }
+.gap { position: absolute; top: 84px; height: 14px; box-sizing: border-box; }
+.gap.short { background: #ffb300; }
+.gap.overlap { background: #d50000; }
+.ok { background: #81c784; }
+.near { background: #dce775; }
+.fast { background: #ffb74d; }
+.tooFast { background: #e57373; }
+#details { min-height: 40px; margin-top: 8px; padding: 6px 10px; background: #fff; border: 1px solid #ddd; white-space: pre-wrap; }
+table { border-collapse: collapse; width: 100%; background: #fff; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #eee; vertical-align: top; }
+th { background: #eceff1; position: sticky; top: 0; }
+tr.selected td { background: #e3f2fd; }
+.change { display: grid; grid-template-columns: 220px 1fr; gap: 8px; padding: 6px 0; border-bottom: 1px solid #eee; }
+.bars { position: relative; height: 44px; background: #fff; border: 1px solid #eee; }
+.bar { position: absolute; height: 16px; border-radius: 2px; font-size: 10px; overflow: hidden; white-space: nowrap; padding: 0 2px; box-sizing: border-box; }
+.bar.before { top: 3px; background: #b0bec5; }
+.bar.after { top: 23px; background: #64b5f6; }
+.muted { color: #777; }
+</style>
+</head>
+<body>
+<header>
+<h1 id="title"></h1>
+<div class="meta" id="meta"></div>
+</header>
+<section>
+<h2>Timeline</h2>
+<div class="toolbar">
+<button id="zoomOut" title="Zoom out">&minus;</button>
+<button id="zoomIn" title="Zoom in">+</button>
+<button id="zoomFit" title="Fit the whole file">Fit</button>
+<span class="muted">Ctrl + wheel zooms around the pointer. Click a subtitle for its details.</span>
+</div>
+<div class="legend" id="legend"></div>
+<div id="timeline"><div id="track"></div></div>
+<div id="details" class="muted">No subtitle selected</div>
+</section>
+<section id="violationsSection">
+<h2 id="violationsTitle">Violations</h2>
+<div id="violations"></div>
+</section>
+<section id="changesSection">
+<h2 id="changesTitle">Changes</h2>
+<p class="muted">Timing of each changed subtitle before (grey) &amp; after (blue) the fix.</p>
+<div id="changes"></div>
+</section>
+<script>
+"use strict";
+var DATA = {{.}};
+
+function el(tag, cls, text) {
+  var e = document.createElement(tag);
+  if (cls) e.className = cls;
+  if (text !== undefined) e.textContent = text;
+  return e;
+}
+
+function fmt(t) {
+  var ms = Math.round(t * 1000), neg = ms < 0;
+  if (neg) ms = -ms;
+  var h = Math.floor(ms / 3600000), m = Math.floor(ms / 60000) % 60, s = Math.floor(ms / 1000) % 60;
+  function pad(n, w) { n = String(n); while (n.length < w) n = "0" + n; return n; }
+  return (neg ? "-" : "") + pad(h, 2) + ":" + pad(m, 2) + ":" + pad(s, 2) + "," + pad(ms % 1000, 3);
+}
+
+function speedClass(cps) {
+  var limit = DATA.readingSpeed;
+  if (!limit || !cps) return "ok";
+  if (cps <= limit * 0.8) return "ok";
+  if (cps <= limit) return "near";
+  if (cps <= limit * 1.2) return "fast";
+  return "tooFast";
+}
+
+var cues = DATA.cues, byId = {}, violationsById = {};
+cues.forEach(function (c) { byId[c.id] = c; });
+DATA.violations.forEach(function (v) { (violationsById[v.id] = violationsById[v.id] || []).push(v); });
+
+document.getElementById("title").textContent = DATA.file;
+var meta = document.getElementById("meta");
+[DATA.command, cues.length + " subtitles",
+ DATA.fixed ? DATA.changes.length + " changes" : null, DATA.violations.length + " violations", "reading speed " + DATA.readingSpeed + " ch/s",
+ DATA.minGap ? "min gap " + DATA.minGap.toFixed(3) + "s" : null, "generated " + DATA.generated]
+  .forEach(function (t) { if (t) meta.appendChild(el("span", "", t)); });
+
+var legend = document.getElementById("legend");
+[["ok", "up to 80% of the reading speed"], ["near", "up to the reading speed"], ["fast", "up to 20% above"],
+ ["tooFast", "more than 20% above"]].forEach(function (l) {
+  var s = el("span"); s.appendChild(el("span", "swatch " + l[0])); s.appendChild(document.createTextNode(l[1])); legend.appendChild(s);
+});
+var sg = el("span"); sg.appendChild(el("span", "swatch gap short")); sg.appendChild(document.createTextNode("gap below the minimum")); legend.appendChild(sg);
+var so = el("span"); so.appendChild(el("span", "swatch gap overlap")); so.appendChild(document.createTextNode("overlap")); legend.appendChild(so);
+
+// Timeline
+var timeline = document.getElementById("timeline"), track = document.getElementById("track");
+var span = 1, items = [];
+cues.forEach(function (c) { if (c.end > span) span = c.end; });
+
+cues.forEach(function (c, i) {
+  var d = el("div", "cue row" + (i % 2) + " " + speedClass(c.cps) + (violationsById[c.id] ? " bad" : ""), "#" + c.id);
+  d.id = "cue-" + c.id;
+  d.title = "#" + c.id + " " + fmt(c.start) + " --> " + fmt(c.end) + " (" + c.cps.toFixed(1) + " ch/s)\n" + c.lines.join("\n");
+  d.onclick = function () { select(c.id, false); };
+  track.appendChild(d);
+  items.push({ e: d, start: c.start, end: c.end });
+
+  var next = cues[i + 1];
+  if (!next) return;
+  var gap = next.start - c.end;
+  if (gap < 0) {
+    var o = el("div", "gap overlap");
+    o.title = "Overlap of " + (-gap).toFixed(3) + "s between #" + c.id + " & #" + next.id;
+    track.appendChild(o);
+    items.push({ e: o, start: next.start, end: Math.min(c.end, next.end) });
+  } else if (DATA.minGap && gap < DATA.minGap) {
+    var g = el("div", "gap short");
+    g.title = "Gap of " + gap.toFixed(3) + "s between #" + c.id + " & #" + next.id;
+    track.appendChild(g);
+    items.push({ e: g, start: c.end, end: next.start, min: 2 });
+  }
+});
+
+var scale = 0, rulers = [];
+
+function layout() {
+  items.forEach(function (it) {
+    it.e.style.left = (it.start * scale) + "px";
+    it.e.style.width = Math.max(it.min || 1, (it.end - it.start) * scale) + "px";
+  });
+  track.style.width = Math.ceil(span * scale) + "px";
+
+  rulers.forEach(function (r) { track.removeChild(r); });
+  rulers = [];
+  var steps = [0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600], step = steps[steps.length - 1];
+  for (var k = 0; k < steps.length; k++) { if (steps[k] * scale >= 90) { step = steps[k]; break; } }
+  for (var t = 0; t <= span; t += step) {
+    var r = el("div", "ruler", step < 1 ? fmt(t) : fmt(t).slice(0, 8));
+    r.style.left = (t * scale) + "px";
+    track.appendChild(r);
+    rulers.push(r);
+  }
+}
+
+function zoom(factor, x) {
+  if (x === undefined) x = timeline.clientWidth / 2;
+  var at = (timeline.scrollLeft + x) / scale;
+  scale = Math.min(2000, Math.max(timeline.clientWidth / span / 2, scale * factor));
+  layout();
+  timeline.scrollLeft = at * scale - x;
+}
+
+function fit() {
+  scale = Math.max(0.01, (timeline.clientWidth - 4) / span);
+  layout();
+}
+
+document.getElementById("zoomIn").onclick = function () { zoom(2); };
+document.getElementById("zoomOut").onclick = function () { zoom(0.5); };
+document.getElementById("zoomFit").onclick = fit;
+timeline.addEventListener("wheel", function (ev) {
+  if (!ev.ctrlKey && !ev.metaKey) return;
+  ev.preventDefault();
+  zoom(ev.deltaY < 0 ? 1.25 : 0.8, ev.clientX - timeline.getBoundingClientRect().left);
+}, { passive: false });
+
+// Violations
+var rows = {};
+var vt = document.getElementById("violations");
+document.getElementById("violationsTitle").textContent = "Violations (" + DATA.violations.length + ")";
+if (DATA.fixed) {
+  vt.appendChild(el("p", "muted", "Left after the fix, checked with the default settings of check."));
+}
+if (DATA.violations.length === 0) {
+  vt.appendChild(el("p", "muted", "Every subtitle passed the perfection check."));
+} else {
+  var table = el("table"), head = el("tr");
+  ["Subtitle", "Time", "Rule", "Message", "Value", "Limit"].forEach(function (h) { head.appendChild(el("th", "", h)); });
+  table.appendChild(head);
+  DATA.violations.forEach(function (v) {
+    var c = byId[v.id], tr = el("tr"), td = el("td"), a = el("a", "", "#" + v.id);
+    a.href = "#cue-" + v.id;
+    a.onclick = function (ev) { ev.preventDefault(); select(v.id, true); };
+    td.appendChild(a);
+    tr.appendChild(td);
+    tr.appendChild(el("td", "", c ? fmt(c.start) : ""));
+    tr.appendChild(el("td", "", v.rule));
+    tr.appendChild(el("td", "", v.message + (v.line ? " (line " + v.line + ")" : "")));
+    tr.appendChild(el("td", "", v.value === undefined ? "" : String(v.value)));
+    tr.appendChild(el("td", "", v.limit === undefined ? "" : String(v.limit)));
+    table.appendChild(tr);
+    (rows[v.id] = rows[v.id] || []).push(tr);
+  });
+  vt.appendChild(table);
+}
+
+var selected = null;
+
+function select(id, scroll) {
+  if (selected) {
+    var old = document.getElementById("cue-" + selected);
+    if (old) old.classList.remove("selected");
+    (rows[selected] || []).forEach(function (tr) { tr.classList.remove("selected"); });
+  }
+  selected = id;
+
+  var c = byId[id], d = document.getElementById("cue-" + id);
+  if (!c || !d) return;
+  d.classList.add("selected");
+  (rows[id] || []).forEach(function (tr) { tr.classList.add("selected"); });
+  if (scroll) {
+    timeline.scrollLeft = c.start * scale - timeline.clientWidth / 2;
+    timeline.scrollIntoView({ block: "nearest" });
+  }
+
+  var text = "#" + c.id + "  " + fmt(c.start) + " --> " + fmt(c.end) + "  " + (c.end - c.start).toFixed(3) + "s, " + c.cps.toFixed(1) + " ch/s\n" + c.lines.join("\n");
+  (violationsById[id] || []).forEach(function (v) { text += "\n• " + v.message; });
+  var details = document.getElementById("details");
+  details.className = "";
+  details.textContent = text;
+}
+
+// Changes
+var cs = document.getElementById("changes");
+if (!DATA.fixed) {
+  document.getElementById("changesSection").style.display = "none";
+} else {
+  document.getElementById("changesTitle").textContent = "Changes (" + DATA.changes.length + ")";
+  if (DATA.changes.length === 0) cs.appendChild(el("p", "muted", "Nothing was changed."));
+}
+DATA.changes.forEach(function (ch) {
+  var all = ch.before.concat(ch.after);
+  if (all.length === 0) return;
+  var from = Math.min.apply(null, all.map(function (c) { return c.start; }));
+  var to = Math.max.apply(null, all.map(function (c) { return c.end; }));
+  var len = Math.max(to - from, 0.001);
+
+  var row = el("div", "change"), label = el("div");
+  label.appendChild(el("div", "", ch.label));
+  label.appendChild(el("div", "muted", fmt(from) + " - " + fmt(to)));
+  row.appendChild(label);
+
+  var bars = el("div", "bars");
+  [["before", ch.before], ["after", ch.after]].forEach(function (side) {
+    side[1].forEach(function (c) {
+      var b = el("div", "bar " + side[0], "#" + c.id + " " + c.lines.join(" "));
+      b.style.left = ((c.start - from) / len * 100) + "%";
+      b.style.width = Math.max(0.5, (c.end - c.start) / len * 100) + "%";
+      b.title = side[0] + ": #" + c.id + " " + fmt(c.start) + " --> " + fmt(c.end) + "\n" + c.lines.join("\n");
+      if (side[0] === "after") b.onclick = function () { select(c.id, true); };
+      bars.appendChild(b);
+    });
+  });
+  row.appendChild(bars);
+  cs.appendChild(row);
+});
+
+fit();
+</script>
+</body>
+</html>
+`)))
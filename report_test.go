@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// longSRT has a line too long for the default -chars_per_line, which
+// fix leaves as it is
+const longSRT = `1
+00:00:01,000 --> 00:00:01,200
+Short
+
+2
+00:00:10,000 --> 00:00:16,000
+A line of subtitle far longer than the characters allowed on one line
+`
+
+func TestValidateHTMLReport(t *testing.T) {
+	for _, c := range []struct {
+		args  []string
+		fails bool
+	}{
+		{args: []string{"-file", "ep01.srt"}},
+		{args: []string{"-file", "ep01.srt", "-report_html", "r.html"}},
+		{args: []string{"-file", "ep01.srt", "-report_html", "r.html", "-stream"}, fails: true},
+		{args: []string{"-file", "ep01.srt", "-report_html", "r.html", "-git_diff"}, fails: true},
+	} {
+		err := validateHTMLReport(commandParams(t, "check", c.args...))
+		if (err != nil) != c.fails {
+			t.Errorf("validateHTMLReport(%v) = %v, expected failure %t", c.args, err, c.fails)
+		}
+	}
+}
+
+func TestHTMLReportPath(t *testing.T) {
+	for _, c := range []struct {
+		report, file, expected string
+	}{
+		{"report.html", "ep01.srt", "report.html"},
+		{"reports/{name}.html", "season1/ep01.en.srt", filepath.Join("reports", "ep01.en.html")},
+		{"reports/{base}.{ext}.html", "season1/ep01.en.srt", filepath.Join("reports", "ep01.srt.html")},
+		{"{name}.html", "-", "stdin.html"},
+	} {
+		params := commandParams(t, "check", "-report_html", c.report)
+		if got := htmlReportPath(c.file, params); got != c.expected {
+			t.Errorf("htmlReportPath(%q, %q) = %q, expected %q", c.file, c.report, got, c.expected)
+		}
+	}
+}
+
+func TestCheckHTMLReport(t *testing.T) {
+	files := []string{"season1/ep01.srt", "season1/ep02.srt"}
+
+	for _, c := range []struct {
+		report string
+		files  []string
+		fails  bool
+	}{
+		{"", files, false},
+		{"report.html", files[:1], false},
+		{"report.html", files, true},
+		{"reports/{name}.html", files, false},
+	} {
+		err := checkHTMLReport(c.files, commandParams(t, "check", "-report_html", c.report))
+		if (err != nil) != c.fails {
+			t.Errorf("checkHTMLReport(%v, %q) = %v, expected failure %t", c.files, c.report, err, c.fails)
+		}
+	}
+}
+
+func TestNewHTMLReport(t *testing.T) {
+	captureLog(t, LogFormatText)
+
+	// check reports violations with its own settings
+	s := readTestSubtitles(t, longSRT)
+	r := newHTMLReport(s, commandParams(t, "check", "-chars_per_line", "80"), nil, logger)
+	if r.Fixed || len(r.Changes) != 0 || hasRule(r.Violations, "chars_per_line") {
+		t.Errorf("check report: fixed %t, %d changes, violations %+v", r.Fixed, len(r.Changes), r.Violations)
+	}
+
+	// fix reports its changes & the violations left, with the defaults
+	// of check
+	s = readTestSubtitles(t, longSRT)
+	before := snapshot(s)
+	params := commandParams(t, "fix")
+	fixSubtitles(s, params, logger)
+
+	r = newHTMLReport(s, params, before, logger)
+	if !r.Fixed || len(r.Changes) == 0 {
+		t.Errorf("fix report: fixed %t, changes %+v", r.Fixed, r.Changes)
+	}
+	if !hasRule(r.Violations, "chars_per_line") {
+		t.Errorf("fix report: expected a chars_per_line violation, got %+v", r.Violations)
+	}
+	if hasRule(r.Violations, "min_length") {
+		t.Errorf("fix report: min_length is not checked by default, got %+v", r.Violations)
+	}
+	if r.ReadingSpeed != DefaultReadingSpeed || len(r.Cues) != len(s.Items) {
+		t.Errorf("fix report: reading speed %g & %d cues, expected %g & %d", r.ReadingSpeed, len(r.Cues), DefaultReadingSpeed, len(s.Items))
+	}
+}
+
+func TestWriteHTMLReport(t *testing.T) {
+	captureLog(t, LogFormatText)
+	path := filepath.Join(t.TempDir(), "reports", "ep01.html")
+
+	s := readTestSubtitles(t, longSRT)
+	if err := writeHTMLReport(path, s, commandParams(t, "check", "-file", "<ep01>.srt"), nil, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The data is embedded, escaped, & nothing is loaded from elsewhere
+	page := string(data)
+	for _, expected := range []string{"<!DOCTYPE html>", `"chars_per_line"`, "&lt;ep01&gt;.srt"} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("report is missing %s", expected)
+		}
+	}
+	for _, unexpected := range []string{"<ep01>", "http://", "https://"} {
+		if strings.Contains(page, unexpected) {
+			t.Errorf("report holds %s", unexpected)
+		}
+	}
+}
@@ -0,0 +1,524 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+	"github.com/chetan-prime/subfixer/fixer"
+)
+
+// Below are the defaults of the serve command
+const (
+	DefaultServeAddr    = "127.0.0.1:8080"
+	DefaultServeMaxBody = 10 << 20
+	DefaultServeTimeout = 30 * time.Second
+)
+
+// serveRequest is the JSON body of the fix & check endpoints. Params
+// takes settings by flag name, as profiles do, over those of Preset
+// & Profile, which are over those the server was started with
+type serveRequest struct {
+	Subtitles string                 `json:"subtitles"`
+	Profile   string                 `json:"profile"`
+	Preset    string                 `json:"preset"`
+	Params    map[string]interface{} `json:"params"`
+}
+
+// serveCue is a subtitle in the change list of a fix
+type serveCue struct {
+	Id    int      `json:"id"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+	Lines []string `json:"lines"`
+}
+
+// serveChange is a change made by a fix, with the subtitles it
+// replaced & those it resulted in
+type serveChange struct {
+	Kind        string     `json:"kind"`
+	Description string     `json:"description"`
+	Before      []serveCue `json:"before"`
+	After       []serveCue `json:"after"`
+}
+
+// fixResponse is returned by the fix endpoint
+type fixResponse struct {
+	Subtitles string        `json:"subtitles"`
+	Summary   string        `json:"summary"`
+	Changes   []serveChange `json:"changes"`
+}
+
+// checkResponse is returned by the check endpoint
+type checkResponse struct {
+	Passed     bool                `json:"passed"`
+	Subtitles  int                 `json:"subtitles"`
+	Violations []astisub.Violation `json:"violations"`
+}
+
+// server answers the HTTP API. Each request starts from the settings
+// the server was started with, kept by flag name in base
+type server struct {
+	params   cliParams
+	cmd      *command
+	base     map[string]string
+	configs  []*configFile
+	requests int64
+}
+
+// requestFlags registers the settings a request to fix subtitles can
+// change
+func requestFlags(fs *flag.FlagSet, p *cliParams) {
+	settingFlags(fs, p)
+	minLengthFlag(fs, p, DefaultMinLength)
+}
+
+// checkRequestFlags registers the settings a request to check subtitles
+// can change, -min_length being off by default as it is for check
+func checkRequestFlags(fs *flag.FlagSet, p *cliParams) {
+	settingFlags(fs, p)
+	minLengthFlag(fs, p, 0)
+}
+
+// settingFlags registers the settings of requests but -min_length
+func settingFlags(fs *flag.FlagSet, p *cliParams) {
+	limitFlags(fs, p)
+	timingFlags(fs, p)
+	checkFlags(fs, p)
+	countingFlags(fs, p)
+}
+
+// serveFlags registers the flags of the HTTP server
+func serveFlags(fs *flag.FlagSet, p *cliParams) {
+	fs.StringVar(&p.Addr, "addr", DefaultServeAddr, "Address to listen on, host:port")
+	fs.Int64Var(&p.MaxBody, "max_body", DefaultServeMaxBody, "Largest request accepted, in bytes")
+	fs.DurationVar(&p.Timeout, "timeout", DefaultServeTimeout, "Longest time a request may take")
+}
+
+// validateServe checks the limits of the server
+func validateServe(p cliParams) error {
+	if p.MaxBody <= 0 {
+		return errors.New("-max_body must be more than 0")
+	}
+	if p.Timeout <= 0 {
+		return errors.New("-timeout must be more than 0")
+	}
+	return nil
+}
+
+// newServer creates the server for the settings of fs, as parsed by
+// the serve command
+func newServer(params cliParams, fs *flag.FlagSet) (*server, error) {
+	configs, err := loadConfigs(params.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	sv := &server{
+		params:  params,
+		cmd:     findCommand("serve"),
+		base:    requestBase(fs),
+		configs: configs,
+	}
+
+	return sv, nil
+}
+
+// requestBase returns the value in fs of each setting a request can
+// change which was set, by a flag, a profile or a preset. Requests
+// start from these, the others keeping the defaults of the request, as
+// fix & check differ on some
+func requestBase(fs *flag.FlagSet) map[string]string {
+	base := make(map[string]string)
+
+	var p cliParams
+	rfs := flag.NewFlagSet("request", flag.ContinueOnError)
+	requestFlags(rfs, &p)
+	fs.Visit(func(f *flag.Flag) {
+		if rfs.Lookup(f.Name) != nil {
+			base[f.Name] = f.Value.String()
+		}
+	})
+
+	return base
+}
+
+// handler returns the API, which can be served by net/http or
+// called directly with httptest:
+//
+//	GET  /healthz    {"status":"ok"}
+//	POST /v1/fix     the fixed subtitles & the list of changes
+//	POST /v1/check   the violations of the perfection check
+//
+// POST bodies are a serveRequest, or the subtitles themselves with
+// the settings in the query string (?preset=...&chars_per_line=37)
+func (sv *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", sv.healthz)
+	mux.HandleFunc("/v1/fix", sv.post(requestFlags, sv.fix))
+	mux.HandleFunc("/v1/check", sv.post(checkRequestFlags, sv.check))
+
+	timeout := http.TimeoutHandler(mux, sv.params.Timeout, `{"error":"request timed out"}`)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		timeout.ServeHTTP(rec, r)
+		logger.Infof("%s %s %d %s", r.Method, r.URL.Path, rec.status, time.Since(start).Round(time.Microsecond))
+	})
+}
+
+// statusRecorder keeps the status of a response for the log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// respond writes v as the JSON body of the response
+func respond(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// respondError writes an error message as the JSON body of the response
+func respondError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	respond(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// healthz tells load balancers & scripts the server is up
+func (sv *server) healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		respondError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// requestOperation processes the subtitles of a request with its
+// settings and writes the response. It stops once ctx, the context of
+// the request, is done, as when the client went away or timed out
+type requestOperation func(ctx context.Context, w http.ResponseWriter, s *astisub.Subtitles, params cliParams, log *leveledLogger)
+
+// post reads the subtitles & settings of a POST request, limited to
+// -max_body, and passes them on to op
+func (sv *server) post(flags func(fs *flag.FlagSet, p *cliParams), op requestOperation) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			respondError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+
+		// One byte more than allowed is enough to tell the body is too large
+		body, err := io.ReadAll(io.LimitReader(r.Body, sv.params.MaxBody+1))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "cannot read request: %s", err)
+			return
+		}
+		if int64(len(body)) > sv.params.MaxBody {
+			// The rest of the body is not read, so the connection cannot
+			// be used for another request
+			w.Header().Set("Connection", "close")
+			respondError(w, http.StatusRequestEntityTooLarge, "request is larger than %d bytes", sv.params.MaxBody)
+			return
+		}
+
+		req, err := parseRequest(r, body)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+
+		params, err := sv.requestParams(req, flags)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+
+		s, err := astisub.ReadFrom(strings.NewReader(req.Subtitles))
+		if err == nil && len(s.Items) == 0 {
+			err = errors.New("no subtitles found")
+		}
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, "cannot read subtitles: %s", err)
+			return
+		}
+
+		log := logger.With("request", atomic.AddInt64(&sv.requests, 1))
+		fixer.Mark(s, fixer.ParseLimits(params.LimitTo), log)
+
+		op(r.Context(), w, s, params, log)
+	}
+}
+
+// parseRequest reads a serveRequest from a JSON body, or from a body
+// holding the subtitles with the settings in the query string
+func parseRequest(r *http.Request, body []byte) (*serveRequest, error) {
+	req := &serveRequest{}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(req); err != nil {
+			return nil, fmt.Errorf("invalid JSON request: %s", err)
+		}
+		return req, nil
+	}
+
+	req.Subtitles = string(body)
+	req.Params = make(map[string]interface{})
+	for key, values := range r.URL.Query() {
+		value := values[len(values)-1]
+		switch key {
+		case "profile":
+			req.Profile = value
+		case "preset":
+			req.Preset = value
+		default:
+			req.Params[key] = value
+		}
+	}
+
+	return req, nil
+}
+
+// requestParams layers the settings of req over those of the server:
+// the preset first, then the profile, then the params. The settings are
+// those registered by flags, with its defaults
+func (sv *server) requestParams(req *serveRequest, flags func(fs *flag.FlagSet, p *cliParams)) (cliParams, error) {
+	return requestSettings(sv.params, sv.cmd, flags, sv.base, sv.configs, req.Profile, req.Preset, req.Params)
+}
+
+// requestSettings layers a preset, a profile & values by flag name over
+// params, which the settings registered by flags are reset to first,
+// with the values in base over their defaults. Only those settings can
+// be changed
+func requestSettings(params cliParams, cmd *command, flags func(fs *flag.FlagSet, p *cliParams), base map[string]string,
+	configs []*configFile, profile string, preset string, values map[string]interface{}) (cliParams, error) {
+	fs := flag.NewFlagSet("request", flag.ContinueOnError)
+	fs.SetOutput(discard{})
+	flags(fs, &params)
+
+	for name, value := range base {
+		if err := fs.Set(name, value); err != nil {
+			return params, err
+		}
+	}
+
+	var profiles []map[string]interface{}
+	if profile != "" {
+		for _, c := range configs {
+			if pv, ok := c.Profiles[profile]; ok {
+				profiles = append(profiles, pv)
+			}
+		}
+		if len(profiles) == 0 {
+			return params, fmt.Errorf("profile '%s' not found in any config file", profile)
+		}
+	}
+
+	// A preset given with the profile wins over the one it builds upon
+	if preset == "" {
+		for _, pv := range profiles {
+			if v, ok := pv["preset"]; ok {
+				var err error
+				if preset, err = configValue(v); err != nil {
+					return params, fmt.Errorf("profile '%s': invalid value for 'preset': %s", profile, err)
+				}
+			}
+		}
+	}
+
+	if preset != "" {
+		if err := applyPreset(fs, cmd, make(map[string]string), preset); err != nil {
+			return params, err
+		}
+	}
+
+	for _, pv := range profiles {
+		if err := setValues(fs, pv, "profile '"+profile+"'"); err != nil {
+			return params, err
+		}
+	}
+
+	if err := setValues(fs, values, "params"); err != nil {
+		return params, err
+	}
+
+	return params, nil
+}
+
+// setValues sets the flags of fs named by the keys of values
+func setValues(fs *flag.FlagSet, values map[string]interface{}, from string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if key == "preset" {
+			continue
+		}
+
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("%s: unknown setting '%s'", from, key)
+		}
+
+		value, err := configValue(values[key])
+		if err == nil {
+			err = fs.Set(key, value)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: invalid value for '%s': %s", from, key, err)
+		}
+	}
+
+	return nil
+}
+
+// fix fixes the subtitles and returns them with the changes made
+func (sv *server) fix(ctx context.Context, w http.ResponseWriter, s *astisub.Subtitles, params cliParams, log *leveledLogger) {
+	before := snapshot(s)
+	if err := fixer.Passes(ctx, s, fixOptions(params, log)); err != nil {
+		respondError(w, http.StatusServiceUnavailable, "fix stopped: %s", err)
+		return
+	}
+	after := snapshot(s)
+
+	var out bytes.Buffer
+	if err := s.WriteToSRT(&out); err != nil {
+		respondError(w, http.StatusInternalServerError, "cannot write subtitles: %s", err)
+		return
+	}
+
+	resp := fixResponse{Subtitles: out.String(), Changes: make([]serveChange, 0)}
+
+	counts := make(map[string]int)
+	for _, e := range alignCues(before, after) {
+		if !e.Changed() {
+			continue
+		}
+
+		counts[e.Kind]++
+		resp.Changes = append(resp.Changes, serveChange{
+			Kind:        e.Kind,
+			Description: e.String(),
+			Before:      serveCues(before, e.Before),
+			After:       serveCues(after, e.After),
+		})
+	}
+	resp.Summary = formatCounts(counts)
+
+	respond(w, http.StatusOK, resp)
+}
+
+// serveCues returns the cues within r, numbered from 1
+func serveCues(cues []cue, r [2]int) []serveCue {
+	list := make([]serveCue, 0, r[1]-r[0])
+	for n := r[0]; n < r[1]; n++ {
+		list = append(list, serveCue{
+			Id:    n + 1,
+			Start: astisub.FormatDurationSRT(cues[n].Start),
+			End:   astisub.FormatDurationSRT(cues[n].End),
+			Lines: cues[n].Lines,
+		})
+	}
+
+	return list
+}
+
+// check runs the perfection check and returns every violation found
+func (sv *server) check(ctx context.Context, w http.ResponseWriter, s *astisub.Subtitles, params cliParams, log *leveledLogger) {
+	resp := checkResponse{Subtitles: len(s.Items), Violations: make([]astisub.Violation, 0)}
+
+	for i := 0; i < len(s.Items); i++ {
+		if err := ctx.Err(); err != nil {
+			respondError(w, http.StatusServiceUnavailable, "check stopped: %s", err)
+			return
+		}
+		if s.Items[i].Process {
+			resp.Violations = append(resp.Violations, s.PerfectionViolations(i, params.CommandParams, log)...)
+		}
+	}
+	resp.Passed = len(resp.Violations) == 0
+
+	respond(w, http.StatusOK, resp)
+}
+
+// ServeOperation runs the HTTP API on -addr until interrupted
+func ServeOperation(inv *invocation) int {
+	sv, err := newServer(inv.Params, inv.Flags)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return ExitError
+	}
+
+	ln, err := net.Listen("tcp", inv.Params.Addr)
+	if err != nil {
+		logger.Errorf("Cannot listen on %s: %s", inv.Params.Addr, err)
+		return ExitError
+	}
+
+	srv := &http.Server{
+		Handler:           sv.handler(),
+		ReadHeaderTimeout: inv.Params.Timeout,
+		ReadTimeout:       inv.Params.Timeout,
+		WriteTimeout:      inv.Params.Timeout + time.Second,
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(ln)
+	}()
+
+	logger.Infof("Serving the API on http://%s", ln.Addr())
+
+	select {
+	case err := <-done:
+		logger.Errorf("Server stopped: %s", err)
+		return ExitError
+	case sig := <-stop:
+		logger.Infof("Shutting down on %s", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), inv.Params.Timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Errorf("Cannot shut down cleanly: %s", err)
+		return ExitError
+	}
+
+	return 0
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// newTestServer returns the server the serve command starts for args
+func newTestServer(t *testing.T, args ...string) *server {
+	captureLog(t, LogFormatText)
+
+	var params cliParams
+	fs := newFlagSet(findCommand("serve"), &params)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	sv, err := newServer(params, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sv
+}
+
+// serveTest sends a request to the API of sv & decodes the JSON response
+// into v, returning the status
+func serveTest(t *testing.T, sv *server, method string, target string, contentType string, body string, v interface{}) int {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	rec := httptest.NewRecorder()
+	sv.handler().ServeHTTP(rec, req)
+
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: invalid JSON response %q: %s", method, target, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+// generateSRT returns n subtitles of 2s, one every 3s
+func generateSRT(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		start := time.Duration(i) * 3 * time.Second
+		fmt.Fprintf(&b, "%d\n%s --> %s\nSubtitle number %d\n\n", i+1,
+			astisub.FormatDurationSRT(start), astisub.FormatDurationSRT(start+2*time.Second), i+1)
+	}
+	return b.String()
+}
+
+// shortSRT has a subtitle too short to be read, which fix lengthens
+const shortSRT = `1
+00:00:01,000 --> 00:00:01,200
+This one is far too short to read
+
+2
+00:00:10,000 --> 00:00:12,000
+Fine
+`
+
+// briefSRT has a subtitle shorter than fix makes them, but long enough
+// to be read
+const briefSRT = `1
+00:00:01,000 --> 00:00:01,500
+Hi
+`
+
+func TestServeHealthz(t *testing.T) {
+	sv := newTestServer(t)
+
+	var resp map[string]string
+	if code := serveTest(t, sv, http.MethodGet, "/healthz", "", "", &resp); code != http.StatusOK || resp["status"] != "ok" {
+		t.Errorf("GET /healthz = %d %v, expected 200 ok", code, resp)
+	}
+	if code := serveTest(t, sv, http.MethodPost, "/healthz", "", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /healthz = %d, expected 405", code)
+	}
+}
+
+func TestServeFix(t *testing.T) {
+	sv := newTestServer(t)
+
+	for _, c := range []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+	}{
+		{"raw body", "/v1/fix", "application/x-subrip", shortSRT},
+		{"JSON body", "/v1/fix", "application/json", `{"subtitles":` + jsonString(shortSRT) + `,"params":{"min_length":1}}`},
+	} {
+		var resp fixResponse
+		if code := serveTest(t, sv, http.MethodPost, c.target, c.contentType, c.body, &resp); code != http.StatusOK {
+			t.Errorf("%s: status %d, expected 200", c.name, code)
+			continue
+		}
+
+		if len(resp.Changes) == 0 || resp.Summary == "" {
+			t.Errorf("%s: expected the short subtitle to be changed, got %+v", c.name, resp)
+		}
+
+		s, err := astisub.ReadFrom(strings.NewReader(resp.Subtitles))
+		if err != nil {
+			t.Fatalf("%s: invalid subtitles returned: %s", c.name, err)
+		}
+		if s.Items[0].GetLength() < 1 {
+			t.Errorf("%s: first subtitle lasts %gs, expected at least 1s", c.name, s.Items[0].GetLength())
+		}
+	}
+
+	if code := serveTest(t, sv, http.MethodGet, "/v1/fix", "", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /v1/fix = %d, expected 405", code)
+	}
+}
+
+func TestServeCheck(t *testing.T) {
+	sv := newTestServer(t)
+
+	for _, c := range []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		status      int
+		passed      bool
+		rule        string
+	}{
+		{"passes", "/v1/check", "text/plain", testSRT, http.StatusOK, true, ""},
+		{"setting in query", "/v1/check?chars_per_line=5", "text/plain", testSRT, http.StatusOK, false, "chars_per_line"},
+		{"setting in JSON", "/v1/check", "application/json", `{"subtitles":` + jsonString(testSRT) + `,"params":{"chars_per_line":5}}`, http.StatusOK, false, "chars_per_line"},
+		{"preset", "/v1/check?preset=bbc:en-GB:adult", "text/plain", shortSRT, http.StatusOK, false, "reading_speed"},
+		// -min_length is off by default, as for the check command
+		{"brief", "/v1/check", "text/plain", briefSRT, http.StatusOK, true, ""},
+		{"brief with min_length", "/v1/check?min_length=1", "text/plain", briefSRT, http.StatusOK, false, "min_length"},
+		{"unknown setting", "/v1/check?speling=1", "text/plain", testSRT, http.StatusBadRequest, false, ""},
+		{"unknown preset", "/v1/check?preset=nope", "text/plain", testSRT, http.StatusBadRequest, false, ""},
+		{"invalid JSON", "/v1/check", "application/json", `{"subtitles":`, http.StatusBadRequest, false, ""},
+		{"no subtitles", "/v1/check", "text/plain", "hello", http.StatusUnprocessableEntity, false, ""},
+	} {
+		var resp checkResponse
+		code := serveTest(t, sv, http.MethodPost, c.target, c.contentType, c.body, &resp)
+		if code != c.status {
+			t.Errorf("%s: status %d, expected %d", c.name, code, c.status)
+			continue
+		}
+		if code != http.StatusOK {
+			continue
+		}
+
+		if resp.Passed != c.passed {
+			t.Errorf("%s: passed is %t, expected %t: %+v", c.name, resp.Passed, c.passed, resp.Violations)
+		}
+		if c.rule != "" && !hasRule(resp.Violations, c.rule) {
+			t.Errorf("%s: expected a %s violation, got %+v", c.name, c.rule, resp.Violations)
+		}
+	}
+}
+
+func TestServeMinLength(t *testing.T) {
+	for _, c := range []struct {
+		args   []string
+		passed bool
+	}{
+		{nil, true},
+		{[]string{"-min_length", "1"}, false},
+	} {
+		sv := newTestServer(t, c.args...)
+
+		var resp checkResponse
+		if code := serveTest(t, sv, http.MethodPost, "/v1/check", "text/plain", briefSRT, &resp); code != http.StatusOK {
+			t.Fatalf("serve %v: status %d, expected 200", c.args, code)
+		}
+		if resp.Passed != c.passed {
+			t.Errorf("serve %v: passed is %t, expected %t: %+v", c.args, resp.Passed, c.passed, resp.Violations)
+		}
+
+		// Fix lengthens the subtitle either way
+		var fixed fixResponse
+		if code := serveTest(t, sv, http.MethodPost, "/v1/fix", "text/plain", briefSRT, &fixed); code != http.StatusOK || len(fixed.Changes) == 0 {
+			t.Errorf("serve %v: fix status %d with changes %+v", c.args, code, fixed.Changes)
+		}
+	}
+}
+
+func TestServeTooLarge(t *testing.T) {
+	sv := newTestServer(t, "-max_body", "100")
+
+	var resp map[string]string
+	if code := serveTest(t, sv, http.MethodPost, "/v1/check", "text/plain", generateSRT(10), &resp); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %d, expected 413: %v", code, resp)
+	}
+
+	// A body of exactly the limit is accepted
+	body := testSRT + strings.Repeat("\n", 100-len(testSRT))
+	if code := serveTest(t, sv, http.MethodPost, "/v1/check", "text/plain", body, nil); code != http.StatusOK {
+		t.Errorf("status %d for a body of the largest size, expected 200", code)
+	}
+}
+
+func TestServeTimeout(t *testing.T) {
+	sv := newTestServer(t, "-timeout", "1ms")
+
+	var resp map[string]string
+	if code := serveTest(t, sv, http.MethodPost, "/v1/fix", "text/plain", generateSRT(50000), &resp); code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, expected 503: %v", code, resp)
+	}
+	if resp["error"] == "" {
+		t.Errorf("expected an error message, got %v", resp)
+	}
+}
+
+func TestServeStopsWithRequest(t *testing.T) {
+	sv := newTestServer(t)
+
+	// The operations get the context of the request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var got error
+	h := sv.post(requestFlags, func(ctx context.Context, w http.ResponseWriter, s *astisub.Subtitles, params cliParams, log *leveledLogger) {
+		got = ctx.Err()
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/fix", strings.NewReader(testSRT)).WithContext(ctx)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != context.Canceled {
+		t.Errorf("operation got context error %v, expected %v", got, context.Canceled)
+	}
+
+	// And stop working once it is done
+	for name, op := range map[string]requestOperation{"fix": sv.fix, "check": sv.check} {
+		s, err := astisub.ReadFrom(strings.NewReader(shortSRT))
+		if err != nil {
+			t.Fatal(err)
+		}
+		params, err := sv.requestParams(&serveRequest{}, requestFlags)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		op(ctx, rec, s, params, logger)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: status %d once the request is done, expected 503", name, rec.Code)
+		}
+	}
+}
+
+// jsonString returns s as a JSON string
+func jsonString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// hasRule checks if one of violations is about rule
+func hasRule(violations []astisub.Violation, rule string) bool {
+	for _, v := range violations {
+		if v.Rule == rule {
+			return true
+		}
+	}
+	return false
+}
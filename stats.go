@@ -0,0 +1,111 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// summary keeps the minimum, maximum and total of a series of values
+type summary struct {
+	count int
+	min   float64
+	max   float64
+	total float64
+}
+
+// add adds v to the summary
+func (s *summary) add(v float64) {
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	s.total += v
+}
+
+// String formats the summary as min / avg / max
+func (s summary) String() string {
+	if s.count == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("min %.3f / avg %.3f / max %.3f", s.min, s.total/float64(s.count), s.max)
+}
+
+// StatsOperation prints statistics on the subtitles marked for processing.
+// Nothing is changed or written
+func StatsOperation(s *astisub.Subtitles, params cliParams, log *leveledLogger) int {
+	var durations, speeds, gaps summary
+	var lines, chars summary
+	var count, tooFast, overlaps int
+	var first, last time.Duration = -1, 0
+
+	for i, item := range s.Items {
+		if !item.Process {
+			continue
+		}
+		count++
+
+		if first < 0 || item.StartAt < first {
+			first = item.StartAt
+		}
+		if item.EndAt > last {
+			last = item.EndAt
+		}
+
+		length := item.GetLength()
+		durations.add(length)
+
+		if length > 0 {
+			speed := float64(item.GetRuneCount(params.CommandParams)) / length
+			speeds.add(speed)
+			if params.ReadingSpeed > 0 && speed > params.ReadingSpeed {
+				tooFast++
+			}
+		}
+
+		lines.add(float64(len(item.Lines)))
+		for _, l := range item.Lines {
+			chars.add(float64(utf8.RuneCountInString(l.String())))
+		}
+
+		if i+1 < len(s.Items) {
+			gap := float64(s.Items[i+1].StartAt-item.EndAt) / float64(time.Second)
+			if gap < 0 {
+				overlaps++
+			} else {
+				gaps.add(gap)
+			}
+		}
+	}
+
+	fmt.Printf("File:             %s\n", params.File)
+	fmt.Printf("Subtitles:        %d\n", count)
+	if count == 0 {
+		return 0
+	}
+
+	fmt.Printf("Span:             %s --> %s\n", first, last)
+	fmt.Printf("Duration (s):     %s\n", durations)
+	fmt.Printf("Speed (ch/s):     %s\n", speeds)
+	fmt.Printf("Above %g ch/s:    %d (%.1f%%)\n", params.ReadingSpeed, tooFast, 100*float64(tooFast)/float64(count))
+	fmt.Printf("Lines:            %s\n", lines)
+	fmt.Printf("Chars/line:       %s\n", chars)
+	fmt.Printf("Gaps (s):         %s\n", gaps)
+	fmt.Printf("Overlaps:         %d\n", overlaps)
+
+	if math.IsNaN(speeds.total) {
+		log.Warnf("some subtitles have an invalid duration")
+	}
+
+	return 0
+}
@@ -0,0 +1,285 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chetan-prime/subfixer/astisub"
+	"github.com/chetan-prime/subfixer/fixer"
+)
+
+// streamLookahead is the number of subtitles kept on either side of the
+// one being processed. ShiftUnfit may move subtitles up to SUB_LEVELS
+// away, so anything further back than this can be written out safely
+const streamLookahead = astisub.SUB_LEVELS + 1
+
+// streamWindow is a sliding window of subtitles over a decoder.
+// Subtitles are read in as they are needed and written out as soon as
+// no further processing can change them, so memory stays flat
+type streamWindow struct {
+	s       *astisub.Subtitles
+	dec     *astisub.SRTDecoder
+	enc     *astisub.SRTEncoder
+	limits  fixer.Limits
+	log     *leveledLogger
+	read    int
+	flushed int
+	eof     bool
+}
+
+// streamReadError is an error decoding the input, told apart from one
+// writing the output
+type streamReadError struct {
+	err error
+}
+
+func (e *streamReadError) Error() string { return e.err.Error() }
+
+// fill reads subtitles until the window holds n of them or the input ends
+func (w *streamWindow) fill(n int) error {
+	for !w.eof && len(w.s.Items) < n {
+		item, err := w.dec.Decode()
+		if err == io.EOF {
+			w.eof = true
+			break
+		}
+		if err != nil {
+			return &streamReadError{err}
+		}
+
+		w.read++
+		item.Process = w.limits.Contains(w.read, item)
+		w.s.Items = append(w.s.Items, item)
+	}
+
+	return nil
+}
+
+// flush writes out the first n subtitles of the window and drops them
+func (w *streamWindow) flush(n int) error {
+	if w.enc != nil {
+		for _, item := range w.s.Items[:n] {
+			if err := w.enc.Encode(item); err != nil {
+				return err
+			}
+		}
+	}
+
+	w.s.Items = append(w.s.Items[:0], w.s.Items[n:]...)
+	w.flushed += n
+
+	return nil
+}
+
+// normal runs the AdjustDuration passes followed by ShiftUnfit over the
+// stream. ShiftUnfit trails AdjustDuration by streamLookahead subtitles,
+// which gives each subtitle the same neighbours it would have had if the
+// whole file were processed in memory
+func (w *streamWindow) normal(params cliParams) error {
+	adj, shift := 0, 0
+	opts := fixOptions(params, w.log)
+
+	for {
+		if err := w.fill(adj + streamLookahead + 1); err != nil {
+			return err
+		}
+
+		done := w.eof && adj >= len(w.s.Items)
+
+		if !done {
+			if w.s.Items[adj].Process {
+				fixer.Adjust(w.s, adj, opts)
+			}
+			adj++
+		}
+
+		for shift < len(w.s.Items) &&
+			(shift < adj-streamLookahead || done) {
+			if w.s.Items[shift].Process {
+				w.s.Pass = astisub.ShiftUnfitPass
+				w.s.ShiftUnfit(shift, params.CommandParams, w.log)
+			}
+			shift++
+		}
+
+		keep := shift - streamLookahead
+		if done {
+			keep = len(w.s.Items)
+		}
+
+		if keep > 0 {
+			if err := w.flush(keep); err != nil {
+				return err
+			}
+			adj -= keep
+			shift -= keep
+		}
+
+		if done {
+			return nil
+		}
+	}
+}
+
+// overlap runs AdjustOverlap over the stream. Only the next subtitle
+// is needed to resolve an overlap
+func (w *streamWindow) overlap(params cliParams) error {
+	for {
+		if err := w.fill(2); err != nil {
+			return err
+		}
+
+		if len(w.s.Items) == 0 {
+			return nil
+		}
+
+		w.s.AdjustOverlap(0, params.CommandParams, w.log)
+
+		if err := w.flush(1); err != nil {
+			return err
+		}
+	}
+}
+
+// perfection runs PerfectionCheck on each subtitle of the stream and
+// returns the ids which failed
+func (w *streamWindow) perfection(params cliParams) ([]int, error) {
+	var failed []int
+
+	for {
+		// The next subtitle is needed for the gap check
+		if err := w.fill(2); err != nil {
+			return failed, err
+		}
+
+		if len(w.s.Items) == 0 {
+			return failed, nil
+		}
+
+		id := w.flushed + 1
+		if w.s.Items[0].Process {
+			perrs := perfectionMessages(w.s, 0, params, w.log, nil)
+			if len(perrs) > 0 {
+				failed = append(failed, id)
+				w.log.Warnf("Subtitle #%d failed perfection check - %s", id, strings.Join(perrs, " @@ "))
+			}
+		}
+
+		if err := w.flush(1); err != nil {
+			return failed, err
+		}
+	}
+}
+
+// StreamOperation runs the selected mode over params.File without loading
+// it in memory. Output is written as it is produced, to a temporary file
+// which replaces the destination once the whole stream has been processed.
+// Changes & violations are counted in t, changes being recorded in j
+// if given
+func StreamOperation(params cliParams, limits fixer.Limits, j *journal, t *tally, log *leveledLogger) int {
+	var in io.Reader = os.Stdin
+	if params.File != "-" {
+		if ext := filepath.Ext(params.File); ext != ".srt" {
+			log.Errorf("streaming is only supported for .srt files, not '%s'", ext)
+			return ExitUsage
+		}
+
+		f, err := os.Open(params.File)
+		if err != nil {
+			log.Errorf("Cannot open file '%s': %s", params.File, err)
+			return ExitError
+		}
+		defer f.Close()
+		in = f
+	}
+
+	w := &streamWindow{
+		s:      astisub.NewSubtitles(),
+		dec:    astisub.NewSRTDecoder(bufio.NewReader(in)),
+		limits: limits,
+		log:    log,
+	}
+	if t != nil {
+		w.s.Recorder = t
+	}
+
+	if params.Mode == "check" {
+		failed, err := w.perfection(params)
+		if err != nil {
+			log.Errorf("Cannot read '%s': %s", params.File, err)
+			return ExitParse
+		}
+
+		if len(failed) > 0 {
+			log.Warnf("Perfection check failed on these ids - [%s]", formatIds(failed))
+			return ExitCheckFailed
+		}
+
+		log.Infof("Perfection check passed succesfully!")
+		return 0
+	}
+
+	dst := params.Out
+	if dst == "" {
+		dst = params.File
+	}
+
+	if j != nil {
+		j.offset = &w.flushed
+		if t == nil {
+			w.s.Recorder = j
+		}
+	}
+
+	process := func(out io.Writer) error {
+		bw := bufio.NewWriter(out)
+		w.enc = astisub.NewSRTEncoder(bw)
+
+		var err error
+		if params.Mode == "overlap" {
+			err = w.overlap(params)
+		} else {
+			err = w.normal(params)
+		}
+
+		if err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+
+	var err error
+	if dst == "-" {
+		err = process(os.Stdout)
+	} else if err = makeBackup(dst, params); err == nil {
+		err = astisub.WriteFileAtomic(dst, process)
+	}
+
+	if err != nil {
+		log.Errorf("Cannot stream '%s' to '%s': %s", params.File, dst, err)
+		var re *streamReadError
+		if errors.As(err, &re) {
+			return ExitParse
+		}
+		return ExitWrite
+	}
+
+	log.Infof("Streamed %d subtitles to %s", w.enc.Count(), dst)
+
+	if j != nil {
+		if err := j.save(dst, params); err != nil {
+			log.Errorf("Cannot write journal of '%s': %s", dst, err)
+			return ExitWrite
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// streamTestSRT returns n subtitles giving every pass something to do:
+// some too short, some to join, split or shrink & some overlapping
+func streamTestSRT(n int) string {
+	var b bytes.Buffer
+	at := time.Second
+	for i := 0; i < n; i++ {
+		var length, gap time.Duration
+		var text string
+		switch i % 5 {
+		case 0:
+			length, gap, text = 300*time.Millisecond, 2*time.Second, "Far too short to be read in time"
+		case 1:
+			length, gap, text = 2*time.Second, 200*time.Millisecond, "Hi"
+		case 2:
+			length, gap, text = 2*time.Second, time.Second, "there"
+		case 3:
+			length, gap, text = 9*time.Second, -500*time.Millisecond, "A first line long enough to be split\nand a second line to go with it"
+		default:
+			length, gap, text = 8*time.Second, 3*time.Second, "A single line lasting far too long"
+		}
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, astisub.FormatDurationSRT(at), astisub.FormatDurationSRT(at+length), text)
+		at += length + gap
+	}
+	return b.String()
+}
+
+// runTestCommand runs subfixer with args & returns its exit code
+func runTestCommand(args ...string) int {
+	return runCommand(append(args, "-quiet"))
+}
+
+func TestStreamMatchesMemory(t *testing.T) {
+	captureLog(t, LogFormatText)
+	dir := t.TempDir()
+	inDir(t, dir)
+
+	input := filepath.Join(dir, "in.srt")
+	if err := os.WriteFile(input, []byte(streamTestSRT(200)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name string
+		args []string
+	}{
+		{"fix", []string{"fix"}},
+		{"fix limited", []string{"fix", "-limit_to", "20-60,150"}},
+		{"overlap", []string{"overlap"}},
+	} {
+		var outputs [2][]byte
+		for i, stream := range []bool{false, true} {
+			out := filepath.Join(dir, fmt.Sprintf("out%d.srt", i))
+			args := append(c.args, "-file", input, "-out", out, "-backup_keep", "0", "-journal", JournalOff)
+			if stream {
+				args = append(args, "-stream")
+			}
+			if code := runTestCommand(args...); code != ExitOK {
+				t.Fatalf("%s: stream %t exited with %d", c.name, stream, code)
+			}
+
+			var err error
+			if outputs[i], err = os.ReadFile(out); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if !bytes.Equal(outputs[0], outputs[1]) {
+			t.Errorf("%s: streaming wrote %d bytes unlike the %d written in memory", c.name, len(outputs[1]), len(outputs[0]))
+		}
+	}
+}
+
+func TestStreamCheck(t *testing.T) {
+	captureLog(t, LogFormatText)
+	dir := t.TempDir()
+	inDir(t, dir)
+
+	bad := filepath.Join(dir, "bad.srt")
+	if err := os.WriteFile(bad, []byte(streamTestSRT(20)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	good := writeTestFiles(t, "good.srt")[0]
+	vtt := filepath.Join(dir, "in.vtt")
+	if err := os.WriteFile(vtt, []byte("WEBVTT\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		file     string
+		expected int
+	}{
+		{good, ExitOK},
+		{bad, ExitCheckFailed},
+		{vtt, ExitUsage},
+	} {
+		for _, stream := range []bool{false, true} {
+			// Only streaming is limited to .srt
+			if c.file == vtt && !stream {
+				continue
+			}
+
+			args := []string{"check", "-file", c.file}
+			if stream {
+				args = append(args, "-stream")
+			}
+			if code := runTestCommand(args...); code != c.expected {
+				t.Errorf("check %s, stream %t: exit %d, expected %d", filepath.Base(c.file), stream, code, c.expected)
+			}
+		}
+	}
+}
@@ -5,225 +5,167 @@
 package main
 
 import (
-	//"github.com/chetan-prime/subfixer/astisub"
-	"./astisub"
+	"context"
 	"errors"
-	"flag"
-	"fmt"
+	"math"
 	"os"
-	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+	"github.com/chetan-prime/subfixer/fixer"
 )
 
-// Below are the default constants for options
+// Below are the default constants for options, those of the
+// fixer package. Durations are in seconds
 const (
-	DefaultMode = "normal"
-	DefaultReadingSpeed = 21.0
-	DefaultMinLength = 1.0
-	DefaultSpeedEpsilon = 1.0
-	DefaultTrimSpaces = 1
-	DefaultJoinShorterThan = 42
-	DefaultExpandCloserThan = 0.5
-	DefaultSplitLongerThan = 7.0
-	DefaultShrinkLongerThan = 7.0
-	DefaultForbiddenChars = "{./;/!/?/,:}"
-	DefaultMaxLines = 2
-	DefaultCharsPerLine = 42
-	DefaultLineBalance = 50.0
-	DefaultPreferCompact = true
-	DefaultSpacesAsChars = true
-	DefaultNewlinesAsChars = false
+	DefaultReadingSpeed = fixer.DefaultSpeed
+	DefaultMinLength = float64(fixer.DefaultMinLength) / float64(time.Second)
+	DefaultSpeedEpsilon = fixer.DefaultSpeedEpsilon
+	DefaultTrimSpaces = fixer.DefaultTrimSpaces
+	DefaultJoinShorterThan = fixer.DefaultJoinShorterThan
+	DefaultExpandCloserThan = float64(fixer.DefaultExpandCloserThan) / float64(time.Second)
+	DefaultSplitLongerThan = float64(fixer.DefaultSplitLongerThan) / float64(time.Second)
+	DefaultShrinkLongerThan = float64(fixer.DefaultShrinkLongerThan) / float64(time.Second)
+	DefaultForbiddenChars = fixer.DefaultForbiddenChars
+	DefaultMaxLines = fixer.DefaultMaxLines
+	DefaultCharsPerLine = fixer.DefaultCharsPerLine
+	DefaultLineBalance = fixer.DefaultLineBalance
+	DefaultPreferCompact = fixer.DefaultPreferCompact
+	DefaultSpacesAsChars = fixer.DefaultSpacesAsChars
+	DefaultNewlinesAsChars = fixer.DefaultNewlinesAsChars
+	DefaultBackupDir = ".subfixer_backup"
+	DefaultBackupKeep = 5
+	DefaultOutTemplate = "{name}.{ext}"
+	DefaultMaxLength = float64(fixer.DefaultMaxLength) / float64(time.Second)
+	DefaultMinGapFrames = fixer.DefaultMinGapFrames
+	DefaultFrameRate = fixer.DefaultFrameRate
 )
 
-// parseFlags processes flags on command line, assigns to CommandParams structs & returns
-func parseFlags() (astisub.CommandParams, error) {
-	filePtr  := flag.String("file", "", "Subtitle Input File (Required)")
-	
-	modePtr  := flag.String("mode", DefaultMode, "Operation Mode (overlap/normal/perfection)")
-
-	speedPtr := flag.Float64(	"speed",
-								DefaultReadingSpeed,
-								"Desired Characters Per Second"	)
-	
-	minLengthPtr := flag.Float64(	"min_length",
-									DefaultMinLength,
-									"Minimum Length for each subtitle"	)
-	
-	speedEpsilonPtr := flag.Float64("speed_epsilon",
-									DefaultSpeedEpsilon,
-									"Epsilon in % of Speed value"	)
-	
-	trimSpacesPtr := flag.Int(	"trim_spaces",
-								DefaultTrimSpaces,
-								"Trim space to left & right of each subtitle")
-	
-	joinShorterThanPtr := flag.Int(	"join_shorter_than",
-									DefaultJoinShorterThan,
-									"Join two lines shorter in length than")
-	
-	expandCloserThanPtr := flag.Float64("expand_closer_than",
-										DefaultExpandCloserThan,
-										"Expand two subtitles closer than n seconds")
-	
-	splitLongerThanPtr := flag.Float64(	"split_longer_than",
-										DefaultSplitLongerThan,
-										"Proportionately split a two line subtitle longer than n seconds")
-	
-	shrinkLongerThanPtr := flag.Float64("shrink_longer_than",
-										DefaultShrinkLongerThan,
-										"Shrink a single line subtitle longer than n seconds")
-	
-	limitToPtr := flag.String(	"limit_to",
-								"",
-								"Limit to range or list of subtitle id's (1-2,4-10,14-16,18)")
-	
-	forbiddenCharsPtr := flag.String(	"forbidden_chars",
-										DefaultForbiddenChars,
-										"Perfection Check - Forbidden Characters")
-	
-	maxLinesPtr := flag.Int(	"max_lines",
-								DefaultMaxLines,
-								"Perfection Check - Max. lines")
-	
-	charsPerLinePtr := flag.Int(	"chars_per_line",
-									DefaultCharsPerLine,
-									"Perfection Check - No. of characters/line")
-	
-	readingSpeedPtr := flag.Float64("reading_speed",
-									DefaultReadingSpeed,
-									"Perfection Check - Reading Speed (ch/sec)"	)
-	
-	lineBalancePtr := flag.Float64(	"line_balance",
-									DefaultLineBalance,
-									"Perfection Check - Length Balance (%)")
-	
-	preferCompactPtr := flag.Bool(	"prefer_compact",
-									DefaultPreferCompact,
-									"Perfection Check - Prefer Compact Subtitles")
-	
-	spacesAsCharsPtr := flag.Bool(	"spaces_as_chars",
-									DefaultSpacesAsChars,
-									"Perfection Check - Treat Spaces as characters")
+// openSubtitles opens the subtitle file name for reading.
+// A name of "-" reads from stdin and sniffs the format, a path
+// inside a zip archive reads the entry in place
+func openSubtitles(name string) (*astisub.Subtitles, error) {
+	if name == "-" {
+		return astisub.ReadFrom(os.Stdin)
+	}
 	
-	newlinesAsCharsPtr := flag.Bool(	"newlines_as_chars",
-										DefaultNewlinesAsChars,
-										"Perfection Check - Treat newlines as characters")
+	if archive, entry, ok := splitZipPath(name); ok {
+		return openZipEntry(archive, entry)
+	}
 	
-	flag.Parse()
+	return astisub.OpenFile(name)
+}
+
+// saveSubtitles writes the subtitles to params.Out, or back to params.File
+// if no output was given. A destination of "-" writes SRT to stdout, and
+// an entry of a zip archive is queued until pendingZips is flushed
+func saveSubtitles(s *astisub.Subtitles, params cliParams) error {
+	dst := params.Out
+	if dst == "" {
+		dst = params.File
+	}
 	
-	limitTo := *limitToPtr
-	var limitRanges []astisub.RangeStruct
+	if params.DryRun {
+		logger.Debugf("Dry run, not saving changes to file %s", dst)
+		return nil
+	}
 	
-	if limitTo!="" {
-		limitArr := strings.Split(limitTo, ",")
-		for _, limitStr := range limitArr {
-			limitStr = strings.Trim(limitStr, " ")
-			limit := strings.Split(limitStr, "-")
-			
-			if len(limit)>0 {
-				if limitRanges==nil {
-					limitRanges = make([]astisub.RangeStruct,0)
-				}
-				if start := limit[0]; start!="" {
-					rangeStr := astisub.RangeStruct{start, start}
-					if len(limit)>1 {
-						if stop := limit[1]; stop!="" {
-							rangeStr.Stop = stop
-						}
-					}
-					
-					limitRanges = append(limitRanges, rangeStr)
-				}
-			}
-		}
+	// Archives are written once all of their entries were saved
+	if isZipEntry(dst) {
+		logger.Debugf("Queued changes to %s", dst)
+		return pendingZips.save(s, params.File, dst)
 	}
 	
-	res := astisub.CommandParams{	File: *filePtr,
-									Mode: *modePtr,
-									Speed: *speedPtr,
-									SpeedEpsilon: *speedEpsilonPtr,
-									MinLength: *minLengthPtr,
-									TrimSpaces: *trimSpacesPtr,
-									JoinShorterThan: *joinShorterThanPtr,
-									ExpandCloserThan: *expandCloserThanPtr,
-									SplitLongerThan: *splitLongerThanPtr,
-									ShrinkLongerThan: *shrinkLongerThanPtr,
-									LimitTo: limitRanges,
-									ForbiddenChars: *forbiddenCharsPtr,
-									MaxLines: 	*maxLinesPtr,
-									CharsPerLine: *charsPerLinePtr,
-									ReadingSpeed: *readingSpeedPtr,
-									LineBalance: *lineBalancePtr,
-									PreferCompact: *preferCompactPtr,
-									SpacesAsChars: *spacesAsCharsPtr,
-									NewlinesAsChars: *newlinesAsCharsPtr	}
-	var err error = nil
+	if err := makeBackup(dst, params); err != nil {
+		return err
+	}
 	
-	if res.File=="" {
-		err = errors.New("Input Subtitle file is required")
-		return res, err
+	var err error
+	if dst == "-" {
+		err = s.WriteToSRT(os.Stdout)
+	} else {
+		err = s.Write(dst)
 	}
 	
-	switch(res.Mode) {
-	case "overlap", "normal", "", "perfection":
-		// Do nothing, all is fine
-	default:
-		err = errors.New("Mode can be only one of: overlap, normal OR perfection")
-		return res, err
+	if err != nil {
+		return err
 	}
 	
-	return res, err
+	logger.Infof("Saved changes to file %s", dst)
+	return nil
 }
 
 // NormalOperation runs normal operation (Read / Write).
 // This function is called based on the command line parameters used
-func NormalOperation(s *astisub.Subtitles, params astisub.CommandParams) int {
-	incBy := 1
+func NormalOperation(s *astisub.Subtitles, params cliParams, log *leveledLogger) int {
+	fixSubtitles(s, params, log)
 	
-	for i:=0; i < len(s.Items); i+= incBy {
-		if s.Items[i].Process {
-			for p:=0; p<3; p++ {
-				fmt.Printf("id #%d: Starting Pass %d..\n", i+1, p+1)
-				incBy = s.AdjustDuration(i, params)
-				if incBy <= 0 {
-					fmt.Printf("Skipping further passes as subtitles seems to have been deleted / split")
-					break
-				}
-			}
-		}
+	if _, err := runPlugins(s, params, "fix", log); err != nil {
+		log.Errorf("Cannot fix subtitles: %s", err)
+		return ExitError
 	}
 	
-	fmt.Printf(
-		"NormalOperation/Post-processing check for subtitles which are still unfit!\n",
-	)
-	
-	// Now check for still unfit subtitles
-	for i:=0; i < len(s.Items); i+= 1 {
-		if s.Items[i].Process {
-			s.ShiftUnfit(i, params)
-		}
+	if err := saveSubtitles(s, params); err != nil {
+		log.Errorf("Cannot save subtitles: %s", err)
+		return ExitWrite
 	}
 	
-	fmt.Printf("Now saving changes to file %s: ", params.File)
-	s.Write(params.File)
-	fmt.Printf("[DONE]\n")
-	
 	return 0
 }
 
+// fixSubtitles runs the Adjust passes over the subtitles to process,
+// then shifts those which are still unfit
+func fixSubtitles(s *astisub.Subtitles, params cliParams, log *leveledLogger) {
+	fixer.Passes(context.Background(), s, fixOptions(params, log))
+}
+
+// fixOptions converts the settings of a command for the fixer package.
+// LimitTo is left out, as the subtitles to process are marked by then
+func fixOptions(params cliParams, log astisub.Logger) fixer.Options {
+	return fixer.Options{
+		Speed:				params.Speed,
+		SpeedEpsilon:		params.SpeedEpsilon,
+		MinLength:			seconds(params.MinLength),
+		TrimSpaces:			params.TrimSpaces,
+		JoinShorterThan:	params.JoinShorterThan,
+		ExpandCloserThan:	seconds(params.ExpandCloserThan),
+		SplitLongerThan:	seconds(params.SplitLongerThan),
+		ShrinkLongerThan:	seconds(params.ShrinkLongerThan),
+		MaxLines:			params.MaxLines,
+		CharsPerLine:		params.CharsPerLine,
+		ReadingSpeed:		params.ReadingSpeed,
+		LineBalance:		params.LineBalance,
+		PreferCompact:		params.PreferCompact,
+		ForbiddenChars:		params.ForbiddenChars,
+		MaxLength:			seconds(params.MaxLength),
+		MinGapFrames:		params.MinGapFrames,
+		FrameRate:			params.FrameRate,
+		SpacesAsChars:		params.SpacesAsChars,
+		NewlinesAsChars:	params.NewlinesAsChars,
+		Logger:				log,
+	}
+}
+
+// seconds converts a number of seconds given to a flag to a duration,
+// rounded so that it converts back to the same number
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Round(s * float64(time.Second)))
+}
+
 // OverlapOperation runs normal operation (Read / Write).
 // This function is called based on the command line parameters used
-func OverlapOperation(s *astisub.Subtitles, params astisub.CommandParams) int {
+func OverlapOperation(s *astisub.Subtitles, params cliParams, log *leveledLogger) int {
 	for i:=0; i < len(s.Items); i+= 1 {
-		s.AdjustOverlap(i, params)
+		s.AdjustOverlap(i, params.CommandParams, log)
 	}
 	
-	fmt.Printf("Now saving changes to file %s: ", params.File)
-	s.Write(params.File)
-	fmt.Printf("[DONE]\n")
+	if err := saveSubtitles(s, params); err != nil {
+		log.Errorf("Cannot save subtitles: %s", err)
+		return ExitWrite
+	}
 	
 	return 0
 }
@@ -231,21 +173,33 @@ func OverlapOperation(s *astisub.Subtitles, params astisub.CommandParams) int {
 
 // Perform Perfection check(read only).
 // This function is called based on the command line parameters used
-func PerfectionOperation(s *astisub.Subtitles, params astisub.CommandParams) int {
+func PerfectionOperation(s *astisub.Subtitles, params cliParams, log *leveledLogger) int {
 	error_code := 0
 	
 	errors := make(map[int][]string)
 	
+	// Plugin rules are reported along with the built-in ones
+	violations, err := runPlugins(s, params, "check", log)
+	if err != nil {
+		log.Errorf("Cannot check subtitles: %s", err)
+		return ExitError
+	}
+	
+	extra := make(map[int][]astisub.Violation)
+	for _, v := range violations {
+		extra[v.Id-1] = append(extra[v.Id-1], v)
+	}
+	
 	for i:=0; i < len(s.Items); i+= 1 {
 		if s.Items[i].Process {
-			perrs := s.PerfectionCheck(i, params)
+			perrs := perfectionMessages(s, i, params, log, extra[i])
 			if len(perrs)>0 {
 				errors[i] = perrs
 				
 				serrs := strings.Join(perrs, " @@ ")
-				fmt.Fprintf(os.Stderr, "Subtitle #%d failed perfection check - %s\n", i+1, serrs)
+				log.Warnf("Subtitle #%d failed perfection check - %s", i+1, serrs)
 				
-				error_code = 10
+				error_code = ExitCheckFailed
 			}
 		}	
 	}
@@ -258,125 +212,92 @@ func PerfectionOperation(s *astisub.Subtitles, params astisub.CommandParams) int
 		
 		sort.Ints(ikeys)
 		
-		keylist := ""
-		
-		for _, k := range ikeys {
-			if len(keylist)>0 {
-				keylist += ","
-			}
-			keylist += strconv.FormatInt(int64(k), 10)
-		}
-		
-		fmt.Fprintf(os.Stderr, "Perfection check failed on these ids - [%s]\n", keylist)
+		log.Warnf("Perfection check failed on these ids - [%s]", formatIds(ikeys))
 	} else {
-		fmt.Fprintf(os.Stderr, "Perfection check passed succesfully!\n")
+		log.Infof("Perfection check passed succesfully!")
 	}
 
 	return error_code
 }
 
-// Main entry point for the program
-func main() {
-	params, err := parseFlags()
+// formatIds joins a list of subtitle ids with commas
+func formatIds(ids []int) string {
+	keylist := ""
 	
-	if len(os.Args) > 2 {
-		if err != nil {
-			os.Stderr.WriteString(fmt.Sprintf("Error: %s", err))
-			return
-		}
-		files := []string{}
-		files, err = filepath.Glob(params.File)
-		
-		if err != nil {
-			os.Stderr.WriteString(fmt.Sprintf("Error globbing list of files for '%s': %s\n", params.File, err))
-			os.Exit(1)
-			return
+	for _, k := range ids {
+		if len(keylist)>0 {
+			keylist += ","
 		}
-		
-		for _, fname := range files {
-			// Open
-			s, err := astisub.OpenFile(fname)
-			if err != nil {
-				os.Stderr.WriteString(fmt.Sprintf("Error opening file '%s': %s\n", fname, err))
-				os.Exit(1)
-				return
-			}
-			
-			params.File = fname
-			
+		keylist += strconv.FormatInt(int64(k), 10)
+	}
 	
-			if params.Mode != "overlap" {
-				if params.LimitTo==nil {
-					params.LimitTo=make([]astisub.RangeStruct, 0)
-					rangeStr := astisub.RangeStruct{"0", strconv.FormatInt(int64(len(s.Items)-1),10)}
-		
-					params.LimitTo = append(params.LimitTo, rangeStr)
-				}
-				
-				for _, limitrec := range params.LimitTo {
-					if strings.Index(limitrec.Start, ":")>-1 ||
-					   strings.Index(limitrec.Start, ".")>-1 {
-						start := time.Duration(0)
-						stop := time.Duration(0)
-					
-						if strings.Index(limitrec.Start, ":")>-1 {
-							start, _ = astisub.ParseDuration(limitrec.Start, ".", 3)
-							stop, _  = astisub.ParseDuration(limitrec.Stop, ".", 3)
-						} else {
-							startf,_ := strconv.ParseFloat(limitrec.Start, 64)
-							stopf,_ := strconv.ParseFloat(limitrec.Stop, 64)
-						
-							start = time.Duration( startf * float64( time.Second ) )
-							stop  = time.Duration( stopf  * float64( time.Second ) )
-						}
-					
-						for i:=0; i < len(s.Items); i++ {
-							if (	s.Items[i].StartAt>=start &&
-									s.Items[i].EndAt<=stop ) ||
-							   (	s.Items[i].StartAt<=stop &&
-									s.Items[i].EndAt>=start ) {
-								s.Items[i].Process = true
-								fmt.Printf("Marking subtitle id #%d for processing\n", i+1)
-							}
-						}
-					} else {
-						start, _ := strconv.Atoi(limitrec.Start)
-						if start < 1 {
-							start = 1
-						}
-						stop, _  := strconv.Atoi(limitrec.Stop)
-						for i:=start-1; i < len(s.Items) && i<=stop-1; i++ {
-							s.Items[i].Process = true
-							fmt.Printf("Marking subtitle id #%d for processing\n", i+1)
-						}
-					}
-				}
-			}
-			
-			error_code := 0
-			
-			if params.Mode=="perfection" {
-				fmt.Printf("Performing in Perfection mode on '%s'\n", fname)
-				error_code = PerfectionOperation(s, params)
-			} else 
-			if params.Mode=="overlap" {
-				fmt.Printf("Performing in Overlap only mode on '%s'\n", fname)
-				error_code = OverlapOperation(s, params)
-			} else {
-				fmt.Printf("Performing in Normal mode on '%s'\n", fname)
-				error_code = NormalOperation(s, params)
-			}
-			
-			if error_code != 0 {
-				os.Exit(error_code)
-			}
-		}
-	} else {
-		avail := fmt.Sprintf("%s: Available parameters are below", os.Args[0])
-		avail += "\n" + strings.Repeat("-", len(avail)) + "\n"
-		os.Stderr.WriteString(avail)
-		flag.PrintDefaults()
+	return keylist
+}
+
+// ShiftOperation moves every subtitle by params.ShiftBy and saves them.
+// Subtitles which end up entirely before 0 are dropped
+func ShiftOperation(s *astisub.Subtitles, params cliParams, log *leveledLogger) int {
+	before := len(s.Items)
+	s.Add(params.ShiftBy)
+	
+	if dropped := before - len(s.Items); dropped > 0 {
+		log.Warnf("Dropped %d subtitles which were shifted before 0", dropped)
+	}
+	
+	if err := saveSubtitles(s, params); err != nil {
+		log.Errorf("Cannot save subtitles: %s", err)
+		return ExitWrite
+	}
+	
+	return 0
+}
+
+// ConvertOperation writes the subtitles to params.Out without
+// changing them, in the format matching its extension
+func ConvertOperation(s *astisub.Subtitles, params cliParams, log *leveledLogger) int {
+	if err := saveSubtitles(s, params); err != nil {
+		log.Errorf("Cannot save subtitles: %s", err)
+		return ExitWrite
 	}
+	
+	return 0
+}
+
+// durationFlag parses a duration either in Go (1.5s, -250ms)
+// or in subtitle (00:00:01,500, -00:00:01.500) notation
+type durationFlag struct {
+	d *time.Duration
 }
 
+func (f *durationFlag) String() string {
+	if f.d == nil || *f.d == 0 {
+		return ""
+	}
+	return f.d.String()
+}
 
+func (f *durationFlag) Set(value string) error {
+	if d, err := time.ParseDuration(value); err == nil {
+		*f.d = d
+		return nil
+	}
+	
+	sign := time.Duration(1)
+	if strings.HasPrefix(value, "-") {
+		sign = -1
+		value = value[1:]
+	}
+	
+	d, err := astisub.ParseDuration(strings.Replace(value, ",", ".", 1), ".", 3)
+	if err != nil {
+		return errors.New("invalid duration " + value)
+	}
+	
+	*f.d = sign * d
+	return nil
+}
+
+// Main entry point for the program
+func main() {
+	os.Exit(runCommand(os.Args[1:]))
+}
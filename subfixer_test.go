@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chetan-prime/subfixer/fixer"
+)
+
+func TestFixOptions(t *testing.T) {
+	var params cliParams
+	fs := flag.NewFlagSet("all", flag.ContinueOnError)
+	allFlags(fs, &params)
+
+	// The flags default to the options of the fixer package
+	if opts, expected := fixOptions(params, nil), fixer.DefaultOptions(); !reflect.DeepEqual(opts, expected) {
+		t.Errorf("fixOptions of the defaults = %+v, expected %+v", opts, expected)
+	}
+	if got, expected := commandParams(t, "check").MinLength, fixer.DefaultCheckOptions().MinLength.Seconds(); got != expected {
+		t.Errorf("check defaults to -min_length %g, expected %g as DefaultCheckOptions", got, expected)
+	}
+
+	if err := fs.Parse([]string{"-min_length", "1.01", "-split_longer_than", "6.5"}); err != nil {
+		t.Fatal(err)
+	}
+	if opts := fixOptions(params, nil); opts.MinLength.Seconds() != 1.01 || opts.SplitLongerThan.Seconds() != 6.5 {
+		t.Errorf("durations converted to %s & %s, expected 1.01s & 6.5s", opts.MinLength, opts.SplitLongerThan)
+	}
+}
+
+// redirect replaces *f with a file holding input until the end of the
+// test, & returns the path of that file
+func redirect(t *testing.T, f **os.File, input string) string {
+	path := filepath.Join(t.TempDir(), "std")
+	if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := *f
+	*f = r
+	t.Cleanup(func() {
+		*f = old
+		r.Close()
+	})
+	return path
+}
+
+func TestStdinToStdout(t *testing.T) {
+	captureLog(t, LogFormatText)
+	redirect(t, &os.Stdin, testSRT)
+	out := redirect(t, &os.Stdout, "")
+
+	s, err := openSubtitles("-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Items) != 2 {
+		t.Fatalf("read %d subtitles from stdin, expected 2", len(s.Items))
+	}
+
+	s.Add(time.Second)
+	if err := saveSubtitles(s, commandParams(t, "shift", "-file", "-", "-by", "1s")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "00:00:02,000 --> 00:00:04,000\nHello there") {
+		t.Errorf("unexpected output on stdout %q", data)
+	}
+}
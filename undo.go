@@ -0,0 +1,166 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/chetan-prime/subfixer/astisub"
+	"github.com/chetan-prime/subfixer/fixer"
+)
+
+// changeMatches checks if item is in the state c left it in
+func changeMatches(item *astisub.Item, c astisub.Change) bool {
+	return astisub.TimingString(item) == c.Timing && item.String() == c.Text
+}
+
+// findChange returns the index of the subtitle c was made to, or -1.
+// Ids change as subtitles are split or edited by hand, so subtitles are
+// matched on their content, the one nearest to the recorded id winning
+func findChange(s *astisub.Subtitles, c astisub.Change) int {
+	at := c.Cue - 1
+
+	for d := 0; at-d >= 0 || at+d < len(s.Items); d++ {
+		if i := at - d; i >= 0 && i < len(s.Items) && changeMatches(s.Items[i], c) {
+			return i
+		}
+		if i := at + d; d > 0 && i >= 0 && i < len(s.Items) && changeMatches(s.Items[i], c) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// revertChange undoes c on subtitle i
+func revertChange(s *astisub.Subtitles, i int, c astisub.Change) error {
+	item := s.Items[i]
+
+	switch c.Field {
+	case "start", "end":
+		d, err := astisub.ParseDuration(c.Before, ",", 3)
+		if err != nil {
+			return err
+		}
+		if c.Field == "start" {
+			item.StartAt = d
+		} else {
+			item.EndAt = d
+		}
+
+	case "text":
+		item.Lines = nil
+		for _, l := range strings.Split(c.Before, "\n") {
+			item.Lines = append(item.Lines, astisub.Line{Items: []astisub.LineItem{{Text: l}}})
+		}
+
+	case "cue":
+		s.Remove(i, 1)
+	}
+
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = true
+		}
+	}
+
+	return set
+}
+
+// UndoOperation reverts the changes of a run recorded in the journal of
+// params.File, the latest run unless -run is given. Changes can be
+// limited to some cues with -cue and to some rules with -rule. Changes
+// to subtitles which were edited since are skipped
+func UndoOperation(s *astisub.Subtitles, params cliParams, log *leveledLogger) int {
+	path := journalPath(params.File, params)
+	if path == "" {
+		log.Errorf("no journal for '%s'", params.File)
+		return ExitError
+	}
+
+	entries, err := readJournal(path)
+	if err != nil {
+		log.Errorf("Cannot read journal '%s': %s", path, err)
+		return ExitError
+	}
+
+	var runs []string
+	for _, entry := range entries {
+		if len(runs) == 0 || runs[len(runs)-1] != entry.Run {
+			runs = append(runs, entry.Run)
+		}
+	}
+
+	if len(runs) == 0 {
+		log.Errorf("No changes recorded for '%s' in %s", params.File, path)
+		return ExitError
+	}
+
+	run := params.Run
+	if run == "" {
+		run = runs[len(runs)-1]
+	}
+
+	found := false
+	log.Infof("Runs recorded for '%s':", params.File)
+	for _, r := range runs {
+		log.Infof("  %s", r)
+		found = found || r == run
+	}
+
+	if !found {
+		log.Errorf("no run '%s' in journal '%s'", run, path)
+		return ExitError
+	}
+
+	limits := fixer.ParseLimits(params.Cues)
+	rules := splitList(params.Rules)
+
+	reverted, skipped := 0, 0
+	for n := len(entries) - 1; n >= 0; n-- {
+		c := entries[n]
+		if c.Run != run || (len(rules) > 0 && !rules[c.Rule]) {
+			continue
+		}
+
+		i := findChange(s, c.Change)
+		if i < 0 {
+			log.Warnf("id #%d: %s %s by %s no longer matches the journal, skipping", c.Cue, c.Field, c.After, c.Rule)
+			skipped++
+			continue
+		}
+
+		if !limits.Contains(c.Cue, s.Items[i]) {
+			continue
+		}
+
+		if err := revertChange(s, i, c.Change); err != nil {
+			log.Warnf("id #%d: reverting %s failed: %s", c.Cue, c.Field, err)
+			skipped++
+			continue
+		}
+
+		log.Infof("id #%d: %s reverted from %q to %q (%s, pass %d)", i+1, c.Field, c.After, c.Before, c.Rule, c.Pass)
+		reverted++
+	}
+
+	log.Infof("Reverted %d changes of run %s, skipped %d", reverted, run, skipped)
+	if reverted == 0 {
+		return ExitError
+	}
+
+	if err := saveSubtitles(s, params); err != nil {
+		log.Errorf("Cannot save subtitles: %s", err)
+		return ExitWrite
+	}
+
+	return 0
+}
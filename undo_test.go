@@ -0,0 +1,267 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// undoTestSRT holds the same text twice, for changes to be matched
+// to the nearest one
+const undoTestSRT = `1
+00:00:01,000 --> 00:00:02,000
+Same
+
+2
+00:00:03,000 --> 00:00:04,000
+Other
+
+3
+00:00:05,000 --> 00:00:06,000
+Text
+
+4
+00:00:07,000 --> 00:00:08,000
+Same
+`
+
+func TestFindChange(t *testing.T) {
+	s := readTestSubtitles(t, undoTestSRT)
+
+	same := astisub.Change{Timing: "00:00:01,000 --> 00:00:02,000", Text: "Same"}
+	for _, c := range []struct {
+		name     string
+		change   astisub.Change
+		cue      int
+		expected int
+	}{
+		{"at its id", same, 1, 0},
+		{"moved down", astisub.Change{Timing: "00:00:05,000 --> 00:00:06,000", Text: "Text"}, 1, 2},
+		{"moved up", astisub.Change{Timing: "00:00:03,000 --> 00:00:04,000", Text: "Other"}, 4, 1},
+		{"id past the end", astisub.Change{Timing: "00:00:07,000 --> 00:00:08,000", Text: "Same"}, 9, 3},
+		{"edited since", astisub.Change{Timing: "00:00:01,000 --> 00:00:02,500", Text: "Same"}, 1, -1},
+		{"text edited since", astisub.Change{Timing: "00:00:03,000 --> 00:00:04,000", Text: "Another"}, 2, -1},
+	} {
+		c.change.Cue = c.cue
+		if got := findChange(s, c.change); got != c.expected {
+			t.Errorf("%s: found at %d, expected %d", c.name, got, c.expected)
+		}
+	}
+
+	// Of equal subtitles, the nearest to the id wins
+	s.Items[3].StartAt, s.Items[3].EndAt = s.Items[0].StartAt, s.Items[0].EndAt
+	for cue, expected := range map[int]int{1: 0, 2: 0, 3: 3, 4: 3} {
+		same.Cue = cue
+		if got := findChange(s, same); got != expected {
+			t.Errorf("id #%d: found at %d, expected %d", cue, got, expected)
+		}
+	}
+}
+
+func TestRevertChange(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		change   astisub.Change
+		fails    bool
+		expected func(s *astisub.Subtitles) bool
+	}{
+		{"start", astisub.Change{Field: "start", Before: "00:00:02,500"}, false, func(s *astisub.Subtitles) bool {
+			return astisub.TimingString(s.Items[1]) == "00:00:02,500 --> 00:00:04,000"
+		}},
+		{"end", astisub.Change{Field: "end", Before: "00:00:04,250"}, false, func(s *astisub.Subtitles) bool {
+			return astisub.TimingString(s.Items[1]) == "00:00:03,000 --> 00:00:04,250"
+		}},
+		{"text", astisub.Change{Field: "text", Before: "Two\nlines"}, false, func(s *astisub.Subtitles) bool {
+			return len(s.Items[1].Lines) == 2 && s.Items[1].String() == "Two\nlines"
+		}},
+		{"cue", astisub.Change{Field: "cue"}, false, func(s *astisub.Subtitles) bool {
+			return len(s.Items) == 3 && s.Items[1].String() == "Text"
+		}},
+		{"invalid time", astisub.Change{Field: "start", Before: "soon"}, true, nil},
+	} {
+		s := readTestSubtitles(t, undoTestSRT)
+		err := revertChange(s, 1, c.change)
+		if (err != nil) != c.fails {
+			t.Errorf("%s: error %v, expected failure %t", c.name, err, c.fails)
+			continue
+		}
+		if err == nil && !c.expected(s) {
+			t.Errorf("%s: subtitle reverted to %s %q", c.name, astisub.TimingString(s.Items[1]), s.Items[1])
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	for _, c := range []struct {
+		list     string
+		expected map[string]bool
+	}{
+		{"", map[string]bool{}},
+		{"split", map[string]bool{"split": true}},
+		{" split, ,join ,", map[string]bool{"split": true, "join": true}},
+	} {
+		if got := splitList(c.list); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("splitList(%q) = %v, expected %v", c.list, got, c.expected)
+		}
+	}
+}
+
+func TestJournalPath(t *testing.T) {
+	shared, _ := backupStem("season1/ep01.srt", cliParams{BackupDir: "/backups"})
+	for _, c := range []struct {
+		name     string
+		params   cliParams
+		expected string
+	}{
+		{"season1/ep01.srt", cliParams{}, filepath.Join("season1", DefaultBackupDir, "ep01.srt.journal.jsonl")},
+		{"season1/ep01.srt", cliParams{BackupDir: "/backups"}, filepath.Join("/backups", shared+".srt.journal.jsonl")},
+		{"season1/ep01.srt", cliParams{Journal: "/changes.jsonl"}, "/changes.jsonl"},
+		{"season1/ep01.srt", cliParams{Journal: JournalOff}, ""},
+		{"-", cliParams{}, ""},
+	} {
+		if got := journalPath(c.name, c.params); got != c.expected {
+			t.Errorf("journalPath(%q, %+v) = %q, expected %q", c.name, c.params, got, c.expected)
+		}
+	}
+}
+
+func TestJournalSave(t *testing.T) {
+	captureLog(t, LogFormatText)
+	path := filepath.Join(t.TempDir(), "ep01.journal.jsonl")
+	params := cliParams{Journal: path}
+
+	first := newJournal("fix")
+	first.Record(astisub.Change{Cue: 1, Field: "end", Rule: "min_length"})
+	if err := first.save("ep01.srt", params); err != nil {
+		t.Fatal(err)
+	}
+	if len(first.entries) != 0 {
+		t.Errorf("%d entries left after saving", len(first.entries))
+	}
+
+	// Runs are appended, streamed ones with ids past those written out
+	second := newJournal("overlap")
+	second.run = first.run + "-2"
+	offset := 10
+	second.offset = &offset
+	second.Record(astisub.Change{Cue: 2, Field: "start", Rule: "overlap"})
+	if err := second.save("ep01.srt", params); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []journalEntry{
+		{Run: first.run, Command: "fix", Change: astisub.Change{Cue: 1, Field: "end", Rule: "min_length"}},
+		{Run: second.run, Command: "overlap", Change: astisub.Change{Cue: 12, Field: "start", Rule: "overlap"}},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("journal holds %+v, expected %+v", entries, expected)
+	}
+
+	if err := os.WriteFile(path, []byte("{\"run\": \"a\"}\n\nnot json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readJournal(path); err == nil {
+		t.Error("reading an invalid journal succeeded")
+	}
+}
+
+func TestUndoOperation(t *testing.T) {
+	captureLog(t, LogFormatText)
+	dir := t.TempDir()
+	inDir(t, dir)
+
+	original := streamTestSRT(20)
+	name := filepath.Join(dir, "ep01.srt")
+	for _, c := range []struct {
+		name     string
+		args     []string
+		expected int
+		reverted bool
+	}{
+		{"latest run", nil, ExitOK, true},
+		{"unknown run", []string{"-run", "20000101-000000.000"}, ExitError, false},
+		{"unknown rule", []string{"-rule", "nope"}, ExitError, false},
+	} {
+		if err := os.WriteFile(name, []byte(original), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.RemoveAll(filepath.Join(dir, DefaultBackupDir))
+
+		if code := runTestCommand("fix", "-file", name); code != ExitOK {
+			t.Fatalf("%s: fix exited with %d", c.name, code)
+		}
+		fixed, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if code := runTestCommand(append([]string{"undo", "-file", name}, c.args...)...); code != c.expected {
+			t.Errorf("%s: undo exited with %d, expected %d", c.name, code, c.expected)
+		}
+
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := string(fixed)
+		if c.reverted {
+			expected = original
+		}
+		if got, want := cueStrings(readTestSubtitles(t, string(data))), cueStrings(readTestSubtitles(t, expected)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: subtitles after undo are %v, expected %v", c.name, got, want)
+		}
+	}
+}
+
+func TestUndoSharedBackupDir(t *testing.T) {
+	captureLog(t, LogFormatText)
+
+	files := writeTestFiles(t, "a/ep01.srt", "b/ep01.srt")
+	backups := filepath.Join(t.TempDir(), "backups")
+	original := streamTestSRT(20)
+	for _, name := range files {
+		if err := os.WriteFile(name, []byte(original), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if code := runTestCommand("fix", "-file", files[0], "-backup_dir", backups); code != ExitOK {
+		t.Fatalf("fix exited with %d", code)
+	}
+
+	// The file of the same name which was not fixed has no run to undo,
+	// so the changes of the other are not applied to it
+	if code := runTestCommand("undo", "-file", files[1], "-backup_dir", backups); code != ExitError {
+		t.Errorf("undo of the file not fixed exited with %d, expected %d", code, ExitError)
+	}
+	if code := runTestCommand("undo", "-file", files[0], "-backup_dir", backups); code != ExitOK {
+		t.Errorf("undo of the fixed file exited with %d", code)
+	}
+
+	expected := cueStrings(readTestSubtitles(t, original))
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cueStrings(readTestSubtitles(t, string(data))); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: subtitles after undo are %v, expected %v", name, got, expected)
+		}
+	}
+}
+
+// cueStrings returns the timing & text of each subtitle of s
+func cueStrings(s *astisub.Subtitles) []string {
+	cues := make([]string, 0, len(s.Items))
+	for _, item := range s.Items {
+		cues = append(cues, astisub.TimingString(item)+" "+item.String())
+	}
+	return cues
+}
@@ -0,0 +1,522 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/chetan-prime/subfixer/astisub"
+	"github.com/chetan-prime/subfixer/fixer"
+)
+
+// Below are the defaults of the watch command
+const (
+	DefaultPipeline      = "fix,check"
+	DefaultWatchInterval = 2 * time.Second
+	DefaultWatchSettle   = 5 * time.Second
+	DefaultWatchState    = ".subfixer_watch.json"
+)
+
+// Outcomes of a file picked up by the watch command, also the names
+// of the folders they are moved to by default
+const (
+	WatchDone     = "done"
+	WatchFailed   = "failed"
+	WatchRejected = "rejected"
+)
+
+// watchSteps are the steps a -pipeline can be made of
+var watchSteps = map[string]bool{"fix": true, "overlap": true, "check": true}
+
+// watchEntry is what the state file records about a file being processed
+type watchEntry struct {
+	SHA256  string    `json:"sha256"`
+	Outcome string    `json:"outcome"`
+	Started time.Time `json:"started"`
+}
+
+// watchState is kept on disk so that a restarted watcher knows which
+// files it had already processed when it stopped
+type watchState struct {
+	Files map[string]*watchEntry `json:"files"`
+}
+
+// watchReport is written next to each file moved out of the inbox
+type watchReport struct {
+	File     string            `json:"file"`
+	Outcome  string            `json:"outcome"`
+	Reason   string            `json:"reason,omitempty"`
+	Pipeline []string          `json:"pipeline"`
+	Exit     int               `json:"exit"`
+	Changes  string            `json:"changes,omitempty"`
+	SHA256   string            `json:"sha256"`
+	Started  time.Time         `json:"started"`
+	Finished time.Time         `json:"finished"`
+	Log      []json.RawMessage `json:"log"`
+}
+
+// pendingFile is a file of the inbox waiting to stop changing
+type pendingFile struct {
+	size  int64
+	mod   time.Time
+	since time.Time
+	stuck bool
+}
+
+// watcher polls an inbox directory and runs the pipeline on each file
+// once it has not changed for -settle
+type watcher struct {
+	params  cliParams
+	check   cliParams
+	inbox   string
+	dirs    map[string]string
+	steps   []string
+	state   watchState
+	pending map[string]*pendingFile
+	errors  int
+}
+
+// validateWatch checks the pipeline & durations of the watch command
+func validateWatch(p cliParams) error {
+	if _, err := parsePipeline(p.Pipeline); err != nil {
+		return err
+	}
+	if p.Interval <= 0 {
+		return errors.New("-interval must be more than 0")
+	}
+	if p.Settle < 0 {
+		return errors.New("-settle cannot be negative")
+	}
+	return nil
+}
+
+// parsePipeline splits a -pipeline into its steps
+func parsePipeline(pipeline string) ([]string, error) {
+	var steps []string
+	for _, step := range strings.Split(pipeline, ",") {
+		if step = strings.TrimSpace(step); step == "" {
+			continue
+		}
+		if !watchSteps[step] {
+			return nil, fmt.Errorf("-pipeline step '%s' is unknown, use fix, overlap or check", step)
+		}
+		steps = append(steps, step)
+	}
+
+	if len(steps) == 0 {
+		return nil, errors.New("-pipeline needs at least one step")
+	}
+
+	return steps, nil
+}
+
+// WatchOperation watches the -file directory, running the pipeline on
+// files dropped into it and moving them to the done, failed or rejected
+// folders along with a report. It runs until interrupted, or with -once
+// until no file is left waiting. Sources tells the settings which were
+// set, the check step leaving -min_length off unless it was
+func WatchOperation(params cliParams, sources map[string]string) int {
+	if !isDir(params.File) {
+		logger.Errorf("-file '%s' must be the directory to watch", params.File)
+		return ExitError
+	}
+
+	w := &watcher{
+		params:  params,
+		check:   checkParams(params, sources),
+		inbox:   params.File,
+		pending: make(map[string]*pendingFile),
+	}
+	w.steps, _ = parsePipeline(params.Pipeline)
+
+	w.dirs = map[string]string{
+		WatchDone:     params.DoneDir,
+		WatchFailed:   params.FailedDir,
+		WatchRejected: params.RejectedDir,
+	}
+	for outcome, dir := range w.dirs {
+		if dir == "" {
+			w.dirs[outcome] = filepath.Join(w.inbox, outcome)
+		}
+		if err := os.MkdirAll(w.dirs[outcome], 0755); err != nil {
+			logger.Errorf("Cannot create folder '%s': %s", w.dirs[outcome], err)
+			return ExitError
+		}
+	}
+
+	if w.params.State == "" {
+		w.params.State = filepath.Join(w.inbox, DefaultWatchState)
+	}
+	if err := w.loadState(); err != nil {
+		logger.Errorf("Cannot read state '%s': %s", w.params.State, err)
+		return ExitError
+	}
+
+	if params.List {
+		files, err := w.scan()
+		if err != nil {
+			logger.Errorf("Cannot search '%s': %s", w.inbox, err)
+			return ExitError
+		}
+		return listFiles(files)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	logger.Infof("Watching '%s' every %s, running %s on files unchanged for %s",
+		w.inbox, params.Interval, strings.Join(w.steps, ","), params.Settle)
+
+	for {
+		waiting := w.poll(stop)
+
+		if params.Once && waiting == 0 {
+			break
+		}
+
+		select {
+		case sig := <-stop:
+			logger.Infof("Stopped watching '%s' on %s", w.inbox, sig)
+			return w.exitCode()
+		case <-time.After(params.Interval):
+		}
+	}
+
+	logger.Infof("No files left waiting in '%s'", w.inbox)
+	return w.exitCode()
+}
+
+// exitCode is 1 if the watcher itself failed on any file
+func (w *watcher) exitCode() int {
+	if w.errors > 0 {
+		return ExitError
+	}
+	return 0
+}
+
+// scan lists the files of the inbox, leaving out the outcome folders
+// and hidden files such as the state & backups
+func (w *watcher) scan() ([]string, error) {
+	wk := &walker{visited: make(map[string]bool), skip: make(map[string]bool)}
+	for _, dir := range w.dirs {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			wk.skip[real] = true
+		}
+	}
+
+	err := wk.walk(w.inbox, func(path string) bool {
+		return keepFile(path, w.params)
+	})
+
+	return wk.files, err
+}
+
+// poll scans the inbox once and processes the files which have settled.
+// It returns the number of files still waiting
+func (w *watcher) poll(stop chan os.Signal) int {
+	files, err := w.scan()
+	if err != nil {
+		logger.Errorf("Cannot search '%s': %s", w.inbox, err)
+		w.errors++
+		return 0
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	var ready []string
+
+	for _, path := range files {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		seen[path] = true
+
+		p := w.pending[path]
+		if p == nil || p.size != fi.Size() || !p.mod.Equal(fi.ModTime()) {
+			if p == nil {
+				logger.Debugf("Found '%s', waiting for it to settle", path)
+			}
+			p = &pendingFile{size: fi.Size(), mod: fi.ModTime(), since: now}
+			w.pending[path] = p
+		}
+
+		if !p.stuck && now.Sub(p.since) >= w.params.Settle {
+			ready = append(ready, path)
+		}
+	}
+
+	// Files which went away are forgotten
+	for path := range w.pending {
+		if !seen[path] {
+			delete(w.pending, path)
+		}
+	}
+
+	for _, path := range ready {
+		select {
+		case sig := <-stop:
+			// Let the main loop stop once the current file is done
+			stop <- sig
+			return len(w.pending)
+		default:
+		}
+
+		if err := w.process(path); err != nil {
+			logger.Errorf("Cannot process '%s', leaving it until it changes: %s", path, err)
+			w.pending[path].stuck = true
+			w.errors++
+			continue
+		}
+		delete(w.pending, path)
+	}
+
+	waiting := 0
+	for _, p := range w.pending {
+		if !p.stuck {
+			waiting++
+		}
+	}
+
+	return waiting
+}
+
+// process runs the pipeline on the file at path and moves it out of the
+// inbox. The state records the file before it is removed from the inbox,
+// so that a watcher stopped in between finishes the move on restart
+// instead of processing the file again
+func (w *watcher) process(path string) error {
+	rel, err := filepath.Rel(w.inbox, path)
+	if err != nil {
+		return err
+	}
+
+	sum, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+
+	if e := w.state.Files[rel]; e != nil && e.SHA256 == sum && e.Outcome != "" {
+		logger.Infof("'%s' was already moved to %s, removing it from the inbox", rel, e.Outcome)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return w.forget(rel)
+	}
+
+	started := time.Now()
+	w.state.Files[rel] = &watchEntry{SHA256: sum, Started: started}
+	if err := w.saveState(); err != nil {
+		return err
+	}
+
+	// Messages about the file go both to the log & into its report
+	var lines bytes.Buffer
+	report := &leveledLogger{out: &logOutput{level: astisub.LevelInfo, format: LogFormatJSON, w: &lines}}
+	log := logger.With("file", rel)
+	log.tee = report
+
+	r := &watchReport{File: rel, Pipeline: w.steps, SHA256: sum, Started: started}
+	s, changed := w.runPipeline(path, r, log)
+
+	dst := filepath.Join(w.dirs[r.Outcome], rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := makeBackup(dst, w.params); err != nil {
+		return err
+	}
+
+	moved := false
+	if changed {
+		stem, ext := splitExt(dst)
+		if err := copyFile(path, filepath.Join(filepath.Dir(dst), stem+".orig"+ext)); err != nil {
+			return err
+		}
+		if err := s.Write(dst); err != nil {
+			return err
+		}
+	} else {
+		if err := moveFile(path, dst); err != nil {
+			return err
+		}
+		moved = true
+	}
+
+	log.Infof("Moved to %s", dst)
+
+	r.Finished = time.Now()
+	for _, line := range strings.Split(strings.TrimSpace(lines.String()), "\n") {
+		if line != "" {
+			r.Log = append(r.Log, json.RawMessage(line))
+		}
+	}
+	if err := writeReport(dst+".report.json", r); err != nil {
+		return err
+	}
+
+	w.state.Files[rel].Outcome = r.Outcome
+	if err := w.saveState(); err != nil {
+		return err
+	}
+
+	if !moved {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	return w.forget(rel)
+}
+
+// runPipeline opens the file at path & runs the steps of the pipeline
+// on it, filling in the outcome of r. It returns the subtitles and
+// whether the steps changed them
+func (w *watcher) runPipeline(path string, r *watchReport, log *leveledLogger) (*astisub.Subtitles, bool) {
+	if !isSubtitle(path) {
+		r.Outcome, r.Exit = WatchRejected, ExitUsage
+		r.Reason = "not a subtitle file"
+		log.Warnf("Rejected, %s", r.Reason)
+		return nil, false
+	}
+
+	s, err := astisub.OpenFile(path)
+	if err == nil && len(s.Items) == 0 {
+		err = errors.New("no subtitles found")
+	}
+	if err != nil {
+		r.Outcome, r.Exit = WatchRejected, openExitCode(err)
+		r.Reason = err.Error()
+		log.Warnf("Rejected, cannot read it: %s", err)
+		return nil, false
+	}
+
+	fixer.Mark(s, nil, log)
+	before := snapshot(s)
+
+	params := w.params
+	params.File = path
+
+	r.Outcome = WatchDone
+	for _, step := range w.steps {
+		log.Log(astisub.LevelDebug, "Running step", "step", step)
+
+		switch step {
+		case "fix":
+			fixSubtitles(s, params, log)
+			if _, err := runPlugins(s, params, "fix", log); err != nil {
+				r.Outcome, r.Exit = WatchFailed, ExitError
+				r.Reason = err.Error()
+				log.Warnf("Failed, %s", err)
+			}
+		case "overlap":
+			for i := 0; i < len(s.Items); i++ {
+				s.AdjustOverlap(i, params.CommandParams, log)
+			}
+		case "check":
+			check := w.check
+			check.File = path
+			if code := PerfectionOperation(s, check, log); code != 0 {
+				r.Outcome, r.Exit = WatchFailed, code
+				r.Reason = "perfection check failed"
+			}
+		}
+	}
+
+	counts := writeDiff(io.Discard, path, path, before, snapshot(s))
+	r.Changes = formatCounts(counts)
+	if len(counts) > 0 {
+		log.Infof("Changes: %s", r.Changes)
+	}
+
+	return s, len(counts) > 0
+}
+
+// hashFile returns the SHA-256 of the content of the file at path
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// moveFile renames src to dst, copying it when they are on
+// different file systems
+func moveFile(src string, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// writeReport writes r as indented JSON to path
+func writeReport(path string, r *watchReport) error {
+	return astisub.WriteFileAtomic(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	})
+}
+
+// loadState reads the state file, if there is one yet
+func (w *watcher) loadState() error {
+	w.state = watchState{Files: make(map[string]*watchEntry)}
+
+	data, err := os.ReadFile(w.params.State)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, &w.state); err != nil {
+		return err
+	}
+	if w.state.Files == nil {
+		w.state.Files = make(map[string]*watchEntry)
+	}
+
+	return nil
+}
+
+// saveState writes the state file atomically
+func (w *watcher) saveState() error {
+	return astisub.WriteFileAtomic(w.params.State, func(wr io.Writer) error {
+		return json.NewEncoder(wr).Encode(w.state)
+	})
+}
+
+// forget drops rel from the state once it has left the inbox
+func (w *watcher) forget(rel string) error {
+	delete(w.state.Files, rel)
+	return w.saveState()
+}
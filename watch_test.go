@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePipeline(t *testing.T) {
+	for _, c := range []struct {
+		pipeline string
+		expected []string
+		fails    bool
+	}{
+		{pipeline: "fix,check", expected: []string{"fix", "check"}},
+		{pipeline: " overlap , fix ", expected: []string{"overlap", "fix"}},
+		{pipeline: "check,", expected: []string{"check"}},
+		{pipeline: "fix,lint", fails: true},
+		{pipeline: "", fails: true},
+		{pipeline: " , ", fails: true},
+	} {
+		steps, err := parsePipeline(c.pipeline)
+		switch {
+		case c.fails && err == nil:
+			t.Errorf("parsePipeline(%q) = %v, expected an error", c.pipeline, steps)
+		case !c.fails && err != nil:
+			t.Errorf("parsePipeline(%q) failed: %s", c.pipeline, err)
+		case !c.fails && !reflect.DeepEqual(steps, c.expected):
+			t.Errorf("parsePipeline(%q) = %v, expected %v", c.pipeline, steps, c.expected)
+		}
+	}
+}
+
+func TestValidateWatch(t *testing.T) {
+	for _, c := range []struct {
+		args  []string
+		fails bool
+	}{
+		{args: nil},
+		{args: []string{"-interval", "0s"}, fails: true},
+		{args: []string{"-settle", "-1s"}, fails: true},
+		{args: []string{"-pipeline", "lint"}, fails: true},
+	} {
+		err := validateWatch(commandParams(t, "watch", c.args...))
+		if (err != nil) != c.fails {
+			t.Errorf("validateWatch(%v) = %v, expected failure %t", c.args, err, c.fails)
+		}
+	}
+}
+
+func TestWatchOnce(t *testing.T) {
+	captureLog(t, LogFormatText)
+	inbox := t.TempDir()
+	files := map[string]string{
+		"ep01.srt":    testSRT,
+		"s1/ep02.srt": testSRT,
+		"broken.srt":  "not subtitles at all",
+		"notes.txt":   "hello",
+		// Shorter than fix makes subtitles, which check allows
+		"short.srt":     "1\n00:00:01,000 --> 00:00:01,500\nHi\n",
+		"long/ep03.srt": testSRT[:len(testSRT)-len("General Kenobi\n")] + "A line far too long to be read in the two seconds it is shown for\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(inbox, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if code := runTestCommand("watch", "-file", inbox, "-once", "-settle", "0s", "-interval", "10ms", "-pipeline", "check"); code != ExitOK {
+		t.Fatalf("watch exited with %d", code)
+	}
+
+	for name, outcome := range map[string]string{
+		"ep01.srt":      WatchDone,
+		"s1/ep02.srt":   WatchDone,
+		"broken.srt":    WatchRejected,
+		"notes.txt":     WatchRejected,
+		"short.srt":     WatchDone,
+		"long/ep03.srt": WatchFailed,
+	} {
+		if _, err := os.Stat(filepath.Join(inbox, name)); !os.IsNotExist(err) {
+			t.Errorf("'%s' was left in the inbox", name)
+		}
+
+		dst := filepath.Join(inbox, outcome, name)
+		data, err := os.ReadFile(dst + ".report.json")
+		if err != nil {
+			t.Errorf("'%s' has no report in %s: %s", name, outcome, err)
+			continue
+		}
+
+		var r watchReport
+		if err := json.Unmarshal(data, &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Outcome != outcome || r.File != filepath.FromSlash(name) {
+			t.Errorf("report of '%s' is for '%s' with outcome %s, expected %s", name, r.File, r.Outcome, outcome)
+		}
+	}
+}
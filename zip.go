@@ -0,0 +1,349 @@
+// Copyright 2019 Michele Gianella & Chetan Chauhan.
+// Use of this source code is governed by an AGPL
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chetan-prime/subfixer/astisub"
+)
+
+// zipSep separates the path of an archive from the name of an entry
+// in it, as in delivery.zip!/en/ep01.srt
+const zipSep = "!/"
+
+// isZip checks if name has the extension of a zip archive
+func isZip(name string) bool {
+	return strings.ToLower(filepath.Ext(name)) == ".zip"
+}
+
+// splitZipPath splits a path inside a zip archive into the path of the
+// archive & the name of the entry. ok is false for other paths
+func splitZipPath(name string) (archive string, entry string, ok bool) {
+	for i := 0; i < len(name); {
+		n := strings.Index(name[i:], zipSep)
+		if n < 0 {
+			break
+		}
+		if isZip(name[:i+n]) {
+			return name[:i+n], name[i+n+len(zipSep):], true
+		}
+		i += n + len(zipSep)
+	}
+
+	return "", "", false
+}
+
+// isZipEntry checks if name is a path inside a zip archive
+func isZipEntry(name string) bool {
+	_, _, ok := splitZipPath(name)
+	return ok
+}
+
+// zipEntries returns the paths of the subtitle entries of archive
+// matching pattern, in the order of the archive. An empty pattern
+// matches every entry, a folder the entries below it, and anything
+// else is a glob which may use **
+func zipEntries(archive string, pattern string) ([]string, error) {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	pattern = strings.Trim(pattern, "/")
+
+	var entries []string
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !isSubtitle(f.Name) {
+			continue
+		}
+
+		if pattern == "" || strings.HasPrefix(f.Name, pattern+"/") || matchPath(pattern, f.Name) {
+			entries = append(entries, archive+zipSep+f.Name)
+		}
+	}
+
+	return entries, nil
+}
+
+// openZipEntry reads the subtitles of an entry of a zip archive in
+// place, without extracting it
+func openZipEntry(archive string, entry string) (*astisub.Subtitles, error) {
+	if !isSubtitle(entry) {
+		return nil, astisub.ErrInvalidExtension
+	}
+
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.Name != entry {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+
+		return astisub.ReadFromSRT(rc)
+	}
+
+	return nil, &os.PathError{Op: "open", Path: archive + zipSep + entry, Err: os.ErrNotExist}
+}
+
+// zipUpdate holds the entries saved for an archive, to be written out
+// as a whole once every entry was processed
+type zipUpdate struct {
+	src     string
+	entries map[string][]byte
+}
+
+// zipUpdates collects the entries saved by the files of a batch, keyed
+// by the archive they are written to. Files may be saved concurrently
+type zipUpdates struct {
+	mu      sync.Mutex
+	updates map[string]*zipUpdate
+}
+
+// pendingZips are the entries saved so far & not yet written out
+var pendingZips = &zipUpdates{updates: make(map[string]*zipUpdate)}
+
+// save queues the subtitles saved to dst, an entry of an archive,
+// read from src, an entry of the same or another archive
+func (z *zipUpdates) save(s *astisub.Subtitles, src string, dst string) error {
+	srcArchive, _, _ := splitZipPath(src)
+	dstArchive, entry, _ := splitZipPath(dst)
+
+	var buf bytes.Buffer
+	if err := s.WriteToSRT(&buf); err != nil {
+		return err
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	u := z.updates[dstArchive]
+	if u == nil {
+		u = &zipUpdate{src: srcArchive, entries: make(map[string][]byte)}
+		z.updates[dstArchive] = u
+	}
+	if u.src != srcArchive {
+		return fmt.Errorf("cannot write entries of both '%s' & '%s' to '%s'", u.src, srcArchive, dstArchive)
+	}
+	u.entries[entry] = buf.Bytes()
+
+	return nil
+}
+
+// flush writes every archive with saved entries, then forgets them
+func (z *zipUpdates) flush(params cliParams) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	dsts := make([]string, 0, len(z.updates))
+	for dst := range z.updates {
+		dsts = append(dsts, dst)
+	}
+	sort.Strings(dsts)
+
+	defer func() {
+		z.updates = make(map[string]*zipUpdate)
+	}()
+
+	for _, dst := range dsts {
+		if err := writeZip(dst, z.updates[dst], params); err != nil {
+			return fmt.Errorf("cannot write archive '%s': %s", dst, err)
+		}
+	}
+
+	return nil
+}
+
+// writeZip writes a new archive to dst holding the entries of u.src,
+// in the same order, with the saved entries replaced. Entries which
+// are unchanged, including those whose saved text is the same, are
+// copied byte for byte. Replaced entries keep their name, timestamp,
+// comment & compression method
+func writeZip(dst string, u *zipUpdate, params cliParams) error {
+	zr, err := zip.OpenReader(u.src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	changed := make(map[string][]byte)
+	for _, f := range zr.File {
+		data, ok := u.entries[f.Name]
+		if !ok {
+			continue
+		}
+
+		old, err := readZipFile(f)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(old, data) {
+			changed[f.Name] = data
+		}
+	}
+
+	if len(changed) == 0 && dst == u.src {
+		logger.Infof("No changes to save to %s", dst)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := makeBackup(dst, params); err != nil {
+		return err
+	}
+
+	err = astisub.WriteFileAtomic(dst, func(w io.Writer) error {
+		zw := zip.NewWriter(w)
+		if err := zw.SetComment(zr.Comment); err != nil {
+			return err
+		}
+
+		for _, f := range zr.File {
+			data, ok := changed[f.Name]
+			if !ok {
+				if err := copyZipFile(zw, f); err != nil {
+					return fmt.Errorf("copying entry '%s' failed: %s", f.Name, err)
+				}
+				continue
+			}
+
+			hdr := &zip.FileHeader{
+				Name:         f.Name,
+				Comment:      f.Comment,
+				Method:       f.Method,
+				ModifiedTime: f.ModifiedTime,
+				ModifiedDate: f.ModifiedDate,
+			}
+			if hasExtendedTime(f.Extra) {
+				hdr.Modified = f.Modified
+			}
+			ew, err := zw.CreateHeader(hdr)
+			if err != nil {
+				return err
+			}
+			if _, err := ew.Write(data); err != nil {
+				return err
+			}
+		}
+
+		return zw.Close()
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Saved changes to %d entries of %s", len(changed), dst)
+	return nil
+}
+
+// hasExtendedTime checks if the extra field of an entry holds an
+// extended timestamp. Without one the time of the entry is in MS-DOS
+// format only, in an unknown time zone, and is kept as such
+func hasExtendedTime(extra []byte) bool {
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if tag == 0x5455 {
+			return true
+		}
+		if len(extra) < 4+size {
+			break
+		}
+		extra = extra[4+size:]
+	}
+
+	return false
+}
+
+// readZipFile returns the uncompressed content of an entry
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// copyZipFile copies an entry without decompressing it, so that it
+// stays byte for byte the same
+func copyZipFile(zw *zip.Writer, f *zip.File) error {
+	r, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+
+	w, err := zw.CreateRaw(&f.FileHeader)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// zipOutput returns where the entry fname of an archive is saved to:
+// the same entry of the archive -out names, or of its own archive
+func zipOutput(fname string, params cliParams) string {
+	if params.Out == "" {
+		return fname
+	}
+
+	_, entry, _ := splitZipPath(fname)
+	return params.Out + zipSep + entry
+}
+
+// checkZipFiles refuses the flags which cannot apply to entries of zip
+// archives, when some of files are
+func checkZipFiles(files []string, params cliParams) error {
+	archives := make(map[string]bool)
+	entries := 0
+	for _, f := range files {
+		if archive, _, ok := splitZipPath(f); ok {
+			archives[archive] = true
+			entries++
+		}
+	}
+
+	if entries == 0 {
+		return nil
+	}
+
+	switch {
+	case params.Stream:
+		return fmt.Errorf("-stream cannot be used with entries of zip archives")
+	case params.OutDir != "":
+		return fmt.Errorf("-out_dir cannot be used with entries of zip archives, use -out with a .zip file")
+	case params.Out != "" && (!isZip(params.Out) || entries < len(files)):
+		return fmt.Errorf("-out must be a .zip file when the inputs are entries of a zip archive")
+	case params.Out != "" && len(archives) > 1:
+		return fmt.Errorf("-out '%s' can only be used with entries of a single zip archive", params.Out)
+	}
+
+	return nil
+}
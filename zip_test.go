@@ -0,0 +1,268 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitZipPath(t *testing.T) {
+	for _, c := range []struct {
+		name, archive, entry string
+		ok                   bool
+	}{
+		{"delivery.zip!/en/ep01.srt", "delivery.zip", "en/ep01.srt", true},
+		{"season1/Delivery.ZIP!/ep01.srt", "season1/Delivery.ZIP", "ep01.srt", true},
+		{"odd!/name.zip!/ep01.srt", "odd!/name.zip", "ep01.srt", true},
+		{"delivery.zip!/", "delivery.zip", "", true},
+		{"delivery.zip", "", "", false},
+		{"notes!/ep01.srt", "", "", false},
+		{"ep01.srt", "", "", false},
+	} {
+		archive, entry, ok := splitZipPath(c.name)
+		if archive != c.archive || entry != c.entry || ok != c.ok {
+			t.Errorf("splitZipPath(%q) = %q, %q, %t, expected %q, %q, %t", c.name, archive, entry, ok, c.archive, c.entry, c.ok)
+		}
+	}
+}
+
+func TestZipOutput(t *testing.T) {
+	for _, c := range []struct {
+		fname, out, expected string
+	}{
+		{"delivery.zip!/en/ep01.srt", "", "delivery.zip!/en/ep01.srt"},
+		{"delivery.zip!/en/ep01.srt", "fixed.zip", "fixed.zip!/en/ep01.srt"},
+	} {
+		if got := zipOutput(c.fname, cliParams{Out: c.out}); got != c.expected {
+			t.Errorf("zipOutput(%q, %q) = %q, expected %q", c.fname, c.out, got, c.expected)
+		}
+	}
+}
+
+func TestCheckZipFiles(t *testing.T) {
+	entries := []string{"a.zip!/ep01.srt", "a.zip!/ep02.srt"}
+	for _, c := range []struct {
+		name   string
+		files  []string
+		params cliParams
+		fails  bool
+	}{
+		{"no entries", []string{"ep01.srt"}, cliParams{Stream: true, OutDir: "out"}, false},
+		{"entries", entries, cliParams{}, false},
+		{"out archive", entries, cliParams{Out: "fixed.zip"}, false},
+		{"stream", entries, cliParams{Stream: true}, true},
+		{"out_dir", entries, cliParams{OutDir: "out"}, true},
+		{"out file", entries, cliParams{Out: "fixed.srt"}, true},
+		{"entries & files", append(entries, "ep03.srt"), cliParams{Out: "fixed.zip"}, true},
+		{"several archives", append(entries, "b.zip!/ep01.srt"), cliParams{Out: "fixed.zip"}, true},
+	} {
+		if err := checkZipFiles(c.files, c.params); (err != nil) != c.fails {
+			t.Errorf("%s: error %v, expected failure %t", c.name, err, c.fails)
+		}
+	}
+}
+
+func TestHasExtendedTime(t *testing.T) {
+	for _, c := range []struct {
+		extra    []byte
+		expected bool
+	}{
+		{nil, false},
+		{[]byte{0x55, 0x54, 5, 0, 1, 0, 0, 0, 0}, true},
+		// Another field first, then the timestamp
+		{[]byte{0x75, 0x78, 2, 0, 1, 2, 0x55, 0x54, 5, 0, 1, 0, 0, 0, 0}, true},
+		{[]byte{0x75, 0x78, 2, 0, 1, 2}, false},
+		{[]byte{0x75, 0x78, 9, 0, 1}, false},
+	} {
+		if got := hasExtendedTime(c.extra); got != c.expected {
+			t.Errorf("hasExtendedTime(%v) = %t, expected %t", c.extra, got, c.expected)
+		}
+	}
+}
+
+// zipTestEntry is an entry of a test archive
+type zipTestEntry struct {
+	name string
+	data string
+}
+
+// writeTestZip writes an archive holding entries, in that order, with a
+// comment & an old timestamp on each entry
+func writeTestZip(t *testing.T, path string, entries ...zipTestEntry) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if err := zw.SetComment("delivery"); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate, Modified: time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC)}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestZipEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "delivery.zip")
+	writeTestZip(t, path,
+		zipTestEntry{"en/ep01.srt", testSRT},
+		zipTestEntry{"en/notes.txt", "notes"},
+		zipTestEntry{"fr/ep01.srt", testSRT},
+		zipTestEntry{"fr/extras/ep01.srt", testSRT},
+		zipTestEntry{"ep01.srt", testSRT},
+	)
+
+	for _, c := range []struct {
+		pattern  string
+		expected []string
+	}{
+		{"", []string{"en/ep01.srt", "fr/ep01.srt", "fr/extras/ep01.srt", "ep01.srt"}},
+		{"fr", []string{"fr/ep01.srt", "fr/extras/ep01.srt"}},
+		{"/fr/", []string{"fr/ep01.srt", "fr/extras/ep01.srt"}},
+		{"*/ep01.srt", []string{"en/ep01.srt", "fr/ep01.srt"}},
+		{"**/extras/*.srt", []string{"fr/extras/ep01.srt"}},
+		{"de", nil},
+	} {
+		entries, err := zipEntries(path, c.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var expected []string
+		for _, e := range c.expected {
+			expected = append(expected, path+zipSep+e)
+		}
+		if !reflect.DeepEqual(entries, expected) {
+			t.Errorf("entries of %q are %v, expected %v", c.pattern, entries, expected)
+		}
+	}
+
+	if _, err := zipEntries(filepath.Join(t.TempDir(), "missing.zip"), ""); err == nil {
+		t.Error("listing the entries of a missing archive succeeded")
+	}
+}
+
+func TestOpenZipEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "delivery.zip")
+	writeTestZip(t, path, zipTestEntry{"en/ep01.srt", testSRT}, zipTestEntry{"en/notes.txt", "notes"})
+
+	for _, c := range []struct {
+		entry    string
+		expected int
+	}{
+		{"en/ep01.srt", ExitOK},
+		{"en/ep02.srt", ExitError},
+		{"en/notes.txt", ExitUsage},
+	} {
+		s, err := openSubtitles(path + zipSep + c.entry)
+		code := ExitOK
+		if err != nil {
+			code = openExitCode(err)
+		}
+		if code != c.expected {
+			t.Errorf("%s: exit %d, %v, expected %d", c.entry, code, err, c.expected)
+		}
+		if err == nil && len(s.Items) != 2 {
+			t.Errorf("%s: %d subtitles, expected 2", c.entry, len(s.Items))
+		}
+	}
+}
+
+func TestFixZip(t *testing.T) {
+	captureLog(t, LogFormatText)
+	dir := t.TempDir()
+	inDir(t, dir)
+
+	// Saved subtitles start with a BOM, so only an entry holding one is
+	// saved as it was
+	saved := "\ufeff" + testSRT
+	entries := []zipTestEntry{
+		{"en/ep01.srt", streamTestSRT(5)},
+		{"en/notes.txt", "notes"},
+		{"en/ep02.srt", saved},
+	}
+
+	for _, c := range []struct {
+		name string
+		args []string
+		out  string
+	}{
+		{"in place", nil, "delivery.zip"},
+		{"to another archive", []string{"-out", "fixed.zip"}, "fixed.zip"},
+	} {
+		src := filepath.Join(dir, "delivery.zip")
+		writeTestZip(t, src, entries...)
+		os.Remove(filepath.Join(dir, "fixed.zip"))
+		original, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		args := append([]string{"fix", "-file", src, "-backup_keep", "0", "-journal", JournalOff}, c.args...)
+		if code := runTestCommand(args...); code != ExitOK {
+			t.Fatalf("%s: fix exited with %d", c.name, code)
+		}
+
+		// Another archive is written from the source, left as it was
+		if data, _ := os.ReadFile(src); c.out != "delivery.zip" && !bytes.Equal(data, original) {
+			t.Errorf("%s: %s changed", c.name, src)
+		}
+
+		before, err := zip.NewReader(bytes.NewReader(original), int64(len(original)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, err := zip.OpenReader(filepath.Join(dir, c.out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		beforeFiles, afterFiles := before.File, after.File
+
+		if after.Comment != before.Comment {
+			t.Errorf("%s: comment is %q, expected %q", c.name, after.Comment, before.Comment)
+		}
+		if len(afterFiles) != len(beforeFiles) {
+			t.Fatalf("%s: %d entries, expected %d", c.name, len(afterFiles), len(beforeFiles))
+		}
+
+		for n, f := range afterFiles {
+			old := beforeFiles[n]
+			if f.Name != old.Name || !f.Modified.Equal(old.Modified) || f.Method != old.Method {
+				t.Errorf("%s: entry %d is %s of %s with method %d, expected %s of %s with method %d",
+					c.name, n, f.Name, f.Modified, f.Method, old.Name, old.Modified, old.Method)
+			}
+
+			data, err := readZipFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			changed := f.Name == "en/ep01.srt"
+			if changed == (f.CRC32 == old.CRC32) {
+				t.Errorf("%s: %s changed %t, expected %t", c.name, f.Name, !changed, changed)
+			}
+			if f.Name == "en/ep02.srt" && string(data) != saved {
+				t.Errorf("%s: unchanged entry holds %q", c.name, data)
+			}
+			if changed && !strings.Contains(string(data), "Far too short") {
+				t.Errorf("%s: fixed entry holds %q", c.name, data)
+			}
+		}
+		after.Close()
+	}
+}